@@ -0,0 +1,82 @@
+package articles
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// --- ACCESS ---
+
+// Owners and collaborators say who may do what with an article besides what
+// the public may. They are facts for the policy engine (who.WhoProlog), which
+// makes the decisions. Editing an article never changes them, only SetAccess
+// does, and restoring an old revision keeps the current ones.
+
+// Access is what a collaborator may do with an article.
+type Access string
+
+const (
+	AccessRead   Access = "read"   // read while not public, e.g. review a draft
+	AccessChange Access = "change" // read and edit
+)
+
+// Collaborator is a user an owner shared the article with.
+type Collaborator struct {
+	UserID string `json:"user_id"`
+	Access Access `json:"access"`
+}
+
+// ErrInvalidAccess is wrapped by every error about malformed owners or
+// collaborators.
+var ErrInvalidAccess = errors.New("invalid access")
+
+// ValidateAccess checks that there is at least one owner, that nobody is
+// listed twice and that every collaborator has a known access.
+func ValidateAccess(owners []string, collaborators []Collaborator) error {
+	if len(owners) == 0 {
+		return fmt.Errorf("%w: an article needs an owner", ErrInvalidAccess)
+	}
+	seen := map[string]bool{}
+	for _, id := range owners {
+		if id == "" || seen[id] {
+			return fmt.Errorf("%w: owner %q is empty or listed twice", ErrInvalidAccess, id)
+		}
+		seen[id] = true
+	}
+	for _, c := range collaborators {
+		if c.UserID == "" || seen[c.UserID] {
+			return fmt.Errorf("%w: collaborator %q is empty or listed twice", ErrInvalidAccess, c.UserID)
+		}
+		if !slices.Contains([]Access{AccessRead, AccessChange}, c.Access) {
+			return fmt.Errorf("%w: unknown access %q for %s", ErrInvalidAccess, c.Access, c.UserID)
+		}
+		seen[c.UserID] = true
+	}
+	return nil
+}
+
+// SetAccess replaces the owners and collaborators of the article on top of
+// the expected revision (compare-and-swap, see Update). The new revision is
+// not a restore, even if the one it replaces was.
+func SetAccess(repo ArticleRepo, id uuid.UUID, expected uint64, owners []string, collaborators []Collaborator) (Article, error) {
+	if err := ValidateAccess(owners, collaborators); err != nil {
+		return Article{}, err
+	}
+	art, err := repo.Get(id)
+	if err != nil {
+		return art, fmt.Errorf("set access: %w", err)
+	}
+	if expected != 0 {
+		art.Rev = expected
+	}
+	art.Owners = owners
+	art.Collaborators = collaborators
+	art.RestoredFrom, art.RestoredBy = 0, ""
+	if art.Collaborators == nil {
+		art.Collaborators = []Collaborator{}
+	}
+	return repo.Update(art)
+}
@@ -0,0 +1,74 @@
+package articles
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateAccess(t *testing.T) {
+	cases := []struct {
+		name          string
+		owners        []string
+		collaborators []Collaborator
+		ok            bool
+	}{
+		{"owner", []string{"a"}, nil, true},
+		{"collaborators", []string{"a"}, []Collaborator{{"b", AccessRead}, {"c", AccessChange}}, true},
+		{"no owner", nil, []Collaborator{{"b", AccessRead}}, false},
+		{"empty owner", []string{""}, nil, false},
+		{"owner twice", []string{"a", "a"}, nil, false},
+		{"owner collaborates", []string{"a"}, []Collaborator{{"a", AccessRead}}, false},
+		{"collaborator twice", []string{"a"}, []Collaborator{{"b", AccessRead}, {"b", AccessChange}}, false},
+		{"unknown access", []string{"a"}, []Collaborator{{"b", "delete"}}, false},
+	}
+	for _, c := range cases {
+		err := ValidateAccess(c.owners, c.collaborators)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if !c.ok && !errors.Is(err, ErrInvalidAccess) {
+			t.Errorf("%s: expected ErrInvalidAccess, got %v", c.name, err)
+		}
+	}
+}
+
+func TestSetAccess(t *testing.T) {
+	repo := testRepo(t)
+
+	art := TestArticle()
+	art.Owners = []string{"owner"}
+	created, err := repo.Create(art)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	collaborators := []Collaborator{{UserID: "reader", Access: AccessRead}}
+	shared, err := SetAccess(repo, created.Id, created.Rev, []string{"owner"}, collaborators)
+	if err != nil {
+		t.Fatalf("set access: %v", err)
+	}
+	if len(shared.Collaborators) != 1 || shared.Collaborators[0] != collaborators[0] || shared.Rev <= created.Rev {
+		t.Errorf("unexpected shared article %+v", shared)
+	}
+	if _, err = SetAccess(repo, created.Id, created.Rev, []string{"owner"}, nil); !errors.Is(err, ErrRevisionConflict) {
+		t.Errorf("expected a conflict on a stale revision, got %v", err)
+	}
+
+	// restoring the revision from before sharing keeps who has access
+	restored, err := Restore(repo, created.Id, created.Rev, shared.Rev, "owner")
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if len(restored.Owners) != 1 || len(restored.Collaborators) != 1 {
+		t.Errorf("expected access to survive a restore, got owners %v, collaborators %v", restored.Owners, restored.Collaborators)
+	}
+
+	// changing access after a restore is not a restore
+	unshared, err := SetAccess(repo, created.Id, restored.Rev, []string{"owner"}, nil)
+	if err != nil {
+		t.Fatalf("set access: %v", err)
+	}
+	if unshared.RestoredFrom != 0 || unshared.RestoredBy != "" {
+		t.Errorf("expected the restore marker to be cleared, got %d by %q", unshared.RestoredFrom, unshared.RestoredBy)
+	}
+}
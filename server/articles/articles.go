@@ -3,7 +3,9 @@ package articles
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
@@ -19,6 +21,10 @@ type ArticleRepo interface {
 	Create(art Article) (Article, error)
 	Update(art Article) (Article, error)
 	Delete(id uuid.UUID) error
+	Trash() ([]Article, error)
+	Undelete(id uuid.UUID) (Article, error)
+	PurgeDeleted(before time.Time) (int, error)
+	Migrate(dryRun bool) (MigrationReport, error)
 	GetHistory(id uuid.UUID) ([]Article, error)
 	GetRevision(id uuid.UUID, revision uint64) (Article, error)
 	Context() context.Context
@@ -33,37 +39,98 @@ type ArticleRepoWithWatchAll interface {
 
 // --- ARTICLE ---
 type articleRepo struct {
-	ctx context.Context
-	kv  jetstream.KeyValue
+	ctx    context.Context
+	nc     *nats.Conn
+	kv     jetstream.KeyValue
+	slugKv jetstream.KeyValue
+	l      *jst_log.Logger
 }
 
 type Article struct {
-	StructVersion int       `json:"struct_version"`
-	Id            uuid.UUID `json:"id"`
-	Rev           uint64    `json:"revision,omitempty"`
-	Slug          string    `json:"slug"`
-	Title         string    `json:"title"`
-	Subtitle      string    `json:"subtitle"`
-	Leading       string    `json:"leading"`
-	Author        string    `json:"author"`
-	PublishedAt   int       `json:"published_at"` // unix timestamp in milliseconds
-	Tags          []string  `json:"tags"`
-	Content       string    `json:"content,omitempty"`
+	StructVersion   int            `json:"struct_version"`
+	Id              uuid.UUID      `json:"id"`
+	Rev             uint64         `json:"revision,omitempty"`
+	Slug            string         `json:"slug"`
+	Title           string         `json:"title"`
+	Subtitle        string         `json:"subtitle"`
+	Leading         string         `json:"leading"`
+	Author          string         `json:"author"`
+	Status          Status         `json:"status"`
+	PublishedAt     int            `json:"published_at"` // unix timestamp in milliseconds
+	Tags            []string       `json:"tags"`
+	Content         string         `json:"content,omitempty"`
+	UpdatedAt       int            `json:"updated_at,omitempty"`    // unix ms when this revision was written, from the kv entry
+	RestoredFrom    uint64         `json:"restored_from,omitempty"` // revision this one was restored from
+	RestoredBy      string         `json:"restored_by,omitempty"`   // id of the user who restored it
+	DeletedAt       int            `json:"deleted_at,omitempty"`    // unix ms of the delete marker, only set in the trash
+	Derived         Derived        `json:"derived"`                 // read from Content on every write, see Derive
+	Owners          []string       `json:"owners,omitempty"`        // user ids, see access.go
+	Collaborators   []Collaborator `json:"collaborators,omitempty"`
+	Lang            string         `json:"lang"`                       // see translation.go
+	TranslationOf   *uuid.UUID     `json:"translation_of,omitempty"`   // id of the source, nil for sources
+	SourceRevision  uint64         `json:"source_revision,omitempty"`  // revision of the source this translation is up to date with
+	ContentRevision uint64         `json:"content_revision,omitempty"` // revision the text last changed in, see SameText
 }
 
+// --- ERRORS ---
+
+// ErrRevisionConflict is matched (errors.Is) by every RevisionConflictError.
+var ErrRevisionConflict = errors.New("revision conflict")
+
+// RevisionConflictError is returned by Update when the revision the caller
+// based its changes on is no longer the latest revision in the bucket.
+type RevisionConflictError struct {
+	Id       uuid.UUID
+	Expected uint64 // revision the caller expected to replace
+	Current  uint64 // latest revision in the bucket, 0 if the article is gone
+}
+
+func (e *RevisionConflictError) Error() string {
+	return fmt.Sprintf("article %s: expected revision %d, current revision is %d", e.Id, e.Expected, e.Current)
+}
+
+func (e *RevisionConflictError) Is(target error) bool {
+	return target == ErrRevisionConflict
+}
+
+// ErrRevisionUnavailable is returned by Restore when the requested revision is
+// not in the article's history anymore (compacted away, deleted or never
+// written for this article).
+var ErrRevisionUnavailable = errors.New("revision unavailable")
+
 // --- REPO ---
 
 // Repo initializes and returns an ArticleRepo backed by a JetStream key-value store.
-// Returns an error if the key-value store cannot be set up.
+// The slug index is rebuilt from the article bucket before the repo is returned.
+// Returns an error if the key-value stores cannot be set up.
 func Repo(ctx context.Context, nc *nats.Conn, l *jst_log.Logger) (ArticleRepo, error) {
-	kv, err := setup(ctx, nc)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("jetstream new: %w", err)
+	}
+	kv, err := setup(ctx, js)
 	if err != nil {
 		return nil, fmt.Errorf("repo setup: %w", err)
 	}
-	return &articleRepo{
-		ctx: ctx,
-		kv:  kv,
-	}, nil
+	slugKv, err := setupSlugIndex(ctx, js)
+	if err != nil {
+		return nil, fmt.Errorf("slug index setup: %w", err)
+	}
+	if err = setupTagRewrites(ctx, js); err != nil {
+		return nil, fmt.Errorf("tag rewrite setup: %w", err)
+	}
+	repo := &articleRepo{
+		ctx:    ctx,
+		nc:     nc,
+		kv:     kv,
+		slugKv: slugKv,
+		l:      l,
+	}
+	err = repo.repairSlugIndex()
+	if err != nil {
+		return nil, fmt.Errorf("repair slug index: %w", err)
+	}
+	return repo, nil
 }
 
 func (r *articleRepo) Get(id uuid.UUID) (Article, error) {
@@ -76,41 +143,26 @@ func (r *articleRepo) Get(id uuid.UUID) (Article, error) {
 	if err != nil {
 		return art, fmt.Errorf("get article: %w", err)
 	}
-	err = json.Unmarshal(entry.Value(), &art)
+	art, err = DecodeEntry(entry)
 	if err != nil {
-		return art, fmt.Errorf("unmarshal article: %w", err)
+		return art, fmt.Errorf("decode article: %w", err)
 	}
 	art.Rev = entry.Revision()
+	art.UpdatedAt = int(entry.Created().UnixMilli())
 	return art, nil
 }
 
+// GetBySLug looks the slug up in the slug index and returns the article it
+// points to.
 func (r *articleRepo) GetBySLug(slug string) (Article, error) {
-	var (
-		err   error
-		keys  jetstream.KeyLister
-		key   string
-		entry jetstream.KeyValueEntry
-		art   Article
-	)
-	keys, err = r.kv.ListKeys(r.ctx)
-	if err != nil {
-		return art, fmt.Errorf("list keys: %w", err)
+	id, _, err := r.slugOwner(slug)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return Article{}, fmt.Errorf("article with slug %s not found: %w", slug, jetstream.ErrKeyNotFound)
 	}
-	for key = range keys.Keys() {
-		entry, err = r.kv.Get(r.ctx, key)
-		if err != nil {
-			return art, fmt.Errorf("get article: %w", err)
-		}
-		err = json.Unmarshal(entry.Value(), &art)
-		if err != nil {
-			return art, fmt.Errorf("unmarshal article: %w", err)
-		}
-		if art.Slug == slug {
-			art.Rev = entry.Revision()
-			return art, nil
-		}
+	if err != nil {
+		return Article{}, fmt.Errorf("get slug: %w", err)
 	}
-	return art, fmt.Errorf("article with slug %s not found", slug)
+	return r.Get(id)
 }
 
 func (r *articleRepo) AllNoContent() ([]Article, error) {
@@ -131,73 +183,204 @@ func (r *articleRepo) AllNoContent() ([]Article, error) {
 		if err != nil {
 			return nil, fmt.Errorf("get article: %w", err)
 		}
-		err = json.Unmarshal(entry.Value(), &art)
+		art, err = DecodeEntry(entry)
 		if err != nil {
-			return nil, fmt.Errorf("unmarshal article: %w", err)
+			return nil, fmt.Errorf("decode article: %w", err)
 		}
 
 		metadataArticle := Article{
-			StructVersion: art.StructVersion,
-			Id:            art.Id,
-			Author:        art.Author,
-			PublishedAt:   art.PublishedAt,
-			Tags:          art.Tags,
-			Rev:           entry.Revision(),
-			Slug:          art.Slug,
-			Title:         art.Title,
-			Subtitle:      art.Subtitle,
-			Leading:       art.Leading,
+			StructVersion:   art.StructVersion,
+			Id:              art.Id,
+			Author:          art.Author,
+			Status:          art.Status,
+			PublishedAt:     art.PublishedAt,
+			Tags:            art.Tags,
+			Rev:             entry.Revision(),
+			UpdatedAt:       int(entry.Created().UnixMilli()),
+			Slug:            art.Slug,
+			Title:           art.Title,
+			Subtitle:        art.Subtitle,
+			Leading:         art.Leading,
+			Derived:         art.Derived,
+			Owners:          art.Owners,
+			Collaborators:   art.Collaborators,
+			Lang:            art.Lang,
+			TranslationOf:   art.TranslationOf,
+			SourceRevision:  art.SourceRevision,
+			ContentRevision: art.ContentRevision,
 		}
 		arts = append(arts, metadataArticle)
 	}
 	return arts, nil
 }
 
+// Create stores art under a new id. The slug must not be used by any other
+// article, otherwise a *SlugTakenError is returned.
 func (r *articleRepo) Create(art Article) (Article, error) {
 	var (
 		err  error
 		data []byte
 		rev  uint64
 	)
-	art.StructVersion = 1
+	art.StructVersion = CurrentStructVersion
 	art.Rev = 1
 	art.Id = uuid.New()
+	art.UpdatedAt = 0
+	art.ContentRevision = 0
+	art.Derived = Derive(art.Content)
+	art.Lang = art.Language()
+	if art.Status == "" {
+		art.Status = art.CurrentStatus(time.Now())
+	}
 	data, err = json.Marshal(art)
 	if err != nil {
 		return art, fmt.Errorf("marshal article: %w", err)
 	}
+	err = r.claimSlug(art.Slug, art.Id)
+	if err != nil {
+		return art, err
+	}
 	rev, err = r.kv.Create(r.ctx, art.Id.String(), data)
 	if err != nil {
+		if relErr := r.releaseSlug(art.Slug, art.Id); relErr != nil {
+			r.l.Error("release slug after failed create: %v", relErr)
+		}
 		return art, fmt.Errorf("create article: %w", err)
 	}
 	art.Rev = rev
+	art.ContentRevision = rev
+	art.UpdatedAt = int(time.Now().UnixMilli())
+	if art.Status == StatusPublished {
+		r.announce(art)
+	}
 	return art, nil
 }
 
+// Update writes art as the new head of the article, but only if art.Rev is
+// still the latest revision in the bucket (compare-and-swap). If someone else
+// has written in the meantime a *RevisionConflictError is returned. Changing
+// the slug to one used by another article returns a *SlugTakenError. A
+// write that changes the status to published is announced on
+// SubjectPublished.
 func (r *articleRepo) Update(art Article) (Article, error) {
 	var (
-		err  error
-		data []byte
-		rev  uint64
+		err       error
+		data      []byte
+		rev       uint64
+		expected  uint64
+		oldSlug   string
+		oldStatus Status
 	)
 
-	art.Rev++
+	expected = art.Rev
+	art.StructVersion = CurrentStructVersion
+	art.UpdatedAt = 0
+	art.ContentRevision = 0
+	art.Derived = Derive(art.Content)
+
+	current, err := r.kv.Get(r.ctx, art.Id.String())
+	if err == nil {
+		old, err := DecodeEntry(current)
+		if err != nil {
+			return art, fmt.Errorf("decode current article: %w", err)
+		}
+		oldSlug, oldStatus = old.Slug, old.Status
+		if SameText(old, art) {
+			art.ContentRevision = old.ContentRevision
+		}
+	} else if !errors.Is(err, jetstream.ErrKeyNotFound) {
+		return art, fmt.Errorf("get current article: %w", err)
+	}
+	if current == nil || current.Revision() != expected {
+		return art, r.conflict(art.Id, expected)
+	}
 	data, err = json.Marshal(art)
 	if err != nil {
 		return art, fmt.Errorf("marshal article: %w", err)
 	}
 
-	// rev, err = r.kv.Update(r.ctx, art.Id.String(), data, uint64(art.Rev)) // TODO: use CAS
-	rev, err = r.kv.Put(r.ctx, art.Id.String(), data)
+	slugChanged := art.Slug != oldSlug
+	if slugChanged {
+		if err = r.claimSlug(art.Slug, art.Id); err != nil {
+			return art, err
+		}
+	}
+
+	rev, err = r.kv.Update(r.ctx, art.Id.String(), data, expected)
 	if err != nil {
+		if slugChanged {
+			if relErr := r.releaseSlug(art.Slug, art.Id); relErr != nil {
+				r.l.Error("release slug after failed update: %v", relErr)
+			}
+		}
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return art, r.conflict(art.Id, expected)
+		}
 		return art, fmt.Errorf("update article: %w", err)
 	}
+	if slugChanged {
+		if err = r.releaseSlug(oldSlug, art.Id); err != nil {
+			r.l.Error("release old slug %q: %v", oldSlug, err)
+		}
+	}
 	art.Rev = rev
+	if art.ContentRevision == 0 {
+		art.ContentRevision = rev
+	}
+	art.UpdatedAt = int(time.Now().UnixMilli())
+	if art.Status == StatusPublished && oldStatus != StatusPublished {
+		r.announce(art)
+	}
 	return art, nil
 }
 
+// conflict builds a RevisionConflictError, looking up the current revision.
+func (r *articleRepo) conflict(id uuid.UUID, expected uint64) error {
+	conflictErr := &RevisionConflictError{Id: id, Expected: expected}
+	entry, err := r.kv.Get(r.ctx, id.String())
+	if err == nil {
+		conflictErr.Current = entry.Revision()
+	}
+	return conflictErr
+}
+
+// Restore writes revision of the article as its new head, on top of the
+// expected head revision (compare-and-swap, see Update). The new revision
+// records which revision it was restored from and by whom. Owners and
+// collaborators are not restored, the current ones are kept.
+func Restore(repo ArticleRepo, id uuid.UUID, revision, expected uint64, by string) (Article, error) {
+	old, err := repo.GetRevision(id, revision)
+	if errors.Is(err, jetstream.ErrKeyNotFound) || errors.Is(err, jetstream.ErrKeyDeleted) {
+		return old, fmt.Errorf("restore article %s: revision %d: %w", id, revision, ErrRevisionUnavailable)
+	}
+	if err != nil {
+		return old, fmt.Errorf("restore article: %w", err)
+	}
+	if old.Id != id {
+		return old, fmt.Errorf("restore article %s: revision %d: %w", id, revision, ErrRevisionUnavailable)
+	}
+	current, err := repo.Get(id)
+	if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		return old, fmt.Errorf("restore article: %w", err)
+	}
+	old.Rev = expected
+	old.RestoredFrom = revision
+	old.RestoredBy = by
+	old.Owners, old.Collaborators = current.Owners, current.Collaborators
+	return repo.Update(old)
+}
+
+// Delete writes a delete marker for the article and releases its slug.
 func (r *articleRepo) Delete(id uuid.UUID) error {
-	err := r.kv.Delete(r.ctx, id.String())
+	art, err := r.Get(id)
+	if err != nil {
+		return fmt.Errorf("delete article: %w", err)
+	}
+	err = r.kv.Delete(r.ctx, id.String())
+	if err != nil {
+		return fmt.Errorf("delete article: %w", err)
+	}
+	err = r.releaseSlug(art.Slug, id)
 	if err != nil {
 		return fmt.Errorf("delete article: %w", err)
 	}
@@ -214,12 +397,12 @@ func (r *articleRepo) GetHistory(id uuid.UUID) ([]Article, error) {
 
 	for _, entry := range history {
 		if entry.Operation() == jetstream.KeyValuePut {
-			var art Article
-			err = json.Unmarshal(entry.Value(), &art)
+			art, err := DecodeEntry(entry)
 			if err != nil {
-				return nil, fmt.Errorf("unmarshal article: %w", err)
+				return nil, fmt.Errorf("decode article: %w", err)
 			}
 			art.Rev = entry.Revision()
+			art.UpdatedAt = int(entry.Created().UnixMilli())
 			revisions = append(revisions, art)
 		}
 	}
@@ -232,6 +415,8 @@ func (r *articleRepo) GetHistory(id uuid.UUID) ([]Article, error) {
 	return revisions, nil
 }
 
+// GetRevision returns revision of the article. Revisions that are not an
+// article, such as its delete marker, are jetstream.ErrKeyNotFound.
 func (r *articleRepo) GetRevision(id uuid.UUID, revision uint64) (Article, error) {
 	var art Article
 
@@ -239,11 +424,16 @@ func (r *articleRepo) GetRevision(id uuid.UUID, revision uint64) (Article, error
 	if err != nil {
 		return art, fmt.Errorf("get article revision: %w", err)
 	}
+	if entry.Operation() != jetstream.KeyValuePut {
+		return art, fmt.Errorf("get article revision %d: %w", revision, jetstream.ErrKeyNotFound)
+	}
 
-	err = json.Unmarshal(entry.Value(), &art)
+	art, err = DecodeEntry(entry)
 	if err != nil {
-		return art, fmt.Errorf("unmarshal article: %w", err)
+		return art, fmt.Errorf("decode article: %w", err)
 	}
+	art.Rev = entry.Revision()
+	art.UpdatedAt = int(entry.Created().UnixMilli())
 
 	return art, nil
 }
@@ -267,141 +457,25 @@ func (r *articleRepo) Purge() error {
 			return fmt.Errorf("purge article: %w", err)
 		}
 	}
+	slugKeys, err := r.slugKv.ListKeys(r.ctx)
+	if err != nil {
+		return fmt.Errorf("purge slug index: %w", err)
+	}
+	for key := range slugKeys.Keys() {
+		err = r.slugKv.Purge(r.ctx, key)
+		if err != nil {
+			return fmt.Errorf("purge slug index: %w", err)
+		}
+	}
 	return nil
 }
 
-// --- REPO WITH IN MEM CACHE ---
-
-// WithInMemCache wraps an ArticleRepoWithWatchAll with an in-memory cache that is kept in sync with JetStream updates.
-// It returns a new ArticleRepo instance that serves reads from the cache and propagates writes to the underlying repository.
-// The cache is updated in real time using a background goroutine that listens for key-value changes.
-// func WithInMemCache(repo ArticleRepoWithWatchAll, l *jst_log.Logger) (ArticleRepo, error) {
-
-// 	repoWrapped := &articleRepoInMem{
-// 		repo:     repo,
-// 		articles: make(map[string]*Article),
-// 		lock:     sync.RWMutex{},
-// 	}
-
-// 	watcher, err := repo.WatchAll()
-// 	if err != nil {
-// 		return nil, fmt.Errorf("watchAll: %w", err)
-// 	}
-// 	go repoWrapped.updater(repo.Context(), watcher, l.WithBreadcrumb("watcher"))
-// 	return repoWrapped, nil
-// }
-
-// // Get returns an article by slug.
-// func (r *articleRepoInMem) Get(slug string) (*Article, error) {
-// 	r.lock.RLock()
-// 	defer r.lock.RUnlock()
-// 	art, ok := r.articles[slug]
-// 	if !ok {
-// 		return nil, fmt.Errorf("article with slug %s not found", slug)
-// 	}
-// 	return art, nil
-// }
-
-// func (r *articleRepoInMem) AllNoContent() ([]ArticleMetadata, error) {
-// 	r.lock.RLock()
-// 	defer r.lock.RUnlock()
-// 	articles := make([]ArticleMetadata, 0, len(r.articles))
-// 	for _, art := range r.articles {
-// 		articles = append(articles, ArticleMetadata{
-// 			StructVersion: art.StructVersion,
-// 			Id:            art.Id,
-// 			Rev:           art.Rev,
-// 			Slug:          art.Slug,
-// 			Title:         art.Title,
-// 			Subtitle:      art.Subtitle,
-// 			Leading:       art.Leading,
-// 		})
-// 	}
-// 	return articles, nil
-// }
-
-// func (r *articleRepoInMem) Create(art Article) (uint64, error) {
-// 	var (
-// 		err error
-// 	)
-// 	rev, err := r.repo.Create(art)
-// 	if err != nil {
-// 		return 0, fmt.Errorf("create article: %w", err)
-// 	}
-
-// 	return rev, nil
-// }
-
-// func (r *articleRepoInMem) Update(art Article) (uint64, error) {
-// 	var (
-// 		err error
-// 	)
-// 	rev, err := r.repo.Update(art)
-// 	if err != nil {
-// 		return 0, fmt.Errorf("update article: %w", err)
-// 	}
-// 	return rev, nil
-// }
-
-// func (r *articleRepoInMem) Context() context.Context {
-// 	return r.repo.Context()
-// }
-
-// func (r *articleRepoInMem) updater(ctx context.Context, w jetstream.KeyWatcher, l *jst_log.Logger) {
-// 	var (
-// 		err error
-// 		art *Article
-// 	)
-// 	defer w.Stop()
-// 	for {
-// 		select {
-// 		case <-ctx.Done():
-// 			l.Info("context done, stopping")
-// 			return
-// 		case update, ok := <-w.Updates():
-// 			if !ok {
-// 				l.Warn("Channel unexpectedly closed")
-// 				return
-// 			}
-// 			if update == nil {
-// 				l.Debug("up to date")
-// 				continue
-// 			}
-
-// 			op := update.Operation()
-// 			switch op {
-// 			case jetstream.KeyValuePut:
-// 				l.Debug("PUT - %s:%d", update.Key(), update.Revision())
-// 				art = &Article{}
-// 				err = json.Unmarshal(update.Value(), art)
-// 				if err != nil {
-// 					l.Error("decode put: %w", err)
-// 				}
-// 				art.Rev = update.Revision()
-// 				r.articles[art.Slug] = art
-// 			case jetstream.KeyValueDelete:
-// 				l.Debug("DELETE - %s:%d", update.Key(), update.Revision())
-// 				delete(r.articles, update.Key())
-// 			case jetstream.KeyValuePurge:
-// 				l.Debug("PURGE - %s:%d", update.Key(), update.Revision())
-// 				l.Error("Purge not handled")
-// 			default:
-// 				l.Debug("UNKNOWN update (%s), on %s:%d", op, update.Key(), update.Revision())
-// 			}
-// 		}
-// 	}
-// }
-
 // --- SETUP ---
 
 // setup initializes and returns a JetStream key-value store bucket named "article" for storing articles in JSON format.
 // The bucket is configured with a 5MB maximum value size, 64 history entries, and file storage.
 // Returns the created key-value store or an error if initialization fails.
-func setup(ctx context.Context, nc *nats.Conn) (jetstream.KeyValue, error) {
-	js, err := jetstream.New(nc)
-	if err != nil {
-		return nil, fmt.Errorf("jetstream new: %w", err)
-	}
+func setup(ctx context.Context, js jetstream.JetStream) (jetstream.KeyValue, error) {
 	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
 		Bucket:       "article",
 		Description:  "articles in json format",
@@ -0,0 +1,266 @@
+package articles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/jst_log"
+)
+
+// testConn starts an in-process JetStream enabled NATS server backed by a
+// temporary directory and returns a connection to it.
+func testConn(t testing.TB) *nats.Conn {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{
+		ServerName: "test-articles",
+		NoLog:      true,
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL(), nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() {
+		nc.Close()
+		ns.Shutdown()
+	})
+	return nc
+}
+
+func testRepo(t testing.TB) ArticleRepo {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	repo, err := Repo(ctx, testConn(t), jst_log.NewLogger("test", jst_log.DefaultSubjects()))
+	if err != nil {
+		t.Fatalf("repo: %v", err)
+	}
+	return repo
+}
+
+func TestUpdateCompareAndSwap(t *testing.T) {
+	repo := testRepo(t)
+
+	created, err := repo.Create(TestArticle())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// first editor saves on top of the created revision
+	first := created
+	first.Title = "first editor"
+	first, err = repo.Update(first)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if first.Rev <= created.Rev {
+		t.Errorf("expected revision to grow, got %d after %d", first.Rev, created.Rev)
+	}
+
+	// second editor still holds the created revision
+	second := created
+	second.Title = "second editor"
+	_, err = repo.Update(second)
+	if !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("expected revision conflict, got %v", err)
+	}
+	var conflictErr *RevisionConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected *RevisionConflictError, got %T", err)
+	}
+	if conflictErr.Expected != created.Rev || conflictErr.Current != first.Rev {
+		t.Errorf("unexpected conflict revisions: %+v", conflictErr)
+	}
+
+	got, err := repo.Get(created.Id)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.Title != "first editor" {
+		t.Errorf("expected first editor to win, got %q", got.Title)
+	}
+}
+
+func TestSlugIndex(t *testing.T) {
+	repo := testRepo(t)
+
+	first, err := repo.Create(TestArticle())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	got, err := repo.GetBySLug(first.Slug)
+	if err != nil {
+		t.Fatalf("get by slug: %v", err)
+	}
+	if got.Id != first.Id {
+		t.Errorf("expected %s, got %s", first.Id, got.Id)
+	}
+
+	// duplicate slug on create
+	_, err = repo.Create(TestArticle())
+	if !errors.Is(err, ErrSlugTaken) {
+		t.Fatalf("expected slug taken, got %v", err)
+	}
+
+	// duplicate slug on update
+	second, err := repo.Create(NatsAllTheWayDown())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	second.Slug = first.Slug
+	_, err = repo.Update(second)
+	if !errors.Is(err, ErrSlugTaken) {
+		t.Fatalf("expected slug taken, got %v", err)
+	}
+
+	// renaming frees the old slug
+	first.Slug = "renamed"
+	first, err = repo.Update(first)
+	if err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if _, err = repo.GetBySLug("test-article"); err == nil {
+		t.Error("expected old slug to be released")
+	}
+	if got, err = repo.GetBySLug("renamed"); err != nil || got.Id != first.Id {
+		t.Errorf("expected renamed slug to resolve to %s, got %s (%v)", first.Id, got.Id, err)
+	}
+
+	// deleting frees the slug
+	if err = repo.Delete(first.Id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err = repo.GetBySLug("renamed"); err == nil {
+		t.Error("expected slug of deleted article to be released")
+	}
+}
+
+func TestSlugIndexRepair(t *testing.T) {
+	repo := testRepo(t).(*articleRepo)
+
+	art, err := repo.Create(TestArticle())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// drift: index lost an entry and gained a stale one
+	if err = repo.slugKv.Purge(repo.ctx, slugKey(art.Slug)); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if _, err = repo.slugKv.Put(repo.ctx, slugKey("ghost slug"), []byte(art.Id.String())); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	// a claim just written belongs to an article about to be
+	if err = repo.repairSlugIndex(); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if _, _, err := repo.slugOwner("ghost slug"); err != nil {
+		t.Errorf("expected a fresh claim to be kept, got %v", err)
+	}
+
+	defer func(grace time.Duration) { slugClaimGrace = grace }(slugClaimGrace)
+	slugClaimGrace = 0
+	if err = repo.repairSlugIndex(); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if got, err := repo.GetBySLug(art.Slug); err != nil || got.Id != art.Id {
+		t.Errorf("expected slug to be restored, got %v", err)
+	}
+	if _, err := repo.GetBySLug("ghost slug"); err == nil {
+		t.Error("expected stale slug to be removed")
+	}
+}
+
+func TestSlugIndexRepairCollision(t *testing.T) {
+	repo := testRepo(t).(*articleRepo)
+
+	first, err := repo.Create(TestArticle())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	second := TestArticle()
+	second.Slug = "second"
+	if second, err = repo.Create(second); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// drift: the second article took the slug of the first behind the
+	// index, then the first was written again so that its head is newer
+	put := func(art Article) {
+		t.Helper()
+		data, _ := json.Marshal(art)
+		if _, err := repo.kv.Put(repo.ctx, art.Id.String(), data); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	second.Slug = first.Slug
+	put(second)
+	first.Title = "edited"
+	put(first)
+
+	if err = repo.repairSlugIndex(); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if owner, _, err := repo.slugOwner(first.Slug); err != nil || owner != first.Id {
+		t.Errorf("expected the article created first to keep the slug, got %s (%v)", owner, err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	repo := testRepo(t)
+
+	created, err := repo.Create(TestArticle())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	edited := created
+	edited.Title = "edited"
+	edited.Content = "edited content"
+	edited, err = repo.Update(edited)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	restored, err := Restore(repo, created.Id, created.Rev, edited.Rev, "user-1")
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if restored.Rev <= edited.Rev {
+		t.Errorf("expected a new head after %d, got %d", edited.Rev, restored.Rev)
+	}
+	got, err := repo.Get(created.Id)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.Title != created.Title || got.Content != created.Content {
+		t.Errorf("expected the created revision back, got %q", got.Title)
+	}
+	if got.RestoredFrom != created.Rev || got.RestoredBy != "user-1" {
+		t.Errorf("expected restore to be recorded, got from %d by %q", got.RestoredFrom, got.RestoredBy)
+	}
+
+	// the head moved on since edited was read
+	_, err = Restore(repo, created.Id, created.Rev, edited.Rev, "user-1")
+	if !errors.Is(err, ErrRevisionConflict) {
+		t.Errorf("expected revision conflict, got %v", err)
+	}
+
+	_, err = Restore(repo, created.Id, restored.Rev+100, restored.Rev, "user-1")
+	if !errors.Is(err, ErrRevisionUnavailable) {
+		t.Errorf("expected unavailable revision, got %v", err)
+	}
+}
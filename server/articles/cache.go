@@ -0,0 +1,290 @@
+package articles
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/jst_log"
+)
+
+// --- REPO WITH IN MEM CACHE ---
+
+// CachedArticleRepo is an ArticleRepo serving reads from memory.
+type CachedArticleRepo interface {
+	ArticleRepo
+	// Ready is closed once the initial watch has caught up with the bucket.
+	// Reads before that fall through to the wrapped repo.
+	Ready() <-chan struct{}
+}
+
+type articleRepoInMem struct {
+	repo     ArticleRepoWithWatchAll
+	articles map[uuid.UUID]Article
+	slugs    map[string]uuid.UUID
+	deleted  map[uuid.UUID]uint64 // tombstones, see remove
+	lock     sync.RWMutex
+	ready    chan struct{}
+}
+
+// WithInMemCache wraps an ArticleRepoWithWatchAll with an in-memory cache that is kept in sync with JetStream updates.
+// It returns a new ArticleRepo instance that serves reads from the cache and propagates writes to the underlying repository.
+// The cache is updated in real time using a background goroutine that listens for key-value changes.
+func WithInMemCache(repo ArticleRepoWithWatchAll, l *jst_log.Logger) (CachedArticleRepo, error) {
+	repoWrapped := &articleRepoInMem{
+		repo:     repo,
+		articles: make(map[uuid.UUID]Article),
+		slugs:    make(map[string]uuid.UUID),
+		deleted:  make(map[uuid.UUID]uint64),
+		ready:    make(chan struct{}),
+	}
+
+	watcher, err := repo.WatchAll()
+	if err != nil {
+		return nil, fmt.Errorf("watchAll: %w", err)
+	}
+	go repoWrapped.updater(repo.Context(), watcher, l.WithBreadcrumb("watcher"))
+	return repoWrapped, nil
+}
+
+func (r *articleRepoInMem) Ready() <-chan struct{} {
+	return r.ready
+}
+
+func (r *articleRepoInMem) isReady() bool {
+	select {
+	case <-r.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Get returns an article by id.
+func (r *articleRepoInMem) Get(id uuid.UUID) (Article, error) {
+	if !r.isReady() {
+		return r.repo.Get(id)
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	art, ok := r.articles[id]
+	if !ok {
+		return Article{}, fmt.Errorf("get article: %w", jetstream.ErrKeyNotFound)
+	}
+	return art, nil
+}
+
+// GetBySLug returns an article by slug.
+func (r *articleRepoInMem) GetBySLug(slug string) (Article, error) {
+	if !r.isReady() {
+		return r.repo.GetBySLug(slug)
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	id, ok := r.slugs[slug]
+	if !ok {
+		return Article{}, fmt.Errorf("article with slug %s not found: %w", slug, jetstream.ErrKeyNotFound)
+	}
+	return r.articles[id], nil
+}
+
+func (r *articleRepoInMem) AllNoContent() ([]Article, error) {
+	if !r.isReady() {
+		return r.repo.AllNoContent()
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	arts := make([]Article, 0, len(r.articles))
+	for _, art := range r.articles {
+		art.Content = ""
+		arts = append(arts, art)
+	}
+	return arts, nil
+}
+
+// Create, Update and Delete go to the wrapped repo. The result is applied to
+// the cache right away so that a read following a write sees it, the watcher
+// delivers the same change again shortly after.
+
+func (r *articleRepoInMem) Create(art Article) (Article, error) {
+	art, err := r.repo.Create(art)
+	if err != nil {
+		return art, err
+	}
+	r.put(art)
+	return art, nil
+}
+
+func (r *articleRepoInMem) Update(art Article) (Article, error) {
+	art, err := r.repo.Update(art)
+	if err != nil {
+		return art, err
+	}
+	r.put(art)
+	return art, nil
+}
+
+// Delete tombstones the head revision it deleted. The delete marker itself
+// comes later through the watcher.
+func (r *articleRepoInMem) Delete(id uuid.UUID) error {
+	head, err := r.repo.Get(id)
+	if err != nil {
+		return fmt.Errorf("delete article: %w", err)
+	}
+	err = r.repo.Delete(id)
+	if err != nil {
+		return err
+	}
+	r.remove(id, head.Rev)
+	return nil
+}
+
+func (r *articleRepoInMem) Trash() ([]Article, error) {
+	return r.repo.Trash()
+}
+
+func (r *articleRepoInMem) Undelete(id uuid.UUID) (Article, error) {
+	art, err := r.repo.Undelete(id)
+	if err != nil {
+		return art, err
+	}
+	r.put(art)
+	return art, nil
+}
+
+func (r *articleRepoInMem) PurgeDeleted(before time.Time) (int, error) {
+	return r.repo.PurgeDeleted(before)
+}
+
+func (r *articleRepoInMem) Migrate(dryRun bool) (MigrationReport, error) {
+	return r.repo.Migrate(dryRun)
+}
+
+func (r *articleRepoInMem) GetHistory(id uuid.UUID) ([]Article, error) {
+	return r.repo.GetHistory(id)
+}
+
+func (r *articleRepoInMem) GetRevision(id uuid.UUID, revision uint64) (Article, error) {
+	return r.repo.GetRevision(id, revision)
+}
+
+func (r *articleRepoInMem) Context() context.Context {
+	return r.repo.Context()
+}
+
+func (r *articleRepoInMem) Purge() error {
+	return r.repo.Purge()
+}
+
+func (r *articleRepoInMem) WatchAll() (jetstream.KeyWatcher, error) {
+	return r.repo.WatchAll()
+}
+
+// put stores art unless the cache already holds a newer revision of it, or
+// it was deleted after art.
+func (r *articleRepoInMem) put(art Article) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if rev, ok := r.deleted[art.Id]; ok {
+		if art.Rev <= rev {
+			return
+		}
+		delete(r.deleted, art.Id)
+	}
+	existing, ok := r.articles[art.Id]
+	if ok && existing.Rev >= art.Rev {
+		return
+	}
+	if ok && existing.Slug != art.Slug && r.slugs[existing.Slug] == art.Id {
+		delete(r.slugs, existing.Slug)
+	}
+	r.articles[art.Id] = art
+	r.slugs[art.Slug] = art.Id
+}
+
+// remove drops the article unless the cache holds a revision newer than rev,
+// the revision of the delete. rev is kept as a tombstone so that puts up to
+// it, arriving late through the watcher, do not bring the article back.
+func (r *articleRepoInMem) remove(id uuid.UUID, rev uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	existing, ok := r.articles[id]
+	if ok && existing.Rev > rev {
+		return
+	}
+	if rev > r.deleted[id] {
+		r.deleted[id] = rev
+	}
+	if !ok {
+		return
+	}
+	if r.slugs[existing.Slug] == id {
+		delete(r.slugs, existing.Slug)
+	}
+	delete(r.articles, id)
+}
+
+func (r *articleRepoInMem) updater(ctx context.Context, w jetstream.KeyWatcher, l *jst_log.Logger) {
+	var (
+		err error
+		id  uuid.UUID
+	)
+	defer func() {
+		if err := w.Stop(); err != nil {
+			l.Warn("stop watcher: %v", err)
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			l.Info("context done, stopping")
+			return
+		case update, ok := <-w.Updates():
+			if !ok {
+				l.Warn("Channel unexpectedly closed")
+				return
+			}
+			if update == nil {
+				if !r.isReady() {
+					r.lock.RLock()
+					l.Info("up to date. %d articles loaded", len(r.articles))
+					r.lock.RUnlock()
+					close(r.ready)
+				}
+				continue
+			}
+
+			id, err = uuid.Parse(update.Key())
+			if err != nil {
+				l.Error("invalid article key %q: %v", update.Key(), err)
+				continue
+			}
+			op := update.Operation()
+			switch op {
+			case jetstream.KeyValuePut:
+				l.Debug("PUT - %s:%d", update.Key(), update.Revision())
+				art, err := DecodeEntry(update)
+				if err != nil {
+					l.Error("decode put: %v", err)
+					continue
+				}
+				art.Id = id
+				art.Rev = update.Revision()
+				art.UpdatedAt = int(update.Created().UnixMilli())
+				r.put(art)
+			case jetstream.KeyValueDelete:
+				l.Debug("DELETE - %s:%d", update.Key(), update.Revision())
+				r.remove(id, update.Revision())
+			case jetstream.KeyValuePurge:
+				l.Debug("PURGE - %s:%d", update.Key(), update.Revision())
+				r.remove(id, update.Revision())
+			default:
+				l.Debug("UNKNOWN update (%s), on %s:%d", op, update.Key(), update.Revision())
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package articles
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"jst_dev/server/jst_log"
+)
+
+func testCachedRepo(t testing.TB, repo ArticleRepo) CachedArticleRepo {
+	t.Helper()
+	cached, err := WithInMemCache(repo, jst_log.NewLogger("test", jst_log.DefaultSubjects()))
+	if err != nil {
+		t.Fatalf("with in mem cache: %v", err)
+	}
+	select {
+	case <-cached.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cache not ready")
+	}
+	return cached
+}
+
+// eventually retries check until it succeeds or a second has passed.
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestInMemCache(t *testing.T) {
+	repo := testRepo(t)
+	existing, err := repo.Create(NatsAllTheWayDown())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	cached := testCachedRepo(t, repo)
+
+	// loaded by the initial watch
+	got, err := cached.GetBySLug(existing.Slug)
+	if err != nil || got.Id != existing.Id {
+		t.Fatalf("expected initial article in cache, got %v", err)
+	}
+
+	// writes through the cache are visible immediately
+	art, err := cached.Create(TestArticle())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if got, err = cached.Get(art.Id); err != nil || got.Rev != art.Rev {
+		t.Fatalf("expected created article in cache, got %v", err)
+	}
+
+	// writes behind the cache's back arrive through the watch
+	art.Slug = "renamed"
+	art, err = repo.Update(art)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	eventually(t, "rename", func() bool {
+		got, err := cached.GetBySLug("renamed")
+		return err == nil && got.Rev == art.Rev
+	})
+	if _, err = cached.GetBySLug("test-article"); err == nil {
+		t.Error("expected old slug to be gone from cache")
+	}
+
+	all, err := cached.AllNoContent()
+	if err != nil {
+		t.Fatalf("all: %v", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("expected 2 articles, got %d", len(all))
+	}
+	for _, a := range all {
+		if a.Content != "" {
+			t.Errorf("expected no content for %s", a.Slug)
+		}
+	}
+
+	if err = repo.Delete(existing.Id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	eventually(t, "delete", func() bool {
+		_, err := cached.Get(existing.Id)
+		return err != nil
+	})
+
+	if err = repo.Purge(); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	eventually(t, "purge", func() bool {
+		all, _ := cached.AllNoContent()
+		return len(all) == 0
+	})
+}
+
+func TestInMemCacheDelete(t *testing.T) {
+	repo := testRepo(t)
+	cached := testCachedRepo(t, repo)
+	inMem := cached.(*articleRepoInMem)
+
+	art, err := cached.Create(TestArticle())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	stale := art
+	art.Title = "updated"
+	if art, err = cached.Update(art); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err = cached.Delete(art.Id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	// puts from before the delete, as the watcher may still deliver them
+	inMem.put(stale)
+	inMem.put(art)
+	if _, err = cached.Get(art.Id); err == nil {
+		t.Fatalf("expected a late put not to bring the deleted article back")
+	}
+	if _, err = cached.GetBySLug(art.Slug); err == nil {
+		t.Fatalf("expected the slug of the deleted article to stay gone")
+	}
+
+	undeleted, err := cached.Undelete(art.Id)
+	if err != nil {
+		t.Fatalf("undelete: %v", err)
+	}
+	if got, err := cached.Get(art.Id); err != nil || got.Rev != undeleted.Rev {
+		t.Errorf("expected the undeleted article in cache, got %v", err)
+	}
+}
+
+func seedBenchmark(b *testing.B, repo ArticleRepo, n int) Article {
+	b.Helper()
+	var art Article
+	for i := 0; i < n; i++ {
+		seed := NatsAllTheWayDown()
+		seed.Slug = fmt.Sprintf("%s-%d", seed.Slug, i)
+		created, err := repo.Create(seed)
+		if err != nil {
+			b.Fatalf("create: %v", err)
+		}
+		art = created
+	}
+	return art
+}
+
+func BenchmarkGet(b *testing.B) {
+	repo := testRepo(b)
+	art := seedBenchmark(b, repo, 50)
+	cached := testCachedRepo(b, repo)
+
+	for name, r := range map[string]ArticleRepo{"kv": repo, "inmem": cached} {
+		b.Run(name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, err := r.Get(art.Id); err != nil {
+					b.Fatalf("get: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetBySlug(b *testing.B) {
+	repo := testRepo(b)
+	art := seedBenchmark(b, repo, 50)
+	cached := testCachedRepo(b, repo)
+
+	for name, r := range map[string]ArticleRepo{"kv": repo, "inmem": cached} {
+		b.Run(name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, err := r.GetBySLug(art.Slug); err != nil {
+					b.Fatalf("get by slug: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAllNoContent(b *testing.B) {
+	repo := testRepo(b)
+	seedBenchmark(b, repo, 50)
+	cached := testCachedRepo(b, repo)
+
+	for name, r := range map[string]ArticleRepo{"kv": repo, "inmem": cached} {
+		b.Run(name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, err := r.AllNoContent(); err != nil {
+					b.Fatalf("all no content: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package articles
+
+import (
+	"math"
+	"slices"
+	"strings"
+
+	"jst_dev/server/djot"
+)
+
+// --- DERIVED METADATA ---
+
+// Derived is what the server reads out of the content of an article. It is
+// computed on every write (Create and Update) and by migrateV2 for articles
+// written before it existed, so whatever a client sends is replaced.
+type Derived struct {
+	WordCount   int       `json:"word_count"`
+	ReadingTime int       `json:"reading_time"` // minutes, rounded up
+	Outline     []Heading `json:"outline"`
+	Links       []string  `json:"links"`       // link destinations in order of first appearance, without in-page anchors
+	FirstImage  string    `json:"first_image"` // destination of the first image, "" if there is none
+}
+
+// Heading is an entry of the outline. ID is the anchor the rendered heading
+// carries.
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	ID    string `json:"id"`
+}
+
+// WordsPerMinute is the reading speed ReadingTime is based on.
+const WordsPerMinute = 200
+
+// Derive parses content as djot and returns its derived metadata.
+func Derive(content string) Derived {
+	doc := djot.Parse(content)
+	d := Derived{
+		WordCount: len(strings.Fields(djot.PlainText(doc))),
+		Outline:   []Heading{},
+		Links:     []string{},
+	}
+	d.ReadingTime = int(math.Ceil(float64(d.WordCount) / WordsPerMinute))
+	doc.Walk(func(n *djot.Node) bool {
+		switch n.Kind {
+		case djot.KindHeading:
+			d.Outline = append(d.Outline, Heading{
+				Level: n.Level,
+				Text:  djot.InlineText(n),
+				ID:    n.Attrs.Get("id"),
+			})
+		case djot.KindLink:
+			if n.Dest != "" && !strings.HasPrefix(n.Dest, "#") && !slices.Contains(d.Links, n.Dest) {
+				d.Links = append(d.Links, n.Dest)
+			}
+		case djot.KindImage:
+			if d.FirstImage == "" {
+				d.FirstImage = n.Dest
+			}
+		}
+		return true
+	})
+	return d
+}
@@ -0,0 +1,70 @@
+package articles
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDerive(t *testing.T) {
+	content := `{#start}
+# Getting started
+
+Read [the docs](https://nats.io) and [jump ahead](#later), or the [docs][] again.
+
+![a diagram](/media/abc/960) ![another](/media/def)
+
+## Later
+
+` + strings.Repeat("word ", 400) + `
+
+[docs]: https://nats.io
+`
+	got := Derive(content)
+	want := Derived{
+		WordCount:   416,
+		ReadingTime: 3,
+		Outline: []Heading{
+			{Level: 1, Text: "Getting started", ID: "start"},
+			{Level: 2, Text: "Later", ID: "Later"},
+		},
+		Links:      []string{"https://nats.io"},
+		FirstImage: "/media/abc/960",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	if got = Derive(""); got.WordCount != 0 || got.ReadingTime != 0 || got.Outline == nil || got.Links == nil {
+		t.Errorf("unexpected metadata for empty content %+v", got)
+	}
+}
+
+func TestDerivedOnWrite(t *testing.T) {
+	repo := testRepo(t)
+	art := TestArticle()
+	art.Derived = Derived{WordCount: 1}
+	art, err := repo.Create(art)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	want := Derive(art.Content)
+	if !reflect.DeepEqual(art.Derived, want) || want.WordCount == 0 {
+		t.Errorf("expected derived metadata to be computed on create, got %+v", art.Derived)
+	}
+
+	art.Content = "## Only\n\ntwo words"
+	if art, err = repo.Update(art); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	all, err := repo.AllNoContent()
+	if err != nil || len(all) != 1 {
+		t.Fatalf("all: %v (%d)", err, len(all))
+	}
+	if all[0].Content != "" || all[0].Derived.WordCount != 3 || all[0].Derived.Outline[0].ID != "Only" {
+		t.Errorf("expected derived metadata without content, got %+v", all[0])
+	}
+	if got, _ := repo.Get(art.Id); !reflect.DeepEqual(got.Derived, all[0].Derived) {
+		t.Errorf("expected Get to return the same metadata, got %+v", got.Derived)
+	}
+}
@@ -0,0 +1,439 @@
+package articles
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// --- DIFF ---
+
+// DiffContext is the number of unchanged lines kept around each change.
+const DiffContext = 3
+
+// Diff describes what changed between two revisions of an article.
+type Diff struct {
+	Id      string        `json:"id"`
+	From    uint64        `json:"from"`
+	To      uint64        `json:"to"`
+	Fields  []FieldChange `json:"fields"`
+	Content ContentDiff   `json:"content"`
+}
+
+// FieldChange is a changed metadata field. Tags also list what was added
+// and removed.
+type FieldChange struct {
+	Field   string   `json:"field"`
+	From    any      `json:"from"`
+	To      any      `json:"to"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// ContentDiff is a line diff of the content, both as hunks for rendering and
+// as a unified diff for the terminal.
+type ContentDiff struct {
+	Hunks   []Hunk `json:"hunks"`
+	Unified string `json:"unified"`
+}
+
+// Hunk is a run of changed lines with their context. Line numbers start at 1.
+type Hunk struct {
+	FromLine  int        `json:"from_line"`
+	FromCount int        `json:"from_count"`
+	ToLine    int        `json:"to_line"`
+	ToCount   int        `json:"to_count"`
+	Lines     []DiffLine `json:"lines"`
+}
+
+// DiffLine is one line of a hunk. Deleted lines directly followed by inserted
+// ones are paired up and carry a word diff against their counterpart.
+type DiffLine struct {
+	Op    DiffOp        `json:"op"`
+	Text  string        `json:"text"`
+	Words []DiffSegment `json:"words,omitempty"`
+}
+
+// DiffSegment is a piece of a word diff.
+type DiffSegment struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffOp is what happened to a line or word.
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffDelete DiffOp = "delete"
+	DiffInsert DiffOp = "insert"
+)
+
+// DiffRevisions compares two revisions of an article.
+func DiffRevisions(from, to Article) Diff {
+	return Diff{
+		Id:      to.Id.String(),
+		From:    from.Rev,
+		To:      to.Rev,
+		Fields:  diffFields(from, to),
+		Content: diffContent(from, to),
+	}
+}
+
+func diffFields(from, to Article) []FieldChange {
+	changes := []FieldChange{}
+	add := func(field string, a, b any) {
+		changes = append(changes, FieldChange{Field: field, From: a, To: b})
+	}
+	if from.Slug != to.Slug {
+		add("slug", from.Slug, to.Slug)
+	}
+	if from.Title != to.Title {
+		add("title", from.Title, to.Title)
+	}
+	if from.Subtitle != to.Subtitle {
+		add("subtitle", from.Subtitle, to.Subtitle)
+	}
+	if from.Leading != to.Leading {
+		add("leading", from.Leading, to.Leading)
+	}
+	if from.Author != to.Author {
+		add("author", from.Author, to.Author)
+	}
+	if from.Status != to.Status {
+		add("status", from.Status, to.Status)
+	}
+	if from.PublishedAt != to.PublishedAt {
+		add("published_at", from.PublishedAt, to.PublishedAt)
+	}
+	if !slices.Equal(from.Tags, to.Tags) {
+		change := FieldChange{Field: "tags", From: from.Tags, To: to.Tags}
+		for _, tag := range to.Tags {
+			if !slices.Contains(from.Tags, tag) {
+				change.Added = append(change.Added, tag)
+			}
+		}
+		for _, tag := range from.Tags {
+			if !slices.Contains(to.Tags, tag) {
+				change.Removed = append(change.Removed, tag)
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func diffContent(from, to Article) ContentDiff {
+	a, b := splitDiffLines(from.Content), splitDiffLines(to.Content)
+	hunks := hunks(a, b, diffSeq(a, b, MaxLineEdits), DiffContext)
+	budget := MaxWordDiffs
+	for i := range hunks {
+		pairWords(hunks[i].Lines, &budget)
+	}
+	return ContentDiff{
+		Hunks:   hunks,
+		Unified: unified(from, to, hunks),
+	}
+}
+
+func splitDiffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// edit is one step of an edit script: keep, delete a[i] or insert b[j].
+type edit struct {
+	op   DiffOp
+	i, j int
+}
+
+// Bounds on the work a diff does. The memory diffSeq takes grows with the
+// square of the edit distance, so beyond the distances below the part of
+// the sequences that differs is reported as deleted and inserted as a whole.
+const (
+	MaxLineEdits = 1000 // lines of the content
+	MaxWordEdits = 200  // words of a changed line
+	MaxWordDiffs = 1000 // changed lines that get a word diff
+)
+
+// diffSeq returns an edit script turning a into b, a shortest one if it takes
+// at most maxEdits edits.
+func diffSeq(a, b []string, maxEdits int) []edit {
+	// the common prefix and suffix are kept as they are
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	script := make([]edit, 0, max(len(a), len(b)))
+	for i := 0; i < pre; i++ {
+		script = append(script, edit{DiffEqual, i, i})
+	}
+	if middle, ok := myers(a[pre:len(a)-suf], b[pre:len(b)-suf], maxEdits); ok {
+		for _, e := range middle {
+			script = append(script, edit{e.op, e.i + pre, e.j + pre})
+		}
+	} else {
+		for i := pre; i < len(a)-suf; i++ {
+			script = append(script, edit{DiffDelete, i, pre})
+		}
+		for j := pre; j < len(b)-suf; j++ {
+			script = append(script, edit{DiffInsert, len(a) - suf, j})
+		}
+	}
+	for k := suf; k > 0; k-- {
+		script = append(script, edit{DiffEqual, len(a) - k, len(b) - k})
+	}
+	return script
+}
+
+// myers returns a shortest edit script turning a into b (Myers, "An O(ND)
+// Difference Algorithm and Its Variations"), false if that takes more than
+// maxEdits edits.
+func myers(a, b []string, maxEdits int) ([]edit, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] is v before round d, limited to the diagonals -d-1..d+1
+	var trace [][]int
+	done := false
+outer:
+	for d := 0; d <= min(n+m, maxEdits); d++ {
+		trace = append(trace, slices.Clone(v[offset-d-1:offset+d+2]))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // down: insertion
+			} else {
+				x = v[offset+k-1] + 1 // right: deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break outer
+			}
+		}
+	}
+	if !done {
+		return nil, false
+	}
+
+	// walk the trace backwards to recover the script
+	var script []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		at := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			script = append(script, edit{DiffEqual, x, y})
+		}
+		if d > 0 {
+			if x == prevX {
+				script = append(script, edit{DiffInsert, x, prevY})
+			} else {
+				script = append(script, edit{DiffDelete, prevX, y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	slices.Reverse(script)
+	return script, true
+}
+
+// hunks groups an edit script into hunks with context lines around the
+// changes. Changes closer than two contexts apart share a hunk.
+func hunks(a, b []string, script []edit, context int) []Hunk {
+	result := []Hunk{}
+	for start := 0; start < len(script); {
+		for start < len(script) && script[start].op == DiffEqual {
+			start++
+		}
+		if start == len(script) {
+			break
+		}
+		end := start
+		for end < len(script) {
+			if script[end].op != DiffEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(script) && script[run].op == DiffEqual {
+				run++
+			}
+			if run == len(script) || run-end > 2*context {
+				end = min(end+context, len(script))
+				break
+			}
+			end = run
+		}
+
+		first := script[max(start-context, 0)]
+		h := Hunk{FromLine: first.i + 1, ToLine: first.j + 1}
+		for _, e := range script[max(start-context, 0):end] {
+			switch e.op {
+			case DiffEqual:
+				h.FromCount++
+				h.ToCount++
+				h.Lines = append(h.Lines, DiffLine{Op: e.op, Text: a[e.i]})
+			case DiffDelete:
+				h.FromCount++
+				h.Lines = append(h.Lines, DiffLine{Op: e.op, Text: a[e.i]})
+			case DiffInsert:
+				h.ToCount++
+				h.Lines = append(h.Lines, DiffLine{Op: e.op, Text: b[e.j]})
+			}
+		}
+		result = append(result, h)
+		start = end
+	}
+	return result
+}
+
+// pairWords attaches word diffs to runs of deleted lines directly followed
+// by inserted lines, pairing them up in order, until budget is used up.
+func pairWords(lines []DiffLine, budget *int) {
+	for i := 0; i < len(lines); {
+		if lines[i].Op != DiffDelete {
+			i++
+			continue
+		}
+		del := i
+		for i < len(lines) && lines[i].Op == DiffDelete {
+			i++
+		}
+		ins := i
+		for i < len(lines) && lines[i].Op == DiffInsert {
+			i++
+		}
+		for k := 0; k < ins-del && ins+k < i && *budget > 0; k++ {
+			*budget--
+			from, to := &lines[del+k], &lines[ins+k]
+			from.Words, to.Words = wordDiff(from.Text, to.Text)
+		}
+	}
+}
+
+// wordDiff diffs two lines word by word. The first result is the old line
+// (equal and deleted segments), the second the new one (equal and inserted).
+func wordDiff(a, b string) ([]DiffSegment, []DiffSegment) {
+	wa, wb := splitWords(a), splitWords(b)
+	opsA, opsB := make([]DiffOp, len(wa)), make([]DiffOp, len(wb))
+	for _, e := range diffSeq(wa, wb, MaxWordEdits) {
+		switch e.op {
+		case DiffEqual:
+			opsA[e.i], opsB[e.j] = DiffEqual, DiffEqual
+		case DiffDelete:
+			opsA[e.i] = DiffDelete
+		case DiffInsert:
+			opsB[e.j] = DiffInsert
+		}
+	}
+	return segments(wa, opsA), segments(wb, opsB)
+}
+
+// segments joins runs of words with the same op.
+func segments(words []string, ops []DiffOp) []DiffSegment {
+	var segs []DiffSegment
+	for start := 0; start < len(words); {
+		end := start + 1
+		for end < len(words) && ops[end] == ops[start] {
+			end++
+		}
+		segs = append(segs, DiffSegment{Op: ops[start], Text: strings.Join(words[start:end], "")})
+		start = end
+	}
+	return segs
+}
+
+// splitWords cuts s into words, runs of whitespace and single punctuation
+// characters, so that joining the pieces gives s back.
+func splitWords(s string) []string {
+	var (
+		words []string
+		start = -1
+		class = 0
+	)
+	classOf := func(r rune) int {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			return 1
+		case unicode.IsSpace(r):
+			return 2
+		default:
+			return 3
+		}
+	}
+	for i, r := range s {
+		c := classOf(r)
+		if start >= 0 && (c != class || c == 3) {
+			words = append(words, s[start:i])
+			start = -1
+		}
+		if start < 0 {
+			start, class = i, c
+		}
+	}
+	if start >= 0 {
+		words = append(words, s[start:])
+	}
+	return words
+}
+
+// unified renders hunks in unified diff format.
+func unified(from, to Article, hunks []Hunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s@%d\n", from.Slug, from.Rev)
+	fmt.Fprintf(&b, "+++ b/%s@%d\n", to.Slug, to.Rev)
+	for _, h := range hunks {
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(h.FromLine, h.FromCount), hunkRange(h.ToLine, h.ToCount))
+		for _, line := range h.Lines {
+			switch line.Op {
+			case DiffEqual:
+				b.WriteByte(' ')
+			case DiffDelete:
+				b.WriteByte('-')
+			case DiffInsert:
+				b.WriteByte('+')
+			}
+			b.WriteString(line.Text)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// hunkRange formats a hunk range like diff(1): an empty range points at the
+// line before it.
+func hunkRange(line, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", line-1)
+	case 1:
+		return fmt.Sprintf("%d", line)
+	default:
+		return fmt.Sprintf("%d,%d", line, count)
+	}
+}
@@ -0,0 +1,126 @@
+package articles
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffSeq(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []string{"a", "b", "c", "d"}
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(12))
+		for i := range lines {
+			lines[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		return lines
+	}
+	for round := 0; round < 500; round++ {
+		a, b := randomLines(), randomLines()
+		var gotA, gotB []string
+		for _, e := range diffSeq(a, b, []int{MaxLineEdits, 2}[round%2]) {
+			switch e.op {
+			case DiffEqual:
+				gotA, gotB = append(gotA, a[e.i]), append(gotB, b[e.j])
+			case DiffDelete:
+				gotA = append(gotA, a[e.i])
+			case DiffInsert:
+				gotB = append(gotB, b[e.j])
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("script does not reproduce input: %v -> %v", a, b)
+		}
+	}
+}
+
+// TestDiffUnrelated diffs two large revisions that have nothing in common,
+// which falls back to replacing the content as a whole.
+func TestDiffUnrelated(t *testing.T) {
+	from, to := TestArticle(), TestArticle()
+	var a, b strings.Builder
+	for i := 0; i < 50_000; i++ {
+		fmt.Fprintf(&a, "old line %d\n", i)
+		fmt.Fprintf(&b, "new line %d %s\n", i, strings.Repeat("word ", i%50))
+	}
+	from.Content, to.Content = "same start\n"+a.String()+"same end", "same start\n"+b.String()+"same end"
+
+	diff := DiffRevisions(from, to)
+	if len(diff.Content.Hunks) != 1 {
+		t.Fatalf("expected one hunk, got %d", len(diff.Content.Hunks))
+	}
+	h := diff.Content.Hunks[0]
+	if h.FromCount != 50_002 || h.ToCount != 50_002 || h.Lines[0].Op != DiffEqual || h.Lines[1].Op != DiffDelete {
+		t.Errorf("unexpected hunk %d,%d starting %+v", h.FromCount, h.ToCount, h.Lines[:2])
+	}
+}
+
+func TestDiffRevisions(t *testing.T) {
+	from := TestArticle()
+	from.Rev = 4
+	to := from
+	to.Rev = 9
+	to.Title = "Tested Article"
+	to.Tags = []string{"test", "go"}
+	to.Content = strings.Replace(from.Content, "- Item 2", "- Item two", 1) + "\n\nA new closing paragraph."
+
+	diff := DiffRevisions(from, to)
+	if diff.From != 4 || diff.To != 9 {
+		t.Errorf("unexpected revisions %d..%d", diff.From, diff.To)
+	}
+
+	fields := map[string]FieldChange{}
+	for _, f := range diff.Fields {
+		fields[f.Field] = f
+	}
+	if len(fields) != 2 || fields["title"].To != "Tested Article" {
+		t.Errorf("expected title and tags to change, got %+v", diff.Fields)
+	}
+	if tags := fields["tags"]; !reflect.DeepEqual(tags.Added, []string{"go"}) || !reflect.DeepEqual(tags.Removed, []string{"test2"}) {
+		t.Errorf("unexpected tag change %+v", tags)
+	}
+
+	want := strings.Join([]string{
+		"--- a/test-article@4",
+		"+++ b/test-article@9",
+		"@@ -9,10 +9,12 @@",
+		" ",
+		" - Item 1",
+		" ",
+		"-- Item 2",
+		"+- Item two",
+		" ",
+		" - Item 3",
+		" ",
+		"   - Item 3.1",
+		"   - Item 3.2",
+		"   - Item 3.3",
+		"+",
+		"+A new closing paragraph.",
+		"",
+	}, "\n")
+	if diff.Content.Unified != want {
+		t.Errorf("unexpected unified diff\n--- got ---\n%s\n--- want ---\n%s", diff.Content.Unified, want)
+	}
+
+	var changed []DiffLine
+	for _, line := range diff.Content.Hunks[0].Lines {
+		if line.Op != DiffEqual {
+			changed = append(changed, line)
+		}
+	}
+	wantWords := [][]DiffSegment{
+		{{DiffEqual, "- Item "}, {DiffDelete, "2"}},
+		{{DiffEqual, "- Item "}, {DiffInsert, "two"}},
+	}
+	if len(changed) != 4 || !reflect.DeepEqual(changed[0].Words, wantWords[0]) || !reflect.DeepEqual(changed[1].Words, wantWords[1]) {
+		t.Errorf("unexpected word diff %+v", changed)
+	}
+
+	if same := DiffRevisions(from, from); len(same.Fields) != 0 || len(same.Content.Hunks) != 0 || same.Content.Unified != "" {
+		t.Errorf("expected an empty diff, got %+v", same)
+	}
+}
@@ -0,0 +1,193 @@
+package articles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"jst_dev/server/jst_log"
+)
+
+// --- LIFECYCLE ---
+
+// Status is where an article is in its lifecycle.
+type Status string
+
+const (
+	StatusDraft     Status = "draft"     // only visible to editors
+	StatusScheduled Status = "scheduled" // goes live at PublishedAt
+	StatusPublished Status = "published" // visible to everyone
+	StatusArchived  Status = "archived"  // taken down, kept for editors
+)
+
+// SubjectPublished is where articles are announced when a write puts them
+// live: a save that publishes, an import or the scheduler.
+const SubjectPublished = "article.event.published"
+
+// PublishedEvent is the payload sent on SubjectPublished.
+type PublishedEvent struct {
+	Id          uuid.UUID `json:"id"`
+	Rev         uint64    `json:"revision"`
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	PublishedAt int       `json:"published_at"`
+}
+
+// ParseStatus validates a status string.
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(s); st {
+	case StatusDraft, StatusScheduled, StatusPublished, StatusArchived:
+		return st, nil
+	default:
+		return "", fmt.Errorf("unknown status: %q", s)
+	}
+}
+
+// CurrentStatus is the status of the article at now. Scheduled articles
+// count as published from PublishedAt on, even before the scheduler has
+// flipped them. Articles stored before Status existed are published if they
+// have a publish time that has passed and drafts otherwise.
+func (a Article) CurrentStatus(now time.Time) Status {
+	switch a.Status {
+	case "":
+		if a.PublishedAt > 0 && int64(a.PublishedAt) <= now.UnixMilli() {
+			return StatusPublished
+		}
+		return StatusDraft
+	case StatusScheduled:
+		if int64(a.PublishedAt) <= now.UnixMilli() {
+			return StatusPublished
+		}
+		return StatusScheduled
+	default:
+		return a.Status
+	}
+}
+
+// IsPublic reports whether readers without edit rights may see the article.
+func (a Article) IsPublic(now time.Time) bool {
+	return a.CurrentStatus(now) == StatusPublished
+}
+
+// StatusFor is the status a publish time stands for, as the editor sets it
+// without a status: no time is a draft, a past one published and a future
+// one scheduled.
+func StatusFor(publishedAt int, now time.Time) Status {
+	switch {
+	case publishedAt == 0:
+		return StatusDraft
+	case int64(publishedAt) <= now.UnixMilli():
+		return StatusPublished
+	default:
+		return StatusScheduled
+	}
+}
+
+// PrepareStatus validates the lifecycle fields of an article about to be
+// saved and fills in the blanks: a missing status is resolved from
+// PublishedAt (see StatusFor) and publishing without a publish time
+// publishes now. Scheduling needs a publish time in the future.
+func PrepareStatus(art *Article, now time.Time) error {
+	if art.Status == "" {
+		art.Status = StatusFor(art.PublishedAt, now)
+	}
+	if _, err := ParseStatus(string(art.Status)); err != nil {
+		return err
+	}
+	switch art.Status {
+	case StatusPublished:
+		if art.PublishedAt == 0 {
+			art.PublishedAt = int(now.UnixMilli())
+		}
+	case StatusScheduled:
+		if int64(art.PublishedAt) <= now.UnixMilli() {
+			return fmt.Errorf("scheduled articles need a publish time in the future")
+		}
+	}
+	return nil
+}
+
+// --- SCHEDULER ---
+
+// Schedule publishes scheduled articles once their PublishedAt has passed,
+// checking every interval until ctx is done. Every instance may run it, the
+// compare-and-swap in Update makes sure each article is flipped (and
+// announced) once.
+func Schedule(ctx context.Context, repo ArticleRepo, l *jst_log.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.Debug("context done, stopping")
+			return
+		case now := <-ticker.C:
+			n, err := publishDue(repo, l, now)
+			if err != nil {
+				l.Error("publish due articles: %v", err)
+			}
+			if n > 0 {
+				l.Info("published %d scheduled articles", n)
+			}
+		}
+	}
+}
+
+// publishDue flips every scheduled article due at now to published and
+// returns how many it flipped. Update announces them.
+func publishDue(repo ArticleRepo, l *jst_log.Logger, now time.Time) (int, error) {
+	all, err := repo.AllNoContent()
+	if err != nil {
+		return 0, fmt.Errorf("list articles: %w", err)
+	}
+	published := 0
+	for _, meta := range all {
+		if meta.Status != StatusScheduled || int64(meta.PublishedAt) > now.UnixMilli() {
+			continue
+		}
+		art, err := repo.Get(meta.Id)
+		if err != nil {
+			l.Error("get scheduled article %s: %v", meta.Id, err)
+			continue
+		}
+		if art.Status != StatusScheduled {
+			continue
+		}
+		art.Status = StatusPublished
+		art.RestoredFrom, art.RestoredBy = 0, ""
+		art, err = repo.Update(art)
+		if errors.Is(err, ErrRevisionConflict) {
+			l.Debug("article %s changed while publishing, retrying next round", meta.Id)
+			continue
+		}
+		if err != nil {
+			l.Error("publish scheduled article %s: %v", meta.Id, err)
+			continue
+		}
+		published++
+	}
+	return published, nil
+}
+
+// announce sends the PublishedEvent of art, just written as published.
+// Failing to announce does not fail the write.
+func (r *articleRepo) announce(art Article) {
+	data, err := json.Marshal(PublishedEvent{
+		Id:          art.Id,
+		Rev:         art.Rev,
+		Slug:        art.Slug,
+		Title:       art.Title,
+		PublishedAt: art.PublishedAt,
+	})
+	if err != nil {
+		r.l.Error("marshal published event: %v", err)
+		return
+	}
+	if err = r.nc.Publish(SubjectPublished, data); err != nil {
+		r.l.Error("publish published event: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package articles
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/jst_log"
+)
+
+func TestCurrentStatus(t *testing.T) {
+	now := time.UnixMilli(1_750_000_000_000)
+	past := int(now.Add(-time.Hour).UnixMilli())
+	future := int(now.Add(time.Hour).UnixMilli())
+
+	for name, tc := range map[string]struct {
+		art  Article
+		want Status
+	}{
+		"legacy unpublished": {Article{}, StatusDraft},
+		"legacy published":   {Article{PublishedAt: past}, StatusPublished},
+		"legacy future":      {Article{PublishedAt: future}, StatusDraft},
+		"draft with date":    {Article{Status: StatusDraft, PublishedAt: past}, StatusDraft},
+		"scheduled not due":  {Article{Status: StatusScheduled, PublishedAt: future}, StatusScheduled},
+		"scheduled due":      {Article{Status: StatusScheduled, PublishedAt: past}, StatusPublished},
+		"archived":           {Article{Status: StatusArchived, PublishedAt: past}, StatusArchived},
+		"published":          {Article{Status: StatusPublished, PublishedAt: past}, StatusPublished},
+	} {
+		if got := tc.art.CurrentStatus(now); got != tc.want {
+			t.Errorf("%s: expected %s, got %s", name, tc.want, got)
+		}
+	}
+}
+
+func TestPrepareStatus(t *testing.T) {
+	now := time.UnixMilli(1_750_000_000_000)
+
+	art := Article{Status: StatusPublished}
+	if err := PrepareStatus(&art, now); err != nil {
+		t.Fatalf("prepare published: %v", err)
+	}
+	if art.PublishedAt != int(now.UnixMilli()) {
+		t.Errorf("expected publish time to be set to now, got %d", art.PublishedAt)
+	}
+
+	past := int(now.Add(-time.Minute).UnixMilli())
+	for _, publishedAt := range []int{0, past} {
+		art = Article{Status: StatusScheduled, PublishedAt: publishedAt}
+		if err := PrepareStatus(&art, now); err == nil {
+			t.Errorf("expected scheduling at %d to fail", publishedAt)
+		}
+	}
+
+	future := int(now.Add(time.Minute).UnixMilli())
+	art = Article{Status: StatusScheduled, PublishedAt: future}
+	if err := PrepareStatus(&art, now); err != nil || art.Status != StatusScheduled || art.PublishedAt != future {
+		t.Errorf("expected a future article to stay scheduled, got %s at %d (%v)", art.Status, art.PublishedAt, err)
+	}
+
+	// without a status the publish time decides
+	for publishedAt, want := range map[int]Status{0: StatusDraft, past: StatusPublished, future: StatusScheduled} {
+		art = Article{PublishedAt: publishedAt}
+		if err := PrepareStatus(&art, now); err != nil || art.Status != want || art.PublishedAt != publishedAt {
+			t.Errorf("published at %d: expected %s, got %s at %d (%v)", publishedAt, want, art.Status, art.PublishedAt, err)
+		}
+	}
+
+	art = Article{Status: "live"}
+	if err := PrepareStatus(&art, now); err == nil {
+		t.Error("expected unknown status to fail")
+	}
+}
+
+func TestPublishDue(t *testing.T) {
+	nc := testConn(t)
+	l := jst_log.NewLogger("test", jst_log.DefaultSubjects())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo, err := Repo(ctx, nc, l)
+	if err != nil {
+		t.Fatalf("repo: %v", err)
+	}
+
+	events := make(chan *nats.Msg, 4)
+	sub, err := nc.ChanSubscribe(SubjectPublished, events)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	now := time.Now()
+	due := TestArticle()
+	due.Status = StatusScheduled
+	due.PublishedAt = int(now.Add(-time.Second).UnixMilli())
+	due, err = repo.Create(due)
+	if err != nil {
+		t.Fatalf("create due: %v", err)
+	}
+	later := NatsAllTheWayDown()
+	later.Status = StatusScheduled
+	later.PublishedAt = int(now.Add(time.Hour).UnixMilli())
+	if _, err = repo.Create(later); err != nil {
+		t.Fatalf("create later: %v", err)
+	}
+
+	n, err := publishDue(repo, l, now)
+	if err != nil {
+		t.Fatalf("publish due: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 published article, got %d", n)
+	}
+	got, err := repo.Get(due.Id)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.Status != StatusPublished || got.Rev <= due.Rev {
+		t.Errorf("expected a new published revision, got %s at rev %d", got.Status, got.Rev)
+	}
+
+	select {
+	case msg := <-events:
+		var ev PublishedEvent
+		if err := json.Unmarshal(msg.Data, &ev); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		if ev.Id != due.Id || ev.Rev != got.Rev {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a published event")
+	}
+
+	// nothing left to do
+	if n, _ = publishDue(repo, l, now); n != 0 {
+		t.Errorf("expected nothing to publish, got %d", n)
+	}
+}
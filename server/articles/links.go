@@ -0,0 +1,376 @@
+package articles
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/jst_log"
+)
+
+// --- LINK INDEX ---
+
+// LinkKind is what an internal link points at.
+type LinkKind string
+
+const (
+	LinkArticle LinkKind = "article" // /article/{slug}
+	LinkShort   LinkKind = "short"   // /u/{code}, u.jst.dev/{code} or url.jst.dev/{code}
+	LinkPage    LinkKind = "page"    // any other page of the site
+)
+
+// Problems the link checker reports.
+const (
+	ProblemMissingArticle = "missing_article" // no article has the slug
+	ProblemUnpublished    = "unpublished"     // a public article links to one that is not
+	ProblemDeadShortCode  = "dead_short_code" // the short url is gone or inactive
+	ProblemUnknownPage    = "unknown_page"    // the site has no such page
+)
+
+var (
+	// SiteHosts are the hosts whose absolute links count as internal.
+	SiteHosts = []string{"jst.dev", "www.jst.dev"}
+	// ShortHosts serve short urls at their root.
+	ShortHosts = []string{"u.jst.dev", "url.jst.dev"}
+	// SitePages are the pages of the frontend that do not belong to an
+	// article.
+	SitePages = []string{"/", "/articles", "/about", "/djot-demo", "/url", "/ui-components", "/push-me", "/profile", "/debug"}
+	// sitePrefixes are served by the server itself and are not checked.
+	sitePrefixes = []string{"/media/", "/feed/", "/api/", "/static/", "/url/"}
+)
+
+// InternalLink is a link of an article to somewhere on the site. Target is
+// the slug, the short code or the path of the page, depending on Kind.
+type InternalLink struct {
+	Dest   string   `json:"dest"` // as written in the content
+	Kind   LinkKind `json:"kind"`
+	Target string   `json:"target"`
+}
+
+// ParseInternal reads a link destination. Links off the site, and to what
+// the server serves itself (media, feeds, the api), are not internal.
+func ParseInternal(dest string) (InternalLink, bool) {
+	link := InternalLink{Dest: dest}
+	u, err := url.Parse(strings.TrimSpace(dest))
+	if err != nil || u.Opaque != "" {
+		return link, false
+	}
+	path := u.Path
+	switch {
+	case u.Scheme == "" && u.Host == "":
+		if !strings.HasPrefix(path, "/") {
+			return link, false // relative, resolved by the reader's browser
+		}
+	case u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https":
+		return link, false
+	case slices.Contains(ShortHosts, strings.ToLower(u.Hostname())):
+		code := strings.Trim(path, "/")
+		if code == "" || strings.Contains(code, "/") {
+			return link, false
+		}
+		link.Kind, link.Target = LinkShort, code
+		return link, true
+	case !slices.Contains(SiteHosts, strings.ToLower(u.Hostname())):
+		return link, false
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case segments[0] == "article" && len(segments) > 1 && segments[1] != "":
+		link.Kind, link.Target = LinkArticle, segments[1]
+	case segments[0] == "u" && len(segments) == 2 && segments[1] != "":
+		link.Kind, link.Target = LinkShort, segments[1]
+	case slices.ContainsFunc(sitePrefixes, func(prefix string) bool { return strings.HasPrefix(path, prefix) }):
+		return link, false
+	default:
+		if path != "/" {
+			path = strings.TrimSuffix(path, "/")
+		}
+		link.Kind, link.Target = LinkPage, path
+	}
+	return link, true
+}
+
+// InternalLinks returns the internal links among the derived links of art.
+func (art Article) InternalLinks() []InternalLink {
+	links := []InternalLink{}
+	for _, dest := range art.Derived.Links {
+		if link, ok := ParseInternal(dest); ok {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// Backlink is an article linking to another one.
+type Backlink struct {
+	Id    uuid.UUID `json:"id"`
+	Slug  string    `json:"slug"`
+	Title string    `json:"title"`
+}
+
+// BrokenLink is an internal link that leads nowhere, with the article it is
+// in.
+type BrokenLink struct {
+	InternalLink
+	ArticleId    uuid.UUID `json:"article_id"`
+	ArticleSlug  string    `json:"article_slug"`
+	ArticleTitle string    `json:"article_title"`
+	Problem      string    `json:"problem"`
+}
+
+// LinkReport is the result of a check of every internal link.
+type LinkReport struct {
+	CheckedAt int          `json:"checked_at"` // unix ms
+	Articles  int          `json:"articles"`   // articles with internal links
+	Links     int          `json:"links"`
+	Broken    []BrokenLink `json:"broken"`
+}
+
+// LinkIndex keeps the internal links of every article, so that an article
+// can list who links to it. It is kept current from the article bucket by
+// Watch, from the links derived on save, and holds article metadata only.
+type LinkIndex struct {
+	lock     sync.RWMutex
+	articles map[uuid.UUID]Article // without content
+	links    map[uuid.UUID][]InternalLink
+	bySlug   map[string]uuid.UUID
+	toSlug   map[string]map[uuid.UUID]struct{} // slug linked to -> articles linking
+}
+
+func NewLinkIndex() *LinkIndex {
+	return &LinkIndex{
+		articles: map[uuid.UUID]Article{},
+		links:    map[uuid.UUID][]InternalLink{},
+		bySlug:   map[string]uuid.UUID{},
+		toSlug:   map[string]map[uuid.UUID]struct{}{},
+	}
+}
+
+// Put adds or replaces the links of art.
+func (x *LinkIndex) Put(art Article) {
+	art.Content = ""
+	links := art.InternalLinks()
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.remove(art.Id)
+	x.articles[art.Id] = art
+	x.links[art.Id] = links
+	if art.Slug != "" {
+		x.bySlug[art.Slug] = art.Id
+	}
+	for _, link := range links {
+		if link.Kind != LinkArticle {
+			continue
+		}
+		ids, ok := x.toSlug[link.Target]
+		if !ok {
+			ids = map[uuid.UUID]struct{}{}
+			x.toSlug[link.Target] = ids
+		}
+		ids[art.Id] = struct{}{}
+	}
+}
+
+// Remove drops the article from the index. Links to it stay, and are broken
+// from now on.
+func (x *LinkIndex) Remove(id uuid.UUID) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.remove(id)
+}
+
+func (x *LinkIndex) remove(id uuid.UUID) {
+	old, ok := x.articles[id]
+	if !ok {
+		return
+	}
+	for _, link := range x.links[id] {
+		if link.Kind != LinkArticle {
+			continue
+		}
+		delete(x.toSlug[link.Target], id)
+		if len(x.toSlug[link.Target]) == 0 {
+			delete(x.toSlug, link.Target)
+		}
+	}
+	if x.bySlug[old.Slug] == id {
+		delete(x.bySlug, old.Slug)
+	}
+	delete(x.links, id)
+	delete(x.articles, id)
+}
+
+// LinkedFrom returns the articles linking to art by its slug or its id,
+// ordered by title. Only articles keep accepts are listed.
+func (x *LinkIndex) LinkedFrom(art Article, keep func(Article) bool) []Backlink {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	ids := map[uuid.UUID]struct{}{}
+	for _, target := range []string{art.Slug, art.Id.String()} {
+		for id := range x.toSlug[target] {
+			ids[id] = struct{}{}
+		}
+	}
+	backlinks := []Backlink{}
+	for id := range ids {
+		from := x.articles[id]
+		if id == art.Id || !keep(from) {
+			continue
+		}
+		backlinks = append(backlinks, Backlink{Id: from.Id, Slug: from.Slug, Title: from.Title})
+	}
+	slices.SortFunc(backlinks, func(a, b Backlink) int {
+		if c := strings.Compare(a.Title, b.Title); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Slug, b.Slug)
+	})
+	return backlinks
+}
+
+// Check looks at every internal link of every article. Links to articles and
+// pages are checked against the index, short codes with shortCodeAlive,
+// which is asked once per code. A failing shortCodeAlive fails the check.
+func (x *LinkIndex) Check(now time.Time, shortCodeAlive func(code string) (bool, error)) (LinkReport, error) {
+	report := LinkReport{CheckedAt: int(now.UnixMilli()), Broken: []BrokenLink{}}
+	x.lock.RLock()
+	type linked struct {
+		from Article
+		link InternalLink
+	}
+	var all []linked
+	for id, links := range x.links {
+		if len(links) > 0 {
+			report.Articles++
+		}
+		for _, link := range links {
+			all = append(all, linked{from: x.articles[id], link: link})
+		}
+	}
+	bySlug := make(map[string]Article, len(x.bySlug))
+	for slug, id := range x.bySlug {
+		bySlug[slug] = x.articles[id]
+	}
+	for _, art := range x.articles {
+		bySlug[art.Id.String()] = art
+	}
+	x.lock.RUnlock()
+
+	// short codes are asked for without holding the lock
+	alive := map[string]bool{}
+	for _, l := range all {
+		report.Links++
+		problem := ""
+		switch l.link.Kind {
+		case LinkArticle:
+			target, ok := bySlug[l.link.Target]
+			if !ok {
+				problem = ProblemMissingArticle
+			} else if l.from.IsPublic(now) && !target.IsPublic(now) {
+				problem = ProblemUnpublished
+			}
+		case LinkShort:
+			ok, checked := alive[l.link.Target]
+			if !checked {
+				var err error
+				if ok, err = shortCodeAlive(l.link.Target); err != nil {
+					return report, fmt.Errorf("check short code %q: %w", l.link.Target, err)
+				}
+				alive[l.link.Target] = ok
+			}
+			if !ok {
+				problem = ProblemDeadShortCode
+			}
+		case LinkPage:
+			if !slices.Contains(SitePages, l.link.Target) {
+				problem = ProblemUnknownPage
+			}
+		}
+		if problem != "" {
+			report.Broken = append(report.Broken, BrokenLink{
+				InternalLink: l.link,
+				ArticleId:    l.from.Id,
+				ArticleSlug:  l.from.Slug,
+				ArticleTitle: l.from.Title,
+				Problem:      problem,
+			})
+		}
+	}
+	slices.SortFunc(report.Broken, func(a, b BrokenLink) int {
+		if c := strings.Compare(a.ArticleSlug, b.ArticleSlug); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Dest, b.Dest)
+	})
+	return report, nil
+}
+
+// Len returns the number of indexed articles.
+func (x *LinkIndex) Len() int {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+	return len(x.articles)
+}
+
+// Watch keeps the index in sync with the article bucket until ctx is done.
+func (x *LinkIndex) Watch(ctx context.Context, repo ArticleRepo, l *jst_log.Logger) error {
+	watcher, err := repo.WatchAll()
+	if err != nil {
+		return fmt.Errorf("watch articles: %w", err)
+	}
+
+	go func() {
+		defer func() {
+			if err := watcher.Stop(); err != nil {
+				l.Warn("stop watcher: %v", err)
+			}
+		}()
+		for {
+			select {
+			case entry, ok := <-watcher.Updates():
+				if !ok {
+					l.Warn("watcher: channel closed")
+					return
+				}
+				if entry == nil {
+					l.Info("up to date. links of %d articles indexed", x.Len())
+					continue
+				}
+				id, err := uuid.Parse(entry.Key())
+				if err != nil {
+					l.Error("invalid article key %q: %s", entry.Key(), err.Error())
+					continue
+				}
+				switch entry.Operation() {
+				case jetstream.KeyValuePut:
+					art, err := DecodeEntry(entry)
+					if err != nil {
+						l.Error("failed to decode article: %s", err.Error())
+						continue
+					}
+					art.Id = id
+					art.Rev = entry.Revision()
+					art.UpdatedAt = int(entry.Created().UnixMilli())
+					x.Put(art)
+				case jetstream.KeyValueDelete, jetstream.KeyValuePurge:
+					x.Remove(id)
+				}
+			case <-ctx.Done():
+				l.Debug("watcher: context done")
+				return
+			}
+		}
+	}()
+	return nil
+}
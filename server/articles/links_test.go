@@ -0,0 +1,111 @@
+package articles
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseInternal(t *testing.T) {
+	cases := []struct {
+		dest   string
+		ok     bool
+		kind   LinkKind
+		target string
+	}{
+		{"/article/hello-world", true, LinkArticle, "hello-world"},
+		{"https://jst.dev/article/hello-world/edit?x=1#top", true, LinkArticle, "hello-world"},
+		{"/u/abc123", true, LinkShort, "abc123"},
+		{"https://u.jst.dev/abc123", true, LinkShort, "abc123"},
+		{"/articles/", true, LinkPage, "/articles"},
+		{"/404_not-found", true, LinkPage, "/404_not-found"},
+		{"https://www.jst.dev", true, LinkPage, "/"},
+		{"/media/abc/960", false, "", ""},
+		{"/feed/articles.xml", false, "", ""},
+		{"https://gleam.run", false, "", ""},
+		{"//gleam.run/news", false, "", ""},
+		{"mailto:me@jst.dev", false, "", ""},
+		{"other-article", false, "", ""},
+	}
+	for _, c := range cases {
+		link, ok := ParseInternal(c.dest)
+		if ok != c.ok || link.Kind != c.kind || link.Target != c.target {
+			t.Errorf("%q: got %+v, %v", c.dest, link, ok)
+		}
+	}
+}
+
+func TestLinkIndex(t *testing.T) {
+	now := time.Now()
+	article := func(slug string, status Status, links ...string) Article {
+		return Article{Id: uuid.New(), Slug: slug, Title: slug, Status: status, PublishedAt: 1, Derived: Derived{Links: links}}
+	}
+	target := article("target", StatusPublished)
+	draft := article("draft", StatusDraft, "/article/target")
+	linking := article("linking", StatusPublished,
+		"/article/target", "/article/draft", "/article/gone", "/u/alive", "/u/dead", "/about", "/404_not-found", "https://gleam.run")
+	byId := article("by-id", StatusPublished, "/article/"+target.Id.String()+"/edit")
+
+	index := NewLinkIndex()
+	for _, art := range []Article{target, draft, linking, byId} {
+		index.Put(art)
+	}
+
+	all := func(Article) bool { return true }
+	if got := index.LinkedFrom(target, all); len(got) != 3 || got[0].Slug != "by-id" || got[2].Slug != "linking" {
+		t.Errorf("unexpected backlinks %+v", got)
+	}
+	public := func(art Article) bool { return art.IsPublic(now) }
+	if got := index.LinkedFrom(target, public); len(got) != 2 {
+		t.Errorf("expected the draft to be left out, got %+v", got)
+	}
+
+	asked := map[string]int{}
+	alive := func(code string) (bool, error) {
+		asked[code]++
+		return code == "alive", nil
+	}
+	report, err := index.Check(now, alive)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	problems := map[string]string{}
+	for _, broken := range report.Broken {
+		problems[broken.ArticleSlug+" "+broken.Dest] = broken.Problem
+	}
+	want := map[string]string{
+		"linking /article/draft": ProblemUnpublished,
+		"linking /article/gone":  ProblemMissingArticle,
+		"linking /u/dead":        ProblemDeadShortCode,
+		"linking /404_not-found": ProblemUnknownPage,
+	}
+	if len(problems) != len(want) {
+		t.Errorf("unexpected broken links %+v", report.Broken)
+	}
+	for link, problem := range want {
+		if problems[link] != problem {
+			t.Errorf("%s: got %q, want %q", link, problems[link], problem)
+		}
+	}
+	if report.Articles != 3 || report.Links != 9 || asked["alive"] != 1 {
+		t.Errorf("unexpected report %+v, asked %v", report, asked)
+	}
+
+	// removing an article drops its backlinks and breaks the links to it
+	index.Remove(linking.Id)
+	index.Remove(target.Id)
+	if got := index.LinkedFrom(target, all); len(got) != 2 {
+		t.Errorf("expected two backlinks left, got %+v", got)
+	}
+	if report, _ = index.Check(now, alive); len(report.Broken) != 2 || report.Broken[0].Problem != ProblemMissingArticle {
+		t.Errorf("expected the links to the removed article to break, got %+v", report.Broken)
+	}
+
+	failing := func(string) (bool, error) { return false, errors.New("no responders") }
+	index.Put(linking)
+	if _, err = index.Check(now, failing); err == nil {
+		t.Errorf("expected a failing short code lookup to fail the check")
+	}
+}
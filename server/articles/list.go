@@ -0,0 +1,271 @@
+package articles
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// --- LISTING ---
+
+// SortField is what article listings can be ordered by.
+type SortField string
+
+const (
+	SortPublished SortField = "published_at"
+	SortTitle     SortField = "title"
+	SortRevision  SortField = "revision"
+)
+
+// MaxListLimit caps the page size of a listing.
+const MaxListLimit = 100
+
+// ErrInvalidQuery is wrapped by every error about a malformed ListQuery.
+var ErrInvalidQuery = errors.New("invalid list query")
+
+// ListQuery selects, orders and pages article listings. It is shared by the
+// HTTP api (see ParseListQuery) and the websocket, which sends it as json.
+type ListQuery struct {
+	Sort     SortField `json:"sort,omitempty"`  // default published_at
+	Order    string    `json:"order,omitempty"` // asc or desc, default asc for title and desc otherwise
+	Author   string    `json:"author,omitempty"`
+	Lang     string    `json:"lang,omitempty"` // articles in Lang, default the sources only
+	Status   []Status  `json:"status,omitempty"`
+	From     int       `json:"from,omitempty"` // published at or after (unix ms)
+	To       int       `json:"to,omitempty"`   // published before (unix ms)
+	Tags     []string  `json:"tags,omitempty"`
+	MatchAny bool      `json:"match_any,omitempty"` // any instead of all of Tags
+	Limit    int       `json:"limit,omitempty"`     // 0 returns everything
+	Cursor   string    `json:"cursor,omitempty"`    // NextCursor of the previous page
+
+	IncludeHidden bool               `json:"-"` // list articles that are not public at Now
+	CanRead       func(Article) bool `json:"-"` // optional, lists the hidden articles it accepts
+	Now           time.Time          `json:"-"`
+}
+
+// ListPage is one page of a listing. NextCursor is empty on the last page.
+type ListPage struct {
+	Articles   []Article `json:"articles"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Total      int       `json:"total"` // matching articles over all pages
+}
+
+// listCursor is the sort key of the last article on a page. Pages continue
+// after that key rather than at an offset, so articles written between two
+// requests do not shift or repeat entries.
+type listCursor struct {
+	Sort  SortField `json:"s"`
+	Order string    `json:"o"`
+	Num   uint64    `json:"n,omitempty"`
+	Str   string    `json:"t,omitempty"`
+	Id    uuid.UUID `json:"i"`
+}
+
+// ParseListQuery reads a ListQuery from url parameters: sort, order, author,
+// lang, status (repeatable), from and to (unix ms or RFC 3339), tag (repeatable),
+// match (all or any), limit and cursor.
+func ParseListQuery(values url.Values) (ListQuery, error) {
+	q := ListQuery{
+		Sort:   SortField(values.Get("sort")),
+		Order:  values.Get("order"),
+		Author: values.Get("author"),
+		Lang:   values.Get("lang"),
+		Tags:   values["tag"],
+		Cursor: values.Get("cursor"),
+	}
+	for _, s := range values["status"] {
+		status, err := ParseStatus(s)
+		if err != nil {
+			return q, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+		}
+		q.Status = append(q.Status, status)
+	}
+	var err error
+	if q.From, err = parseListTime(values.Get("from")); err != nil {
+		return q, fmt.Errorf("%w: from: %w", ErrInvalidQuery, err)
+	}
+	if q.To, err = parseListTime(values.Get("to")); err != nil {
+		return q, fmt.Errorf("%w: to: %w", ErrInvalidQuery, err)
+	}
+	switch values.Get("match") {
+	case "", "all":
+	case "any":
+		q.MatchAny = true
+	default:
+		return q, fmt.Errorf("%w: match must be all or any", ErrInvalidQuery)
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return q, fmt.Errorf("%w: limit: %w", ErrInvalidQuery, err)
+		}
+	}
+	return q, q.normalize()
+}
+
+func parseListTime(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return int(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("expected unix ms or RFC 3339, got %q", s)
+	}
+	return int(t.UnixMilli()), nil
+}
+
+// normalize validates q and fills in the defaults.
+func (q *ListQuery) normalize() error {
+	switch q.Sort {
+	case "":
+		q.Sort = SortPublished
+	case SortPublished, SortTitle, SortRevision:
+	default:
+		return fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, q.Sort)
+	}
+	switch q.Order {
+	case "":
+		q.Order = "desc"
+		if q.Sort == SortTitle {
+			q.Order = "asc"
+		}
+	case "asc", "desc":
+	default:
+		return fmt.Errorf("%w: order must be asc or desc", ErrInvalidQuery)
+	}
+	if q.Limit < 0 || q.Limit > MaxListLimit {
+		return fmt.Errorf("%w: limit must be between 0 and %d", ErrInvalidQuery, MaxListLimit)
+	}
+	if q.Now.IsZero() {
+		q.Now = time.Now()
+	}
+	return nil
+}
+
+// List filters, sorts and pages arts according to q.
+func List(arts []Article, q ListQuery) (ListPage, error) {
+	if err := q.normalize(); err != nil {
+		return ListPage{}, err
+	}
+	var after *listCursor
+	if q.Cursor != "" {
+		c, err := decodeListCursor(q.Cursor)
+		if err != nil {
+			return ListPage{}, err
+		}
+		if c.Sort != q.Sort || c.Order != q.Order {
+			return ListPage{}, fmt.Errorf("%w: cursor belongs to a listing sorted by %s %s", ErrInvalidQuery, c.Sort, c.Order)
+		}
+		after = &c
+	}
+
+	matched := make([]Article, 0, len(arts))
+	for _, art := range arts {
+		if q.matches(art) {
+			matched = append(matched, art)
+		}
+	}
+	slices.SortFunc(matched, func(a, b Article) int {
+		return q.compare(q.cursorOf(a), q.cursorOf(b))
+	})
+
+	page := ListPage{Articles: matched, Total: len(matched)}
+	if after != nil {
+		start, _ := slices.BinarySearchFunc(matched, *after, func(art Article, c listCursor) int {
+			if n := q.compare(q.cursorOf(art), c); n != 0 {
+				return n
+			}
+			return -1 // the cursor article itself belongs to the previous page
+		})
+		page.Articles = matched[start:]
+	}
+	if q.Limit > 0 && len(page.Articles) > q.Limit {
+		page.Articles = page.Articles[:q.Limit]
+		page.NextCursor = encodeListCursor(q.cursorOf(page.Articles[q.Limit-1]))
+	}
+	return page, nil
+}
+
+func (q *ListQuery) matches(art Article) bool {
+	if !q.IncludeHidden && !art.IsPublic(q.Now) && (q.CanRead == nil || !q.CanRead(art)) {
+		return false
+	}
+	if q.Author != "" && art.Author != q.Author {
+		return false
+	}
+	if q.Lang == "" && art.TranslationOf != nil {
+		return false
+	}
+	if q.Lang != "" && art.Language() != q.Lang {
+		return false
+	}
+	if len(q.Status) > 0 && !slices.Contains(q.Status, art.CurrentStatus(q.Now)) {
+		return false
+	}
+	if q.From != 0 && art.PublishedAt < q.From {
+		return false
+	}
+	if q.To != 0 && art.PublishedAt >= q.To {
+		return false
+	}
+	if len(q.Tags) > 0 {
+		has := func(tag string) bool { return slices.Contains(art.Tags, tag) }
+		if q.MatchAny && !slices.ContainsFunc(q.Tags, has) {
+			return false
+		}
+		if !q.MatchAny && slices.ContainsFunc(q.Tags, func(tag string) bool { return !has(tag) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *ListQuery) cursorOf(art Article) listCursor {
+	c := listCursor{Sort: q.Sort, Order: q.Order, Id: art.Id}
+	switch q.Sort {
+	case SortPublished:
+		c.Num = uint64(max(art.PublishedAt, 0))
+	case SortRevision:
+		c.Num = art.Rev
+	case SortTitle:
+		c.Str = strings.ToLower(art.Title)
+	}
+	return c
+}
+
+// compare orders two sort keys, ties broken by id so the order is total.
+func (q *ListQuery) compare(a, b listCursor) int {
+	n := cmp.Or(cmp.Compare(a.Num, b.Num), strings.Compare(a.Str, b.Str), strings.Compare(a.Id.String(), b.Id.String()))
+	if q.Order == "desc" {
+		return -n
+	}
+	return n
+}
+
+func encodeListCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(s string) (listCursor, error) {
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return c, nil
+}
@@ -0,0 +1,131 @@
+package articles
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func listed(page ListPage) []string {
+	titles := make([]string, len(page.Articles))
+	for i, art := range page.Articles {
+		titles[i] = art.Title
+	}
+	return titles
+}
+
+func TestList(t *testing.T) {
+	now := time.Now()
+	published := func(title string, at int, author string, tags ...string) Article {
+		return Article{Id: uuid.New(), Title: title, Status: StatusPublished, PublishedAt: at, Author: author, Tags: tags}
+	}
+	arts := []Article{
+		published("Bravo", 2000, "ann", "go"),
+		published("alpha", 1000, "bob", "go", "nats"),
+		published("Delta", 4000, "ann", "nats"),
+		published("Charlie", 3000, "bob"),
+		{Id: uuid.New(), Title: "Draft", Status: StatusDraft},
+	}
+
+	cases := []struct {
+		name  string
+		query ListQuery
+		want  []string
+	}{
+		{"newest first by default", ListQuery{}, []string{"Delta", "Charlie", "Bravo", "alpha"}},
+		{"title ignores case", ListQuery{Sort: SortTitle}, []string{"alpha", "Bravo", "Charlie", "Delta"}},
+		{"author", ListQuery{Author: "ann", Order: "asc"}, []string{"Bravo", "Delta"}},
+		{"date range", ListQuery{From: 2000, To: 4000}, []string{"Charlie", "Bravo"}},
+		{"all tags", ListQuery{Tags: []string{"go", "nats"}}, []string{"alpha"}},
+		{"any tag", ListQuery{Tags: []string{"go", "nats"}, MatchAny: true}, []string{"Delta", "Bravo", "alpha"}},
+		{"drafts for editors", ListQuery{Status: []Status{StatusDraft}, IncludeHidden: true}, []string{"Draft"}},
+		{"drafts hidden otherwise", ListQuery{Status: []Status{StatusDraft}}, []string{}},
+	}
+	for _, tc := range cases {
+		tc.query.Now = now
+		page, err := List(arts, tc.query)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := listed(page); !slices.Equal(got, tc.want) || page.Total != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestListCursor(t *testing.T) {
+	var arts []Article
+	for i, title := range []string{"a", "b", "c", "d", "e"} {
+		arts = append(arts, Article{Id: uuid.New(), Title: title, Status: StatusPublished, PublishedAt: (i + 1) * 1000})
+	}
+	query := ListQuery{Sort: SortTitle, Limit: 2}
+
+	first, err := List(arts, query)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if got := listed(first); len(got) != 2 || got[0] != "a" || got[1] != "b" || first.NextCursor == "" {
+		t.Fatalf("unexpected first page %v", got)
+	}
+
+	// an article sorting before the cursor must not shift the next page
+	arts = append(arts, Article{Id: uuid.New(), Title: "aa", Status: StatusPublished, PublishedAt: 1})
+	query.Cursor = first.NextCursor
+	second, err := List(arts, query)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if got := listed(second); len(got) != 2 || got[0] != "c" || got[1] != "d" {
+		t.Fatalf("unexpected second page %v", got)
+	}
+
+	query.Cursor = second.NextCursor
+	last, err := List(arts, query)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if got := listed(last); len(got) != 1 || got[0] != "e" || last.NextCursor != "" {
+		t.Errorf("unexpected last page %v (next %q)", got, last.NextCursor)
+	}
+
+	// cursors only continue the listing they came from
+	_, err = List(arts, ListQuery{Sort: SortRevision, Cursor: first.NextCursor})
+	if !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected invalid query for a foreign cursor, got %v", err)
+	}
+}
+
+func TestParseListQuery(t *testing.T) {
+	q, err := ParseListQuery(url.Values{
+		"sort":   {"revision"},
+		"status": {"published", "archived"},
+		"from":   {"2024-01-01T00:00:00Z"},
+		"tag":    {"go", "nats"},
+		"match":  {"any"},
+		"limit":  {"10"},
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if q.Sort != SortRevision || q.Order != "desc" || len(q.Status) != 2 || q.From != 1704067200000 || !q.MatchAny || q.Limit != 10 {
+		t.Errorf("unexpected query %+v", q)
+	}
+
+	for _, bad := range []url.Values{
+		{"sort": {"author"}},
+		{"order": {"up"}},
+		{"status": {"lost"}},
+		{"from": {"yesterday"}},
+		{"match": {"some"}},
+		{"limit": {"1000"}},
+	} {
+		if _, err := ParseListQuery(bad); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("expected %v to be invalid, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package articles
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/jst_log"
+)
+
+// --- MIGRATIONS ---
+
+// Stored articles carry the StructVersion they were written with. Reads
+// upgrade older articles in memory (Decode), the migrate job rewrites them in
+// the bucket so that the upgrade only has to happen once.
+
+// CurrentStructVersion is the StructVersion of the Article struct. Bump it
+// together with a new entry in migrations.
+const CurrentStructVersion = 4
+
+// Migration upgrades a stored article by one StructVersion. It works on the
+// decoded json rather than on Article so that it can read fields the struct
+// no longer has. Migrations must be pure: same input, same output, no clock
+// and no I/O.
+type Migration func(doc map[string]any) (map[string]any, error)
+
+// migrations holds the step from each version to the next.
+var migrations = map[int]Migration{
+	1: migrateV1,
+	2: migrateV2,
+	3: migrateV3,
+}
+
+// migrateV1 makes the lifecycle explicit and cleans up tags. Articles written
+// before Status existed were served to everyone. Those with a publish time
+// went live at it, which is what scheduled means now (the scheduler flips
+// them to published). Those without one were live all along and are
+// published, at the time they were written if the caller knows it.
+func migrateV1(doc map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(doc))
+	for k, v := range doc {
+		out[k] = v
+	}
+
+	if status, _ := out["status"].(string); status == "" {
+		publishedAt, _ := out["published_at"].(float64)
+		if publishedAt > 0 {
+			out["status"] = string(StatusScheduled)
+		} else {
+			out["status"] = string(StatusPublished)
+			if written, _ := out["updated_at"].(float64); written > 0 {
+				out["published_at"] = written
+			}
+		}
+	}
+
+	tags := []any{}
+	if raw, ok := out["tags"].([]any); ok {
+		for _, t := range raw {
+			tag, ok := t.(string)
+			if !ok {
+				return nil, fmt.Errorf("tag is not a string: %v", t)
+			}
+			tag = strings.TrimSpace(tag)
+			if tag != "" && !slices.Contains(tags, any(tag)) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	out["tags"] = tags
+	return out, nil
+}
+
+// migrateV2 adds the metadata derived from the content, which Create and
+// Update compute from then on.
+func migrateV2(doc map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(doc)+1)
+	for k, v := range doc {
+		out[k] = v
+	}
+	content, _ := out["content"].(string)
+	data, err := json.Marshal(Derive(content))
+	if err != nil {
+		return nil, fmt.Errorf("encode derived: %w", err)
+	}
+	var derived map[string]any
+	if err := json.Unmarshal(data, &derived); err != nil {
+		return nil, fmt.Errorf("decode derived: %w", err)
+	}
+	out["derived"] = derived
+	return out, nil
+}
+
+// migrateV3 gives every article a language. Everything written before
+// translations existed is in DefaultLang and is a source.
+func migrateV3(doc map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(doc)+1)
+	for k, v := range doc {
+		out[k] = v
+	}
+	if lang, _ := out["lang"].(string); lang == "" {
+		out["lang"] = DefaultLang
+	}
+	return out, nil
+}
+
+// migrate upgrades stored article json to CurrentStructVersion. written is
+// when the entry was written (unix ms, 0 if unknown), migrations read it as
+// updated_at. It returns the version it started from, data is returned as is
+// if that is current.
+func migrate(data []byte, written int) ([]byte, int, error) {
+	var head struct {
+		StructVersion int `json:"struct_version"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, 0, fmt.Errorf("read struct version: %w", err)
+	}
+	version := max(head.StructVersion, 1)
+	if version >= CurrentStructVersion {
+		return data, version, nil
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, version, fmt.Errorf("decode article: %w", err)
+	}
+	_, stored := doc["updated_at"]
+	if !stored && written > 0 {
+		doc["updated_at"] = float64(written) // as json numbers decode
+	}
+	for v := version; v < CurrentStructVersion; v++ {
+		step, ok := migrations[v]
+		if !ok {
+			return nil, version, fmt.Errorf("no migration from struct version %d", v)
+		}
+		var err error
+		if doc, err = step(doc); err != nil {
+			return nil, version, fmt.Errorf("migrate struct version %d: %w", v, err)
+		}
+		doc["struct_version"] = v + 1
+	}
+	if !stored {
+		delete(doc, "updated_at") // comes from the entry, see Get
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, version, fmt.Errorf("encode article: %w", err)
+	}
+	return data, version, nil
+}
+
+// Decode reads an article as stored in the bucket, upgrading it to
+// CurrentStructVersion on the way. Prefer DecodeEntry when the entry is at
+// hand, some migrations need to know when it was written.
+func Decode(data []byte) (Article, error) {
+	return decode(data, 0)
+}
+
+// DecodeEntry is Decode for an entry of the article bucket.
+func DecodeEntry(entry jetstream.KeyValueEntry) (Article, error) {
+	art, err := decode(entry.Value(), int(entry.Created().UnixMilli()))
+	if err == nil && art.ContentRevision == 0 {
+		art.ContentRevision = entry.Revision()
+	}
+	return art, err
+}
+
+func decode(data []byte, written int) (Article, error) {
+	var art Article
+	data, _, err := migrate(data, written)
+	if err != nil {
+		return art, err
+	}
+	if err = json.Unmarshal(data, &art); err != nil {
+		return art, fmt.Errorf("unmarshal article: %w", err)
+	}
+	return art, nil
+}
+
+// MigrationReport says what a migrate run found, and did unless DryRun.
+type MigrationReport struct {
+	DryRun    bool               `json:"dry_run"`
+	Total     int                `json:"total"`
+	Versions  map[int]int        `json:"versions"` // articles per stored struct version
+	Outdated  int                `json:"outdated"`
+	Migrated  int                `json:"migrated"`
+	Conflicts int                `json:"conflicts"` // changed while migrating, retried on the next run
+	Failed    []MigrationFailure `json:"failed"`
+}
+
+// MigrationFailure is an article a migration could not be applied to.
+type MigrationFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// Migrate rewrites every article stored with an old StructVersion in the
+// current one. Each write is a compare-and-swap against the revision that
+// was read, so concurrent edits win and are counted as conflicts. With
+// dryRun nothing is written.
+func (r *articleRepo) Migrate(dryRun bool) (MigrationReport, error) {
+	report := MigrationReport{DryRun: dryRun, Versions: map[int]int{}, Failed: []MigrationFailure{}}
+	keys, err := r.kv.ListKeys(r.ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return report, nil
+	}
+	if err != nil {
+		return report, fmt.Errorf("migrate: list keys: %w", err)
+	}
+	for key := range keys.Keys() {
+		entry, err := r.kv.Get(r.ctx, key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue // deleted since listing
+		}
+		if err != nil {
+			return report, fmt.Errorf("migrate: get %s: %w", key, err)
+		}
+		report.Total++
+		data, version, err := migrate(entry.Value(), int(entry.Created().UnixMilli()))
+		report.Versions[version]++
+		if err != nil {
+			report.Failed = append(report.Failed, MigrationFailure{Key: key, Error: err.Error()})
+			continue
+		}
+		if version >= CurrentStructVersion {
+			continue
+		}
+		report.Outdated++
+		if dryRun {
+			continue
+		}
+		// the rewrite does not change the text, see SameText
+		data, err = pinContentRevision(data, entry.Revision())
+		if err != nil {
+			report.Failed = append(report.Failed, MigrationFailure{Key: key, Error: err.Error()})
+			continue
+		}
+		_, err = r.kv.Update(r.ctx, key, data, entry.Revision())
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			report.Conflicts++
+			continue
+		}
+		if err != nil {
+			report.Failed = append(report.Failed, MigrationFailure{Key: key, Error: err.Error()})
+			continue
+		}
+		report.Migrated++
+	}
+	return report, nil
+}
+
+// pinContentRevision sets content_revision in the stored article data to
+// rev, unless it is set already.
+func pinContentRevision(data []byte, rev uint64) ([]byte, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("unmarshal article: %w", err)
+	}
+	if _, ok := doc["content_revision"]; ok {
+		return data, nil
+	}
+	doc["content_revision"] = rev
+	return json.Marshal(doc)
+}
+
+// MigrateBucket runs the migrate job once and logs the outcome. It is meant
+// to be started in the background when the server starts.
+func MigrateBucket(repo ArticleRepo, l *jst_log.Logger) {
+	report, err := repo.Migrate(false)
+	if err != nil {
+		l.Error("migrate articles: %v", err)
+		return
+	}
+	if report.Outdated == 0 && len(report.Failed) == 0 {
+		l.Debug("all %d articles at struct version %d", report.Total, CurrentStructVersion)
+		return
+	}
+	l.Info("migrated %d of %d outdated articles to struct version %d (%d conflicts, %d failed)",
+		report.Migrated, report.Outdated, CurrentStructVersion, report.Conflicts, len(report.Failed))
+	for _, f := range report.Failed {
+		l.Error("migrate article %s: %s", f.Key, f.Error)
+	}
+}
@@ -0,0 +1,199 @@
+package articles
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMigrationRegistry(t *testing.T) {
+	for v := 1; v < CurrentStructVersion; v++ {
+		if migrations[v] == nil {
+			t.Errorf("no migration from struct version %d", v)
+		}
+	}
+}
+
+func TestMigrateV1(t *testing.T) {
+	cases := []struct {
+		name string
+		in   map[string]any
+		want map[string]any
+	}{
+		{
+			"without publish time it was live, published when written",
+			map[string]any{"title": "a", "published_at": float64(0), "updated_at": float64(1700000000000)},
+			map[string]any{"title": "a", "published_at": float64(1700000000000), "updated_at": float64(1700000000000), "status": "published", "tags": []any{}},
+		},
+		{
+			"without publish time nor write time it is still published",
+			map[string]any{"title": "a", "published_at": float64(0)},
+			map[string]any{"title": "a", "published_at": float64(0), "status": "published", "tags": []any{}},
+		},
+		{
+			"published becomes scheduled",
+			map[string]any{"published_at": float64(1700000000000), "tags": nil},
+			map[string]any{"published_at": float64(1700000000000), "status": "scheduled", "tags": []any{}},
+		},
+		{
+			"status is kept",
+			map[string]any{"status": "archived", "published_at": float64(1)},
+			map[string]any{"status": "archived", "published_at": float64(1), "tags": []any{}},
+		},
+		{
+			"tags are trimmed and deduplicated",
+			map[string]any{"status": "draft", "tags": []any{" go", "nats", "", "go "}},
+			map[string]any{"status": "draft", "tags": []any{"go", "nats"}},
+		},
+	}
+	for _, tc := range cases {
+		before, _ := json.Marshal(tc.in)
+		got, err := migrateV1(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+		if after, _ := json.Marshal(tc.in); string(after) != string(before) {
+			t.Errorf("%s: input was modified", tc.name)
+		}
+	}
+
+	if _, err := migrateV1(map[string]any{"tags": []any{1}}); err == nil {
+		t.Errorf("expected an error for a non-string tag")
+	}
+}
+
+func TestMigrateV2(t *testing.T) {
+	in := map[string]any{"struct_version": float64(2), "content": "# Hello\n\nThree little words."}
+	got, err := migrateV2(in)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, ok := in["derived"]; ok {
+		t.Errorf("input was modified")
+	}
+	derived, _ := got["derived"].(map[string]any)
+	if derived["word_count"] != float64(4) || len(derived["outline"].([]any)) != 1 {
+		t.Errorf("unexpected derived metadata %v", got["derived"])
+	}
+
+	// articles without content get empty metadata, not null lists
+	got, _ = migrateV2(map[string]any{})
+	if derived, _ = got["derived"].(map[string]any); derived["links"] == nil || derived["word_count"] != float64(0) {
+		t.Errorf("unexpected derived metadata %v", got["derived"])
+	}
+}
+
+func TestMigrateV3(t *testing.T) {
+	got, err := migrateV3(map[string]any{"struct_version": float64(3)})
+	if err != nil || got["lang"] != DefaultLang {
+		t.Errorf("expected the default language, got %v, %v", got["lang"], err)
+	}
+	if got, _ = migrateV3(map[string]any{"lang": "sv"}); got["lang"] != "sv" {
+		t.Errorf("expected the language to be kept, got %v", got["lang"])
+	}
+}
+
+func TestDecode(t *testing.T) {
+	art, err := Decode([]byte(`{"struct_version":1,"title":"old","published_at":5,"tags":["go","go"]}`))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if art.StructVersion != CurrentStructVersion || art.Status != StatusScheduled || !reflect.DeepEqual(art.Tags, []string{"go"}) {
+		t.Errorf("expected an upgraded article, got %+v", art)
+	}
+
+	// articles without a struct version predate it and are version 1
+	if art, err = Decode([]byte(`{"title":"older"}`)); err != nil || art.Status != StatusPublished {
+		t.Errorf("expected an upgraded article, got %+v (%v)", art, err)
+	}
+
+	current := []byte(fmt.Sprintf(`{"struct_version":%d,"title":"new","tags":[" kept "]}`, CurrentStructVersion))
+	if art, err = Decode(current); err != nil || art.Tags[0] != " kept " {
+		t.Errorf("expected current articles to be read as is, got %+v (%v)", art, err)
+	}
+}
+
+func TestMigrateBucket(t *testing.T) {
+	repo := testRepo(t)
+	kv := repo.(*articleRepo).kv
+
+	id := uuid.New()
+	_, err := kv.Put(repo.Context(), id.String(), []byte(`{"struct_version":1,"id":"`+id.String()+`","slug":"legacy","title":"legacy","published_at":1}`))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err = repo.Create(TestArticle()); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	report, err := repo.Migrate(true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if report.Total != 2 || report.Outdated != 1 || report.Migrated != 0 || report.Versions[1] != 1 {
+		t.Errorf("unexpected dry run report %+v", report)
+	}
+	entry, _ := kv.Get(repo.Context(), id.String())
+	if entry.Revision() != 1 {
+		t.Errorf("dry run wrote to the bucket")
+	}
+
+	if report, err = repo.Migrate(false); err != nil || report.Migrated != 1 {
+		t.Fatalf("unexpected report %+v (%v)", report, err)
+	}
+	if report, _ = repo.Migrate(true); report.Outdated != 0 {
+		t.Errorf("expected nothing left to migrate, got %+v", report)
+	}
+	entry, _ = kv.Get(repo.Context(), id.String())
+	var stored Article
+	if err = json.Unmarshal(entry.Value(), &stored); err != nil || stored.StructVersion != CurrentStructVersion || stored.Status != StatusScheduled {
+		t.Errorf("expected the stored article to be upgraded, got %s", entry.Value())
+	}
+}
+
+// TestMigrateBaseline upgrades an article as the baseline stored it, without
+// a publish time. Readers saw it then and must still see it.
+func TestMigrateBaseline(t *testing.T) {
+	repo := testRepo(t)
+	kv := repo.(*articleRepo).kv
+
+	id := uuid.New()
+	baseline := `{"struct_version":1,"id":"` + id.String() + `","slug":"nats-all-the-way-down","title":"NATS all the way down","subtitle":"..","leading":"..","author":"johan","published_at":0,"tags":["nats","go"],"content":"# NATS\n\nall the way down"}`
+	written, err := kv.Put(repo.Context(), id.String(), []byte(baseline))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	entry, _ := kv.GetRevision(repo.Context(), id.String(), written)
+	writtenAt := int(entry.Created().UnixMilli())
+
+	art, err := repo.Get(id)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !art.IsPublic(time.Now()) || art.Status != StatusPublished || art.PublishedAt != writtenAt {
+		t.Errorf("expected a public article published at %d, got %s at %d", writtenAt, art.Status, art.PublishedAt)
+	}
+
+	if report, err := repo.Migrate(false); err != nil || report.Migrated != 1 {
+		t.Fatalf("unexpected report %+v (%v)", report, err)
+	}
+	entry, _ = kv.Get(repo.Context(), id.String())
+	var stored map[string]any
+	if err = json.Unmarshal(entry.Value(), &stored); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if stored["status"] != string(StatusPublished) || stored["published_at"] != float64(writtenAt) {
+		t.Errorf("expected the stored article to be published at %d, got %s", writtenAt, entry.Value())
+	}
+	if _, ok := stored["updated_at"]; ok {
+		t.Errorf("expected no updated_at in the stored article, got %s", entry.Value())
+	}
+}
@@ -0,0 +1,246 @@
+package articles
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// The slug index is a secondary KV bucket mapping slug -> article id. Every
+// slug in it is claimed with kv.Create, which makes the bucket the authority
+// on slug uniqueness. JetStream has no multi-key transactions so the index
+// is written before (claim) and after (release) the article itself, undoing
+// the claim if the article write fails. repairSlugIndex fixes any drift left
+// behind by a crash between the two writes.
+
+// slugClaimGrace is how long repairSlugIndex leaves an index entry without
+// an article alone: a claim is written just before its article.
+var slugClaimGrace = time.Minute
+
+// ErrSlugTaken is matched (errors.Is) by every SlugTakenError.
+var ErrSlugTaken = errors.New("slug taken")
+
+// SlugTakenError is returned by Create and Update when the slug already
+// belongs to another article.
+type SlugTakenError struct {
+	Slug  string
+	Owner uuid.UUID // article currently holding the slug
+}
+
+func (e *SlugTakenError) Error() string {
+	return fmt.Sprintf("slug %q is taken by article %s", e.Slug, e.Owner)
+}
+
+func (e *SlugTakenError) Is(target error) bool {
+	return target == ErrSlugTaken
+}
+
+var slugKeyPlain = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// slugKey maps a slug to a valid KV key. Plain slugs are used as is, anything
+// else is base64url encoded behind a "=" which plain slugs can not contain.
+func slugKey(slug string) string {
+	if slugKeyPlain.MatchString(slug) {
+		return slug
+	}
+	return "=" + base64.RawURLEncoding.EncodeToString([]byte(slug))
+}
+
+// claimSlug reserves slug for id. Claiming a slug the article already holds
+// is a no-op.
+func (r *articleRepo) claimSlug(slug string, id uuid.UUID) error {
+	if slug == "" {
+		return fmt.Errorf("claim slug: slug is empty")
+	}
+	_, err := r.slugKv.Create(r.ctx, slugKey(slug), []byte(id.String()))
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, jetstream.ErrKeyExists) {
+		return fmt.Errorf("claim slug: %w", err)
+	}
+	owner, _, err := r.slugOwner(slug)
+	if err != nil {
+		return fmt.Errorf("claim slug: %w", err)
+	}
+	if owner == id {
+		return nil
+	}
+	return &SlugTakenError{Slug: slug, Owner: owner}
+}
+
+// releaseSlug removes the index entry for slug, but only if it still points
+// at id.
+func (r *articleRepo) releaseSlug(slug string, id uuid.UUID) error {
+	if slug == "" {
+		return nil
+	}
+	owner, rev, err := r.slugOwner(slug)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("release slug: %w", err)
+	}
+	if owner != id {
+		return nil
+	}
+	err = r.slugKv.Delete(r.ctx, slugKey(slug), jetstream.LastRevision(rev))
+	if err != nil {
+		return fmt.Errorf("release slug: %w", err)
+	}
+	return nil
+}
+
+// slugOwner returns the id of the article holding slug and the revision of
+// the index entry.
+func (r *articleRepo) slugOwner(slug string) (uuid.UUID, uint64, error) {
+	entry, err := r.slugKv.Get(r.ctx, slugKey(slug))
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	id, err := uuid.ParseBytes(entry.Value())
+	if err != nil {
+		return uuid.Nil, 0, fmt.Errorf("parse slug owner: %w", err)
+	}
+	return id, entry.Revision(), nil
+}
+
+// repairSlugIndex rebuilds the slug index from the article bucket. When two
+// articles share a slug the one created first keeps it and the collision is
+// logged. Entries no article uses are removed unless they are younger than
+// slugClaimGrace or were written again since they were read.
+func (r *articleRepo) repairSlugIndex() error {
+	type claim struct {
+		id      uuid.UUID
+		created time.Time
+	}
+	var (
+		claims  = map[string]claim{}
+		fixed   int
+		removed int
+	)
+
+	keys, err := r.kv.ListKeys(r.ctx)
+	if err != nil {
+		return fmt.Errorf("list article keys: %w", err)
+	}
+	for key := range keys.Keys() {
+		entry, err := r.kv.Get(r.ctx, key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("get article: %w", err)
+		}
+		art, err := DecodeEntry(entry)
+		if err != nil {
+			return fmt.Errorf("decode article %s: %w", key, err)
+		}
+		if art.Slug == "" {
+			continue
+		}
+		prev, ok := claims[art.Slug]
+		if !ok {
+			claims[art.Slug] = claim{id: art.Id}
+			continue
+		}
+		// only collisions need to know when the articles were created
+		if prev.created.IsZero() {
+			if prev.created, err = r.createdAt(prev.id.String()); err != nil {
+				return err
+			}
+		}
+		created, err := r.createdAt(key)
+		if err != nil {
+			return err
+		}
+		winner, loser := prev, claim{art.Id, created}
+		if loser.created.Before(winner.created) {
+			winner, loser = loser, winner
+		}
+		r.l.Warn("slug %q is used by %s and %s, keeping %s", art.Slug, winner.id, loser.id, winner.id)
+		claims[art.Slug] = winner
+	}
+
+	for slug, c := range claims {
+		owner, _, err := r.slugOwner(slug)
+		if err == nil && owner == c.id {
+			continue
+		}
+		if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+			return fmt.Errorf("get slug %q: %w", slug, err)
+		}
+		if _, err = r.slugKv.Put(r.ctx, slugKey(slug), []byte(c.id.String())); err != nil {
+			return fmt.Errorf("put slug %q: %w", slug, err)
+		}
+		fixed++
+	}
+
+	indexKeys, err := r.slugKv.ListKeys(r.ctx)
+	if err != nil {
+		return fmt.Errorf("list slug keys: %w", err)
+	}
+	wanted := make(map[string]bool, len(claims))
+	for slug := range claims {
+		wanted[slugKey(slug)] = true
+	}
+	for key := range indexKeys.Keys() {
+		if wanted[key] {
+			continue
+		}
+		entry, err := r.slugKv.Get(r.ctx, key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("get slug %q: %w", key, err)
+		}
+		if time.Since(entry.Created()) < slugClaimGrace {
+			continue // claimed for an article being written
+		}
+		err = r.slugKv.Delete(r.ctx, key, jetstream.LastRevision(entry.Revision()))
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			continue // claimed again since
+		}
+		if err != nil {
+			return fmt.Errorf("delete stale slug %q: %w", key, err)
+		}
+		removed++
+	}
+
+	r.l.Info("slug index repaired: %d slugs, %d fixed, %d removed", len(claims), fixed, removed)
+	return nil
+}
+
+// createdAt returns when the article under key was first written, as far
+// back as the history of the bucket goes.
+func (r *articleRepo) createdAt(key string) (time.Time, error) {
+	history, err := r.kv.History(r.ctx, key)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("history of article %s: %w", key, err)
+	}
+	return history[0].Created(), nil
+}
+
+// setupSlugIndex initializes the "article_slug" bucket holding the slug index.
+func setupSlugIndex(ctx context.Context, js jetstream.JetStream) (jetstream.KeyValue, error) {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:       "article_slug",
+		Description:  "article slug -> article id",
+		MaxValueSize: 1024,            // 1 KB
+		MaxBytes:     1024 * 1024 * 5, // 5 MB
+		History:      1,
+		Storage:      jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kv create: %w", err)
+	}
+	return kv, nil
+}
@@ -0,0 +1,331 @@
+package articles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/jst_log"
+)
+
+// --- TAG INDEX ---
+
+// TagIndex maps tags to the articles carrying them. It is kept current from
+// the article bucket by Watch and holds article metadata only, so that
+// counts can be limited to what the caller may see.
+type TagIndex struct {
+	lock     sync.RWMutex
+	byTag    map[string]map[uuid.UUID]struct{}
+	articles map[uuid.UUID]Article // without content
+}
+
+// TagCount is a tag with the number of articles carrying it and when one of
+// them was last written (unix ms).
+type TagCount struct {
+	Tag      string `json:"tag"`
+	Count    int    `json:"count"`
+	LastUsed int    `json:"last_used"`
+}
+
+func NewTagIndex() *TagIndex {
+	return &TagIndex{
+		byTag:    map[string]map[uuid.UUID]struct{}{},
+		articles: map[uuid.UUID]Article{},
+	}
+}
+
+// Put adds or replaces the tags of art.
+func (t *TagIndex) Put(art Article) {
+	art.Content = ""
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.remove(art.Id)
+	t.articles[art.Id] = art
+	for _, tag := range art.Tags {
+		ids, ok := t.byTag[tag]
+		if !ok {
+			ids = map[uuid.UUID]struct{}{}
+			t.byTag[tag] = ids
+		}
+		ids[art.Id] = struct{}{}
+	}
+}
+
+// Remove drops the article from the index.
+func (t *TagIndex) Remove(id uuid.UUID) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.remove(id)
+}
+
+func (t *TagIndex) remove(id uuid.UUID) {
+	old, ok := t.articles[id]
+	if !ok {
+		return
+	}
+	for _, tag := range old.Tags {
+		delete(t.byTag[tag], id)
+		if len(t.byTag[tag]) == 0 {
+			delete(t.byTag, tag)
+		}
+	}
+	delete(t.articles, id)
+}
+
+// Tags returns every tag with its count, most used first. Unless
+// includeHidden is set only articles public at now are counted.
+func (t *TagIndex) Tags(includeHidden bool, now time.Time) []TagCount {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	counts := make([]TagCount, 0, len(t.byTag))
+	for tag, ids := range t.byTag {
+		tc := TagCount{Tag: tag}
+		for id := range ids {
+			art := t.articles[id]
+			if !includeHidden && !art.IsPublic(now) {
+				continue
+			}
+			tc.Count++
+			tc.LastUsed = max(tc.LastUsed, art.UpdatedAt)
+		}
+		if tc.Count > 0 {
+			counts = append(counts, tc)
+		}
+	}
+	slices.SortFunc(counts, func(a, b TagCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+	return counts
+}
+
+// Len returns the number of distinct tags.
+func (t *TagIndex) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return len(t.byTag)
+}
+
+// Watch keeps the index in sync with the article bucket until ctx is done.
+func (t *TagIndex) Watch(ctx context.Context, repo ArticleRepo, l *jst_log.Logger) error {
+	watcher, err := repo.WatchAll()
+	if err != nil {
+		return fmt.Errorf("watch articles: %w", err)
+	}
+
+	go func() {
+		defer func() {
+			if err := watcher.Stop(); err != nil {
+				l.Warn("stop watcher: %v", err)
+			}
+		}()
+		for {
+			select {
+			case entry, ok := <-watcher.Updates():
+				if !ok {
+					l.Warn("watcher: channel closed")
+					return
+				}
+				if entry == nil {
+					l.Info("up to date. %d tags indexed", t.Len())
+					continue
+				}
+				id, err := uuid.Parse(entry.Key())
+				if err != nil {
+					l.Error("invalid article key %q: %s", entry.Key(), err.Error())
+					continue
+				}
+				switch entry.Operation() {
+				case jetstream.KeyValuePut:
+					art, err := DecodeEntry(entry)
+					if err != nil {
+						l.Error("failed to decode article: %s", err.Error())
+						continue
+					}
+					art.Id = id
+					art.Rev = entry.Revision()
+					art.UpdatedAt = int(entry.Created().UnixMilli())
+					t.Put(art)
+				case jetstream.KeyValueDelete, jetstream.KeyValuePurge:
+					t.Remove(id)
+				}
+			case <-ctx.Done():
+				l.Debug("watcher: context done")
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// --- TAG REWRITES ---
+
+const (
+	// SubjectTagsRewritten is where every tag rename or merge is recorded.
+	SubjectTagsRewritten = "article.event.tags_rewritten"
+	// StreamTagRewrites keeps the records published on SubjectTagsRewritten.
+	StreamTagRewrites = "ARTICLE_TAG_REWRITES"
+)
+
+// ErrTagRewriteNotRecorded is wrapped by the error RewriteTags returns when
+// the articles were rewritten but the audit record could not be kept. The
+// batch it returns along is complete apart from its Seq.
+var ErrTagRewriteNotRecorded = errors.New("tag rewrite not recorded")
+
+// TagRewrite is the audit record of a rename or merge: every article that
+// carried one of From now carries To instead. JetStream can not write several
+// keys atomically, so articles that could not be rewritten are listed in
+// Failed and the rewrite can simply be run again.
+type TagRewrite struct {
+	Id        uuid.UUID         `json:"id"`
+	Op        string            `json:"op"` // "rename" or "merge"
+	From      []string          `json:"from"`
+	To        string            `json:"to"`
+	By        string            `json:"by"`
+	At        int               `json:"at"` // unix ms
+	Rewritten []TagRewriteItem  `json:"rewritten"`
+	Failed    []TagRewriteError `json:"failed"`
+	Seq       uint64            `json:"seq,omitempty"` // of the record in StreamTagRewrites
+}
+
+// TagRewriteItem is an article rewritten from revision From to revision To.
+type TagRewriteItem struct {
+	Id   uuid.UUID `json:"id"`
+	Slug string    `json:"slug"`
+	From uint64    `json:"from"`
+	To   uint64    `json:"to"`
+}
+
+// TagRewriteError is an article the rewrite gave up on.
+type TagRewriteError struct {
+	Id    uuid.UUID `json:"id"`
+	Slug  string    `json:"slug"`
+	Error string    `json:"error"`
+}
+
+// RewriteTags replaces the tags in from with to on every article, as one
+// batch recorded in StreamTagRewrites. Articles changed concurrently are
+// retried a few times before they are reported as failed. The returned batch
+// carries the sequence of its record, see ErrTagRewriteNotRecorded for when
+// there is none.
+func RewriteTags(repo ArticleRepo, nc *nats.Conn, op string, from []string, to, by string) (TagRewrite, error) {
+	to = strings.TrimSpace(to)
+	batch := TagRewrite{
+		Id:        uuid.New(),
+		Op:        op,
+		From:      from,
+		To:        to,
+		By:        by,
+		At:        int(time.Now().UnixMilli()),
+		Rewritten: []TagRewriteItem{},
+		Failed:    []TagRewriteError{},
+	}
+	if to == "" || len(from) == 0 {
+		return batch, fmt.Errorf("rewrite tags: from and to are required")
+	}
+
+	all, err := repo.AllNoContent()
+	if err != nil {
+		return batch, fmt.Errorf("rewrite tags: %w", err)
+	}
+	for _, meta := range all {
+		if !slices.ContainsFunc(meta.Tags, func(tag string) bool { return slices.Contains(from, tag) }) {
+			continue
+		}
+		item, err := rewriteArticleTags(repo, meta.Id, from, to)
+		if err != nil {
+			batch.Failed = append(batch.Failed, TagRewriteError{Id: meta.Id, Slug: meta.Slug, Error: err.Error()})
+			continue
+		}
+		if item.To != 0 {
+			batch.Rewritten = append(batch.Rewritten, item)
+		}
+	}
+
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return batch, fmt.Errorf("%w: marshal: %w", ErrTagRewriteNotRecorded, err)
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return batch, fmt.Errorf("%w: jetstream new: %w", ErrTagRewriteNotRecorded, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ack, err := js.Publish(ctx, SubjectTagsRewritten, data)
+	if err != nil {
+		return batch, fmt.Errorf("%w: %w", ErrTagRewriteNotRecorded, err)
+	}
+	batch.Seq = ack.Sequence
+	return batch, nil
+}
+
+// setupTagRewrites creates the stream the audit records of tag rewrites are
+// kept in. Records are dropped, oldest first, after two years or once there
+// are too many of them.
+func setupTagRewrites(ctx context.Context, js jetstream.JetStream) error {
+	_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        StreamTagRewrites,
+		Description: "audit records of tag renames and merges",
+		Subjects:    []string{SubjectTagsRewritten},
+		Storage:     jetstream.FileStorage,
+		MaxAge:      2 * 365 * 24 * time.Hour,
+		MaxMsgs:     10_000,
+		MaxBytes:    64 * 1024 * 1024,
+		Discard:     jetstream.DiscardOld,
+	})
+	if err != nil {
+		return fmt.Errorf("create tag rewrite stream: %w", err)
+	}
+	return nil
+}
+
+// rewriteArticleTags rewrites the tags of one article, retrying on revision
+// conflicts. A zero To in the result means there was nothing to change.
+func rewriteArticleTags(repo ArticleRepo, id uuid.UUID, from []string, to string) (TagRewriteItem, error) {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var art Article
+		art, err = repo.Get(id)
+		if err != nil {
+			return TagRewriteItem{}, err
+		}
+		item := TagRewriteItem{Id: id, Slug: art.Slug, From: art.Rev}
+		tags := make([]string, 0, len(art.Tags))
+		changed := false
+		for _, tag := range art.Tags {
+			if slices.Contains(from, tag) {
+				tag, changed = to, true
+			}
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		if !changed {
+			return item, nil
+		}
+		art.Tags = tags
+		art.RestoredFrom, art.RestoredBy = 0, ""
+		art, err = repo.Update(art)
+		if errors.Is(err, ErrRevisionConflict) {
+			continue
+		}
+		if err != nil {
+			return item, err
+		}
+		item.To = art.Rev
+		return item, nil
+	}
+	return TagRewriteItem{}, err
+}
@@ -0,0 +1,133 @@
+package articles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/jst_log"
+)
+
+func TestTagIndex(t *testing.T) {
+	now := time.Now()
+	tagged := func(status Status, updated int, tags ...string) Article {
+		return Article{Id: uuid.New(), Status: status, PublishedAt: 1, UpdatedAt: updated, Tags: tags}
+	}
+	goNats := tagged(StatusPublished, 10, "go", "nats")
+	goOnly := tagged(StatusPublished, 20, "go")
+	draft := tagged(StatusDraft, 30, "go", "draft")
+
+	index := NewTagIndex()
+	for _, art := range []Article{goNats, goOnly, draft} {
+		index.Put(art)
+	}
+
+	public := index.Tags(false, now)
+	if want := []TagCount{{"go", 2, 20}, {"nats", 1, 10}}; !slices.Equal(public, want) {
+		t.Errorf("public tags: got %v, want %v", public, want)
+	}
+	if all := index.Tags(true, now); len(all) != 3 || all[0] != (TagCount{"go", 3, 30}) {
+		t.Errorf("unexpected tags for editors %v", all)
+	}
+
+	// retagging and removing keep the index tidy
+	goNats.Tags = []string{"go"}
+	index.Put(goNats)
+	index.Remove(draft.Id)
+	if index.Len() != 1 {
+		t.Errorf("expected only go left, got %v", index.Tags(true, now))
+	}
+}
+
+func TestRewriteTags(t *testing.T) {
+	nc := testConn(t)
+	l := jst_log.NewLogger("test", jst_log.DefaultSubjects())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo, err := Repo(ctx, nc, l)
+	if err != nil {
+		t.Fatalf("repo: %v", err)
+	}
+
+	events := make(chan *nats.Msg, 1)
+	sub, err := nc.ChanSubscribe(SubjectTagsRewritten, events)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	both := TestArticle()
+	both.Tags = []string{"golang", "go-lang", "nats"}
+	both, err = repo.Create(both)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	other := TestArticle()
+	other.Slug = "other"
+	other.Tags = []string{"nats"}
+	if _, err = repo.Create(other); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	batch, err := RewriteTags(repo, nc, "merge", []string{"golang", "go-lang"}, "go", "user-1")
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if len(batch.Rewritten) != 1 || batch.Rewritten[0].Id != both.Id || len(batch.Failed) != 0 {
+		t.Fatalf("unexpected batch %+v", batch)
+	}
+	got, err := repo.Get(both.Id)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !slices.Equal(got.Tags, []string{"go", "nats"}) || got.Rev != batch.Rewritten[0].To {
+		t.Errorf("unexpected tags after merge %v (rev %d)", got.Tags, got.Rev)
+	}
+
+	select {
+	case msg := <-events:
+		var recorded TagRewrite
+		if err := json.Unmarshal(msg.Data, &recorded); err != nil || recorded.Id != batch.Id || recorded.By != "user-1" {
+			t.Errorf("unexpected audit record %s (%v)", msg.Data, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected the rewrite to be announced")
+	}
+
+	js, _ := jetstream.New(nc)
+	stream, err := js.Stream(ctx, StreamTagRewrites)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	msg, err := stream.GetMsg(ctx, batch.Seq)
+	if err != nil {
+		t.Fatalf("expected the rewrite to be recorded at %d: %v", batch.Seq, err)
+	}
+	var recorded TagRewrite
+	if err := json.Unmarshal(msg.Data, &recorded); err != nil || recorded.Id != batch.Id {
+		t.Errorf("unexpected stored record %s (%v)", msg.Data, err)
+	}
+	if cfg := stream.CachedInfo().Config; cfg.MaxAge == 0 || cfg.MaxMsgs <= 0 || cfg.MaxBytes <= 0 {
+		t.Errorf("expected the audit stream to be bounded, got max age %s, %d msgs, %d bytes", cfg.MaxAge, cfg.MaxMsgs, cfg.MaxBytes)
+	}
+
+	// losing the record does not undo or hide the rewrite
+	_ = sub.Unsubscribe()
+	if err := js.DeleteStream(ctx, StreamTagRewrites); err != nil {
+		t.Fatalf("delete stream: %v", err)
+	}
+	batch, err = RewriteTags(repo, nc, "rename", []string{"nats"}, "jetstream", "user-1")
+	if !errors.Is(err, ErrTagRewriteNotRecorded) {
+		t.Fatalf("expected the record to be missing, got %v", err)
+	}
+	if len(batch.Rewritten) != 2 || batch.Seq != 0 {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+}
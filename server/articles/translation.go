@@ -0,0 +1,354 @@
+package articles
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// --- TRANSLATIONS ---
+
+// A translation is an article of its own, with its own id, slug and revision
+// history in the article bucket. TranslationOf points at the article it was
+// translated from, its source. The source and its translations are a family,
+// one article per language. SourceRevision is the revision of the source a
+// translation was last brought up to date with. It goes stale when the text
+// of the source changes after that revision (its ContentRevision), other
+// writes such as a publish or an access change leave it current.
+
+// DefaultLang is the language of articles that do not say otherwise.
+const DefaultLang = "en"
+
+// Languages are the languages articles are written in. A family lacking one
+// of them has a missing translation.
+var Languages = []string{"en", "sv"}
+
+// ErrInvalidTranslation is wrapped by every error about a translation that
+// cannot be made.
+var ErrInvalidTranslation = errors.New("invalid translation")
+
+// TranslationState is how a language of a family compares to the source.
+type TranslationState string
+
+const (
+	TranslationSource  TranslationState = "source"
+	TranslationCurrent TranslationState = "current" // up to date with the source
+	TranslationStale   TranslationState = "stale"   // the source changed since
+	TranslationMissing TranslationState = "missing"
+)
+
+// ValidLang reports whether lang is one of Languages.
+func ValidLang(lang string) bool {
+	return slices.Contains(Languages, lang)
+}
+
+// Language returns the language of the article, DefaultLang if unset.
+func (art Article) Language() string {
+	return cmp.Or(art.Lang, DefaultLang)
+}
+
+// SourceId returns the id of the source of the family the article is in,
+// which is its own id unless it is a translation.
+func (art Article) SourceId() uuid.UUID {
+	if art.TranslationOf != nil {
+		return *art.TranslationOf
+	}
+	return art.Id
+}
+
+// TranslationSlug is the default slug of the translation of slug into lang.
+func TranslationSlug(slug, lang string) string {
+	return slug + "-" + lang
+}
+
+// Family is a source article and its translations, sorted by language. The
+// source is the zero Article if it is gone while translations are left.
+type Family struct {
+	Source       Article
+	Translations []Article
+}
+
+// Variants returns the articles of the family, the source first.
+func (f Family) Variants() []Article {
+	variants := make([]Article, 0, len(f.Translations)+1)
+	if f.Source.Id != uuid.Nil {
+		variants = append(variants, f.Source)
+	}
+	return append(variants, f.Translations...)
+}
+
+// Variant returns the article of the family in lang.
+func (f Family) Variant(lang string) (Article, bool) {
+	for _, art := range f.Variants() {
+		if art.Language() == lang {
+			return art, true
+		}
+	}
+	return Article{}, false
+}
+
+// Filter returns the family with only the articles keep accepts.
+func (f Family) Filter(keep func(Article) bool) Family {
+	out := Family{Translations: []Article{}}
+	if f.Source.Id != uuid.Nil && keep(f.Source) {
+		out.Source = f.Source
+	}
+	for _, art := range f.Translations {
+		if keep(art) {
+			out.Translations = append(out.Translations, art)
+		}
+	}
+	return out
+}
+
+// FamilyOf finds the family of the article id in arts, as listed by
+// AllNoContent. The article may be the source or any translation.
+func FamilyOf(arts []Article, id uuid.UUID) (Family, bool) {
+	var (
+		source uuid.UUID
+		found  bool
+	)
+	for _, art := range arts {
+		if art.Id == id {
+			source, found = art.SourceId(), true
+			break
+		}
+	}
+	if !found {
+		return Family{}, false
+	}
+	return Families(arts)[source], true
+}
+
+// Families groups arts by family, keyed by the id of the source.
+func Families(arts []Article) map[uuid.UUID]Family {
+	families := map[uuid.UUID]Family{}
+	for _, art := range arts {
+		f := families[art.SourceId()]
+		if art.TranslationOf == nil {
+			f.Source = art
+		} else {
+			f.Translations = append(f.Translations, art)
+		}
+		families[art.SourceId()] = f
+	}
+	for id, f := range families {
+		if f.Translations == nil {
+			f.Translations = []Article{}
+		}
+		slices.SortFunc(f.Translations, func(a, b Article) int {
+			return strings.Compare(a.Language(), b.Language())
+		})
+		families[id] = f
+	}
+	return families
+}
+
+// Translation is one language of a family, as listed by Status.
+type Translation struct {
+	Lang           string           `json:"lang"`
+	State          TranslationState `json:"state"`
+	Id             uuid.UUID        `json:"id,omitempty"`
+	Slug           string           `json:"slug,omitempty"`
+	Title          string           `json:"title,omitempty"`
+	Status         Status           `json:"status,omitempty"`
+	Revision       uint64           `json:"revision,omitempty"`
+	SourceRevision uint64           `json:"source_revision,omitempty"`
+}
+
+// Status lists every language of Languages and of the family, the source
+// language first, and says whether its translation is missing or stale.
+func (f Family) Status() []Translation {
+	langs := slices.Clone(Languages)
+	for _, art := range f.Variants() {
+		if !slices.Contains(langs, art.Language()) {
+			langs = append(langs, art.Language())
+		}
+	}
+	sourceLang := f.Source.Language()
+	slices.SortStableFunc(langs, func(a, b string) int {
+		switch {
+		case a == sourceLang:
+			return -1
+		case b == sourceLang:
+			return 1
+		}
+		return 0
+	})
+
+	status := make([]Translation, 0, len(langs))
+	for _, lang := range langs {
+		art, ok := f.Variant(lang)
+		if !ok {
+			status = append(status, Translation{Lang: lang, State: TranslationMissing})
+			continue
+		}
+		t := Translation{
+			Lang:           lang,
+			State:          TranslationCurrent,
+			Id:             art.Id,
+			Slug:           art.Slug,
+			Title:          art.Title,
+			Status:         art.Status,
+			Revision:       art.Rev,
+			SourceRevision: art.SourceRevision,
+		}
+		switch {
+		case art.TranslationOf == nil:
+			t.State = TranslationSource
+		case art.SourceRevision < f.Source.ContentRevision:
+			t.State = TranslationStale
+		}
+		status = append(status, t)
+	}
+	return status
+}
+
+// SameText reports whether a and b have the same title, subtitle, leading and
+// content, the parts of an article a translation follows. A write that
+// changes any of them becomes the ContentRevision of the article, which is
+// left out of the stored data in that case and read as the revision of the
+// entry (see DecodeEntry).
+func SameText(a, b Article) bool {
+	return a.Title == b.Title && a.Subtitle == b.Subtitle && a.Leading == b.Leading && a.Content == b.Content
+}
+
+// HrefLang points at an article of a family in another language, for
+// alternate links. Default marks the source (x-default).
+type HrefLang struct {
+	Lang    string    `json:"lang"`
+	Id      uuid.UUID `json:"id"`
+	Slug    string    `json:"slug"`
+	Default bool      `json:"default,omitempty"`
+}
+
+// HrefLangs lists every article of the family.
+func (f Family) HrefLangs() []HrefLang {
+	links := []HrefLang{}
+	for _, art := range f.Variants() {
+		links = append(links, HrefLang{
+			Lang:    art.Language(),
+			Id:      art.Id,
+			Slug:    art.Slug,
+			Default: art.TranslationOf == nil,
+		})
+	}
+	return links
+}
+
+// NewTranslation creates art as the translation of the source article into
+// lang. Empty fields are taken from the source, the slug becomes the
+// TranslationSlug, and the translation starts as a draft up to date with the
+// current revision of the source. Owners and collaborators are copied.
+func NewTranslation(repo ArticleRepo, sourceId uuid.UUID, lang string, art Article) (Article, error) {
+	if !ValidLang(lang) {
+		return art, fmt.Errorf("%w: unknown language %q", ErrInvalidTranslation, lang)
+	}
+	source, err := repo.Get(sourceId)
+	if err != nil {
+		return art, fmt.Errorf("new translation: %w", err)
+	}
+	if source.TranslationOf != nil {
+		return art, fmt.Errorf("%w: %s is a translation, translate its source", ErrInvalidTranslation, sourceId)
+	}
+	all, err := repo.AllNoContent()
+	if err != nil {
+		return art, fmt.Errorf("new translation: %w", err)
+	}
+	family, _ := FamilyOf(all, source.Id)
+	family.Source = source
+	if existing, ok := family.Variant(lang); ok {
+		return art, fmt.Errorf("%w: %s already has a %s version (%s)", ErrInvalidTranslation, sourceId, lang, existing.Id)
+	}
+
+	art.Lang = lang
+	art.TranslationOf = &source.Id
+	art.SourceRevision = source.Rev
+	art.Slug = cmp.Or(art.Slug, TranslationSlug(source.Slug, lang))
+	art.Title = cmp.Or(art.Title, source.Title)
+	art.Subtitle = cmp.Or(art.Subtitle, source.Subtitle)
+	art.Leading = cmp.Or(art.Leading, source.Leading)
+	art.Content = cmp.Or(art.Content, source.Content)
+	art.Author = cmp.Or(art.Author, source.Author)
+	if art.Tags == nil {
+		art.Tags = source.Tags
+	}
+	art.Status = StatusDraft
+	art.PublishedAt = 0
+	art.Owners = source.Owners
+	art.Collaborators = source.Collaborators
+	return repo.Create(art)
+}
+
+// CheckLang validates a change of the language of art to lang: translations
+// keep theirs, a source may not take the language of one of its translations.
+func CheckLang(repo ArticleRepo, art Article, lang string) error {
+	if lang == art.Language() {
+		return nil
+	}
+	if !ValidLang(lang) {
+		return fmt.Errorf("%w: unknown language %q", ErrInvalidTranslation, lang)
+	}
+	if art.TranslationOf != nil {
+		return fmt.Errorf("%w: the language of a translation cannot change", ErrInvalidTranslation)
+	}
+	all, err := repo.AllNoContent()
+	if err != nil {
+		return fmt.Errorf("check language: %w", err)
+	}
+	family, _ := FamilyOf(all, art.Id)
+	if existing, ok := family.Variant(lang); ok && existing.Id != art.Id {
+		return fmt.Errorf("%w: %s already has a %s version (%s)", ErrInvalidTranslation, art.Id, lang, existing.Id)
+	}
+	return nil
+}
+
+// GetFamily returns the family of the article id.
+func GetFamily(repo ArticleRepo, id uuid.UUID) (Family, error) {
+	all, err := repo.AllNoContent()
+	if err != nil {
+		return Family{}, fmt.Errorf("get family: %w", err)
+	}
+	family, ok := FamilyOf(all, id)
+	if !ok {
+		return family, fmt.Errorf("get family of %s: %w", id, jetstream.ErrKeyNotFound)
+	}
+	return family, nil
+}
+
+// --- NEGOTIATION ---
+
+// Negotiate picks the language of available the Accept-Language header value
+// asks for most. A request for a regional variant (sv-SE) is served the
+// language (sv). It returns false if none is acceptable.
+func Negotiate(acceptLanguage string, available []string) (string, bool) {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if tag == "" || q <= bestQ {
+			continue
+		}
+		for _, lang := range available {
+			base, _, _ := strings.Cut(tag, "-")
+			if tag == "*" || tag == lang || base == lang {
+				best, bestQ = lang, q
+				break
+			}
+		}
+	}
+	return best, best != ""
+}
@@ -0,0 +1,130 @@
+package articles
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	available := []string{"en", "sv"}
+	cases := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{"sv", "sv", true},
+		{"sv-SE,sv;q=0.9,en;q=0.8", "sv", true},
+		{"de,en;q=0.5,sv;q=0.7", "sv", true},
+		{"EN-gb", "en", true},
+		{"*", "en", true},
+		{"de, fr;q=0.9", "", false},
+		{"sv;q=0, en;q=0.1", "en", true},
+		{"sv;q=oops", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		got, ok := Negotiate(c.header, available)
+		if got != c.want || ok != c.ok {
+			t.Errorf("Negotiate(%q) = %q, %v, want %q, %v", c.header, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestTranslations(t *testing.T) {
+	repo := testRepo(t)
+
+	source, err := repo.Create(TestArticle())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if source.Lang != DefaultLang {
+		t.Errorf("expected new articles in %s, got %q", DefaultLang, source.Lang)
+	}
+
+	if _, err = NewTranslation(repo, source.Id, "de", Article{}); !errors.Is(err, ErrInvalidTranslation) {
+		t.Errorf("expected an unknown language to be refused, got %v", err)
+	}
+	if _, err = NewTranslation(repo, source.Id, DefaultLang, Article{}); !errors.Is(err, ErrInvalidTranslation) {
+		t.Errorf("expected a second %s version to be refused, got %v", DefaultLang, err)
+	}
+
+	sv, err := NewTranslation(repo, source.Id, "sv", Article{Title: "Testartikel"})
+	if err != nil {
+		t.Fatalf("translate: %v", err)
+	}
+	if sv.TranslationOf == nil || *sv.TranslationOf != source.Id || sv.SourceRevision != source.Rev || sv.Status != StatusDraft {
+		t.Errorf("unexpected translation %+v", sv)
+	}
+	if sv.Slug != "test-article-sv" || sv.Title != "Testartikel" || sv.Content != source.Content {
+		t.Errorf("expected slug and missing fields from the source, got %q, %q", sv.Slug, sv.Title)
+	}
+	if _, err = NewTranslation(repo, sv.Id, "en", Article{}); !errors.Is(err, ErrInvalidTranslation) {
+		t.Errorf("expected a translation of a translation to be refused, got %v", err)
+	}
+	if _, err = NewTranslation(repo, source.Id, "sv", Article{Slug: "another-slug"}); !errors.Is(err, ErrInvalidTranslation) {
+		t.Errorf("expected a second sv version to be refused, got %v", err)
+	}
+	if err = CheckLang(repo, source, "sv"); !errors.Is(err, ErrInvalidTranslation) {
+		t.Errorf("expected the source to not take the language of its translation, got %v", err)
+	}
+	if err = CheckLang(repo, sv, "en"); !errors.Is(err, ErrInvalidTranslation) {
+		t.Errorf("expected the language of a translation to be fixed, got %v", err)
+	}
+
+	state := func() map[string]TranslationState {
+		family, err := GetFamily(repo, sv.Id)
+		if err != nil {
+			t.Fatalf("family: %v", err)
+		}
+		states := map[string]TranslationState{}
+		for _, tr := range family.Status() {
+			states[tr.Lang] = tr.State
+		}
+		return states
+	}
+	if got := state(); got["en"] != TranslationSource || got["sv"] != TranslationCurrent {
+		t.Errorf("unexpected states %v", got)
+	}
+
+	// writes that leave the text alone do not make it stale
+	source.Tags = append(source.Tags, "translated")
+	if source, err = repo.Update(source); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got := state(); got["sv"] != TranslationCurrent {
+		t.Errorf("expected a tag change to keep the translation current, got %v", got)
+	}
+
+	// editing the source leaves the translation behind until it catches up
+	source.Title = "Test Article, revised"
+	if source, err = repo.Update(source); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got := state(); got["sv"] != TranslationStale {
+		t.Errorf("expected the translation to be stale, got %v", got)
+	}
+	sv.SourceRevision = source.Rev
+	if sv, err = repo.Update(sv); err != nil {
+		t.Fatalf("update translation: %v", err)
+	}
+	if got := state(); got["sv"] != TranslationCurrent {
+		t.Errorf("expected the translation to be current, got %v", got)
+	}
+
+	// listings show sources unless asked for a language
+	all, _ := repo.AllNoContent()
+	page, err := List(all, ListQuery{IncludeHidden: true})
+	if err != nil || page.Total != 1 || page.Articles[0].Id != source.Id {
+		t.Errorf("expected only the source, got %+v, %v", page.Articles, err)
+	}
+	page, err = List(all, ListQuery{IncludeHidden: true, Lang: "sv"})
+	if err != nil || page.Total != 1 || page.Articles[0].Id != sv.Id {
+		t.Errorf("expected only the translation, got %+v, %v", page.Articles, err)
+	}
+
+	family, _ := GetFamily(repo, source.Id)
+	links := family.HrefLangs()
+	if len(links) != 2 || links[0].Lang != "en" || !links[0].Default || links[1].Slug != sv.Slug {
+		t.Errorf("unexpected hreflang links %+v", links)
+	}
+}
@@ -0,0 +1,196 @@
+package articles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/jst_log"
+)
+
+// Deleting an article writes a KV delete marker, which keeps the history of
+// the key around. The trash is every key whose latest entry is a delete
+// marker, shown as the last put before it. Undelete writes that put back as
+// the new head. Once a delete marker is older than the retention the key is
+// purged, dropping its history for good.
+
+// DefaultTrashRetention is how long deleted articles stay in the trash.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// ErrNotDeleted is returned by Undelete for articles that are not in the trash.
+var ErrNotDeleted = errors.New("article is not deleted")
+
+// Trash lists deleted articles that can still be undeleted, most recently
+// deleted first. DeletedAt is set, Content is left out.
+func (r *articleRepo) Trash() ([]Article, error) {
+	markers, err := r.deleteMarkers()
+	if err != nil {
+		return nil, fmt.Errorf("list trash: %w", err)
+	}
+	trash := []Article{}
+	for _, marker := range markers {
+		art, err := r.lastPut(marker.Key())
+		if errors.Is(err, ErrRevisionUnavailable) {
+			continue // nothing left to undelete
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list trash: %w", err)
+		}
+		art.Content = ""
+		art.DeletedAt = int(marker.Created().UnixMilli())
+		trash = append(trash, art)
+	}
+	slices.SortFunc(trash, func(a, b Article) int {
+		return b.DeletedAt - a.DeletedAt
+	})
+	return trash, nil
+}
+
+// Undelete writes the last revision before the delete back as the head of
+// the article and claims its slug again. A *SlugTakenError is returned if
+// another article took the slug in the meantime.
+func (r *articleRepo) Undelete(id uuid.UUID) (Article, error) {
+	key := id.String()
+	head, err := r.kv.Get(r.ctx, key)
+	if err == nil {
+		return Article{}, fmt.Errorf("undelete article %s: %w", id, ErrNotDeleted)
+	}
+	if !errors.Is(err, jetstream.ErrKeyNotFound) {
+		return Article{}, fmt.Errorf("undelete article: %w", err)
+	}
+	history, err := r.kv.History(r.ctx, key)
+	if err != nil {
+		return Article{}, fmt.Errorf("undelete article: %w", err)
+	}
+	head = history[len(history)-1]
+	if head.Operation() != jetstream.KeyValueDelete {
+		return Article{}, fmt.Errorf("undelete article %s: %w", id, ErrRevisionUnavailable)
+	}
+	art, err := r.lastPut(key)
+	if err != nil {
+		return art, fmt.Errorf("undelete article: %w", err)
+	}
+
+	art.UpdatedAt = 0
+	data, err := json.Marshal(art)
+	if err != nil {
+		return art, fmt.Errorf("marshal article: %w", err)
+	}
+	if err = r.claimSlug(art.Slug, id); err != nil {
+		return art, err
+	}
+	rev, err := r.kv.Update(r.ctx, key, data, head.Revision())
+	if err != nil {
+		if relErr := r.releaseSlug(art.Slug, id); relErr != nil {
+			r.l.Error("release slug after failed undelete: %v", relErr)
+		}
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return art, r.conflict(id, head.Revision())
+		}
+		return art, fmt.Errorf("undelete article: %w", err)
+	}
+	art.Rev = rev
+	art.UpdatedAt = int(time.Now().UnixMilli())
+	return art, nil
+}
+
+// PurgeDeleted purges every article deleted before the given time, removing
+// it from the trash for good. It returns how many were purged.
+func (r *articleRepo) PurgeDeleted(before time.Time) (int, error) {
+	markers, err := r.deleteMarkers()
+	if err != nil {
+		return 0, fmt.Errorf("purge deleted: %w", err)
+	}
+	purged := 0
+	for _, marker := range markers {
+		if !marker.Created().Before(before) {
+			continue
+		}
+		// only purge if nobody undeleted the article since we looked
+		err = r.kv.Purge(r.ctx, marker.Key(), jetstream.LastRevision(marker.Revision()))
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			continue
+		}
+		if err != nil {
+			return purged, fmt.Errorf("purge deleted %s: %w", marker.Key(), err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// deleteMarkers returns the latest entry of every key that is a delete marker.
+func (r *articleRepo) deleteMarkers() ([]jetstream.KeyValueEntry, error) {
+	watcher, err := r.kv.WatchAll(r.ctx, jetstream.MetaOnly())
+	if err != nil {
+		return nil, fmt.Errorf("watch all: %w", err)
+	}
+	defer watcher.Stop()
+
+	var markers []jetstream.KeyValueEntry
+	for {
+		select {
+		case <-r.ctx.Done():
+			return nil, r.ctx.Err()
+		case entry := <-watcher.Updates():
+			if entry == nil {
+				return markers, nil
+			}
+			if entry.Operation() == jetstream.KeyValueDelete {
+				markers = append(markers, entry)
+			}
+		}
+	}
+}
+
+// lastPut returns the newest revision of key that is not a delete marker.
+func (r *articleRepo) lastPut(key string) (Article, error) {
+	var art Article
+	history, err := r.kv.History(r.ctx, key)
+	if err != nil {
+		return art, fmt.Errorf("history: %w", err)
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		entry := history[i]
+		if entry.Operation() != jetstream.KeyValuePut {
+			continue
+		}
+		if art, err = DecodeEntry(entry); err != nil {
+			return art, fmt.Errorf("decode article: %w", err)
+		}
+		art.Rev = entry.Revision()
+		art.UpdatedAt = int(entry.Created().UnixMilli())
+		return art, nil
+	}
+	return art, ErrRevisionUnavailable
+}
+
+// --- REAPER ---
+
+// Reap purges articles that have been in the trash for longer than
+// retention, checking every interval until ctx is done.
+func Reap(ctx context.Context, repo ArticleRepo, l *jst_log.Logger, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.Debug("context done, stopping")
+			return
+		case now := <-ticker.C:
+			n, err := repo.PurgeDeleted(now.Add(-retention))
+			if err != nil {
+				l.Error("purge deleted articles: %v", err)
+			}
+			if n > 0 {
+				l.Info("purged %d deleted articles", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package articles
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	repo := testRepo(t)
+
+	created, err := repo.Create(TestArticle())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err = repo.Undelete(created.Id); !errors.Is(err, ErrNotDeleted) {
+		t.Errorf("expected live article to not be undeletable, got %v", err)
+	}
+	if err = repo.Delete(created.Id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	trash, err := repo.Trash()
+	if err != nil {
+		t.Fatalf("trash: %v", err)
+	}
+	if len(trash) != 1 || trash[0].Id != created.Id || trash[0].DeletedAt == 0 || trash[0].Content != "" {
+		t.Fatalf("expected the deleted article in the trash, got %+v", trash)
+	}
+
+	// nothing is old enough to purge yet
+	if n, err := repo.PurgeDeleted(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("expected nothing purged, got %d, %v", n, err)
+	}
+
+	restored, err := repo.Undelete(created.Id)
+	if err != nil {
+		t.Fatalf("undelete: %v", err)
+	}
+	if restored.Title != created.Title || restored.Content != created.Content || restored.Rev <= created.Rev {
+		t.Errorf("unexpected undeleted article %+v", restored)
+	}
+	if bySlug, err := repo.GetBySLug(created.Slug); err != nil || bySlug.Id != created.Id {
+		t.Errorf("expected slug to be claimed again, got %v", err)
+	}
+	if trash, _ = repo.Trash(); len(trash) != 0 {
+		t.Errorf("expected empty trash, got %d", len(trash))
+	}
+
+	if err = repo.Delete(created.Id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if n, err := repo.PurgeDeleted(time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("expected one purged, got %d, %v", n, err)
+	}
+	if trash, _ = repo.Trash(); len(trash) != 0 {
+		t.Errorf("expected empty trash after purge, got %d", len(trash))
+	}
+	if _, err = repo.Undelete(created.Id); !errors.Is(err, ErrRevisionUnavailable) {
+		t.Errorf("expected purged article to be gone, got %v", err)
+	}
+}
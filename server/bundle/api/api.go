@@ -0,0 +1,59 @@
+package api
+
+import "github.com/google/uuid"
+
+// the NATS subject used by this package
+var Subj = struct {
+	// articles
+	ArticleGroup  string
+	ArticleExport string
+	ArticleImport string
+}{
+	// articles
+	ArticleGroup:  "svc.bundle.articles",
+	ArticleExport: "export",
+	ArticleImport: "import",
+}
+
+// EXPORT
+type ExportRequest struct {
+	Format string `json:"format,omitempty"` // "tar" (default) or "zip"
+	Tag    string `json:"tag,omitempty"`    // Optional: only articles with this tag
+}
+
+type ExportResponse struct {
+	Format   string `json:"format"`
+	Articles int    `json:"articles"`
+	Data     []byte `json:"data"` // the archive, base64 in json
+}
+
+// IMPORT
+type ImportRequest struct {
+	Format string `json:"format,omitempty"` // Optional: detected from the data
+	DryRun bool   `json:"dry_run,omitempty"`
+	Author string `json:"author,omitempty"` // for new articles without one
+	Data   []byte `json:"data"`
+}
+
+type ImportReport struct {
+	DryRun    bool         `json:"dry_run"`
+	Created   int          `json:"created"`
+	Updated   int          `json:"updated"`
+	Unchanged int          `json:"unchanged"`
+	Conflicts int          `json:"conflicts"`
+	Failed    int          `json:"failed"` // invalid files and failed writes
+	Items     []ImportItem `json:"items"`
+}
+
+// ImportItem is the outcome for one file. Action is one of create, update,
+// unchanged, conflict (slug or revision clash, nothing written), invalid
+// (file could not be read) or failed (write failed). Revision is the revision
+// written, or on a dry run the revision that would be replaced.
+type ImportItem struct {
+	File     string    `json:"file"`
+	Slug     string    `json:"slug"`
+	Id       uuid.UUID `json:"id,omitempty"`
+	Action   string    `json:"action"`
+	Revision uint64    `json:"revision,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
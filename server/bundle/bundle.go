@@ -0,0 +1,371 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/bundle/api"
+)
+
+// Format is the archive format of a bundle.
+type Format string
+
+const (
+	FormatTar Format = "tar"
+	FormatZip Format = "zip"
+)
+
+// MaxFileSize is the largest article file read from a bundle, the value
+// size limit of the article bucket.
+const MaxFileSize = 5 * 1024 * 1024
+
+// ParseFormat validates a format name. Empty means tar.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", FormatTar:
+		return FormatTar, nil
+	case FormatZip:
+		return FormatZip, nil
+	default:
+		return "", fmt.Errorf("unknown bundle format %q, expected tar or zip", s)
+	}
+}
+
+// ContentType is the media type of the format.
+func (f Format) ContentType() string {
+	if f == FormatZip {
+		return "application/zip"
+	}
+	return "application/x-tar"
+}
+
+// Detect tells zip from tar by the magic number at the start of data.
+func Detect(data []byte) Format {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) || bytes.HasPrefix(data, []byte("PK\x05\x06")) {
+		return FormatZip
+	}
+	return FormatTar
+}
+
+// --- EXPORT ---
+
+// Collect loads the articles to export, with content: all of them or those
+// tagged tag. They are ordered by slug so that bundles diff nicely.
+func Collect(repo articles.ArticleRepo, tag string) ([]articles.Article, error) {
+	all, err := repo.AllNoContent()
+	if err != nil {
+		return nil, fmt.Errorf("list articles: %w", err)
+	}
+	var arts []articles.Article
+	for _, meta := range all {
+		if tag != "" && !slices.Contains(meta.Tags, tag) {
+			continue
+		}
+		art, err := repo.Get(meta.Id)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue // deleted since listing
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get article %s: %w", meta.Id, err)
+		}
+		arts = append(arts, art)
+	}
+	slices.SortFunc(arts, func(a, b articles.Article) int {
+		return strings.Compare(a.Slug, b.Slug)
+	})
+	return arts, nil
+}
+
+// FileName is the name of the article's file in a bundle.
+func FileName(art articles.Article) string {
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(art.Slug)
+	if name == "" || name == "." || name == ".." {
+		name = art.Id.String()
+	}
+	return name + ".dj"
+}
+
+// Export writes arts as a bundle to w.
+func Export(w io.Writer, format Format, arts []articles.Article) error {
+	now := time.Now()
+	switch format {
+	case FormatZip:
+		zw := zip.NewWriter(w)
+		for _, art := range arts {
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: FileName(art), Method: zip.Deflate, Modified: modified(art, now)})
+			if err != nil {
+				return fmt.Errorf("zip %s: %w", art.Slug, err)
+			}
+			if _, err = f.Write(Marshal(art)); err != nil {
+				return fmt.Errorf("zip %s: %w", art.Slug, err)
+			}
+		}
+		return zw.Close()
+	case FormatTar:
+		tw := tar.NewWriter(w)
+		for _, art := range arts {
+			data := Marshal(art)
+			err := tw.WriteHeader(&tar.Header{
+				Name:    FileName(art),
+				Mode:    0o644,
+				Size:    int64(len(data)),
+				ModTime: modified(art, now),
+			})
+			if err != nil {
+				return fmt.Errorf("tar %s: %w", art.Slug, err)
+			}
+			if _, err = tw.Write(data); err != nil {
+				return fmt.Errorf("tar %s: %w", art.Slug, err)
+			}
+		}
+		return tw.Close()
+	default:
+		return fmt.Errorf("unknown bundle format %q", format)
+	}
+}
+
+func modified(art articles.Article, fallback time.Time) time.Time {
+	if art.UpdatedAt == 0 {
+		return fallback
+	}
+	return time.UnixMilli(int64(art.UpdatedAt))
+}
+
+// --- IMPORT ---
+
+// Import actions.
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionUnchanged = "unchanged"
+	ActionConflict  = "conflict" // slug or revision clash, nothing written
+	ActionInvalid   = "invalid"  // file could not be read
+	ActionFailed    = "failed"   // write failed
+)
+
+// ImportReport lists what happened, or on a dry run would happen, to every
+// file in a bundle.
+type ImportReport = api.ImportReport
+
+// ImportItem is the outcome for one file.
+type ImportItem = api.ImportItem
+
+// Import reads a bundle and creates or updates its articles. Files are
+// matched to articles by id, then by slug. A revision in the front matter
+// must still be the current one, so editing an export and importing it does
+// not overwrite changes made in between. With dryRun nothing is written.
+// author is used for new articles that do not name one.
+func Import(repo articles.ArticleRepo, data []byte, format Format, dryRun bool, author string) (ImportReport, error) {
+	report := ImportReport{DryRun: dryRun, Items: []ImportItem{}}
+	files, err := readArchive(data, format)
+	if err != nil {
+		return report, err
+	}
+	for _, f := range files {
+		item := importFile(repo, f, dryRun, author)
+		switch item.Action {
+		case ActionCreate:
+			report.Created++
+		case ActionUpdate:
+			report.Updated++
+		case ActionUnchanged:
+			report.Unchanged++
+		case ActionConflict:
+			report.Conflicts++
+		default:
+			report.Failed++
+		}
+		report.Items = append(report.Items, item)
+	}
+	return report, nil
+}
+
+type bundleFile struct {
+	name string
+	data []byte
+	err  error
+}
+
+// readArchive returns the .dj files of the archive in archive order.
+func readArchive(data []byte, format Format) ([]bundleFile, error) {
+	var files []bundleFile
+	keep := func(name string) bool {
+		return strings.HasSuffix(name, ".dj") && !strings.HasPrefix(path.Base(name), ".")
+	}
+	switch format {
+	case FormatZip:
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("read zip: %w", err)
+		}
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() || !keep(zf.Name) {
+				continue
+			}
+			f := bundleFile{name: zf.Name}
+			if zf.UncompressedSize64 > MaxFileSize {
+				f.err = fmt.Errorf("larger than %d bytes", MaxFileSize)
+			} else if rc, err := zf.Open(); err != nil {
+				f.err = err
+			} else {
+				f.data, f.err = io.ReadAll(io.LimitReader(rc, MaxFileSize+1))
+				rc.Close()
+			}
+			files = append(files, f)
+		}
+	case FormatTar:
+		tr := tar.NewReader(bytes.NewReader(data))
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read tar: %w", err)
+			}
+			if hdr.Typeflag != tar.TypeReg || !keep(hdr.Name) {
+				continue
+			}
+			f := bundleFile{name: hdr.Name}
+			if hdr.Size > MaxFileSize {
+				f.err = fmt.Errorf("larger than %d bytes", MaxFileSize)
+			} else {
+				f.data, f.err = io.ReadAll(tr)
+			}
+			files = append(files, f)
+		}
+	default:
+		return nil, fmt.Errorf("unknown bundle format %q", format)
+	}
+	return files, nil
+}
+
+func importFile(repo articles.ArticleRepo, f bundleFile, dryRun bool, author string) ImportItem {
+	item := ImportItem{File: f.name}
+	invalid := func(err error) ImportItem {
+		item.Action, item.Error = ActionInvalid, err.Error()
+		return item
+	}
+	if f.err != nil {
+		return invalid(f.err)
+	}
+	in, err := Unmarshal(f.data)
+	if err != nil {
+		return invalid(err)
+	}
+	if in.Slug == "" {
+		in.Slug = strings.TrimSuffix(path.Base(f.name), ".dj")
+	}
+	item.Slug, item.Id = in.Slug, in.Id
+
+	// find the article the file belongs to
+	var existing articles.Article
+	if in.Id != uuid.Nil {
+		existing, err = repo.Get(in.Id)
+		if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+			item.Action, item.Error = ActionFailed, err.Error()
+			return item
+		}
+	}
+	owner, err := repo.GetBySLug(in.Slug)
+	if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		item.Action, item.Error = ActionFailed, err.Error()
+		return item
+	}
+	if existing.Id == uuid.Nil && in.Id == uuid.Nil {
+		existing = owner
+	}
+	if owner.Id != uuid.Nil && owner.Id != existing.Id {
+		item.Action, item.Error = ActionConflict, fmt.Sprintf("slug %q is taken by article %s", in.Slug, owner.Id)
+		return item
+	}
+
+	if existing.Id == uuid.Nil {
+		art := in
+		art.Id = uuid.Nil
+		if art.Author == "" {
+			art.Author = author
+		}
+		if err = articles.PrepareStatus(&art, time.Now()); err != nil {
+			return invalid(err)
+		}
+		item.Action = ActionCreate
+		if dryRun {
+			return item
+		}
+		created, err := repo.Create(art)
+		if err != nil {
+			return writeFailed(item, err)
+		}
+		item.Id, item.Revision = created.Id, created.Rev
+		return item
+	}
+
+	item.Id = existing.Id
+	if in.Rev != 0 && in.Rev != existing.Rev {
+		item.Action = ActionConflict
+		item.Error = fmt.Sprintf("bundle has revision %d, current revision is %d", in.Rev, existing.Rev)
+		return item
+	}
+	art := existing
+	art.Status = existing.CurrentStatus(time.Now()) // a due article is published, whether flipped yet or not
+	art.Slug, art.Title, art.Subtitle, art.Leading = in.Slug, in.Title, in.Subtitle, in.Leading
+	art.Tags, art.Content = in.Tags, in.Content
+	if in.Author != "" {
+		art.Author = in.Author
+	}
+	if in.Status != "" {
+		art.Status = in.Status
+	}
+	if in.PublishedAt != 0 {
+		art.PublishedAt = in.PublishedAt
+	}
+	if art.Tags == nil {
+		art.Tags = []string{}
+	}
+	if err = articles.PrepareStatus(&art, time.Now()); err != nil {
+		return invalid(err)
+	}
+	if sameArticle(art, existing) {
+		item.Action, item.Revision = ActionUnchanged, existing.Rev
+		return item
+	}
+	item.Action, item.Revision = ActionUpdate, existing.Rev
+	if dryRun {
+		return item
+	}
+	art.RestoredFrom, art.RestoredBy = 0, ""
+	updated, err := repo.Update(art)
+	if err != nil {
+		return writeFailed(item, err)
+	}
+	item.Revision = updated.Rev
+	return item
+}
+
+func writeFailed(item ImportItem, err error) ImportItem {
+	item.Action, item.Error = ActionFailed, err.Error()
+	if errors.Is(err, articles.ErrSlugTaken) || errors.Is(err, articles.ErrRevisionConflict) {
+		item.Action = ActionConflict
+	}
+	return item
+}
+
+func sameArticle(a, b articles.Article) bool {
+	return a.Slug == b.Slug && a.Title == b.Title && a.Subtitle == b.Subtitle &&
+		a.Leading == b.Leading && a.Author == b.Author && a.Status == b.Status &&
+		a.PublishedAt == b.PublishedAt && slices.Equal(a.Tags, b.Tags) &&
+		strings.TrimRight(a.Content, "\n") == strings.TrimRight(b.Content, "\n")
+}
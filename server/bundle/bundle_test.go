@@ -0,0 +1,195 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+)
+
+func testRepo(t testing.TB) articles.ArticleRepo {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{
+		ServerName: "test-bundle",
+		NoLog:      true,
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL(), nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		nc.Close()
+		ns.Shutdown()
+	})
+	repo, err := articles.Repo(ctx, nc, jst_log.NewLogger("test", jst_log.DefaultSubjects()))
+	if err != nil {
+		t.Fatalf("repo: %v", err)
+	}
+	return repo
+}
+
+func TestFrontMatter(t *testing.T) {
+	art := articles.Article{
+		Id:          uuid.New(),
+		Rev:         7,
+		Slug:        "hello",
+		Title:       `Hello, "World"`,
+		Subtitle:    "a: colon",
+		Status:      articles.StatusPublished,
+		PublishedAt: 1714564800000,
+		Tags:        []string{"go", "a, b"},
+		Content:     "# Hello\n\nWorld.\n",
+	}
+	got, err := Unmarshal(Marshal(art))
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got, art) {
+		t.Errorf("round trip changed the article\n got: %+v\nwant: %+v", got, art)
+	}
+
+	handWritten := []string{
+		"---\ntitle: Plain title # a comment\nslug: 'it''s'\npublished_at: 2024-05-01T12:00:00Z\ntags:\n  - go\n  - \"nats\"\n---\nBody\n",
+		"+++\ntitle = \"Plain title\"\nslug = \"it's\"\npublished_at = 1714564800000\ntags = [\"go\", 'nats']\n+++\n\nBody\n",
+	}
+	for _, src := range handWritten {
+		got, err := Unmarshal([]byte(src))
+		if err != nil {
+			t.Errorf("unmarshal %q: %v", src, err)
+			continue
+		}
+		if got.Title != "Plain title" || got.Slug != "it's" || got.PublishedAt != 1714564800000 ||
+			!reflect.DeepEqual(got.Tags, []string{"go", "nats"}) || got.Content != "Body\n" {
+			t.Errorf("unexpected article from %q: %+v", src, got)
+		}
+	}
+
+	if got, _ := Unmarshal([]byte("just content")); got.Content != "just content" {
+		t.Errorf("expected a file without front matter to be content, got %+v", got)
+	}
+	for _, bad := range []string{"---\ntitle: x\n", "---\nstatus: lost\n---\n", "---\nno separator\n---\n"} {
+		if _, err := Unmarshal([]byte(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []Format{FormatTar, FormatZip} {
+		t.Run(string(format), func(t *testing.T) {
+			repo := testRepo(t)
+			first, err := repo.Create(articles.TestArticle())
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			second := articles.TestArticle()
+			second.Slug, second.Tags = "second", []string{"other"}
+			if second, err = repo.Create(second); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+
+			arts, err := Collect(repo, "test")
+			if err != nil || len(arts) != 1 || arts[0].Content == "" {
+				t.Fatalf("expected the tagged article with content, got %d (%v)", len(arts), err)
+			}
+			arts, _ = Collect(repo, "")
+			var buf bytes.Buffer
+			if err = Export(&buf, format, arts); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+			if Detect(buf.Bytes()) != format {
+				t.Errorf("expected %s to be detected", format)
+			}
+
+			// edit one file, add a new one and one clashing with an existing slug
+			files, err := readArchive(buf.Bytes(), format)
+			if err != nil || len(files) != 2 {
+				t.Fatalf("expected two files, got %d (%v)", len(files), err)
+			}
+			byName := map[string]string{}
+			for _, f := range files {
+				byName[f.name] = string(f.data)
+			}
+			edited := byName[FileName(first)] + "\nEdited.\n"
+			bundle := archive(t, format, map[string]string{
+				FileName(first):  edited,
+				FileName(second): byName[FileName(second)],
+				"new.dj":         "---\ntitle: New\ntags: [go]\n---\nFresh.\n",
+				"clash.dj":       "---\nid: " + uuid.NewString() + "\nslug: second\ntitle: Clash\n---\n",
+			})
+
+			dry, err := Import(repo, bundle, format, true, "importer")
+			if err != nil {
+				t.Fatalf("dry run: %v", err)
+			}
+			if dry.Created != 1 || dry.Updated != 1 || dry.Unchanged != 1 || dry.Conflicts != 1 {
+				t.Errorf("unexpected dry run report %+v", dry)
+			}
+			if got, _ := repo.Get(first.Id); got.Rev != first.Rev {
+				t.Errorf("dry run wrote to the repo")
+			}
+
+			report, err := Import(repo, bundle, format, false, "importer")
+			if err != nil {
+				t.Fatalf("import: %v", err)
+			}
+			if report.Created != 1 || report.Updated != 1 || report.Unchanged != 1 || report.Conflicts != 1 {
+				t.Errorf("unexpected report %+v", report)
+			}
+			if got, _ := repo.Get(first.Id); !strings.Contains(got.Content, "Edited.") {
+				t.Errorf("expected the edit to be imported")
+			}
+			created, err := repo.GetBySLug("new")
+			if err != nil || created.Author != "importer" || created.Status != articles.StatusDraft {
+				t.Errorf("unexpected new article %+v (%v)", created, err)
+			}
+
+			// the export is stale now, its revision no longer matches
+			stale, _ := Import(repo, bundle, format, true, "importer")
+			if stale.Conflicts != 2 {
+				t.Errorf("expected the stale revision to conflict, got %+v", stale)
+			}
+		})
+	}
+}
+
+func archive(t *testing.T, format Format, files map[string]string) []byte {
+	t.Helper()
+	var arts []articles.Article
+	for name, src := range files {
+		art, err := Unmarshal([]byte(src))
+		if err != nil {
+			t.Fatalf("unmarshal %s: %v", name, err)
+		}
+		if art.Slug == "" {
+			art.Slug = strings.TrimSuffix(name, ".dj")
+		}
+		arts = append(arts, art)
+	}
+	var buf bytes.Buffer
+	if err := Export(&buf, format, arts); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,278 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"jst_dev/server/articles"
+)
+
+// A bundle file is an article as djot with its metadata in front matter:
+//
+//	---
+//	id: "0b9d8f0e-..."
+//	slug: "hello-world"
+//	title: "Hello, World"
+//	published_at: "2024-05-01T12:00:00Z"
+//	revision: 42
+//	tags: ["go", "nats"]
+//	---
+//
+//	The content.
+//
+// Export writes YAML with every string in double quotes (json escaping is
+// valid YAML). Import reads that, hand written YAML with plain or quoted
+// scalars, flow or block lists, and TOML between "+++" lines. Only the keys
+// below are known, nesting is not supported.
+
+// Marshal renders art as a bundle file.
+func Marshal(art articles.Article) []byte {
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	if art.Id != uuid.Nil {
+		writeYAML(&b, "id", art.Id.String())
+	}
+	writeYAML(&b, "slug", art.Slug)
+	writeYAML(&b, "title", art.Title)
+	if art.Subtitle != "" {
+		writeYAML(&b, "subtitle", art.Subtitle)
+	}
+	if art.Leading != "" {
+		writeYAML(&b, "leading", art.Leading)
+	}
+	if art.Author != "" {
+		writeYAML(&b, "author", art.Author)
+	}
+	if art.Status != "" {
+		writeYAML(&b, "status", string(art.Status))
+	}
+	if art.PublishedAt != 0 {
+		writeYAML(&b, "published_at", time.UnixMilli(int64(art.PublishedAt)).UTC().Format(time.RFC3339Nano))
+	}
+	if art.Rev != 0 {
+		fmt.Fprintf(&b, "revision: %d\n", art.Rev)
+	}
+	tags, _ := json.Marshal(append([]string{}, art.Tags...))
+	fmt.Fprintf(&b, "tags: %s\n", strings.ReplaceAll(string(tags), `","`, `", "`))
+	b.WriteString("---\n\n")
+	b.WriteString(art.Content)
+	if art.Content != "" && !strings.HasSuffix(art.Content, "\n") {
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+func writeYAML(b *bytes.Buffer, key, value string) {
+	quoted, _ := json.Marshal(value)
+	fmt.Fprintf(b, "%s: %s\n", key, quoted)
+}
+
+// Unmarshal reads a bundle file. Files without front matter are all content.
+func Unmarshal(data []byte) (articles.Article, error) {
+	var art articles.Article
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+
+	var fence, sep string
+	switch {
+	case strings.HasPrefix(text, "---\n"):
+		fence, sep = "---", ":"
+	case strings.HasPrefix(text, "+++\n"):
+		fence, sep = "+++", "="
+	default:
+		art.Content = text
+		return art, nil
+	}
+	rest := text[len(fence)+1:]
+	end := strings.Index("\n"+rest, "\n"+fence+"\n")
+	if end < 0 {
+		if !strings.HasSuffix(rest, "\n"+fence) && rest != fence {
+			return art, fmt.Errorf("front matter is not closed with %q", fence)
+		}
+		end = len(rest) - len(fence)
+		rest += "\n"
+	}
+	head := rest[:max(end-1, 0)]
+	art.Content = strings.TrimPrefix(rest[end+len(fence)+1:], "\n")
+
+	lines := strings.Split(head, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, raw, ok := strings.Cut(line, sep)
+		if !ok {
+			return art, fmt.Errorf("front matter line %d: expected key%svalue", i+1, sep)
+		}
+		key = strings.TrimSpace(key)
+		raw = strings.TrimSpace(raw)
+		if raw == "" && sep == ":" {
+			// YAML block list
+			var items []string
+			for i+1 < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i+1]), "-") {
+				i++
+				item, err := scalar(strings.TrimSpace(strings.TrimSpace(lines[i])[1:]))
+				if err != nil {
+					return art, fmt.Errorf("front matter %s: %w", key, err)
+				}
+				items = append(items, item)
+			}
+			raw = listLiteral(items)
+		}
+		if err := setField(&art, key, raw); err != nil {
+			return art, fmt.Errorf("front matter %s: %w", key, err)
+		}
+	}
+	return art, nil
+}
+
+func setField(art *articles.Article, key, raw string) error {
+	if key == "tags" {
+		tags, err := list(raw)
+		if err != nil {
+			return err
+		}
+		art.Tags = tags
+		return nil
+	}
+	value, err := scalar(raw)
+	if err != nil {
+		return err
+	}
+	switch key {
+	case "id":
+		if value == "" {
+			return nil
+		}
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return err
+		}
+		art.Id = id
+	case "slug":
+		art.Slug = value
+	case "title":
+		art.Title = value
+	case "subtitle":
+		art.Subtitle = value
+	case "leading":
+		art.Leading = value
+	case "author":
+		art.Author = value
+	case "status":
+		if value == "" {
+			return nil
+		}
+		status, err := articles.ParseStatus(value)
+		if err != nil {
+			return err
+		}
+		art.Status = status
+	case "published_at":
+		if value == "" {
+			return nil
+		}
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			art.PublishedAt = int(ms)
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("expected RFC 3339 time or unix ms, got %q", value)
+		}
+		art.PublishedAt = int(t.UnixMilli())
+	case "revision":
+		if value == "" {
+			return nil
+		}
+		rev, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		art.Rev = rev
+	default:
+		// unknown keys are ignored so bundles can carry notes for humans
+	}
+	return nil
+}
+
+// scalar reads a double quoted (json escapes), single quoted or plain value.
+// Plain values end at a " #" comment.
+func scalar(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		var s string
+		if err := json.Unmarshal([]byte(raw), &s); err != nil {
+			return "", fmt.Errorf("bad quoted string %s", raw)
+		}
+		return s, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("bad quoted string %s", raw)
+		}
+		return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), nil
+	default:
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw = raw[:i]
+		}
+		return strings.TrimSpace(raw), nil
+	}
+}
+
+// list reads a flow list like [go, "nats"]. A lone scalar is a list of one.
+func list(raw string) ([]string, error) {
+	if !strings.HasPrefix(raw, "[") {
+		value, err := scalar(raw)
+		if err != nil || value == "" {
+			return []string{}, err
+		}
+		return []string{value}, nil
+	}
+	if !strings.HasSuffix(raw, "]") {
+		return nil, fmt.Errorf("list is not closed: %s", raw)
+	}
+	items := []string{}
+	inner := raw[1 : len(raw)-1]
+	for start, i, quote := 0, 0, byte(0); i <= len(inner); i++ {
+		if i < len(inner) {
+			c := inner[i]
+			switch {
+			case quote != 0 && c == '\\' && quote == '"':
+				i++
+				continue
+			case quote != 0 && c == quote:
+				quote = 0
+				continue
+			case quote != 0:
+				continue
+			case c == '"' || c == '\'':
+				quote = c
+				continue
+			case c != ',':
+				continue
+			}
+		}
+		item := strings.TrimSpace(inner[start:i])
+		start = i + 1
+		if item == "" {
+			continue
+		}
+		value, err := scalar(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+func listLiteral(items []string) string {
+	data, _ := json.Marshal(append([]string{}, items...))
+	return string(data)
+}
@@ -0,0 +1,164 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/bundle/api"
+	"jst_dev/server/jst_log"
+)
+
+type BundleService struct {
+	l    *jst_log.Logger
+	nc   *nats.Conn
+	repo articles.ArticleRepo
+	ctx  context.Context
+}
+
+type Conf struct {
+	NatsConn    *nats.Conn
+	Logger      *jst_log.Logger
+	ArticleRepo articles.ArticleRepo
+}
+
+// New creates a new BundleService instance with the provided configuration.
+func New(ctx context.Context, c *Conf) (*BundleService, error) {
+	if c.ArticleRepo == nil {
+		return nil, fmt.Errorf("article repo is required")
+	}
+	return &BundleService{
+		l:    c.Logger,
+		nc:   c.NatsConn,
+		repo: c.ArticleRepo,
+		ctx:  ctx,
+	}, nil
+}
+
+// Start registers the import and export endpoints.
+func (s *BundleService) Start(ctx context.Context) error {
+	if s.nc.Status() != nats.CONNECTED {
+		return fmt.Errorf("nats connection not connected: %s", s.nc.Status())
+	}
+
+	svcMetadata := map[string]string{}
+	svcMetadata["location"] = "unknown"
+	svcMetadata["environment"] = "development"
+	bundleSvc, err := micro.AddService(s.nc, micro.Config{
+		Name:        "bundle",
+		Version:     "1.0.0",
+		Description: "import and export articles as djot bundles",
+		Metadata:    svcMetadata,
+	})
+	if err != nil {
+		return fmt.Errorf("add service: %w", err)
+	}
+
+	// ----------- Articles -----------
+	articleSvcGroup := bundleSvc.AddGroup(api.Subj.ArticleGroup, micro.WithGroupQueueGroup(api.Subj.ArticleGroup))
+	if err = articleSvcGroup.AddEndpoint("article_export", s.handleArticleExport(), micro.WithEndpointSubject(api.Subj.ArticleExport)); err != nil {
+		return fmt.Errorf("add bundle endpoint (article_export): %w", err)
+	}
+	if err = articleSvcGroup.AddEndpoint("article_import", s.handleArticleImport(), micro.WithEndpointSubject(api.Subj.ArticleImport)); err != nil {
+		return fmt.Errorf("add bundle endpoint (article_import): %w", err)
+	}
+
+	return nil
+}
+
+// ----------- HANDLERS -----------
+
+func (s *BundleService) handleArticleExport() micro.HandlerFunc {
+	l := s.l.WithBreadcrumb("article_export")
+	return func(req micro.Request) {
+		var reqData api.ExportRequest
+
+		l.Debug("got request")
+		if len(req.Data()) > 0 {
+			if err := json.Unmarshal(req.Data(), &reqData); err != nil {
+				l.Warn("failed to unmarshal export request: %s", err.Error())
+				if err := req.Error("INVALID_REQUEST", "invalid request", []byte(err.Error())); err != nil {
+					l.Error("failed to respond to export request: %v", err)
+				}
+				return
+			}
+		}
+		format, err := ParseFormat(reqData.Format)
+		if err != nil {
+			if err := req.Error("INVALID_REQUEST", err.Error(), nil); err != nil {
+				l.Error("failed to respond to export request: %v", err)
+			}
+			return
+		}
+
+		arts, err := Collect(s.repo, reqData.Tag)
+		if err != nil {
+			l.Error("failed to collect articles: %v", err)
+			if err := req.Error("INTERNAL_ERROR", "failed to collect articles", nil); err != nil {
+				l.Error("failed to respond to export request: %v", err)
+			}
+			return
+		}
+		var buf bytes.Buffer
+		if err = Export(&buf, format, arts); err != nil {
+			l.Error("failed to export articles: %v", err)
+			if err := req.Error("INTERNAL_ERROR", "failed to export articles", nil); err != nil {
+				l.Error("failed to respond to export request: %v", err)
+			}
+			return
+		}
+		if err := req.RespondJSON(api.ExportResponse{
+			Format:   string(format),
+			Articles: len(arts),
+			Data:     buf.Bytes(),
+		}); err != nil {
+			l.Error("failed to respond to export request: %v", err)
+		}
+	}
+}
+
+func (s *BundleService) handleArticleImport() micro.HandlerFunc {
+	l := s.l.WithBreadcrumb("article_import")
+	return func(req micro.Request) {
+		var reqData api.ImportRequest
+
+		l.Debug("got request")
+		if err := json.Unmarshal(req.Data(), &reqData); err != nil {
+			l.Warn("failed to unmarshal import request: %s", err.Error())
+			if err := req.Error("INVALID_REQUEST", "invalid request", []byte(err.Error())); err != nil {
+				l.Error("failed to respond to import request: %v", err)
+			}
+			return
+		}
+		format := Detect(reqData.Data)
+		if reqData.Format != "" {
+			var err error
+			if format, err = ParseFormat(reqData.Format); err != nil {
+				if err := req.Error("INVALID_REQUEST", err.Error(), nil); err != nil {
+					l.Error("failed to respond to import request: %v", err)
+				}
+				return
+			}
+		}
+
+		report, err := Import(s.repo, reqData.Data, format, reqData.DryRun, reqData.Author)
+		if err != nil {
+			l.Warn("failed to import bundle: %v", err)
+			if err := req.Error("INVALID_REQUEST", err.Error(), nil); err != nil {
+				l.Error("failed to respond to import request: %v", err)
+			}
+			return
+		}
+		if !report.DryRun {
+			l.Info("imported bundle: %d created, %d updated, %d conflicts, %d failed", report.Created, report.Updated, report.Conflicts, report.Failed)
+		}
+		if err := req.RespondJSON(report); err != nil {
+			l.Error("failed to respond to import request: %v", err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+// the NATS subject used by this package
+var Subj = struct {
+	// comments
+	CommentGroup    string
+	CommentCreate   string
+	CommentList     string
+	CommentQueue    string
+	CommentModerate string
+}{
+	// comments
+	CommentGroup:    "svc.comments.comments",
+	CommentCreate:   "create",
+	CommentList:     "list",
+	CommentQueue:    "queue",
+	CommentModerate: "moderate",
+}
+
+// COMMENT
+
+type Status string
+
+const (
+	StatusPending  Status = "pending"  // waiting in the moderation queue
+	StatusApproved Status = "approved" // visible to everyone
+	StatusRejected Status = "rejected" // kept, visible to editors only
+)
+
+type Comment struct {
+	ID          string `json:"id"`
+	ArticleID   string `json:"article_id"`
+	ParentID    string `json:"parent_id,omitempty"` // comment this replies to
+	UserID      string `json:"user_id,omitempty"`   // empty for anonymous authors
+	Name        string `json:"name"`
+	Body        string `json:"body"`
+	Status      Status `json:"status"`
+	CreatedAt   int64  `json:"created_at"` // unix ms
+	ModeratedBy string `json:"moderated_by,omitempty"`
+	ModeratedAt int64  `json:"moderated_at,omitempty"` // unix ms
+	Revision    uint64 `json:"revision,omitempty"`
+}
+
+// Thread is a comment with its replies, oldest first.
+type Thread struct {
+	Comment
+	Replies []Thread `json:"replies"`
+}
+
+type CommentCreateRequest struct {
+	ArticleID string `json:"article_id"`
+	ParentID  string `json:"parent_id,omitempty"`
+	UserID    string `json:"user_id,omitempty"` // set by the web layer for logged in users
+	Name      string `json:"name,omitempty"`    // required without UserID
+	Body      string `json:"body"`
+	// AutoApprove skips the moderation queue. Set it for editors only.
+	AutoApprove bool `json:"auto_approve,omitempty"`
+}
+
+type CommentListRequest struct {
+	ArticleID string `json:"article_id"`
+	// IncludeHidden also returns pending and rejected comments, and the
+	// comments of articles that are not public. Set it for editors only.
+	IncludeHidden bool `json:"include_hidden,omitempty"`
+}
+
+type CommentListResponse struct {
+	ArticleID string   `json:"article_id"`
+	Total     int      `json:"total"`
+	Threads   []Thread `json:"threads"`
+}
+
+type CommentQueueRequest struct {
+	Limit int `json:"limit,omitempty"` // Optional: defaults to 50
+}
+
+type CommentQueueResponse struct {
+	Total    int       `json:"total"` // pending comments, also those over the limit
+	Comments []Comment `json:"comments"`
+}
+
+type Action string
+
+const (
+	ActionApprove Action = "approve"
+	ActionReject  Action = "reject"
+	ActionDelete  Action = "delete"
+)
+
+type CommentModerateRequest struct {
+	ArticleID string `json:"article_id"`
+	ID        string `json:"id"`
+	Action    Action `json:"action"`
+	By        string `json:"by"` // id of the moderator
+}
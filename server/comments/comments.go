@@ -0,0 +1,523 @@
+package comments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/comments/api"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/ntfy"
+	whoApi "jst_dev/server/who/api"
+)
+
+// Comments are stored in the "comments" bucket under "{article id}.{comment
+// id}", so a watch on "{article id}.*" follows the comments of one article.
+
+const (
+	MaxBodyLength = 5000 // characters
+	MaxNameLength = 50   // characters
+	defaultQueue  = 50
+)
+
+type CommentService struct {
+	l    *jst_log.Logger
+	nc   *nats.Conn
+	kv   jetstream.KeyValue
+	repo articles.ArticleRepo
+	ctx  context.Context
+}
+
+type Conf struct {
+	NatsConn    *nats.Conn
+	Logger      *jst_log.Logger
+	ArticleRepo articles.ArticleRepo
+}
+
+// New creates a new CommentService instance with the provided configuration.
+func New(ctx context.Context, c *Conf) (*CommentService, error) {
+	if c.ArticleRepo == nil {
+		return nil, fmt.Errorf("article repo is required")
+	}
+	return &CommentService{
+		l:    c.Logger,
+		nc:   c.NatsConn,
+		repo: c.ArticleRepo,
+		ctx:  ctx,
+	}, nil
+}
+
+// Start creates the comments bucket and registers the comment endpoints.
+func (s *CommentService) Start(ctx context.Context) error {
+	if s.nc.Status() != nats.CONNECTED {
+		return fmt.Errorf("nats connection not connected: %s", s.nc.Status())
+	}
+
+	js, err := jetstream.New(s.nc)
+	if err != nil {
+		return fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	confKv := jetstream.KeyValueConfig{
+		Bucket:       "comments",
+		Description:  "article comments, keyed {article id}.{comment id}",
+		Storage:      jetstream.FileStorage,
+		MaxValueSize: 32 * 1024,         // 32 KB
+		MaxBytes:     100 * 1024 * 1024, // 100 MB
+		History:      5,
+		Compression:  true,
+	}
+	kv, err := js.CreateOrUpdateKeyValue(s.ctx, confKv)
+	if err != nil {
+		return fmt.Errorf("create comments kv store %s: %w", confKv.Bucket, err)
+	}
+	s.kv = kv
+
+	svcMetadata := map[string]string{}
+	svcMetadata["location"] = "unknown"
+	svcMetadata["environment"] = "development"
+	commentSvc, err := micro.AddService(s.nc, micro.Config{
+		Name:        "comments",
+		Version:     "1.0.0",
+		Description: "article comments with moderation",
+		Metadata:    svcMetadata,
+	})
+	if err != nil {
+		return fmt.Errorf("add service: %w", err)
+	}
+
+	// ----------- Comments -----------
+	commentSvcGroup := commentSvc.AddGroup(api.Subj.CommentGroup, micro.WithGroupQueueGroup(api.Subj.CommentGroup))
+	if err = commentSvcGroup.AddEndpoint("comment_create", s.handleCommentCreate(), micro.WithEndpointSubject(api.Subj.CommentCreate)); err != nil {
+		return fmt.Errorf("add comment endpoint (comment_create): %w", err)
+	}
+	if err = commentSvcGroup.AddEndpoint("comment_list", s.handleCommentList(), micro.WithEndpointSubject(api.Subj.CommentList)); err != nil {
+		return fmt.Errorf("add comment endpoint (comment_list): %w", err)
+	}
+	if err = commentSvcGroup.AddEndpoint("comment_queue", s.handleCommentQueue(), micro.WithEndpointSubject(api.Subj.CommentQueue)); err != nil {
+		return fmt.Errorf("add comment endpoint (comment_queue): %w", err)
+	}
+	if err = commentSvcGroup.AddEndpoint("comment_moderate", s.handleCommentModerate(), micro.WithEndpointSubject(api.Subj.CommentModerate)); err != nil {
+		return fmt.Errorf("add comment endpoint (comment_moderate): %w", err)
+	}
+
+	return nil
+}
+
+// Key is the bucket key of a comment.
+func Key(articleID, id string) string {
+	return articleID + "." + id
+}
+
+// ----------- HANDLERS -----------
+
+func (s *CommentService) handleCommentCreate() micro.HandlerFunc {
+	l := s.l.WithBreadcrumb("comment_create")
+	return func(req micro.Request) {
+		var reqData api.CommentCreateRequest
+
+		l.Debug("got request")
+		if err := json.Unmarshal(req.Data(), &reqData); err != nil {
+			l.Warn("failed to unmarshal comment create request: %s", err.Error())
+			respondError(l, req, "INVALID_REQUEST", "invalid request")
+			return
+		}
+		reqData.Body = strings.TrimSpace(reqData.Body)
+		reqData.Name = strings.TrimSpace(reqData.Name)
+		if err := validateCreate(reqData); err != nil {
+			respondError(l, req, "INVALID_REQUEST", err.Error())
+			return
+		}
+
+		articleID, _ := uuid.Parse(reqData.ArticleID)
+		art, err := s.repo.Get(articleID)
+		if err != nil || (!art.IsPublic(time.Now()) && !reqData.AutoApprove) {
+			respondError(l, req, "NOT_FOUND", "article not found")
+			return
+		}
+		if reqData.ParentID != "" {
+			parent, _, err := s.get(reqData.ArticleID, reqData.ParentID)
+			if err != nil || (parent.Status != api.StatusApproved && !reqData.AutoApprove) {
+				respondError(l, req, "NOT_FOUND", "parent comment not found")
+				return
+			}
+		}
+
+		comment := api.Comment{
+			ID:        uuid.NewString(),
+			ArticleID: reqData.ArticleID,
+			ParentID:  reqData.ParentID,
+			UserID:    reqData.UserID,
+			Name:      reqData.Name,
+			Body:      reqData.Body,
+			Status:    api.StatusPending,
+			CreatedAt: time.Now().UnixMilli(),
+		}
+		if comment.UserID != "" {
+			comment.Name = s.username(comment.UserID)
+		}
+		if reqData.AutoApprove {
+			comment.Status = api.StatusApproved
+			comment.ModeratedBy = comment.UserID
+			comment.ModeratedAt = comment.CreatedAt
+		}
+		data, err := json.Marshal(comment)
+		if err != nil {
+			l.Error("failed to marshal comment: %v", err)
+			respondError(l, req, "SERVER_ERROR", "server error")
+			return
+		}
+		comment.Revision, err = s.kv.Create(s.ctx, Key(comment.ArticleID, comment.ID), data)
+		if err != nil {
+			l.Error("failed to store comment: %v", err)
+			respondError(l, req, "SERVER_ERROR", "server error")
+			return
+		}
+		l.Info("new %s comment %s on article %s", comment.Status, comment.ID, comment.ArticleID)
+
+		go s.notifyOwners(art, comment)
+		if err := req.RespondJSON(comment); err != nil {
+			l.Error("failed to respond to comment create request: %v", err)
+		}
+	}
+}
+
+func (s *CommentService) handleCommentList() micro.HandlerFunc {
+	l := s.l.WithBreadcrumb("comment_list")
+	return func(req micro.Request) {
+		var reqData api.CommentListRequest
+
+		l.Debug("got request")
+		if err := json.Unmarshal(req.Data(), &reqData); err != nil {
+			l.Warn("failed to unmarshal comment list request: %s", err.Error())
+			respondError(l, req, "INVALID_REQUEST", "invalid request")
+			return
+		}
+		articleID, err := uuid.Parse(reqData.ArticleID)
+		if err != nil {
+			respondError(l, req, "INVALID_REQUEST", "invalid article id")
+			return
+		}
+		art, err := s.repo.Get(articleID)
+		if err != nil || (!art.IsPublic(time.Now()) && !reqData.IncludeHidden) {
+			respondError(l, req, "NOT_FOUND", "article not found")
+			return
+		}
+		all, err := s.list(reqData.ArticleID + ".*")
+		if err != nil {
+			l.Error("failed to list comments: %v", err)
+			respondError(l, req, "SERVER_ERROR", "server error")
+			return
+		}
+		visible := all[:0]
+		for _, c := range all {
+			if reqData.IncludeHidden || c.Status == api.StatusApproved {
+				visible = append(visible, c)
+			}
+		}
+		if err := req.RespondJSON(api.CommentListResponse{
+			ArticleID: reqData.ArticleID,
+			Total:     len(visible),
+			Threads:   Threads(visible),
+		}); err != nil {
+			l.Error("failed to respond to comment list request: %v", err)
+		}
+	}
+}
+
+func (s *CommentService) handleCommentQueue() micro.HandlerFunc {
+	l := s.l.WithBreadcrumb("comment_queue")
+	return func(req micro.Request) {
+		var reqData api.CommentQueueRequest
+
+		l.Debug("got request")
+		if len(req.Data()) > 0 {
+			if err := json.Unmarshal(req.Data(), &reqData); err != nil {
+				l.Warn("failed to unmarshal comment queue request: %s", err.Error())
+				respondError(l, req, "INVALID_REQUEST", "invalid request")
+				return
+			}
+		}
+		if reqData.Limit <= 0 {
+			reqData.Limit = defaultQueue
+		}
+		all, err := s.list(">")
+		if err != nil {
+			l.Error("failed to list comments: %v", err)
+			respondError(l, req, "SERVER_ERROR", "server error")
+			return
+		}
+		pending := []api.Comment{}
+		for _, c := range all {
+			if c.Status == api.StatusPending {
+				pending = append(pending, c)
+			}
+		}
+		resp := api.CommentQueueResponse{Total: len(pending), Comments: pending}
+		if len(pending) > reqData.Limit {
+			resp.Comments = pending[:reqData.Limit]
+		}
+		if err := req.RespondJSON(resp); err != nil {
+			l.Error("failed to respond to comment queue request: %v", err)
+		}
+	}
+}
+
+func (s *CommentService) handleCommentModerate() micro.HandlerFunc {
+	l := s.l.WithBreadcrumb("comment_moderate")
+	return func(req micro.Request) {
+		var reqData api.CommentModerateRequest
+
+		l.Debug("got request")
+		if err := json.Unmarshal(req.Data(), &reqData); err != nil {
+			l.Warn("failed to unmarshal comment moderate request: %s", err.Error())
+			respondError(l, req, "INVALID_REQUEST", "invalid request")
+			return
+		}
+		if reqData.By == "" {
+			respondError(l, req, "INVALID_REQUEST", "moderator is required")
+			return
+		}
+		comment, rev, err := s.get(reqData.ArticleID, reqData.ID)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			respondError(l, req, "NOT_FOUND", "comment not found")
+			return
+		}
+		if err != nil {
+			l.Error("failed to get comment: %v", err)
+			respondError(l, req, "SERVER_ERROR", "server error")
+			return
+		}
+
+		key := Key(comment.ArticleID, comment.ID)
+		switch reqData.Action {
+		case api.ActionApprove, api.ActionReject:
+			comment.Status = api.StatusApproved
+			if reqData.Action == api.ActionReject {
+				comment.Status = api.StatusRejected
+			}
+			comment.ModeratedBy = reqData.By
+			comment.ModeratedAt = time.Now().UnixMilli()
+			data, err := json.Marshal(comment)
+			if err != nil {
+				l.Error("failed to marshal comment: %v", err)
+				respondError(l, req, "SERVER_ERROR", "server error")
+				return
+			}
+			comment.Revision, err = s.kv.Update(s.ctx, key, data, rev)
+		case api.ActionDelete:
+			err = s.kv.Delete(s.ctx, key, jetstream.LastRevision(rev))
+		default:
+			respondError(l, req, "INVALID_REQUEST", fmt.Sprintf("unknown action %q", reqData.Action))
+			return
+		}
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			respondError(l, req, "CONFLICT", "comment changed, reload and try again")
+			return
+		}
+		if err != nil {
+			l.Error("failed to %s comment: %v", reqData.Action, err)
+			respondError(l, req, "SERVER_ERROR", "server error")
+			return
+		}
+		l.Info("%s: comment %s by %s", reqData.Action, comment.ID, reqData.By)
+		if err := req.RespondJSON(comment); err != nil {
+			l.Error("failed to respond to comment moderate request: %v", err)
+		}
+	}
+}
+
+func respondError(l *jst_log.Logger, req micro.Request, code, description string) {
+	if err := req.Error(code, description, []byte(description)); err != nil {
+		l.Error("failed to respond to request: %v", err)
+	}
+}
+
+// ----------- STORAGE -----------
+
+func (s *CommentService) get(articleID, id string) (api.Comment, uint64, error) {
+	var comment api.Comment
+	if _, err := uuid.Parse(articleID); err != nil {
+		return comment, 0, jetstream.ErrKeyNotFound
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return comment, 0, jetstream.ErrKeyNotFound
+	}
+	entry, err := s.kv.Get(s.ctx, Key(articleID, id))
+	if err != nil {
+		return comment, 0, fmt.Errorf("get comment: %w", err)
+	}
+	if err = json.Unmarshal(entry.Value(), &comment); err != nil {
+		return comment, 0, fmt.Errorf("decode comment: %w", err)
+	}
+	comment.Revision = entry.Revision()
+	return comment, entry.Revision(), nil
+}
+
+// list returns the comments with keys matching filter, oldest first.
+func (s *CommentService) list(filter string) ([]api.Comment, error) {
+	keys, err := s.kv.ListKeysFiltered(s.ctx, filter)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return []api.Comment{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+	comments := []api.Comment{}
+	for key := range keys.Keys() {
+		articleID, id, _ := strings.Cut(key, ".")
+		comment, _, err := s.get(articleID, id)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue // deleted since listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	slices.SortFunc(comments, byCreated)
+	return comments, nil
+}
+
+// ----------- NOTIFICATIONS -----------
+
+// username looks the display name of a user up, falling back to the id.
+func (s *CommentService) username(userID string) string {
+	reqBytes, err := json.Marshal(whoApi.UserGetRequest{ID: userID})
+	if err != nil {
+		return userID
+	}
+	msg, err := s.nc.Request(whoApi.Subj.UserGroup+"."+whoApi.Subj.UserGet, reqBytes, 2*time.Second)
+	if err != nil || msg.Header.Get("Nats-Service-Error") != "" {
+		return userID
+	}
+	var user whoApi.UserFullResponse
+	if err := json.Unmarshal(msg.Data, &user); err != nil || user.Username == "" {
+		return userID
+	}
+	return user.Username
+}
+
+// notifyOwners tells the owners of art about a new comment through ntfy,
+// except the one who wrote it.
+func (s *CommentService) notifyOwners(art articles.Article, comment api.Comment) {
+	message := comment.Body
+	if utf8.RuneCountInString(message) > 200 {
+		message = string([]rune(message)[:200]) + "…"
+	}
+	title := fmt.Sprintf("%s commented on %q", comment.Name, art.Title)
+	if comment.Status == api.StatusPending {
+		title = fmt.Sprintf("%s commented on %q (awaiting moderation)", comment.Name, art.Title)
+	}
+	for _, owner := range art.Owners {
+		if owner == comment.UserID {
+			continue
+		}
+		notification := ntfy.Notification{
+			ID:       uuid.NewString(),
+			UserID:   owner,
+			Title:    title,
+			Message:  message,
+			Category: "comments",
+			Priority: ntfy.PriorityNormal,
+			Data: map[string]interface{}{
+				"article_id": comment.ArticleID,
+				"comment_id": comment.ID,
+			},
+			CreatedAt: time.Now(),
+		}
+		data, err := json.Marshal(notification)
+		if err != nil {
+			s.l.Error("failed to marshal notification: %v", err)
+			continue
+		}
+		msg, err := s.nc.Request(ntfy.SubjectNotification, data, 10*time.Second)
+		if err != nil {
+			s.l.Warn("failed to notify owner %s: %v", owner, err)
+			continue
+		}
+		if msg.Header.Get("Nats-Service-Error") != "" {
+			s.l.Warn("failed to notify owner %s: %s", owner, msg.Header.Get("Nats-Service-Error"))
+		}
+	}
+}
+
+// ----------- THREADS -----------
+
+func validateCreate(req api.CommentCreateRequest) error {
+	if _, err := uuid.Parse(req.ArticleID); err != nil {
+		return fmt.Errorf("invalid article id")
+	}
+	if req.ParentID != "" {
+		if _, err := uuid.Parse(req.ParentID); err != nil {
+			return fmt.Errorf("invalid parent id")
+		}
+	}
+	if req.Body == "" {
+		return fmt.Errorf("body is required")
+	}
+	if utf8.RuneCountInString(req.Body) > MaxBodyLength {
+		return fmt.Errorf("body is longer than %d characters", MaxBodyLength)
+	}
+	if req.UserID == "" && req.Name == "" {
+		return fmt.Errorf("name is required for anonymous comments")
+	}
+	if utf8.RuneCountInString(req.Name) > MaxNameLength {
+		return fmt.Errorf("name is longer than %d characters", MaxNameLength)
+	}
+	return nil
+}
+
+// Threads nests comments under the comment they reply to, oldest first at
+// every level. Replies whose parent is not among comments (hidden or
+// deleted) become threads of their own.
+func Threads(comments []api.Comment) []api.Thread {
+	sorted := slices.Clone(comments)
+	slices.SortFunc(sorted, byCreated)
+
+	present := make(map[string]bool, len(sorted))
+	for _, c := range sorted {
+		present[c.ID] = true
+	}
+	children := map[string][]api.Comment{}
+	var roots []api.Comment
+	for _, c := range sorted {
+		if c.ParentID != "" && c.ParentID != c.ID && present[c.ParentID] {
+			children[c.ParentID] = append(children[c.ParentID], c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	// a parent always exists before its replies, so there are no cycles
+	var build func(c api.Comment) api.Thread
+	build = func(c api.Comment) api.Thread {
+		t := api.Thread{Comment: c, Replies: []api.Thread{}}
+		for _, child := range children[c.ID] {
+			t.Replies = append(t.Replies, build(child))
+		}
+		return t
+	}
+	threads := make([]api.Thread, 0, len(roots))
+	for _, c := range roots {
+		threads = append(threads, build(c))
+	}
+	return threads
+}
+
+func byCreated(a, b api.Comment) int {
+	if a.CreatedAt != b.CreatedAt {
+		return int(a.CreatedAt - b.CreatedAt)
+	}
+	return strings.Compare(a.ID, b.ID)
+}
@@ -0,0 +1,242 @@
+package comments
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/comments/api"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/ntfy"
+)
+
+func testService(t testing.TB) (*nats.Conn, articles.ArticleRepo) {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{
+		ServerName: "test-comments",
+		NoLog:      true,
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL(), nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		nc.Close()
+		ns.Shutdown()
+	})
+	l := jst_log.NewLogger("test", jst_log.DefaultSubjects())
+	repo, err := articles.Repo(ctx, nc, l)
+	if err != nil {
+		t.Fatalf("repo: %v", err)
+	}
+	svc, err := New(ctx, &Conf{NatsConn: nc, Logger: l, ArticleRepo: repo})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err = svc.Start(ctx); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	return nc, repo
+}
+
+// request calls an endpoint and returns the service error code, if any.
+func request(t testing.TB, nc *nats.Conn, endpoint string, req, resp any) string {
+	t.Helper()
+	data, _ := json.Marshal(req)
+	msg, err := nc.Request(api.Subj.CommentGroup+"."+endpoint, data, 2*time.Second)
+	if err != nil {
+		t.Fatalf("request %s: %v", endpoint, err)
+	}
+	if code := msg.Header.Get("Nats-Service-Error-Code"); code != "" {
+		return code
+	}
+	if err := json.Unmarshal(msg.Data, resp); err != nil {
+		t.Fatalf("decode %s: %v", endpoint, err)
+	}
+	return ""
+}
+
+func TestThreads(t *testing.T) {
+	comments := []api.Comment{
+		{ID: "c", ParentID: "a", CreatedAt: 3},
+		{ID: "a", CreatedAt: 1},
+		{ID: "b", CreatedAt: 2},
+		{ID: "d", ParentID: "c", CreatedAt: 4},
+		{ID: "e", ParentID: "hidden", CreatedAt: 5},
+		{ID: "f", ParentID: "a", CreatedAt: 6},
+	}
+	threads := Threads(comments)
+	var shape func([]api.Thread) string
+	shape = func(ts []api.Thread) string {
+		out := ""
+		for _, t := range ts {
+			out += t.ID
+			if len(t.Replies) > 0 {
+				out += "(" + shape(t.Replies) + ")"
+			}
+		}
+		return out
+	}
+	if got, want := shape(threads), "a(c(d)f)be"; got != want {
+		t.Errorf("threads = %s, want %s", got, want)
+	}
+}
+
+func TestComments(t *testing.T) {
+	nc, repo := testService(t)
+
+	notified := make(chan ntfy.Notification, 16)
+	if _, err := nc.Subscribe(ntfy.SubjectNotification, func(m *nats.Msg) {
+		var n ntfy.Notification
+		_ = json.Unmarshal(m.Data, &n)
+		notified <- n
+		_ = m.Respond([]byte("success"))
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	art := articles.TestArticle()
+	art.Status = articles.StatusPublished
+	art.PublishedAt = int(time.Now().Add(-time.Hour).UnixMilli())
+	art.Owners = []string{"owner", "editor"}
+	art, err := repo.Create(art)
+	if err != nil {
+		t.Fatalf("create article: %v", err)
+	}
+	draft := articles.TestArticle()
+	draft.Slug, draft.Status = "draft", articles.StatusDraft
+	draft, _ = repo.Create(draft)
+	articleID := art.Id.String()
+
+	// invalid requests
+	var c api.Comment
+	for _, req := range []api.CommentCreateRequest{
+		{ArticleID: articleID, Body: "no name"},
+		{ArticleID: articleID, Name: "anon", Body: "  "},
+		{ArticleID: "nope", Name: "anon", Body: "hi"},
+	} {
+		if code := request(t, nc, api.Subj.CommentCreate, req, &c); code != "INVALID_REQUEST" {
+			t.Errorf("expected %+v to be invalid, got %q", req, code)
+		}
+	}
+	if code := request(t, nc, api.Subj.CommentCreate, api.CommentCreateRequest{ArticleID: draft.Id.String(), Name: "anon", Body: "hi"}, &c); code != "NOT_FOUND" {
+		t.Errorf("expected comments on drafts to be refused, got %q", code)
+	}
+
+	// an anonymous comment waits for moderation and notifies the owners
+	var first api.Comment
+	if code := request(t, nc, api.Subj.CommentCreate, api.CommentCreateRequest{ArticleID: articleID, Name: " anon ", Body: "first!"}, &first); code != "" {
+		t.Fatalf("create: %s", code)
+	}
+	if first.Status != api.StatusPending || first.Name != "anon" {
+		t.Errorf("unexpected comment %+v", first)
+	}
+	owners := map[string]bool{}
+	for range art.Owners {
+		select {
+		case n := <-notified:
+			if n.Message != "first!" || n.Data["comment_id"] != first.ID {
+				t.Errorf("unexpected notification %+v", n)
+			}
+			owners[n.UserID] = true
+		case <-time.After(2 * time.Second):
+			t.Errorf("owners were not notified, got %v", owners)
+		}
+	}
+	if !owners["owner"] || !owners["editor"] {
+		t.Errorf("expected both owners to be notified, got %v", owners)
+	}
+
+	// replies need an approved parent
+	reply := api.CommentCreateRequest{ArticleID: articleID, ParentID: first.ID, Name: "other", Body: "reply"}
+	if code := request(t, nc, api.Subj.CommentCreate, reply, &c); code != "NOT_FOUND" {
+		t.Errorf("expected reply to pending comment to be refused, got %q", code)
+	}
+
+	var list api.CommentListResponse
+	if code := request(t, nc, api.Subj.CommentList, api.CommentListRequest{ArticleID: draft.Id.String()}, &list); code != "NOT_FOUND" {
+		t.Errorf("expected the comments of drafts to be hidden, got %q", code)
+	}
+	if code := request(t, nc, api.Subj.CommentList, api.CommentListRequest{ArticleID: draft.Id.String(), IncludeHidden: true}, &list); code != "" {
+		t.Errorf("expected editors to list the comments of drafts, got %q", code)
+	}
+	request(t, nc, api.Subj.CommentList, api.CommentListRequest{ArticleID: articleID}, &list)
+	if list.Total != 0 {
+		t.Errorf("pending comment is visible: %+v", list)
+	}
+	var queue api.CommentQueueResponse
+	request(t, nc, api.Subj.CommentQueue, api.CommentQueueRequest{}, &queue)
+	if queue.Total != 1 || queue.Comments[0].ID != first.ID {
+		t.Errorf("unexpected queue %+v", queue)
+	}
+
+	var approved api.Comment
+	moderate := api.CommentModerateRequest{ArticleID: articleID, ID: first.ID, Action: api.ActionApprove, By: "editor"}
+	if code := request(t, nc, api.Subj.CommentModerate, moderate, &approved); code != "" {
+		t.Fatalf("approve: %s", code)
+	}
+	if approved.Status != api.StatusApproved || approved.ModeratedBy != "editor" {
+		t.Errorf("unexpected approved comment %+v", approved)
+	}
+
+	// an editor's reply is approved right away
+	editorReply := api.CommentCreateRequest{ArticleID: articleID, ParentID: first.ID, UserID: "editor", Body: "thanks", AutoApprove: true}
+	if code := request(t, nc, api.Subj.CommentCreate, editorReply, &c); code != "" || c.Status != api.StatusApproved || c.Name != "editor" {
+		t.Errorf("unexpected editor reply %+v (%s)", c, code)
+	}
+	select {
+	case n := <-notified:
+		if n.UserID != "owner" || n.Data["comment_id"] != c.ID {
+			t.Errorf("expected only the other owner to be notified, got %+v", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("owner was not notified")
+	}
+	select {
+	case n := <-notified:
+		t.Errorf("expected no notification of the commenting owner, got %+v", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if code := request(t, nc, api.Subj.CommentCreate, reply, &c); code != "" {
+		t.Errorf("reply: %s", code)
+	}
+
+	request(t, nc, api.Subj.CommentList, api.CommentListRequest{ArticleID: articleID}, &list)
+	if list.Total != 2 || len(list.Threads) != 1 || len(list.Threads[0].Replies) != 1 {
+		t.Errorf("expected the comment with the editor reply, got %+v", list)
+	}
+	request(t, nc, api.Subj.CommentList, api.CommentListRequest{ArticleID: articleID, IncludeHidden: true}, &list)
+	if list.Total != 3 || len(list.Threads[0].Replies) != 2 {
+		t.Errorf("expected editors to see the pending reply, got %+v", list)
+	}
+
+	// stale and unknown moderation
+	moderate.Action = "shrug"
+	if code := request(t, nc, api.Subj.CommentModerate, moderate, &c); code != "INVALID_REQUEST" {
+		t.Errorf("expected unknown action to be invalid, got %q", code)
+	}
+	moderate.Action, moderate.ID = api.ActionDelete, c.ID
+	if code := request(t, nc, api.Subj.CommentModerate, moderate, &c); code != "" {
+		t.Errorf("delete: %s", code)
+	}
+	if code := request(t, nc, api.Subj.CommentModerate, moderate, &c); code != "NOT_FOUND" {
+		t.Errorf("expected deleted comment to be gone, got %q", code)
+	}
+}
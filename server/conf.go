@@ -3,8 +3,11 @@ package main
 import (
 	"flag"
 	"log"
+	"net/url"
+	"strings"
 	"time"
 
+	"jst_dev/server/articles"
 	"jst_dev/server/talk"
 )
 
@@ -15,6 +18,7 @@ type GlobalConfig struct {
 	WebJwtSecret string
 	WebHashSalt  string
 	WebPort      string
+	SiteURL      string // public url of the site, without trailing slash
 	NtfyToken    string
 
 	AppName       string
@@ -25,11 +29,15 @@ type GlobalConfig struct {
 	Flags Flags
 }
 
+// defaultSiteURL is used when SITE_URL is not set.
+const defaultSiteURL = "https://jst.dev"
+
 type Flags struct {
-	NatsEmbedded  bool
-	ProxyFrontend bool
-	LogLevel      string
-	SlowSocket    time.Duration
+	NatsEmbedded   bool
+	ProxyFrontend  bool
+	LogLevel       string
+	SlowSocket     time.Duration
+	TrashRetention time.Duration
 }
 
 // loadConf returns a GlobalConfig instance with default settings for the talk component.
@@ -37,12 +45,13 @@ func loadConf(getenv func(string) string) (*GlobalConfig, error) {
 	var (
 		natsEmbedded, proxyFrontend bool
 		logLevel                    string
-		slowSocket                  time.Duration
+		slowSocket, trashRetention  time.Duration
 	)
 	flag.BoolVar(&natsEmbedded, "local", false, "run an embedded nats server")
 	flag.BoolVar(&proxyFrontend, "proxy", false, "proxy frontend to dev server")
 	flag.StringVar(&logLevel, "log", "info", "set log level (debug, info, warn, error, fatal)")
 	flag.DurationVar(&slowSocket, "slow", 0, "add sleep delay to socket sends (e.g., 100ms, 1s)")
+	flag.DurationVar(&trashRetention, "trash-retention", articles.DefaultTrashRetention, "how long deleted articles are kept before they are purged")
 	flag.Parse()
 
 	envNatsJwt := getenv("NATS_JWT")
@@ -70,6 +79,16 @@ func loadConf(getenv func(string) string) (*GlobalConfig, error) {
 		log.Fatalf("missing env-var: PORT")
 	}
 
+	// SITE_URL is optional. Absolute links in feeds and article pages are
+	// built from it, never from the Host header of the request.
+	envSiteURL := strings.TrimSuffix(getenv("SITE_URL"), "/")
+	if envSiteURL == "" {
+		envSiteURL = defaultSiteURL
+	}
+	if u, err := url.Parse(envSiteURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Fatalf("invalid env-var: SITE_URL: %q", envSiteURL)
+	}
+
 	// NTFY_TOKEN is optional
 	envNtfyToken := getenv("NTFY_TOKEN")
 	if envNtfyToken == "" {
@@ -82,6 +101,7 @@ func loadConf(getenv func(string) string) (*GlobalConfig, error) {
 		WebJwtSecret: envJwtSecret,
 		WebHashSalt:  envHashSalt,
 		WebPort:      envPort,
+		SiteURL:      envSiteURL,
 		NtfyToken:    envNtfyToken,
 
 		AppName:       getenv("FLY_APP_NAME"),
@@ -93,10 +113,11 @@ func loadConf(getenv func(string) string) (*GlobalConfig, error) {
 			ListenOnLocalhost: true,
 		},
 		Flags: Flags{
-			NatsEmbedded:  natsEmbedded,
-			ProxyFrontend: proxyFrontend,
-			LogLevel:      logLevel,
-			SlowSocket:    slowSocket,
+			NatsEmbedded:   natsEmbedded,
+			ProxyFrontend:  proxyFrontend,
+			LogLevel:       logLevel,
+			SlowSocket:     slowSocket,
+			TrashRetention: trashRetention,
 		},
 	}
 
@@ -0,0 +1,189 @@
+// Package djot parses Djot (https://djot.net) into an AST and renders it as
+// sanitised HTML or plain text.
+//
+// It covers the parts of Djot articles are written in: headings (with
+// generated ids), paragraphs, emphasis, links and images (inline, reference
+// and auto), verbatim, code blocks, block quotes, bullet and ordered lists,
+// divs, thematic breaks, attributes and footnotes. Raw HTML is parsed but
+// never rendered.
+package djot
+
+import "strings"
+
+// Kind is the type of a Node.
+type Kind int
+
+const (
+	// blocks
+	KindParagraph Kind = iota
+	KindHeading
+	KindThematicBreak
+	KindCodeBlock
+	KindRawBlock
+	KindBlockQuote
+	KindDiv
+	KindBulletList
+	KindOrderedList
+	KindListItem
+	KindFootnote
+
+	// inlines
+	KindText
+	KindSoftBreak
+	KindHardBreak
+	KindEmphasis
+	KindStrong
+	KindVerbatim
+	KindRawInline
+	KindLink
+	KindImage
+	KindSpan
+	KindFootnoteRef
+)
+
+var kindNames = [...]string{
+	KindParagraph:     "paragraph",
+	KindHeading:       "heading",
+	KindThematicBreak: "thematic_break",
+	KindCodeBlock:     "code_block",
+	KindRawBlock:      "raw_block",
+	KindBlockQuote:    "block_quote",
+	KindDiv:           "div",
+	KindBulletList:    "bullet_list",
+	KindOrderedList:   "ordered_list",
+	KindListItem:      "list_item",
+	KindFootnote:      "footnote",
+	KindText:          "text",
+	KindSoftBreak:     "soft_break",
+	KindHardBreak:     "hard_break",
+	KindEmphasis:      "emphasis",
+	KindStrong:        "strong",
+	KindVerbatim:      "verbatim",
+	KindRawInline:     "raw_inline",
+	KindLink:          "link",
+	KindImage:         "image",
+	KindSpan:          "span",
+	KindFootnoteRef:   "footnote_reference",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "unknown"
+}
+
+// IsBlock reports whether nodes of the kind are block level.
+func (k Kind) IsBlock() bool {
+	return k < KindText
+}
+
+// Node is a block or inline element. Which fields are used depends on Kind.
+type Node struct {
+	Kind     Kind
+	Attrs    Attrs
+	Children []*Node
+	Text     string // text, verbatim, code block and raw content
+	Level    int    // heading level
+	Lang     string // code block language, raw format
+	Dest     string // link and image destination, resolved
+	Label    string // reference label of links and images, footnote label
+	Tight    bool   // lists: items are not separated by blank lines
+	Start    int    // ordered lists: number of the first item
+}
+
+// Document is a parsed Djot document.
+type Document struct {
+	Children   []*Node
+	References map[string]string // reference label -> destination
+	Footnotes  map[string]*Node  // footnote label -> definition
+}
+
+// Walk calls fn for every node in the document, depth first, children after
+// their parent. Footnote definitions are walked after the main content in
+// the order they are referenced. Returning false skips the node's children.
+func (d *Document) Walk(fn func(n *Node) bool) {
+	walk(d.Children, fn)
+	for _, note := range d.NoteOrder() {
+		if n := d.Footnotes[note]; n != nil {
+			walk([]*Node{n}, fn)
+		}
+	}
+}
+
+func walk(nodes []*Node, fn func(n *Node) bool) {
+	for _, n := range nodes {
+		if fn(n) {
+			walk(n.Children, fn)
+		}
+	}
+}
+
+// NoteOrder returns the labels of referenced footnotes in the order of their
+// first reference, which is also their number (index + 1).
+func (d *Document) NoteOrder() []string {
+	var (
+		order []string
+		seen  = map[string]bool{}
+	)
+	var visit func(nodes []*Node)
+	visit = func(nodes []*Node) {
+		for _, n := range nodes {
+			if n.Kind == KindFootnoteRef && !seen[n.Label] {
+				seen[n.Label] = true
+				order = append(order, n.Label)
+			}
+			visit(n.Children)
+		}
+	}
+	visit(d.Children)
+	// notes referenced from notes are numbered after them
+	for i := 0; i < len(order); i++ {
+		if def := d.Footnotes[order[i]]; def != nil {
+			visit(def.Children)
+		}
+	}
+	return order
+}
+
+// Attr is a single key value attribute.
+type Attr struct {
+	Key, Value string
+}
+
+// Attrs keeps attributes in the order they were written.
+type Attrs []Attr
+
+// Get returns the value of key, "" if unset.
+func (a Attrs) Get(key string) string {
+	for _, attr := range a {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Set sets key to value. Classes accumulate, everything else is replaced.
+func (a Attrs) Set(key, value string) Attrs {
+	for i, attr := range a {
+		if attr.Key != key {
+			continue
+		}
+		if key == "class" {
+			a[i].Value = strings.TrimSpace(attr.Value + " " + value)
+		} else {
+			a[i].Value = value
+		}
+		return a
+	}
+	return append(a, Attr{key, value})
+}
+
+// Merge sets every attribute of other on a.
+func (a Attrs) Merge(other Attrs) Attrs {
+	for _, attr := range other {
+		a = a.Set(attr.Key, attr.Value)
+	}
+	return a
+}
@@ -0,0 +1,490 @@
+package djot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Parse parses src into a Document. Parsing never fails, anything that is
+// not recognised ends up as text.
+func Parse(src string) *Document {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	p := &parser{
+		doc: &Document{
+			References: map[string]string{},
+			Footnotes:  map[string]*Node{},
+		},
+		ids:         map[string]bool{},
+		idCounts:    map[string]int{},
+		headingRefs: map[string]string{},
+	}
+	p.doc.Children = p.blocks(splitLines(src))
+	p.resolve(p.doc.Children)
+	for _, note := range p.doc.Footnotes {
+		p.resolve(note.Children)
+	}
+	return p.doc
+}
+
+// MaxNesting is how deep divs, block quotes, lists and footnotes nest, and
+// how many inline openers (emphasis, brackets) wait for their closer at once.
+// Deeper containers are read as paragraphs and further openers as text:
+// every level handles what it holds again, so the bound keeps parsing linear.
+const MaxNesting = 32
+
+type parser struct {
+	doc         *Document
+	ids         map[string]bool   // ids used by headings so far
+	idCounts    map[string]int    // base id -> last number appended to it
+	headingRefs map[string]string // implicit references to headings
+	depth       int               // containers the lines being parsed are in
+}
+
+func splitLines(src string) []string {
+	lines := strings.Split(strings.TrimRight(src, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	return lines
+}
+
+// expandTabs replaces tabs in the indentation with four spaces each.
+func expandTabs(line string) string {
+	n := 0
+	for n < len(line) && (line[n] == ' ' || line[n] == '\t') {
+		n++
+	}
+	if !strings.Contains(line[:n], "\t") {
+		return line
+	}
+	return strings.ReplaceAll(line[:n], "\t", "    ") + line[n:]
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// dedent strips up to n leading spaces.
+func dedent(line string, n int) string {
+	if ind := indentOf(line); ind < n {
+		n = ind
+	}
+	return line[n:]
+}
+
+// blocks parses a sequence of lines into block nodes.
+func (p *parser) blocks(lines []string) []*Node {
+	var (
+		nodes   []*Node
+		pending Attrs // block attributes waiting for the block they belong to
+	)
+	p.depth++
+	defer func() { p.depth-- }()
+	nested := p.depth <= MaxNesting
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			i++
+			continue
+		}
+
+		var node *Node
+		switch {
+		case strings.HasPrefix(trimmed, "{") && strings.HasSuffix(trimmed, "}"):
+			if attrs, n, ok := parseAttrs(trimmed); ok && n == len(trimmed) {
+				pending = pending.Merge(attrs)
+				i++
+				continue
+			}
+		case strings.HasPrefix(trimmed, "```"):
+			node, i = p.codeBlock(lines, i)
+		case strings.HasPrefix(trimmed, ":::") && nested:
+			node, i = p.div(lines, i)
+		case isThematicBreak(trimmed):
+			node, i = &Node{Kind: KindThematicBreak}, i+1
+		case headingLevel(trimmed) > 0:
+			node, i = p.heading(lines, i, pending)
+		case (trimmed == ">" || strings.HasPrefix(trimmed, "> ")) && nested:
+			node, i = p.blockQuote(lines, i)
+		case strings.HasPrefix(trimmed, "[^") && nested:
+			if label, rest, ok := parseDefinition(trimmed); ok {
+				i = p.footnote(lines, i, label[1:], rest)
+				continue
+			}
+		case strings.HasPrefix(trimmed, "["):
+			if label, dest, ok := parseDefinition(trimmed); ok && !strings.Contains(dest, " ") {
+				if _, taken := p.doc.References[normalizeLabel(label)]; !taken {
+					p.doc.References[normalizeLabel(label)] = dest
+				}
+				i++
+				continue
+			}
+		}
+		if node == nil {
+			if m, ok := parseMarker(line); ok && nested {
+				node, i = p.list(lines, i, m)
+			} else {
+				node, i = p.paragraph(lines, i)
+			}
+		}
+		if node.Kind != KindHeading { // headings take theirs before generating an id
+			node.Attrs = node.Attrs.Merge(pending)
+		}
+		pending = nil
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// startsBlock reports whether line would start something other than a
+// paragraph continuation.
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if _, ok := parseMarker(line); ok {
+		return true
+	}
+	return strings.HasPrefix(trimmed, "```") ||
+		strings.HasPrefix(trimmed, ":::") ||
+		strings.HasPrefix(trimmed, ">") ||
+		isThematicBreak(trimmed) ||
+		headingLevel(trimmed) > 0
+}
+
+// --- PARAGRAPHS AND HEADINGS ---
+
+func (p *parser) paragraph(lines []string, i int) (*Node, int) {
+	var text []string
+	for ; i < len(lines) && !isBlank(lines[i]); i++ {
+		text = append(text, strings.TrimSpace(lines[i]))
+	}
+	return &Node{Kind: KindParagraph, Children: parseInlines(strings.Join(text, "\n"))}, i
+}
+
+func headingLevel(trimmed string) int {
+	n := 0
+	for n < len(trimmed) && trimmed[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || (n < len(trimmed) && trimmed[n] != ' ') {
+		return 0
+	}
+	return n
+}
+
+func (p *parser) heading(lines []string, i int, attrs Attrs) (*Node, int) {
+	trimmed := strings.TrimSpace(lines[i])
+	level := headingLevel(trimmed)
+	text := []string{strings.TrimSpace(trimmed[level:])}
+	prefix := strings.Repeat("#", level) + " "
+	for i++; i < len(lines) && !isBlank(lines[i]); i++ {
+		line := strings.TrimSpace(lines[i])
+		text = append(text, strings.TrimSpace(strings.TrimPrefix(line, prefix)))
+	}
+	node := &Node{
+		Kind:     KindHeading,
+		Level:    level,
+		Children: parseInlines(strings.Join(text, "\n")),
+		Attrs:    attrs,
+	}
+	plain := inlineText(node.Children)
+	id := attrs.Get("id")
+	if id == "" {
+		id = p.uniqueID(plain)
+		node.Attrs = append(Attrs{{"id", id}}, node.Attrs...)
+	}
+	p.ids[id] = true
+	if _, ok := p.headingRefs[normalizeLabel(plain)]; !ok {
+		p.headingRefs[normalizeLabel(plain)] = "#" + id
+	}
+	return node, i
+}
+
+// uniqueID derives an anchor id from heading text the way the djot reference
+// implementation does: punctuation is dropped, whitespace becomes "-", and a
+// number is appended if the id is already taken.
+func (p *parser) uniqueID(text string) string {
+	var b strings.Builder
+	for _, f := range strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || (unicode.IsPunct(r) || unicode.IsSymbol(r)) && r != '-' && r != '_'
+	}) {
+		if b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(f)
+	}
+	base := b.String()
+	if base == "" {
+		base = "s"
+	}
+	id := base
+	for p.ids[id] {
+		p.idCounts[base]++
+		id = fmt.Sprintf("%s-%d", base, p.idCounts[base])
+	}
+	return id
+}
+
+func isThematicBreak(trimmed string) bool {
+	count := 0
+	var mark rune
+	for _, r := range trimmed {
+		switch {
+		case r == ' ':
+		case (r == '-' || r == '*') && (mark == 0 || r == mark):
+			mark = r
+			count++
+		default:
+			return false
+		}
+	}
+	return count >= 3
+}
+
+// --- FENCED BLOCKS ---
+
+func (p *parser) codeBlock(lines []string, i int) (*Node, int) {
+	indent := indentOf(lines[i])
+	trimmed := strings.TrimSpace(lines[i])
+	fence := len(trimmed) - len(strings.TrimLeft(trimmed, "`"))
+	info := strings.TrimSpace(trimmed[fence:])
+	if strings.Contains(info, "`") {
+		return p.paragraph(lines, i)
+	}
+
+	var content []string
+	for i++; i < len(lines); i++ {
+		t := strings.TrimSpace(lines[i])
+		if strings.Trim(t, "`") == "" && len(t) >= fence {
+			i++
+			break
+		}
+		content = append(content, dedent(lines[i], indent))
+	}
+	node := &Node{Kind: KindCodeBlock, Lang: info, Text: strings.Join(content, "\n")}
+	if len(content) > 0 {
+		node.Text += "\n"
+	}
+	if strings.HasPrefix(info, "=") {
+		node.Kind, node.Lang = KindRawBlock, info[1:]
+	}
+	return node, i
+}
+
+func (p *parser) div(lines []string, i int) (*Node, int) {
+	trimmed := strings.TrimSpace(lines[i])
+	fence := len(trimmed) - len(strings.TrimLeft(trimmed, ":"))
+	class := strings.TrimSpace(trimmed[fence:])
+
+	var (
+		content []string
+		depth   = 0
+	)
+	for i++; i < len(lines); i++ {
+		t := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(t, strings.Repeat(":", fence)) {
+			if strings.Trim(t, ":") == "" {
+				if depth == 0 {
+					i++
+					break
+				}
+				depth--
+			} else {
+				depth++
+			}
+		}
+		content = append(content, lines[i])
+	}
+	node := &Node{Kind: KindDiv, Children: p.blocks(content)}
+	if class != "" {
+		node.Attrs = node.Attrs.Set("class", class)
+	}
+	return node, i
+}
+
+// --- CONTAINERS ---
+
+func (p *parser) blockQuote(lines []string, i int) (*Node, int) {
+	var content []string
+	for ; i < len(lines); i++ {
+		t := strings.TrimSpace(lines[i])
+		switch {
+		case t == ">":
+			content = append(content, "")
+		case strings.HasPrefix(t, "> "):
+			content = append(content, t[2:])
+		case t != "" && len(content) > 0 && content[len(content)-1] != "" && !startsBlock(t):
+			content = append(content, t) // lazy paragraph continuation
+		default:
+			return &Node{Kind: KindBlockQuote, Children: p.blocks(content)}, i
+		}
+	}
+	return &Node{Kind: KindBlockQuote, Children: p.blocks(content)}, i
+}
+
+// parseDefinition parses "[label]: rest".
+func parseDefinition(s string) (label, rest string, ok bool) {
+	end := strings.Index(s, "]:")
+	if !strings.HasPrefix(s, "[") || end < 2 {
+		return "", "", false
+	}
+	return s[1:end], strings.TrimSpace(s[end+2:]), true
+}
+
+func normalizeLabel(label string) string {
+	return strings.Join(strings.Fields(label), " ")
+}
+
+func (p *parser) footnote(lines []string, i int, label, first string) int {
+	indent := indentOf(lines[i])
+	content := []string{first}
+	i, content = gather(lines, i+1, indent, indent+2, content)
+	if _, ok := p.doc.Footnotes[label]; !ok {
+		p.doc.Footnotes[label] = &Node{Kind: KindFootnote, Label: label, Children: p.blocks(content)}
+	}
+	return i
+}
+
+// gather collects the lines belonging to a container opened at column
+// indent: blank lines, lines indented past it (dedented by up to width) and
+// lazy paragraph continuations. Trailing blank lines are left for the caller.
+func gather(lines []string, i, indent, width int, content []string) (int, []string) {
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			content = append(content, "")
+		case indentOf(line) > indent:
+			content = append(content, dedent(line, width))
+		case !isBlank(content[len(content)-1]) && !startsBlock(line):
+			content = append(content, strings.TrimSpace(line))
+		default:
+			return unread(i, content)
+		}
+	}
+	return unread(i, content)
+}
+
+// unread gives trailing blank lines back.
+func unread(i int, content []string) (int, []string) {
+	for len(content) > 1 && isBlank(content[len(content)-1]) {
+		content = content[:len(content)-1]
+		i--
+	}
+	return i, content
+}
+
+// --- LISTS ---
+
+type marker struct {
+	indent  int  // column of the marker
+	width   int  // column the content starts at
+	bullet  byte // '-', '*' or '+', 0 for ordered lists
+	delim   string
+	ordinal int
+}
+
+func (m marker) sameList(o marker) bool {
+	return m.bullet == o.bullet && m.delim == o.delim
+}
+
+func parseMarker(line string) (marker, bool) {
+	m := marker{indent: indentOf(line)}
+	rest := line[m.indent:]
+	switch {
+	case rest == "":
+		return m, false
+	case rest[0] == '-' || rest[0] == '*' || rest[0] == '+':
+		if isThematicBreak(strings.TrimSpace(rest)) {
+			return m, false
+		}
+		m.bullet = rest[0]
+		rest = rest[1:]
+		m.width = m.indent + 1
+	default:
+		open := strings.HasPrefix(rest, "(")
+		digits := strings.TrimPrefix(rest, "(")
+		n := 0
+		for n < len(digits) && n < 9 && digits[n] >= '0' && digits[n] <= '9' {
+			n++
+		}
+		if n == 0 || n >= len(digits) {
+			return m, false
+		}
+		switch {
+		case open && digits[n] == ')':
+			m.delim = "()"
+		case !open && (digits[n] == '.' || digits[n] == ')'):
+			m.delim = string(digits[n])
+		default:
+			return m, false
+		}
+		m.ordinal, _ = strconv.Atoi(digits[:n])
+		m.width = m.indent + len(rest) - len(digits[n+1:])
+		rest = digits[n+1:]
+	}
+	if rest != "" && rest[0] != ' ' {
+		return m, false
+	}
+	m.width++
+	return m, true
+}
+
+func (p *parser) list(lines []string, i int, first marker) (*Node, int) {
+	list := &Node{Kind: KindBulletList, Tight: true}
+	if first.bullet == 0 {
+		list.Kind, list.Start = KindOrderedList, first.ordinal
+	}
+	for i < len(lines) {
+		m, ok := parseMarker(lines[i])
+		if !ok || !m.sameList(first) || m.indent >= first.width {
+			break
+		}
+		content := []string{strings.TrimSpace(lines[i][min(m.width, len(lines[i])):])}
+		i, content = gather(lines, i+1, m.indent, m.width, content)
+		for _, line := range content {
+			if isBlank(line) {
+				list.Tight = false // blank line between blocks of the item
+				break
+			}
+		}
+
+		list.Children = append(list.Children, &Node{Kind: KindListItem, Children: p.blocks(content)})
+
+		next := i
+		for next < len(lines) && isBlank(lines[next]) {
+			next++
+		}
+		if next < len(lines) && next > i {
+			if m, ok := parseMarker(lines[next]); ok && m.sameList(first) && m.indent < first.width {
+				list.Tight = false // blank line between items
+			}
+		}
+		i = next
+	}
+	return list, i
+}
+
+// --- REFERENCES ---
+
+// resolve fills in link and image destinations from the reference
+// definitions, which may come after their use.
+func (p *parser) resolve(nodes []*Node) {
+	for _, n := range nodes {
+		if (n.Kind == KindLink || n.Kind == KindImage) && n.Dest == "" && n.Label != "" {
+			label := normalizeLabel(n.Label)
+			if dest, ok := p.doc.References[label]; ok {
+				n.Dest = dest
+			} else if dest, ok := p.headingRefs[label]; ok {
+				n.Dest = dest
+			}
+		}
+		p.resolve(n.Children)
+	}
+}
@@ -0,0 +1,161 @@
+package djot_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/djot"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares got with testdata/name, or writes it there with -update.
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("write golden file: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file (run with -update to create it): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from golden file\n--- got ---\n%s\n--- want ---\n%s", name, got, want)
+	}
+}
+
+func TestGoldenSeedArticles(t *testing.T) {
+	for _, art := range []articles.Article{articles.TestArticle(), articles.NatsAllTheWayDown()} {
+		t.Run(art.Slug, func(t *testing.T) {
+			doc := djot.Parse(art.Content)
+			golden(t, art.Slug+".html", djot.RenderHTML(doc))
+			golden(t, art.Slug+".txt", djot.PlainText(doc))
+		})
+	}
+}
+
+func TestGoldenFeatures(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("testdata", "features.dj"))
+	if err != nil {
+		t.Fatalf("read features: %v", err)
+	}
+	doc := djot.Parse(string(src))
+	golden(t, "features.html", djot.RenderHTML(doc))
+	golden(t, "features.txt", djot.PlainText(doc))
+}
+
+func TestSanitize(t *testing.T) {
+	for _, src := range []string{
+		"[x](javascript:alert(1))",
+		"[x](JavaScript:alert(1))",
+		"![x](data:text/html,<script>)",
+		"`<script>`{=html}",
+		"``` =html\n<script>\n```",
+		"<script>alert(1)</script>",
+		"[x](/ok){onclick=\"alert(1)\"}",
+		"{.bad\"class}\npara",
+	} {
+		out := djot.ToHTML(src)
+		for _, bad := range []string{"javascript:", "JavaScript:", "data:", "<script", "onclick", "bad\""} {
+			if strings.Contains(out, bad) {
+				t.Errorf("%q rendered unsafe %q: %s", src, bad, out)
+			}
+		}
+	}
+}
+
+func TestHeadingIDs(t *testing.T) {
+	doc := djot.Parse("# In the beginning..\n\n# In the beginning..\n\n{#own}\n# Custom")
+	var ids []string
+	doc.Walk(func(n *djot.Node) bool {
+		if n.Kind == djot.KindHeading {
+			ids = append(ids, n.Attrs.Get("id"))
+		}
+		return true
+	})
+	want := []string{"In-the-beginning", "In-the-beginning-1", "own"}
+	if strings.Join(ids, " ") != strings.Join(want, " ") {
+		t.Errorf("expected ids %v, got %v", want, ids)
+	}
+}
+
+// pathological inputs, each parsed quadratically at some point
+var pathological = map[string]string{
+	"unclosed link destinations":  "[a](",
+	"unclosed image destinations": "![a](",
+	"unclosed emphasis":           "*a ",
+	"unmatched closers":           "_a a* ",
+	"unclosed brackets":           "[",
+	"footnote references":         "[^",
+	"autolinks":                   "<",
+	"escapes":                     "\\.",
+	"attributes":                  "{#a ",
+	"raw inlines":                 "`a`{=",
+	"divs":                        "::: d\n",
+	"block quotes":                "> ",
+	"lists":                       "- a\n  ",
+	"headings":                    "# a\n\n",
+}
+
+// TestPathological parses 100 KB of each pathological input. Parsing is
+// linear, so it takes milliseconds; the bound is loose enough for a slow
+// machine and still far below what a quadratic parser needs.
+func TestPathological(t *testing.T) {
+	for name, unit := range pathological {
+		t.Run(name, func(t *testing.T) {
+			src := strings.Repeat(unit, 100_000/len(unit))
+			start := time.Now()
+			djot.ToHTML(src)
+			if took := time.Since(start); took > time.Second {
+				t.Errorf("parsing took %s", took)
+			}
+		})
+	}
+}
+
+func BenchmarkPathological(b *testing.B) {
+	for name, unit := range pathological {
+		src := strings.Repeat(unit, 100_000/len(unit))
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			for i := 0; i < b.N; i++ {
+				djot.ToHTML(src)
+			}
+		})
+	}
+}
+
+func TestLinkDestinations(t *testing.T) {
+	for src, want := range map[string]string{
+		"[a](b(c)d)":            `<a href="b(c)d">a</a>`,
+		"[a](b\\)c) [d](e)":     `<a href="b\)c">a</a> <a href="e">d</a>`,
+		"[a](b [c](d)":          `[a](b <a href="d">c</a>`,
+		"(x [a](y)":             `(x <a href="y">a</a>`,
+		"[^n [a](z)\n\n[^n]: x": `<a href="z">a</a>`,
+	} {
+		if out := djot.ToHTML(src); !strings.Contains(out, want) {
+			t.Errorf("%q: expected %s in %s", src, want, out)
+		}
+	}
+}
+
+func FuzzToHTML(f *testing.F) {
+	for _, src := range []string{articles.TestArticle().Content, articles.NatsAllTheWayDown().Content, "[^a]: [^a]\n\n[^a]", "_*[x](y)*_", "```\n", "- \n  -\n    -", "{#a}\n::: x\n::::\n:::"} {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		if out := djot.ToHTML(src); strings.Contains(out, "<script") {
+			t.Errorf("unsafe output for %q: %s", src, out)
+		}
+		djot.ToText(src)
+	})
+}
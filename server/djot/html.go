@@ -0,0 +1,230 @@
+package djot
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// ToHTML parses src and renders it as sanitised HTML.
+func ToHTML(src string) string {
+	return RenderHTML(Parse(src))
+}
+
+// RenderHTML renders the document as HTML that is safe to embed in a page:
+// text is escaped, raw HTML is dropped, only id and class attributes are
+// kept and link and image destinations with a scheme other than http, https
+// or mailto are removed. Headings carry their id so they can be linked to.
+func RenderHTML(doc *Document) string {
+	r := &htmlRenderer{doc: doc, notes: map[string]int{}}
+	for i, label := range doc.NoteOrder() {
+		r.notes[label] = i + 1
+	}
+	r.blocks(doc.Children, false)
+	r.footnotes()
+	return r.b.String()
+}
+
+type htmlRenderer struct {
+	b     strings.Builder
+	doc   *Document
+	notes map[string]int // footnote label -> number
+}
+
+func (r *htmlRenderer) blocks(nodes []*Node, tight bool) {
+	for _, n := range nodes {
+		r.block(n, tight)
+	}
+}
+
+func (r *htmlRenderer) block(n *Node, tight bool) {
+	switch n.Kind {
+	case KindParagraph:
+		if tight {
+			r.inlines(n.Children)
+			r.b.WriteString("\n")
+			return
+		}
+		r.open("p", n.Attrs)
+		r.inlines(n.Children)
+		r.b.WriteString("</p>\n")
+	case KindHeading:
+		tag := "h" + strconv.Itoa(n.Level)
+		r.open(tag, n.Attrs)
+		r.inlines(n.Children)
+		r.b.WriteString("</" + tag + ">\n")
+	case KindThematicBreak:
+		r.open("hr", n.Attrs)
+		r.b.WriteString("\n")
+	case KindCodeBlock:
+		r.open("pre", n.Attrs)
+		if lang := sanitizeClass(n.Lang); lang != "" {
+			r.b.WriteString(`<code class="language-` + lang + `">`)
+		} else {
+			r.b.WriteString("<code>")
+		}
+		r.b.WriteString(html.EscapeString(n.Text))
+		r.b.WriteString("</code></pre>\n")
+	case KindRawBlock:
+		// raw html is not trusted
+	case KindBlockQuote:
+		r.open("blockquote", n.Attrs)
+		r.b.WriteString("\n")
+		r.blocks(n.Children, false)
+		r.b.WriteString("</blockquote>\n")
+	case KindDiv:
+		r.open("div", n.Attrs)
+		r.b.WriteString("\n")
+		r.blocks(n.Children, false)
+		r.b.WriteString("</div>\n")
+	case KindBulletList, KindOrderedList:
+		tag := "ul"
+		if n.Kind == KindOrderedList {
+			tag = "ol"
+		}
+		var extra []string
+		if n.Kind == KindOrderedList && n.Start != 1 {
+			extra = append(extra, `start="`+strconv.Itoa(n.Start)+`"`)
+		}
+		r.open(tag, n.Attrs, extra...)
+		r.b.WriteString("\n")
+		for _, item := range n.Children {
+			r.open("li", item.Attrs)
+			r.b.WriteString("\n")
+			r.blocks(item.Children, n.Tight)
+			r.b.WriteString("</li>\n")
+		}
+		r.b.WriteString("</" + tag + ">\n")
+	}
+}
+
+func (r *htmlRenderer) inlines(nodes []*Node) {
+	for _, n := range nodes {
+		r.inline(n)
+	}
+}
+
+func (r *htmlRenderer) inline(n *Node) {
+	switch n.Kind {
+	case KindText:
+		r.b.WriteString(html.EscapeString(n.Text))
+	case KindSoftBreak:
+		r.b.WriteString("\n")
+	case KindHardBreak:
+		r.b.WriteString("<br>\n")
+	case KindEmphasis:
+		r.wrap("em", n)
+	case KindStrong:
+		r.wrap("strong", n)
+	case KindSpan:
+		r.wrap("span", n)
+	case KindVerbatim:
+		r.open("code", n.Attrs)
+		r.b.WriteString(html.EscapeString(n.Text))
+		r.b.WriteString("</code>")
+	case KindRawInline:
+		// raw html is not trusted
+	case KindLink:
+		var extra []string
+		if dest, ok := SafeURL(n.Dest); ok {
+			extra = append(extra, `href="`+html.EscapeString(dest)+`"`)
+		}
+		r.open("a", n.Attrs, extra...)
+		r.inlines(n.Children)
+		r.b.WriteString("</a>")
+	case KindImage:
+		dest, ok := SafeURL(n.Dest)
+		if !ok {
+			r.b.WriteString(html.EscapeString(inlineText(n.Children)))
+			return
+		}
+		r.open("img", n.Attrs, `alt="`+html.EscapeString(inlineText(n.Children))+`"`, `src="`+html.EscapeString(dest)+`"`)
+	case KindFootnoteRef:
+		num := strconv.Itoa(r.notes[n.Label])
+		r.b.WriteString(`<a id="fnref` + num + `" href="#fn` + num + `" role="doc-noteref"><sup>` + num + `</sup></a>`)
+	}
+}
+
+func (r *htmlRenderer) wrap(tag string, n *Node) {
+	r.open(tag, n.Attrs)
+	r.inlines(n.Children)
+	r.b.WriteString("</" + tag + ">")
+}
+
+// open writes the opening tag with the attributes that survive sanitising,
+// followed by extra, already escaped, attributes.
+func (r *htmlRenderer) open(tag string, attrs Attrs, extra ...string) {
+	r.b.WriteString("<" + tag)
+	if id := attrs.Get("id"); id != "" {
+		r.b.WriteString(` id="` + html.EscapeString(id) + `"`)
+	}
+	if class := sanitizeClass(attrs.Get("class")); class != "" {
+		r.b.WriteString(` class="` + class + `"`)
+	}
+	for _, attr := range extra {
+		r.b.WriteString(" " + attr)
+	}
+	r.b.WriteString(">")
+}
+
+func (r *htmlRenderer) footnotes() {
+	order := r.doc.NoteOrder()
+	if len(order) == 0 {
+		return
+	}
+	r.b.WriteString("<section role=\"doc-endnotes\">\n<hr>\n<ol>\n")
+	for i, label := range order {
+		num := strconv.Itoa(i + 1)
+		r.b.WriteString(`<li id="fn` + num + `">` + "\n")
+		backlink := `<a href="#fnref` + num + `" role="doc-backlink">↩︎</a>`
+		var children []*Node
+		if note := r.doc.Footnotes[label]; note != nil {
+			children = note.Children
+		}
+		if n := len(children); n > 0 && children[n-1].Kind == KindParagraph {
+			r.blocks(children[:n-1], false)
+			last := children[n-1]
+			r.open("p", last.Attrs)
+			r.inlines(last.Children)
+			r.b.WriteString(backlink + "</p>\n")
+		} else {
+			r.blocks(children, false)
+			r.b.WriteString("<p>" + backlink + "</p>\n")
+		}
+		r.b.WriteString("</li>\n")
+	}
+	r.b.WriteString("</ol>\n</section>\n")
+}
+
+// SafeURL reports whether dest may be used as a link or image destination
+// and returns it trimmed. Relative urls, fragments and http, https and mailto
+// urls are fine, everything else (javascript:, data:, ...) is not.
+func SafeURL(dest string) (string, bool) {
+	dest = strings.TrimSpace(dest)
+	if dest == "" {
+		return "", false
+	}
+	colon := strings.IndexByte(dest, ':')
+	if colon < 0 || strings.IndexAny(dest[:colon], "/?#") >= 0 {
+		return dest, true // no scheme
+	}
+	switch strings.ToLower(dest[:colon]) {
+	case "http", "https", "mailto":
+		return dest, true
+	default:
+		return "", false
+	}
+}
+
+// sanitizeClass keeps the class names made of letters, digits, '-' and '_'.
+func sanitizeClass(class string) string {
+	var keep []string
+	for _, c := range strings.Fields(class) {
+		if strings.IndexFunc(c, func(r rune) bool {
+			return !(r == '-' || r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9'))
+		}) < 0 {
+			keep = append(keep, c)
+		}
+	}
+	return strings.Join(keep, " ")
+}
@@ -0,0 +1,595 @@
+package djot
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// frame is an opener waiting for its closer: emphasis, strong or the
+// bracket of a link, image or span. The root frame has no opener. Text is
+// gathered in a builder and only becomes a node when something else follows,
+// so long runs of text are not copied over and over.
+type frame struct {
+	opener string
+	nodes  []*Node
+	text   strings.Builder
+}
+
+// flush moves the gathered text into a node.
+func (f *frame) flush() {
+	if f.text.Len() == 0 {
+		return
+	}
+	f.nodes = append(f.nodes, &Node{Kind: KindText, Text: f.text.String()})
+	f.text.Reset()
+}
+
+func (f *frame) empty() bool {
+	return len(f.nodes) == 0 && f.text.Len() == 0
+}
+
+// Parsing stays linear in the length of the paragraph: the lookahead of
+// links, footnote references and autolinks is remembered (parens, next) and
+// frames are only searched for openers known to be on the stack (open).
+type inlineParser struct {
+	src    string
+	pos    int
+	stack  []*frame
+	open   map[string]int // opener -> frames on the stack opened by it
+	parens map[int]int    // index of '(' -> index of its ')', see closeParen
+	next   map[byte]int   // byte -> index of its next occurrence, see nextByte
+}
+
+// parseInlines parses the text of a paragraph or heading.
+func parseInlines(src string) []*Node {
+	p := &inlineParser{src: src, stack: []*frame{{}}, open: map[string]int{}, next: map[byte]int{}}
+	for p.pos < len(p.src) {
+		p.step()
+	}
+	// unclosed openers are plain text
+	p.collapse(0)
+	p.stack[0].flush()
+	return smarten(p.stack[0].nodes)
+}
+
+func (p *inlineParser) top() *frame {
+	return p.stack[len(p.stack)-1]
+}
+
+// push opens a frame, unless MaxNesting of them are open already, in which
+// case the opener is text.
+func (p *inlineParser) push(opener string) {
+	if len(p.stack) > MaxNesting {
+		p.text(opener)
+		return
+	}
+	p.stack = append(p.stack, &frame{opener: opener})
+	p.open[opener]++
+}
+
+func (p *inlineParser) emit(n *Node) {
+	f := p.top()
+	f.flush()
+	f.nodes = append(f.nodes, n)
+}
+
+func (p *inlineParser) text(s string) {
+	p.top().text.WriteString(s)
+}
+
+// collapse turns every frame above index into literal text of the frame at
+// index.
+func (p *inlineParser) collapse(index int) {
+	into := p.stack[index]
+	for _, f := range p.stack[index+1:] {
+		p.open[f.opener]--
+		into.text.WriteString(f.opener)
+		f.flush()
+		for _, n := range f.nodes {
+			if n.Kind == KindText {
+				into.text.WriteString(n.Text)
+			} else {
+				into.flush()
+				into.nodes = append(into.nodes, n)
+			}
+		}
+	}
+	p.stack = p.stack[:index+1]
+}
+
+// find returns the index of the innermost frame opened by one of openers.
+func (p *inlineParser) find(openers ...string) int {
+	onStack := false
+	for _, o := range openers {
+		onStack = onStack || p.open[o] > 0
+	}
+	if !onStack {
+		return -1
+	}
+	for i := len(p.stack) - 1; i > 0; i-- {
+		for _, o := range openers {
+			if p.stack[i].opener == o {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// close pops the frame at index, and everything above it, returning its
+// content.
+func (p *inlineParser) close(index int) []*Node {
+	p.collapse(index)
+	f := p.stack[index]
+	p.open[f.opener]--
+	p.stack = p.stack[:index]
+	f.flush()
+	return f.nodes
+}
+
+// nextByte returns the index of the next c at or after from, -1 if there is
+// none. The answer is kept until the parser has moved past it.
+func (p *inlineParser) nextByte(c byte, from int) int {
+	if at, ok := p.next[c]; ok && (at >= from || at < 0) {
+		return at
+	}
+	at := strings.IndexByte(p.src[from:], c)
+	if at >= 0 {
+		at += from
+	}
+	p.next[c] = at
+	return at
+}
+
+func (p *inlineParser) prevRune() (rune, bool) {
+	if p.pos == 0 {
+		return 0, false
+	}
+	r, _ := utf8.DecodeLastRuneInString(p.src[:p.pos])
+	return r, true
+}
+
+func (p *inlineParser) nextRune(offset int) (rune, bool) {
+	if p.pos+offset >= len(p.src) {
+		return 0, false
+	}
+	r, _ := utf8.DecodeRuneInString(p.src[p.pos+offset:])
+	return r, true
+}
+
+const special = "\\`\n_*[]!<{"
+
+func (p *inlineParser) step() {
+	c := p.src[p.pos]
+	switch c {
+	case '\\':
+		p.escape()
+	case '`':
+		p.verbatim()
+	case '\n':
+		p.emit(&Node{Kind: KindSoftBreak})
+		p.pos++
+	case '_', '*':
+		p.emphasis(c)
+	case '[':
+		p.openBracket()
+	case '!':
+		if strings.HasPrefix(p.src[p.pos:], "![") {
+			p.push("![")
+			p.pos += 2
+			return
+		}
+		p.text("!")
+		p.pos++
+	case ']':
+		p.closeBracket()
+	case '<':
+		p.autolink()
+	case '{':
+		p.inlineAttrs()
+	default:
+		end := strings.IndexAny(p.src[p.pos:], special)
+		if end < 0 {
+			end = len(p.src) - p.pos
+		}
+		if end == 0 {
+			end = 1
+		}
+		p.text(p.src[p.pos : p.pos+end])
+		p.pos += end
+	}
+}
+
+func (p *inlineParser) escape() {
+	next, ok := p.nextRune(1)
+	switch {
+	case ok && next == '\n':
+		p.emit(&Node{Kind: KindHardBreak})
+		p.pos += 2
+	case ok && next < utf8.RuneSelf && (unicode.IsPunct(next) || unicode.IsSymbol(next)):
+		p.text(string(next))
+		p.pos += 2
+	default:
+		p.text("\\")
+		p.pos++
+	}
+}
+
+// verbatim parses `code`, closed by the same number of backticks or the end
+// of the paragraph.
+func (p *inlineParser) verbatim() {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] == '`' {
+		p.pos++
+	}
+	fence := p.src[start:p.pos]
+	content := p.src[p.pos:]
+	end := len(p.src)
+	for i := 0; i < len(content); {
+		j := strings.Index(content[i:], fence)
+		if j < 0 {
+			break
+		}
+		j += i
+		k := j + len(fence)
+		if k < len(content) && content[k] == '`' {
+			for k < len(content) && content[k] == '`' {
+				k++
+			}
+			i = k
+			continue
+		}
+		content, end = content[:j], p.pos+k
+		break
+	}
+	p.pos = end
+	// a single space pads verbatim starting or ending with a backtick
+	if len(content) >= 2 && content[0] == ' ' && content[len(content)-1] == ' ' && strings.Trim(content, " ") != "" {
+		if t := strings.Trim(content, " "); t[0] == '`' || t[len(t)-1] == '`' {
+			content = content[1 : len(content)-1]
+		}
+	}
+	content = strings.ReplaceAll(content, "\n", " ")
+
+	if strings.HasPrefix(p.src[p.pos:], "{=") {
+		if end := p.nextByte('}', p.pos) - p.pos; end > 2 {
+			p.emit(&Node{Kind: KindRawInline, Lang: p.src[p.pos+2 : p.pos+end], Text: content})
+			p.pos += end + 1
+			return
+		}
+	}
+	p.emit(&Node{Kind: KindVerbatim, Text: content})
+}
+
+// emphasis handles _emphasis_ and *strong*. A delimiter can open if it is
+// not followed by whitespace and close if it is not preceded by whitespace.
+func (p *inlineParser) emphasis(c byte) {
+	delim := string(c)
+	prev, hasPrev := p.prevRune()
+	next, hasNext := p.nextRune(1)
+	canOpen := hasNext && !unicode.IsSpace(next)
+	canClose := hasPrev && !unicode.IsSpace(prev)
+	p.pos++
+
+	if canClose {
+		if i := p.find(delim); i > 0 && !p.stack[i].empty() {
+			kind := KindEmphasis
+			if c == '*' {
+				kind = KindStrong
+			}
+			children := p.close(i)
+			p.emit(&Node{Kind: kind, Children: children})
+			return
+		}
+	}
+	if canOpen {
+		p.push(delim)
+		return
+	}
+	p.text(delim)
+}
+
+func (p *inlineParser) openBracket() {
+	rest := p.src[p.pos:]
+	if strings.HasPrefix(rest, "[^") {
+		if end := p.nextByte(']', p.pos) - p.pos; end > 2 && !strings.ContainsAny(rest[2:end], " \n[") {
+			p.emit(&Node{Kind: KindFootnoteRef, Label: rest[2:end]})
+			p.pos += end + 1
+			return
+		}
+	}
+	p.push("[")
+	p.pos++
+}
+
+// closeBracket finishes [text](dest), [text][label], [text]{attrs} and the
+// image variants. Anything else leaves the brackets as text.
+func (p *inlineParser) closeBracket() {
+	i := p.find("[", "![")
+	if i < 0 {
+		p.text("]")
+		p.pos++
+		return
+	}
+	kind := KindLink
+	if p.stack[i].opener == "![" {
+		kind = KindImage
+	}
+	rest := p.src[p.pos+1:]
+	switch {
+	case strings.HasPrefix(rest, "("):
+		end := p.closeParen(p.pos + 1)
+		if end < 0 {
+			break
+		}
+		end -= p.pos + 1
+		dest := strings.TrimSpace(strings.ReplaceAll(rest[1:end], "\n", ""))
+		children := p.close(i)
+		p.emit(&Node{Kind: kind, Dest: dest, Children: children})
+		p.pos += 1 + end + 1
+		return
+	case strings.HasPrefix(rest, "["):
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			break
+		}
+		children := p.close(i)
+		label := rest[1:end]
+		if label == "" {
+			label = inlineText(children)
+		}
+		p.emit(&Node{Kind: kind, Label: strings.ReplaceAll(label, "\n", " "), Children: children})
+		p.pos += 1 + end + 1
+		return
+	case strings.HasPrefix(rest, "{") && kind == KindLink:
+		attrs, n, ok := parseAttrs(rest)
+		if !ok {
+			break
+		}
+		children := p.close(i)
+		p.emit(&Node{Kind: KindSpan, Attrs: attrs, Children: children})
+		p.pos += 1 + n
+		return
+	}
+	// not a link after all, the brackets are text
+	p.collapse(i - 1)
+	p.text("]")
+	p.pos++
+}
+
+// closeParen returns the index of the parenthesis closing the one at open,
+// -1 if there is none. Every parenthesis of the paragraph is matched in one
+// pass the first time a link destination is looked for. open always follows
+// a ']', so it is never taken for escaped by that pass.
+func (p *inlineParser) closeParen(open int) int {
+	if p.parens == nil {
+		p.parens = map[int]int{}
+		var opened []int
+		for i := 0; i < len(p.src); i++ {
+			switch p.src[i] {
+			case '\\':
+				i++
+			case '(':
+				opened = append(opened, i)
+			case ')':
+				if len(opened) > 0 {
+					p.parens[opened[len(opened)-1]] = i
+					opened = opened[:len(opened)-1]
+				}
+			}
+		}
+	}
+	if end, ok := p.parens[open]; ok {
+		return end
+	}
+	return -1
+}
+
+// autolink handles <https://example.com> and <mail@example.com>.
+func (p *inlineParser) autolink() {
+	rest := p.src[p.pos:]
+	end := p.nextByte('>', p.pos) - p.pos
+	if end > 1 && !strings.ContainsAny(rest[1:end], " \n<") {
+		target := rest[1:end]
+		dest := ""
+		switch {
+		case strings.Contains(target, "://"):
+			dest = target
+		case strings.Contains(target, "@"):
+			dest = "mailto:" + target
+		}
+		if dest != "" {
+			p.emit(&Node{Kind: KindLink, Dest: dest, Children: []*Node{{Kind: KindText, Text: target}}})
+			p.pos += end + 1
+			return
+		}
+	}
+	p.text("<")
+	p.pos++
+}
+
+// inlineAttrs attaches {attributes} to the element right before them.
+func (p *inlineParser) inlineAttrs() {
+	attrs, n, ok := parseAttrs(p.src[p.pos:])
+	if !ok {
+		p.text("{")
+		p.pos++
+		return
+	}
+	p.pos += n
+	f := p.top()
+	f.flush()
+	if len(f.nodes) > 0 {
+		if last := f.nodes[len(f.nodes)-1]; last.Kind != KindText && last.Kind != KindSoftBreak {
+			last.Attrs = last.Attrs.Merge(attrs)
+		}
+	}
+}
+
+// parseAttrs parses an attribute set like {#id .class key="value"} at the
+// start of s and returns it with the number of bytes consumed.
+func parseAttrs(s string) (Attrs, int, bool) {
+	if !strings.HasPrefix(s, "{") {
+		return nil, 0, false
+	}
+	var attrs Attrs
+	i := 1
+	for i < len(s) {
+		switch c := s[i]; {
+		case c == '}':
+			return attrs, i + 1, true
+		case c == ' ' || c == '\n' || c == '\t':
+			i++
+		case c == '%': // comment
+			end := strings.IndexByte(s[i+1:], '%')
+			if end < 0 {
+				return nil, 0, false
+			}
+			i += end + 2
+		case c == '#' || c == '.':
+			j := i + 1
+			for j < len(s) && isNameByte(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, 0, false
+			}
+			key := "id"
+			if c == '.' {
+				key = "class"
+			}
+			attrs = attrs.Set(key, s[i+1:j])
+			i = j
+		case isNameByte(c):
+			j := i
+			for j < len(s) && isNameByte(s[j]) {
+				j++
+			}
+			if j >= len(s) || s[j] != '=' {
+				return nil, 0, false
+			}
+			key := s[i:j]
+			j++
+			var value string
+			if j < len(s) && s[j] == '"' {
+				end := strings.IndexByte(s[j+1:], '"')
+				if end < 0 {
+					return nil, 0, false
+				}
+				value = s[j+1 : j+1+end]
+				j += end + 2
+			} else {
+				k := j
+				for k < len(s) && isNameByte(s[k]) {
+					k++
+				}
+				value = s[j:k]
+				j = k
+			}
+			attrs = attrs.Set(key, value)
+			i = j
+		default:
+			return nil, 0, false
+		}
+	}
+	return nil, 0, false
+}
+
+func isNameByte(c byte) bool {
+	return c == '-' || c == '_' || c == ':' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// --- SMART PUNCTUATION ---
+
+// smarten replaces straight quotes, "..." and dashes in text nodes with
+// their typographic versions, like djot does.
+func smarten(nodes []*Node) []*Node {
+	prev := ' '
+	var visit func(nodes []*Node)
+	visit = func(nodes []*Node) {
+		for _, n := range nodes {
+			switch n.Kind {
+			case KindText:
+				n.Text = smartText(n.Text, &prev)
+			case KindVerbatim, KindRawInline:
+				prev = 'x'
+			case KindSoftBreak, KindHardBreak:
+				prev = ' '
+			default:
+				visit(n.Children)
+			}
+		}
+	}
+	visit(nodes)
+	return nodes
+}
+
+func smartText(s string, prev *rune) string {
+	if !strings.ContainsAny(s, "'\".-") {
+		if r, _ := utf8.DecodeLastRuneInString(s); s != "" {
+			*prev = r
+		}
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case strings.HasPrefix(s[i:], "..."):
+			b.WriteString("…")
+			i += 3
+			*prev = '…'
+			continue
+		case strings.HasPrefix(s[i:], "---"):
+			b.WriteString("—")
+			i += 3
+			*prev = '—'
+			continue
+		case strings.HasPrefix(s[i:], "--"):
+			b.WriteString("–")
+			i += 2
+			*prev = '–'
+			continue
+		case r == '\'' || r == '"':
+			opening := unicode.IsSpace(*prev) || strings.ContainsRune("([{-–—", *prev)
+			switch {
+			case r == '\'' && opening:
+				b.WriteString("‘")
+			case r == '\'':
+				b.WriteString("’")
+			case opening:
+				b.WriteString("“")
+			default:
+				b.WriteString("”")
+			}
+		default:
+			b.WriteRune(r)
+		}
+		*prev = r
+		i += size
+	}
+	return b.String()
+}
+
+// inlineText is the text content of inlines, used for heading ids and
+// implicit reference labels.
+func inlineText(nodes []*Node) string {
+	var b strings.Builder
+	var visit func(nodes []*Node)
+	visit = func(nodes []*Node) {
+		for _, n := range nodes {
+			switch n.Kind {
+			case KindText, KindVerbatim:
+				b.WriteString(n.Text)
+			case KindSoftBreak, KindHardBreak:
+				b.WriteByte(' ')
+			default:
+				visit(n.Children)
+			}
+		}
+	}
+	visit(nodes)
+	return b.String()
+}
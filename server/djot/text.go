@@ -0,0 +1,88 @@
+package djot
+
+import "strings"
+
+// ToText parses src and returns its plain text.
+func ToText(src string) string {
+	return PlainText(Parse(src))
+}
+
+// PlainText returns the text of the document without markup, for previews,
+// search and word counts. Blocks are separated by blank lines, list items by
+// newlines, and footnotes follow the content. Images contribute their alt
+// text, raw content and footnote markers nothing.
+func PlainText(doc *Document) string {
+	var blocks []string
+	for _, n := range doc.Children {
+		if t := blockText(n); t != "" {
+			blocks = append(blocks, t)
+		}
+	}
+	for _, label := range doc.NoteOrder() {
+		if note := doc.Footnotes[label]; note != nil {
+			if t := containerText(note.Children, "\n\n"); t != "" {
+				blocks = append(blocks, t)
+			}
+		}
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+func blockText(n *Node) string {
+	switch n.Kind {
+	case KindParagraph, KindHeading:
+		return strings.TrimSpace(plainInlines(n.Children))
+	case KindCodeBlock:
+		return strings.TrimRight(n.Text, "\n")
+	case KindBlockQuote, KindDiv:
+		return containerText(n.Children, "\n\n")
+	case KindBulletList, KindOrderedList:
+		var items []string
+		for _, item := range n.Children {
+			if t := containerText(item.Children, "\n"); t != "" {
+				items = append(items, t)
+			}
+		}
+		return strings.Join(items, "\n")
+	default:
+		return ""
+	}
+}
+
+func containerText(nodes []*Node, sep string) string {
+	var parts []string
+	for _, n := range nodes {
+		if t := blockText(n); t != "" {
+			parts = append(parts, t)
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func plainInlines(nodes []*Node) string {
+	var b strings.Builder
+	var visit func(nodes []*Node)
+	visit = func(nodes []*Node) {
+		for _, n := range nodes {
+			switch n.Kind {
+			case KindText, KindVerbatim:
+				b.WriteString(n.Text)
+			case KindSoftBreak:
+				b.WriteByte(' ')
+			case KindHardBreak:
+				b.WriteByte('\n')
+			case KindRawInline, KindFootnoteRef:
+			default:
+				visit(n.Children)
+			}
+		}
+	}
+	visit(nodes)
+	return b.String()
+}
+
+// InlineText returns the text of the inline content of n without markup,
+// line breaks as spaces. Headings use it for their generated ids.
+func InlineText(n *Node) string {
+	return strings.TrimSpace(inlineText(n.Children))
+}
@@ -0,0 +1,397 @@
+// Package feed renders published articles as RSS 2.0, Atom 1.0 and JSON Feed
+// 1.1 documents.
+//
+// Feeds are built from article metadata (AllNoContent), so items carry the
+// leading paragraph as summary and link to the article on the site.
+package feed
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"jst_dev/server/articles"
+)
+
+const (
+	DefaultTitle       = "jst.dev"
+	DefaultDescription = "Articles from jst.dev"
+	DefaultLimit       = 50
+)
+
+// Format is one of the supported feed formats.
+type Format string
+
+const (
+	FormatRSS  Format = "rss"
+	FormatAtom Format = "atom"
+	FormatJSON Format = "json"
+)
+
+// ContentType is the media type served for the format.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatRSS:
+		return "application/rss+xml; charset=utf-8"
+	case FormatAtom:
+		return "application/atom+xml; charset=utf-8"
+	case FormatJSON:
+		return "application/feed+json; charset=utf-8"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Ext is the file extension feeds of the format are served under.
+func (f Format) Ext() string {
+	switch f {
+	case FormatAtom:
+		return ".atom"
+	case FormatJSON:
+		return ".json"
+	default:
+		return ".xml"
+	}
+}
+
+// FormatFromExt maps a file extension (".xml", ".atom", ".json") to a format.
+func FormatFromExt(ext string) (Format, bool) {
+	switch ext {
+	case ".xml", ".rss":
+		return FormatRSS, true
+	case ".atom":
+		return FormatAtom, true
+	case ".json":
+		return FormatJSON, true
+	default:
+		return "", false
+	}
+}
+
+// Feed is a format independent feed.
+type Feed struct {
+	Title       string
+	Description string
+	Home        string // site url
+	Self        string // url the feed is served from
+	Updated     time.Time
+	Items       []Item
+}
+
+// Item is one article in a feed.
+type Item struct {
+	ID        string // urn:uuid:<article id>, stable across slug changes
+	Title     string
+	Subtitle  string
+	Link      string
+	Summary   string
+	Author    string
+	Tags      []string
+	Published time.Time
+	Updated   time.Time
+}
+
+// Select returns the articles that belong in a feed at now: published only,
+// optionally carrying tag, newest first and at most limit of them.
+func Select(arts []articles.Article, tag string, now time.Time, limit int) []articles.Article {
+	selected := make([]articles.Article, 0, len(arts))
+	for _, art := range arts {
+		if !art.IsPublic(now) {
+			continue
+		}
+		if tag != "" && !slices.Contains(art.Tags, tag) {
+			continue
+		}
+		selected = append(selected, art)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].PublishedAt != selected[j].PublishedAt {
+			return selected[i].PublishedAt > selected[j].PublishedAt
+		}
+		return selected[i].Slug < selected[j].Slug
+	})
+	if limit > 0 && len(selected) > limit {
+		selected = selected[:limit]
+	}
+	return selected
+}
+
+// New builds a feed of the given (already selected) articles. base is the
+// site url without trailing slash, self the full url of the feed.
+func New(title, description, base, self string, arts []articles.Article) Feed {
+	f := Feed{
+		Title:       title,
+		Description: description,
+		Home:        base + "/",
+		Self:        self,
+		Items:       make([]Item, 0, len(arts)),
+	}
+	f.Updated, _ = Validators(arts)
+	for _, art := range arts {
+		f.Items = append(f.Items, Item{
+			ID:        "urn:uuid:" + art.Id.String(),
+			Title:     art.Title,
+			Subtitle:  art.Subtitle,
+			Link:      base + "/article/" + url.PathEscape(art.Slug),
+			Summary:   art.Leading,
+			Author:    art.Author,
+			Tags:      art.Tags,
+			Published: time.UnixMilli(int64(art.PublishedAt)).UTC(),
+			Updated:   modified(art),
+		})
+	}
+	return f
+}
+
+// Validators derives the caching validators for a feed of arts from their kv
+// revisions. The ETag changes whenever an article is added, dropped or gets
+// a new revision. lastModified is the latest revision write or publish time.
+func Validators(arts []articles.Article) (lastModified time.Time, etag string) {
+	h := sha256.New()
+	for _, art := range arts {
+		fmt.Fprintf(h, "%s:%d\n", art.Id, art.Rev)
+		if m := modified(art); m.After(lastModified) {
+			lastModified = m
+		}
+	}
+	return lastModified, fmt.Sprintf("\"%s\"", hex.EncodeToString(h.Sum(nil))[:16])
+}
+
+// modified is when the article last changed for a reader: when the revision
+// was written or, for scheduled articles, when it went live.
+func modified(art articles.Article) time.Time {
+	ms := art.UpdatedAt
+	if art.PublishedAt > ms {
+		ms = art.PublishedAt
+	}
+	return time.UnixMilli(int64(ms)).UTC()
+}
+
+// Render encodes the feed in format.
+func (f Feed) Render(format Format) ([]byte, error) {
+	switch format {
+	case FormatRSS:
+		return f.RSS()
+	case FormatAtom:
+		return f.Atom()
+	case FormatJSON:
+		return f.JSON()
+	default:
+		return nil, fmt.Errorf("unknown feed format: %q", format)
+	}
+}
+
+// --- RSS 2.0 ---
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	AtomLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Generator     string    `xml:"generator"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	Description string   `xml:"description,omitempty"`
+	Categories  []string `xml:"category"`
+	PubDate     string   `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// RSS encodes the feed as RSS 2.0.
+func (f Feed) RSS() ([]byte, error) {
+	doc := rss{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.Home,
+			Description: f.Description,
+			AtomLink:    atomLink{Href: f.Self, Rel: "self", Type: FormatRSS.mediaType()},
+			Generator:   "jst.dev",
+			Items:       make([]rssItem, 0, len(f.Items)),
+		},
+	}
+	if !f.Updated.IsZero() {
+		doc.Channel.LastBuildDate = f.Updated.Format(time.RFC1123Z)
+	}
+	for _, it := range f.Items {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       it.Title,
+			Link:        it.Link,
+			GUID:        rssGUID{Value: it.ID},
+			Description: it.Summary,
+			Categories:  it.Tags,
+			PubDate:     it.Published.Format(time.RFC1123Z),
+		})
+	}
+	return marshalXML(doc)
+}
+
+// --- ATOM 1.0 ---
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   *atomPerson `xml:"author,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Links      []atomLink     `xml:"link"`
+	Summary    string         `xml:"summary,omitempty"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// Atom encodes the feed as Atom 1.0.
+func (f Feed) Atom() ([]byte, error) {
+	doc := atomFeed{
+		Title:    f.Title,
+		Subtitle: f.Description,
+		ID:       f.Self,
+		Updated:  f.Updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.Self, Rel: "self", Type: FormatAtom.mediaType()},
+			{Href: f.Home, Rel: "alternate", Type: "text/html"},
+		},
+		// atom requires an author on the feed or on every entry
+		Author:  &atomPerson{Name: f.Title},
+		Entries: make([]atomEntry, 0, len(f.Items)),
+	}
+	for _, it := range f.Items {
+		entry := atomEntry{
+			Title:     it.Title,
+			ID:        it.ID,
+			Updated:   it.Updated.Format(time.RFC3339),
+			Published: it.Published.Format(time.RFC3339),
+			Links:     []atomLink{{Href: it.Link, Rel: "alternate", Type: "text/html"}},
+			Summary:   it.Summary,
+		}
+		if it.Author != "" {
+			entry.Author = &atomPerson{Name: it.Author}
+		}
+		for _, tag := range it.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return marshalXML(doc)
+}
+
+// --- JSON FEED 1.1 ---
+
+type jsonFeed struct {
+	Version     string     `json:"version"`
+	Title       string     `json:"title"`
+	HomePageURL string     `json:"home_page_url"`
+	FeedURL     string     `json:"feed_url"`
+	Description string     `json:"description,omitempty"`
+	Items       []jsonItem `json:"items"`
+}
+
+type jsonItem struct {
+	ID            string       `json:"id"`
+	URL           string       `json:"url"`
+	Title         string       `json:"title"`
+	Summary       string       `json:"summary,omitempty"`
+	ContentText   string       `json:"content_text"`
+	DatePublished string       `json:"date_published"`
+	DateModified  string       `json:"date_modified"`
+	Authors       []jsonAuthor `json:"authors,omitempty"`
+	Tags          []string     `json:"tags,omitempty"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+// JSON encodes the feed as JSON Feed 1.1.
+func (f Feed) JSON() ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Home,
+		FeedURL:     f.Self,
+		Description: f.Description,
+		Items:       make([]jsonItem, 0, len(f.Items)),
+	}
+	for _, it := range f.Items {
+		item := jsonItem{
+			ID:            it.ID,
+			URL:           it.Link,
+			Title:         it.Title,
+			Summary:       it.Subtitle,
+			ContentText:   it.Summary, // an item needs content, the leading paragraph will do
+			DatePublished: it.Published.Format(time.RFC3339),
+			DateModified:  it.Updated.Format(time.RFC3339),
+			Tags:          it.Tags,
+		}
+		if it.Author != "" {
+			item.Authors = []jsonAuthor{{Name: it.Author}}
+		}
+		doc.Items = append(doc.Items, item)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// --- HELPERS ---
+
+// mediaType is the content type without parameters, as used in link elements.
+func (f Format) mediaType() string {
+	mt, _, _ := strings.Cut(f.ContentType(), ";")
+	return mt
+}
+
+func marshalXML(v any) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal xml: %w", err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
@@ -0,0 +1,117 @@
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"jst_dev/server/articles"
+)
+
+var now = time.UnixMilli(1_760_000_000_000)
+
+func testArticles() []articles.Article {
+	day := int(24 * time.Hour / time.Millisecond)
+	at := int(now.UnixMilli())
+	return []articles.Article{
+		{Id: uuid.New(), Rev: 3, Slug: "old", Title: "Old", Status: articles.StatusPublished, PublishedAt: at - 3*day, UpdatedAt: at - 3*day, Tags: []string{"go"}},
+		{Id: uuid.New(), Rev: 7, Slug: "new", Title: "New & shiny", Leading: "<b>bold</b> claims", Status: articles.StatusPublished, PublishedAt: at - day, UpdatedAt: at - day, Tags: []string{"go", "nats"}},
+		{Id: uuid.New(), Rev: 9, Slug: "draft", Title: "Draft", Status: articles.StatusDraft, Tags: []string{"go"}},
+		{Id: uuid.New(), Rev: 11, Slug: "soon", Title: "Soon", Status: articles.StatusScheduled, PublishedAt: at + day, Tags: []string{"go"}},
+	}
+}
+
+func TestSelect(t *testing.T) {
+	got := Select(testArticles(), "", now, 0)
+	if len(got) != 2 || got[0].Slug != "new" || got[1].Slug != "old" {
+		t.Fatalf("expected published articles newest first, got %v", got)
+	}
+	if got = Select(testArticles(), "nats", now, 0); len(got) != 1 || got[0].Slug != "new" {
+		t.Errorf("expected only the nats article, got %v", got)
+	}
+	if got = Select(testArticles(), "", now, 1); len(got) != 1 {
+		t.Errorf("expected limit to apply, got %d", len(got))
+	}
+}
+
+func TestValidators(t *testing.T) {
+	arts := Select(testArticles(), "", now, 0)
+	lastModified, etag := Validators(arts)
+	if lastModified.UnixMilli() != int64(arts[0].UpdatedAt) {
+		t.Errorf("expected last modified from newest revision, got %s", lastModified)
+	}
+
+	arts[1].Rev++
+	if _, changed := Validators(arts); changed == etag {
+		t.Error("expected etag to change with a new revision")
+	}
+	if _, changed := Validators(arts[:1]); changed == etag {
+		t.Error("expected etag to change when an article drops out")
+	}
+}
+
+func TestRender(t *testing.T) {
+	f := New("jst.dev", "desc", "https://jst.dev", "https://jst.dev/feed/rss.xml", Select(testArticles(), "", now, 0))
+
+	data, err := f.RSS()
+	if err != nil {
+		t.Fatalf("rss: %v", err)
+	}
+	var rssDoc struct {
+		Channel struct {
+			Items []struct {
+				Title       string   `xml:"title"`
+				Link        string   `xml:"link"`
+				GUID        string   `xml:"guid"`
+				Description string   `xml:"description"`
+				Categories  []string `xml:"category"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err = xml.Unmarshal(data, &rssDoc); err != nil {
+		t.Fatalf("parse rss: %v\n%s", err, data)
+	}
+	items := rssDoc.Channel.Items
+	if len(items) != 2 || items[0].Title != "New & shiny" || items[0].Link != "https://jst.dev/article/new" || len(items[0].Categories) != 2 {
+		t.Errorf("unexpected rss items: %+v", items)
+	}
+	if items[0].Description != "<b>bold</b> claims" {
+		t.Errorf("expected description to round trip, got %q", items[0].Description)
+	}
+	if !strings.HasPrefix(items[0].GUID, "urn:uuid:") {
+		t.Errorf("expected urn guid, got %q", items[0].GUID)
+	}
+
+	data, err = f.Atom()
+	if err != nil {
+		t.Fatalf("atom: %v", err)
+	}
+	var atomDoc struct {
+		XMLName xml.Name
+		Entries []struct {
+			ID string `xml:"id"`
+		} `xml:"entry"`
+	}
+	if err = xml.Unmarshal(data, &atomDoc); err != nil {
+		t.Fatalf("parse atom: %v\n%s", err, data)
+	}
+	if atomDoc.XMLName.Space != "http://www.w3.org/2005/Atom" || len(atomDoc.Entries) != 2 {
+		t.Errorf("unexpected atom document: %+v", atomDoc)
+	}
+
+	data, err = f.JSON()
+	if err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	var jsonDoc map[string]any
+	if err = json.Unmarshal(data, &jsonDoc); err != nil {
+		t.Fatalf("parse json: %v", err)
+	}
+	if jsonDoc["version"] != "https://jsonfeed.org/version/1.1" || len(jsonDoc["items"].([]any)) != 2 {
+		t.Errorf("unexpected json feed: %s", data)
+	}
+}
@@ -11,10 +11,17 @@ import (
 	"time"
 
 	"jst_dev/server/articles"
+	"jst_dev/server/bundle"
+	"jst_dev/server/comments"
 	"jst_dev/server/jst_log"
+	"jst_dev/server/media"
 	"jst_dev/server/ntfy"
+	"jst_dev/server/presence"
+	"jst_dev/server/search"
+	"jst_dev/server/series"
 	"jst_dev/server/talk"
 	"jst_dev/server/urlShort"
+	"jst_dev/server/views"
 	web "jst_dev/server/web"
 	"jst_dev/server/who"
 
@@ -200,14 +207,134 @@ func run(
 
 	// - articles
 	l.Debug("starting articles")
-	articleRepo, err := articles.Repo(ctx, nc, lRoot.WithBreadcrumb("articles"))
+	articleRepoKv, err := articles.Repo(ctx, nc, lRoot.WithBreadcrumb("articles"))
 	if err != nil {
 		return fmt.Errorf("new articles: %w", err)
 	}
+	articleRepo, err := articles.WithInMemCache(articleRepoKv, lRoot.WithBreadcrumb("articles").WithBreadcrumb("cache"))
+	if err != nil {
+		return fmt.Errorf("articles cache: %w", err)
+	}
+	select {
+	case <-articleRepo.Ready():
+		l.Debug("articles cache ready")
+	case <-time.After(10 * time.Second):
+		l.Warn("articles cache not ready after 10s, reads fall through to kv until it is")
+	}
+	go articles.MigrateBucket(articleRepo, lRoot.WithBreadcrumb("articles").WithBreadcrumb("migrate"))
+	go articles.Schedule(ctx, articleRepo, lRoot.WithBreadcrumb("articles").WithBreadcrumb("scheduler"), 15*time.Second)
+	go articles.Reap(ctx, articleRepo, lRoot.WithBreadcrumb("articles").WithBreadcrumb("reaper"), conf.Flags.TrashRetention, time.Hour)
+
+	// - tags
+	tagIndex := articles.NewTagIndex()
+	err = tagIndex.Watch(ctx, articleRepo, lRoot.WithBreadcrumb("articles").WithBreadcrumb("tags"))
+	if err != nil {
+		return fmt.Errorf("watch tags: %w", err)
+	}
+
+	// - links between articles
+	linkIndex := articles.NewLinkIndex()
+	err = linkIndex.Watch(ctx, articleRepo, lRoot.WithBreadcrumb("articles").WithBreadcrumb("links"))
+	if err != nil {
+		return fmt.Errorf("watch links: %w", err)
+	}
+
+	// - series
+	seriesStore, err := series.NewStore(ctx, nc, articleRepo, lRoot.WithBreadcrumb("series"))
+	if err != nil {
+		return fmt.Errorf("new series: %w", err)
+	}
+
+	// - presence and edit locks
+	presenceStore, err := presence.NewStore(ctx, nc, lRoot.WithBreadcrumb("presence"))
+	if err != nil {
+		return fmt.Errorf("new presence: %w", err)
+	}
+
+	// - view counts
+	viewStore, err := views.NewStore(ctx, nc, lRoot.WithBreadcrumb("views"))
+	if err != nil {
+		return fmt.Errorf("new views: %w", err)
+	}
+	err = viewStore.Aggregate(ctx)
+	if err != nil {
+		return fmt.Errorf("aggregate views: %w", err)
+	}
+
+	// - access policy
+	acl, err := who.NewProlog(lRoot.WithBreadcrumb("who").WithBreadcrumb("prolog"))
+	if err != nil {
+		return fmt.Errorf("new access policy: %w", err)
+	}
+
+	// - comments
+	l.Debug("starting comments")
+	commentSvc, err := comments.New(ctx, &comments.Conf{
+		Logger:      lRoot.WithBreadcrumb("comments"),
+		NatsConn:    nc,
+		ArticleRepo: articleRepo,
+	})
+	if err != nil {
+		return fmt.Errorf("new comments: %w", err)
+	}
+	err = commentSvc.Start(ctx)
+	if err != nil {
+		return fmt.Errorf("start comments: %w", err)
+	}
+
+	// - media
+	l.Debug("starting media")
+	mediaStore, err := media.NewStore(ctx, nc, lRoot.WithBreadcrumb("media"))
+	if err != nil {
+		return fmt.Errorf("new media: %w", err)
+	}
+	mediaSvc, err := media.New(ctx, &media.Conf{
+		Logger:   lRoot.WithBreadcrumb("media"),
+		NatsConn: nc,
+		Store:    mediaStore,
+	})
+	if err != nil {
+		return fmt.Errorf("new media: %w", err)
+	}
+	err = mediaSvc.Start(ctx)
+	if err != nil {
+		return fmt.Errorf("start media: %w", err)
+	}
+	go media.Collect(ctx, mediaStore, articleRepo, lRoot.WithBreadcrumb("media").WithBreadcrumb("gc"), media.DefaultGCGrace, time.Hour)
+
+	// - search
+	l.Debug("starting search")
+	searchSvc, err := search.New(ctx, &search.Conf{
+		Logger:      lRoot.WithBreadcrumb("search"),
+		NatsConn:    nc,
+		ArticleRepo: articleRepo,
+	})
+	if err != nil {
+		return fmt.Errorf("new search: %w", err)
+	}
+	err = searchSvc.Start(ctx)
+	if err != nil {
+		return fmt.Errorf("start search: %w", err)
+	}
+
+	// - bundle
+	l.Debug("starting bundle")
+	bundleSvc, err := bundle.New(ctx, &bundle.Conf{
+		Logger:      lRoot.WithBreadcrumb("bundle"),
+		NatsConn:    nc,
+		ArticleRepo: articleRepo,
+	})
+	if err != nil {
+		return fmt.Errorf("new bundle: %w", err)
+	}
+	err = bundleSvc.Start(ctx)
+	if err != nil {
+		return fmt.Errorf("start bundle: %w", err)
+	}
 
 	// - web
 	l.Debug("http server, start")
-	httpServer := web.New(ctx, nc, conf.WebJwtSecret, lRoot.WithBreadcrumb("http"), articleRepo, conf.Flags.ProxyFrontend, conf.Flags.SlowSocket)
+	httpServer := web.New(ctx, nc, conf.WebJwtSecret, lRoot.WithBreadcrumb("http"), articleRepo, tagIndex, linkIndex, mediaStore, seriesStore, presenceStore, viewStore, acl, conf.SiteURL, conf.Flags.ProxyFrontend, conf.Flags.SlowSocket)
 	go httpServer.Run(cleanShutdown, conf.WebPort)
 
 	// - time ticker publisher (NATS core)
@@ -0,0 +1,37 @@
+package api
+
+// the NATS subject used by this package
+var Subj = struct {
+	// images
+	ImageGroup    string
+	ImageVariants string
+}{
+	// images
+	ImageGroup:    "svc.media.images",
+	ImageVariants: "variants",
+}
+
+// IMAGE VARIANTS
+
+// VariantsRequest asks for the resized variants of an uploaded image to be
+// generated. Sent without a reply subject it is a fire and forget job.
+type VariantsRequest struct {
+	Hash string `json:"hash"`
+}
+
+type VariantsResponse struct {
+	Hash     string    `json:"hash"`
+	Variants []Variant `json:"variants"` // narrowest first
+	// Srcset lists the variants as an HTML srcset value, e.g.
+	// "/media/ab12../480 480w, /media/ab12../960 960w".
+	Srcset string `json:"srcset"`
+}
+
+// Variant is a resized, re-encoded copy of an image.
+type Variant struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Size        uint64 `json:"size"`
+}
@@ -0,0 +1,266 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// Images are served as a set of variants for srcset: copies resized to each
+// of Widths that is narrower than the image, plus one at full width if the
+// image is narrower than the widest. Variants are re-encoded from pixels, so
+// EXIF and other metadata (location, camera) are left behind. The EXIF
+// orientation is applied first so that variants display upright without it.
+
+// Widths are the variant widths in pixels.
+var Widths = []int{480, 960, 1920}
+
+// MaxPixels is the largest image, in pixels, variants are made of. Decoding
+// allocates about 4 bytes per pixel.
+const MaxPixels = 40_000_000
+
+// jpegQuality is used when encoding JPEG variants.
+const jpegQuality = 82
+
+// ErrNotImage is returned for uploads variants cannot be made of.
+var ErrNotImage = errors.New("not a resizable image")
+
+// Resizable reports whether variants can be made of contentType. The
+// standard library has no webp or bmp encoder, those are served as uploaded.
+func Resizable(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// encodedVariant is a variant ready to be stored.
+type encodedVariant struct {
+	width, height int
+	contentType   string
+	data          []byte
+}
+
+// makeVariants decodes an image and encodes its variants. JPEGs stay JPEG,
+// PNG and GIF (first frame) become PNG so transparency is kept.
+func makeVariants(data []byte, contentType string) ([]encodedVariant, error) {
+	if !Resizable(contentType) {
+		return nil, fmt.Errorf("%w: %s", ErrNotImage, contentType)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", ErrNotImage, cfg.Width, cfg.Height)
+	}
+	var img image.Image
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotImage, err)
+	}
+	src := orient(toRGBA(img), jpegOrientation(data))
+
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	var widths []int
+	for _, w := range Widths {
+		if w < sw {
+			widths = append(widths, w)
+		}
+	}
+	if sw <= Widths[len(Widths)-1] {
+		widths = append(widths, sw)
+	}
+
+	variants := make([]encodedVariant, 0, len(widths))
+	for _, w := range widths {
+		h := max(int(math.Round(float64(sh)*float64(w)/float64(sw))), 1)
+		dst := src
+		if w != sw {
+			dst = resize(src, w, h)
+		}
+		var buf bytes.Buffer
+		v := encodedVariant{width: w, height: h}
+		if contentType == "image/jpeg" {
+			v.contentType = "image/jpeg"
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			v.contentType = "image/png"
+			err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, dst)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encode %dw: %w", w, err)
+		}
+		v.data = buf.Bytes()
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// resize scales src to w x h with a box filter: every destination pixel is
+// the area weighted average of the source pixels it covers. That is what
+// downscaling photos needs; upscaling is never asked for.
+func resize(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// horizontal pass into a w x sh float buffer
+	tmp := make([]float64, w*sh*4)
+	scaleX := float64(sw) / float64(w)
+	for x := 0; x < w; x++ {
+		x0, x1 := float64(x)*scaleX, float64(x+1)*scaleX
+		for sx := int(x0); sx < sw && float64(sx) < x1; sx++ {
+			weight := (math.Min(x1, float64(sx+1)) - math.Max(x0, float64(sx))) / scaleX
+			for y := 0; y < sh; y++ {
+				p := src.Pix[y*src.Stride+sx*4:]
+				t := tmp[(y*w+x)*4:]
+				t[0] += float64(p[0]) * weight
+				t[1] += float64(p[1]) * weight
+				t[2] += float64(p[2]) * weight
+				t[3] += float64(p[3]) * weight
+			}
+		}
+	}
+
+	// vertical pass into the destination
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	scaleY := float64(sh) / float64(h)
+	for y := 0; y < h; y++ {
+		y0, y1 := float64(y)*scaleY, float64(y+1)*scaleY
+		acc := make([]float64, w*4)
+		for sy := int(y0); sy < sh && float64(sy) < y1; sy++ {
+			weight := (math.Min(y1, float64(sy+1)) - math.Max(y0, float64(sy))) / scaleY
+			row := tmp[sy*w*4 : (sy+1)*w*4]
+			for i, v := range row {
+				acc[i] += v * weight
+			}
+		}
+		out := dst.Pix[y*dst.Stride : y*dst.Stride+w*4]
+		for i, v := range acc {
+			out[i] = uint8(math.Min(math.Round(v), 255))
+		}
+	}
+	return dst
+}
+
+// orient applies an EXIF orientation (1-8) so the image displays upright.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := sw, sh
+	if orientation >= 5 {
+		dw, dh = sh, sw
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = sw-1-x, y
+			case 3: // rotated 180
+				sx, sy = sw-1-x, sh-1-y
+			case 4: // flipped
+				sx, sy = x, sh-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotate 90 clockwise to display
+				sx, sy = y, sh-1-x
+			case 7: // transversed
+				sx, sy = sw-1-y, sh-1-x
+			case 8: // rotate 90 counter clockwise to display
+				sx, sy = sw-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG, 1 (upright) if there
+// is none or data is not a JPEG.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // image data starts, no EXIF before it
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// tiffOrientation finds the orientation tag in the first IFD of EXIF data.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < entries; k++ {
+		e := ifd + 2 + k*12
+		if e+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
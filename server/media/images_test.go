@@ -0,0 +1,193 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// withOrientation inserts an EXIF APP1 segment with the given orientation
+// after the SOI marker of a JPEG.
+func withOrientation(data []byte, orientation byte) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // big endian header, IFD at 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, // orientation SHORT
+		0, 0, 0, 0, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := append([]byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func testJPEG(t testing.TB, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestResize(t *testing.T) {
+	// a 4x2 checkerboard of black and white averages to gray at 2x1
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if (x+y)%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+	dst := resize(src, 2, 1)
+	for x := 0; x < 2; x++ {
+		if got := dst.RGBAAt(x, 0); got != (color.RGBA{128, 128, 128, 255}) {
+			t.Errorf("pixel %d = %v, want mid gray", x, got)
+		}
+	}
+
+	// scaling by a non integer factor keeps a flat color flat
+	flat := image.NewRGBA(image.Rect(0, 0, 7, 5))
+	for i := range flat.Pix {
+		flat.Pix[i] = 200
+	}
+	for _, p := range resize(flat, 3, 2).Pix {
+		if p != 200 {
+			t.Fatalf("flat image changed to %d", p)
+		}
+	}
+}
+
+func TestOrientation(t *testing.T) {
+	data := testJPEG(t, 40, 20)
+	if got := jpegOrientation(data); got != 1 {
+		t.Errorf("expected no orientation, got %d", got)
+	}
+	for o := byte(1); o <= 8; o++ {
+		if got := jpegOrientation(withOrientation(data, o)); got != int(o) {
+			t.Errorf("orientation %d read as %d", o, got)
+		}
+	}
+	if got := jpegOrientation([]byte("not a jpeg")); got != 1 {
+		t.Errorf("expected 1 for garbage, got %d", got)
+	}
+
+	// 2x1: red, blue
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	src.SetRGBA(0, 0, red)
+	src.SetRGBA(1, 0, blue)
+	cases := map[int][]color.RGBA{ // pixels of the result in reading order
+		1: {red, blue},
+		2: {blue, red},
+		3: {blue, red},
+		4: {red, blue},
+		5: {red, blue},
+		6: {red, blue}, // rotated clockwise: red on top
+		7: {blue, red},
+		8: {blue, red}, // rotated counter clockwise: blue on top
+	}
+	for o, want := range cases {
+		dst := orient(src, o)
+		var got []color.RGBA
+		b := dst.Bounds()
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				got = append(got, dst.RGBAAt(x, y))
+			}
+		}
+		if (o >= 5) != (b.Dx() == 1) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("orientation %d: %dx%d %v, want %v", o, b.Dx(), b.Dy(), got, want)
+		}
+	}
+}
+
+func TestMakeVariants(t *testing.T) {
+	variants, err := makeVariants(withOrientation(testJPEG(t, 1000, 500), 6), "image/jpeg")
+	if err != nil {
+		t.Fatalf("make variants: %v", err)
+	}
+	// upright the image is 500x1000
+	want := [][2]int{{480, 960}, {500, 1000}}
+	if len(variants) != len(want) {
+		t.Fatalf("expected %d variants, got %d", len(want), len(variants))
+	}
+	for i, v := range variants {
+		if v.width != want[i][0] || v.height != want[i][1] || v.contentType != "image/jpeg" {
+			t.Errorf("variant %d: %dx%d %s, want %v", i, v.width, v.height, v.contentType, want[i])
+		}
+		if bytes.Contains(v.data, []byte("Exif")) {
+			t.Errorf("variant %d kept EXIF", i)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(v.data))
+		if err != nil || cfg.Width != v.width || cfg.Height != v.height {
+			t.Errorf("variant %d decodes as %dx%d (%v)", i, cfg.Width, cfg.Height, err)
+		}
+	}
+
+	variants, err = makeVariants(testPNG(t, 2000), "image/png")
+	if err != nil || len(variants) != 3 || variants[2].width != 1920 || variants[2].contentType != "image/png" {
+		t.Errorf("unexpected png variants (%v)", err)
+	}
+	if _, err = makeVariants([]byte("%PDF-1.4"), "application/pdf"); !errors.Is(err, ErrNotImage) {
+		t.Errorf("expected pdf to be refused, got %v", err)
+	}
+}
+
+func TestVariantWorker(t *testing.T) {
+	store, repo, nc := testStore(t)
+	svc, err := New(context.Background(), &Conf{NatsConn: nc, Logger: store.l, Store: store})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err = svc.Start(context.Background()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1200, 600)))
+	obj, _, err := store.Put(buf.Bytes(), uuid.New(), "user")
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = RequestVariants(nc, obj.Hash); err != nil {
+		t.Fatalf("request variants: %v", err)
+	}
+
+	var variants []Variant
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if variants, _ = store.Variants(obj.Hash); len(variants) == 3 {
+			break
+		}
+	}
+	if len(variants) != 3 || variants[0].Width != 480 || variants[0].Height != 240 || variants[2].Width != 1200 {
+		t.Fatalf("unexpected variants %+v", variants)
+	}
+	if got, want := Srcset(variants), "/media/"+obj.Hash+"/480 480w, /media/"+obj.Hash+"/960 960w, /media/"+obj.Hash+"/1200 1200w"; got != want {
+		t.Errorf("Srcset() = %q, want %q", got, want)
+	}
+
+	// variants are collected with their image
+	report, err := store.GC(repo, time.Now().Add(time.Second), false)
+	if err != nil || report.Total != 4 || len(report.Unreferenced) != 4 {
+		t.Errorf("expected the image and its variants to be collected, got %+v (%v)", report, err)
+	}
+}
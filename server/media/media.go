@@ -0,0 +1,258 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/jst_log"
+	"jst_dev/server/media/api"
+)
+
+// Media attachments are stored content addressed: the object name is the hex
+// sha256 of the data, so uploading the same file twice stores it once and a
+// name never changes content. That lets /media/{hash} be cached forever.
+
+// MaxSize is the largest attachment accepted.
+const MaxSize = 10 * 1024 * 1024
+
+// Allowed maps the content types accepted for upload, as sniffed from the
+// data, to a file extension. Types a browser would run (html, svg) are left
+// out on purpose.
+var Allowed = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/bmp":       ".bmp",
+	"application/pdf": ".pdf",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"audio/mpeg":      ".mp3",
+	"audio/ogg":       ".ogg",
+}
+
+var (
+	ErrNotFound        = errors.New("media not found")
+	ErrTooLarge        = fmt.Errorf("media larger than %d bytes", MaxSize)
+	ErrUnsupportedType = errors.New("unsupported media type")
+)
+
+// Object is a stored attachment, or with Width set one of its variants.
+type Object struct {
+	Hash        string    `json:"hash"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"content_type"`
+	Size        uint64    `json:"size"`
+	Article     uuid.UUID `json:"article"`     // article it was uploaded for
+	UploadedBy  string    `json:"uploaded_by"` // user id
+	UploadedAt  int       `json:"uploaded_at"` // unix ms
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+}
+
+// Variant is a resized copy of an image attachment.
+type Variant = api.Variant
+
+// IsVariant reports whether o is a variant rather than an upload.
+func (o Object) IsVariant() bool {
+	return o.Width != 0
+}
+
+// name is the object store name: the hash, variants add "/{width}".
+func (o Object) name() string {
+	if o.IsVariant() {
+		return variantName(o.Hash, o.Width)
+	}
+	return o.Hash
+}
+
+func variantName(hash string, width int) string {
+	return hash + "/" + strconv.Itoa(width)
+}
+
+// Store keeps attachments in the "media" object store bucket.
+type Store struct {
+	ctx context.Context
+	obj jetstream.ObjectStore
+	l   *jst_log.Logger
+}
+
+// NewStore creates the media bucket if needed and returns a Store for it.
+func NewStore(ctx context.Context, nc *nats.Conn, l *jst_log.Logger) (*Store, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("jetstream new: %w", err)
+	}
+	obj, err := js.CreateOrUpdateObjectStore(ctx, jetstream.ObjectStoreConfig{
+		Bucket:      "media",
+		Description: "article attachments, named by sha256",
+		MaxBytes:    1024 * 1024 * 1024, // 1 GB
+		Storage:     jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("object store create: %w", err)
+	}
+	return &Store{ctx: ctx, obj: obj, l: l}, nil
+}
+
+// Hash is the name data is stored under.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// URL is where an attachment is served.
+func URL(hash string) string {
+	return "/media/" + hash
+}
+
+// VariantURL is where a variant of an image is served.
+func VariantURL(hash string, width int) string {
+	return URL(variantName(hash, width))
+}
+
+// Sniff returns the content type of data if it may be uploaded.
+func Sniff(data []byte) (string, error) {
+	contentType, _, _ := bytes.Cut([]byte(http.DetectContentType(data)), []byte(";"))
+	if _, ok := Allowed[string(contentType)]; !ok {
+		return string(contentType), fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+	return string(contentType), nil
+}
+
+// Put stores data as an attachment of article. If the same data is already
+// stored the existing object is returned and created is false.
+func (s *Store) Put(data []byte, article uuid.UUID, by string) (obj Object, created bool, err error) {
+	if len(data) > MaxSize {
+		return obj, false, ErrTooLarge
+	}
+	contentType, err := Sniff(data)
+	if err != nil {
+		return obj, false, err
+	}
+	hash := Hash(data)
+	if obj, err = s.Info(hash); err == nil {
+		return obj, false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return obj, false, err
+	}
+
+	info, err := s.obj.Put(s.ctx, jetstream.ObjectMeta{
+		Name:    hash,
+		Headers: nats.Header{"Content-Type": []string{contentType}},
+		Metadata: map[string]string{
+			"article":     article.String(),
+			"uploaded_by": by,
+		},
+	}, bytes.NewReader(data))
+	if err != nil {
+		return obj, false, fmt.Errorf("put media: %w", err)
+	}
+	return object(info), true, nil
+}
+
+// Info returns the stored attachment named hash.
+func (s *Store) Info(hash string) (Object, error) {
+	info, err := s.obj.GetInfo(s.ctx, hash)
+	if errors.Is(err, jetstream.ErrObjectNotFound) {
+		return Object{}, fmt.Errorf("media %s: %w", hash, ErrNotFound)
+	}
+	if err != nil {
+		return Object{}, fmt.Errorf("get media info: %w", err)
+	}
+	return object(info), nil
+}
+
+// Open returns the attachment, or with width > 0 the variant of it, and a
+// reader for its data, which the caller must close.
+func (s *Store) Open(hash string, width int) (Object, io.ReadCloser, error) {
+	name := hash
+	if width > 0 {
+		name = variantName(hash, width)
+	}
+	res, err := s.obj.Get(s.ctx, name)
+	if errors.Is(err, jetstream.ErrObjectNotFound) {
+		return Object{}, nil, fmt.Errorf("media %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return Object{}, nil, fmt.Errorf("get media: %w", err)
+	}
+	info, err := res.Info()
+	if err != nil {
+		res.Close()
+		return Object{}, nil, fmt.Errorf("get media info: %w", err)
+	}
+	return object(info), res, nil
+}
+
+// List returns every stored attachment and variant.
+func (s *Store) List() ([]Object, error) {
+	infos, err := s.obj.List(s.ctx)
+	if errors.Is(err, jetstream.ErrNoObjectsFound) {
+		return []Object{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list media: %w", err)
+	}
+	objs := make([]Object, 0, len(infos))
+	for _, info := range infos {
+		objs = append(objs, object(info))
+	}
+	return objs, nil
+}
+
+// Delete removes a stored attachment or variant.
+func (s *Store) Delete(obj Object) error {
+	err := s.obj.Delete(s.ctx, obj.name())
+	if errors.Is(err, jetstream.ErrObjectNotFound) {
+		return fmt.Errorf("media %s: %w", obj.name(), ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("delete media: %w", err)
+	}
+	return nil
+}
+
+func object(info *jetstream.ObjectInfo) Object {
+	obj := Object{
+		Hash:        info.Name,
+		URL:         URL(info.Name),
+		ContentType: info.Headers.Get("Content-Type"),
+		Size:        info.Size,
+		UploadedBy:  info.Metadata["uploaded_by"],
+		UploadedAt:  int(info.ModTime.UnixMilli()),
+	}
+	obj.Article, _ = uuid.Parse(info.Metadata["article"])
+	if hash, width, ok := strings.Cut(info.Name, "/"); ok {
+		obj.Hash = hash
+		obj.Width, _ = strconv.Atoi(width)
+		obj.Height, _ = strconv.Atoi(info.Metadata["height"])
+	}
+	if info.ModTime.IsZero() {
+		obj.UploadedAt = int(time.Now().UnixMilli())
+	}
+	return obj
+}
+
+// validHash reports whether s looks like an object name made by Hash.
+func validHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
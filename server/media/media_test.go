@@ -0,0 +1,176 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+)
+
+func testStore(t testing.TB) (*Store, articles.ArticleRepo, *nats.Conn) {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{
+		ServerName: "test-media",
+		NoLog:      true,
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL(), nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		nc.Close()
+		ns.Shutdown()
+	})
+	l := jst_log.NewLogger("test", jst_log.DefaultSubjects())
+	repo, err := articles.Repo(ctx, nc, l)
+	if err != nil {
+		t.Fatalf("repo: %v", err)
+	}
+	store, err := NewStore(ctx, nc, l)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	return store, repo, nc
+}
+
+func testPNG(t testing.TB, size int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPut(t *testing.T) {
+	store, _, _ := testStore(t)
+	article := uuid.New()
+	data := testPNG(t, 4)
+
+	obj, created, err := store.Put(data, article, "user")
+	if err != nil || !created {
+		t.Fatalf("put: created %t, %v", created, err)
+	}
+	if obj.Hash != Hash(data) || obj.URL != "/media/"+obj.Hash || obj.ContentType != "image/png" ||
+		obj.Size != uint64(len(data)) || obj.Article != article || obj.UploadedBy != "user" {
+		t.Errorf("unexpected object %+v", obj)
+	}
+
+	again, created, err := store.Put(data, uuid.New(), "other")
+	if err != nil || created || again.Article != article {
+		t.Errorf("expected the stored object back, got %+v created %t (%v)", again, created, err)
+	}
+
+	got, rc, err := store.Open(obj.Hash, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	stored, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(stored, data) || got.ContentType != "image/png" {
+		t.Errorf("stored data or type differ: %s", got.ContentType)
+	}
+
+	if _, _, err = store.Put([]byte("<html><script>alert(1)</script>"), article, "user"); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected html to be rejected, got %v", err)
+	}
+	if _, _, err = store.Put([]byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), article, "user"); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected svg to be rejected, got %v", err)
+	}
+	if _, _, err = store.Put(make([]byte, MaxSize+1), article, "user"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected too large, got %v", err)
+	}
+	if _, err = store.Info(Hash([]byte("missing"))); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestRefs(t *testing.T) {
+	a, b := Hash([]byte("a")), Hash([]byte("b"))
+	art := articles.Article{
+		Leading: "![lead](/media/" + b + ")",
+		Content: "![x](/media/" + a + ") and [pdf](https://jst.dev/media/" + b + ") /media/" + a + "\n" +
+			"/media/" + a[:63] + " /media/" + a + "0",
+	}
+	if got, want := Refs(art), []string{b, a}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Refs() = %v, want %v", got, want)
+	}
+}
+
+func TestGC(t *testing.T) {
+	store, repo, _ := testStore(t)
+
+	linked, _, _ := store.Put(testPNG(t, 1), uuid.Nil, "user")
+	trashed, _, _ := store.Put(testPNG(t, 2), uuid.Nil, "user")
+	orphan, _, _ := store.Put(testPNG(t, 3), uuid.Nil, "user")
+
+	art := articles.TestArticle()
+	art.Content = "![](" + linked.URL + ")"
+	if _, err := repo.Create(art); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	gone := articles.TestArticle()
+	gone.Slug, gone.Content = "gone", "![]("+trashed.URL+")"
+	gone, err := repo.Create(gone)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err = repo.Delete(gone.Id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	report, err := store.GC(repo, time.Now().Add(-time.Hour), false)
+	if err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if report.Total != 3 || report.Referenced != 2 || report.Kept != 1 || len(report.Unreferenced) != 0 {
+		t.Errorf("expected the new orphan to be kept, got %+v", report)
+	}
+
+	report, err = store.GC(repo, time.Now().Add(time.Second), true)
+	if err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if !reflect.DeepEqual(report.Unreferenced, []string{orphan.Hash}) {
+		t.Errorf("expected the orphan to be collected, got %+v", report)
+	}
+	if _, err = store.Info(orphan.Hash); err != nil {
+		t.Errorf("dry run deleted the orphan: %v", err)
+	}
+
+	if _, err = store.GC(repo, time.Now().Add(time.Second), false); err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if _, err = store.Info(orphan.Hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the orphan to be deleted, got %v", err)
+	}
+	for _, obj := range []Object{linked, trashed} {
+		if _, err = store.Info(obj.Hash); err != nil {
+			t.Errorf("referenced %s was deleted: %v", obj.Hash, err)
+		}
+	}
+}
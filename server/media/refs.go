@@ -0,0 +1,144 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+)
+
+// References are not stored anywhere: an article references an attachment
+// when its text links to /media/{hash}. Garbage collection collects the links
+// of every article, including those in the trash, and deletes attachments
+// nobody links to. Uploads get a grace period so an editor has time to save
+// the article that uses them. Older revisions are not looked at, restoring
+// one may bring back a link to an attachment that is gone.
+
+// DefaultGCGrace is how long an unreferenced attachment is kept.
+const DefaultGCGrace = 24 * time.Hour
+
+var refPattern = regexp.MustCompile(`/media/([0-9a-f]{64})\b`)
+
+// Refs returns the attachments art links to, in order of first appearance.
+func Refs(art articles.Article) []string {
+	refs := []string{}
+	for _, text := range []string{art.Leading, art.Content} {
+		for _, m := range refPattern.FindAllStringSubmatch(text, -1) {
+			if !slices.Contains(refs, m[1]) {
+				refs = append(refs, m[1])
+			}
+		}
+	}
+	return refs
+}
+
+// Referenced returns every attachment linked to by an article in the repo or
+// in its trash.
+func Referenced(repo articles.ArticleRepo) (map[string]struct{}, error) {
+	refs := map[string]struct{}{}
+	add := func(art articles.Article) {
+		for _, hash := range Refs(art) {
+			refs[hash] = struct{}{}
+		}
+	}
+
+	all, err := repo.AllNoContent()
+	if err != nil {
+		return nil, fmt.Errorf("list articles: %w", err)
+	}
+	for _, meta := range all {
+		art, err := repo.Get(meta.Id)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue // deleted since listing, it is in the trash below
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get article %s: %w", meta.Id, err)
+		}
+		add(art)
+	}
+
+	trash, err := repo.Trash()
+	if err != nil {
+		return nil, fmt.Errorf("list trash: %w", err)
+	}
+	for _, meta := range trash {
+		art, err := repo.GetRevision(meta.Id, meta.Rev)
+		if err != nil {
+			return nil, fmt.Errorf("get deleted article %s: %w", meta.Id, err)
+		}
+		add(art)
+	}
+	return refs, nil
+}
+
+// GCReport says what a garbage collection found, and deleted unless DryRun.
+type GCReport struct {
+	DryRun       bool     `json:"dry_run"`
+	Total        int      `json:"total"`
+	Referenced   int      `json:"referenced"`
+	Unreferenced []string `json:"unreferenced"` // collected, or would be on a dry run
+	Kept         int      `json:"kept"`         // unreferenced but still in the grace period
+}
+
+// GC deletes attachments that no article links to and that were uploaded
+// before the given time. With dryRun nothing is deleted.
+func (s *Store) GC(repo articles.ArticleRepo, before time.Time, dryRun bool) (GCReport, error) {
+	report := GCReport{DryRun: dryRun, Unreferenced: []string{}}
+	// list objects before collecting references, an upload linked to in
+	// between is then either not listed or seen as referenced
+	objs, err := s.List()
+	if err != nil {
+		return report, fmt.Errorf("gc: %w", err)
+	}
+	refs, err := Referenced(repo)
+	if err != nil {
+		return report, fmt.Errorf("gc: %w", err)
+	}
+	for _, obj := range objs {
+		report.Total++
+		if _, ok := refs[obj.Hash]; ok {
+			report.Referenced++
+			continue
+		}
+		if !time.UnixMilli(int64(obj.UploadedAt)).Before(before) {
+			report.Kept++
+			continue
+		}
+		if !dryRun {
+			if err := s.Delete(obj); err != nil && !errors.Is(err, ErrNotFound) {
+				return report, fmt.Errorf("gc: %w", err)
+			}
+		}
+		report.Unreferenced = append(report.Unreferenced, obj.name())
+	}
+	return report, nil
+}
+
+// Collect runs GC every interval until ctx is done, deleting unreferenced
+// attachments older than grace.
+func Collect(ctx context.Context, s *Store, repo articles.ArticleRepo, l *jst_log.Logger, grace, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.Debug("context done, stopping")
+			return
+		case now := <-ticker.C:
+			report, err := s.GC(repo, now.Add(-grace), false)
+			if err != nil {
+				l.Error("collect media: %v", err)
+			}
+			if n := len(report.Unreferenced); n > 0 {
+				l.Info("deleted %d unreferenced media objects", n)
+			}
+		}
+	}
+}
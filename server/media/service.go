@@ -0,0 +1,133 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+
+	"jst_dev/server/jst_log"
+	"jst_dev/server/media/api"
+)
+
+// MediaService is the image worker. Every instance joins the same queue
+// group, so each job is done once by whichever instance picks it up.
+type MediaService struct {
+	l     *jst_log.Logger
+	nc    *nats.Conn
+	store *Store
+	ctx   context.Context
+}
+
+type Conf struct {
+	NatsConn *nats.Conn
+	Logger   *jst_log.Logger
+	Store    *Store
+}
+
+// New creates a new MediaService instance with the provided configuration.
+func New(ctx context.Context, c *Conf) (*MediaService, error) {
+	if c.Store == nil {
+		return nil, fmt.Errorf("media store is required")
+	}
+	return &MediaService{
+		l:     c.Logger,
+		nc:    c.NatsConn,
+		store: c.Store,
+		ctx:   ctx,
+	}, nil
+}
+
+// Start registers the image endpoints.
+func (s *MediaService) Start(ctx context.Context) error {
+	if s.nc.Status() != nats.CONNECTED {
+		return fmt.Errorf("nats connection not connected: %s", s.nc.Status())
+	}
+
+	svcMetadata := map[string]string{}
+	svcMetadata["location"] = "unknown"
+	svcMetadata["environment"] = "development"
+	mediaSvc, err := micro.AddService(s.nc, micro.Config{
+		Name:        "media",
+		Version:     "1.0.0",
+		Description: "image variants for article attachments",
+		Metadata:    svcMetadata,
+	})
+	if err != nil {
+		return fmt.Errorf("add service: %w", err)
+	}
+
+	// ----------- Images -----------
+	imageSvcGroup := mediaSvc.AddGroup(api.Subj.ImageGroup, micro.WithGroupQueueGroup(api.Subj.ImageGroup))
+	if err = imageSvcGroup.AddEndpoint("image_variants", s.handleImageVariants(), micro.WithEndpointSubject(api.Subj.ImageVariants)); err != nil {
+		return fmt.Errorf("add media endpoint (image_variants): %w", err)
+	}
+
+	return nil
+}
+
+// RequestVariants queues variant generation for an uploaded image without
+// waiting for it.
+func RequestVariants(nc *nats.Conn, hash string) error {
+	data, err := json.Marshal(api.VariantsRequest{Hash: hash})
+	if err != nil {
+		return fmt.Errorf("marshal variants request: %w", err)
+	}
+	return nc.Publish(api.Subj.ImageGroup+"."+api.Subj.ImageVariants, data)
+}
+
+// ----------- HANDLERS -----------
+
+func (s *MediaService) handleImageVariants() micro.HandlerFunc {
+	l := s.l.WithBreadcrumb("image_variants")
+	return func(req micro.Request) {
+		var reqData api.VariantsRequest
+
+		// jobs published without a reply subject only get logged
+		respondErr := func(code, description string) {
+			if req.Reply() == "" {
+				return
+			}
+			if err := req.Error(code, description, nil); err != nil {
+				l.Error("failed to respond to variants request: %v", err)
+			}
+		}
+
+		l.Debug("got request")
+		if err := json.Unmarshal(req.Data(), &reqData); err != nil || !validHash(reqData.Hash) {
+			l.Warn("invalid variants request: %s", req.Data())
+			respondErr("INVALID_REQUEST", "invalid request")
+			return
+		}
+
+		variants, err := s.store.GenerateVariants(reqData.Hash)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			respondErr("NOT_FOUND", "media not found")
+			return
+		case errors.Is(err, ErrNotImage):
+			l.Debug("no variants for %s: %v", reqData.Hash, err)
+			respondErr("INVALID_REQUEST", err.Error())
+			return
+		case err != nil:
+			l.Error("failed to generate variants for %s: %v", reqData.Hash, err)
+			respondErr("INTERNAL_ERROR", "failed to generate variants")
+			return
+		}
+		l.Info("%d variants of %s ready", len(variants), reqData.Hash)
+
+		if req.Reply() == "" {
+			return
+		}
+		if err := req.RespondJSON(api.VariantsResponse{
+			Hash:     reqData.Hash,
+			Variants: variants,
+			Srcset:   Srcset(variants),
+		}); err != nil {
+			l.Error("failed to respond to variants request: %v", err)
+		}
+	}
+}
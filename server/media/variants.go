@@ -0,0 +1,104 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Variants returns the stored variants of the image hash, narrowest first.
+// The list is empty until the worker made them, or if it cannot.
+func (s *Store) Variants(hash string) ([]Variant, error) {
+	objs, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	variants := []Variant{}
+	for _, obj := range objs {
+		if obj.Hash != hash || !obj.IsVariant() {
+			continue
+		}
+		variants = append(variants, Variant{
+			Width:       obj.Width,
+			Height:      obj.Height,
+			URL:         obj.URL,
+			ContentType: obj.ContentType,
+			Size:        obj.Size,
+		})
+	}
+	slices.SortFunc(variants, func(a, b Variant) int {
+		return a.Width - b.Width
+	})
+	return variants, nil
+}
+
+// GenerateVariants makes and stores the variants of the image hash. Variants
+// that are already stored are kept, so running it twice is cheap. ErrNotImage
+// is returned for attachments that are not resizable images.
+func (s *Store) GenerateVariants(hash string) ([]Variant, error) {
+	existing, err := s.Variants(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return existing, nil
+	}
+
+	orig, rc, err := s.Open(hash, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !Resizable(orig.ContentType) {
+		rc.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotImage, orig.ContentType)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read media %s: %w", hash, err)
+	}
+	encoded, err := makeVariants(data, orig.ContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	variants := make([]Variant, 0, len(encoded))
+	for _, v := range encoded {
+		info, err := s.obj.Put(s.ctx, jetstream.ObjectMeta{
+			Name:    variantName(hash, v.width),
+			Headers: nats.Header{"Content-Type": []string{v.contentType}},
+			Metadata: map[string]string{
+				"article":     orig.Article.String(),
+				"uploaded_by": orig.UploadedBy,
+				"height":      strconv.Itoa(v.height),
+			},
+		}, bytes.NewReader(v.data))
+		if err != nil {
+			return nil, fmt.Errorf("put variant %s: %w", variantName(hash, v.width), err)
+		}
+		obj := object(info)
+		variants = append(variants, Variant{
+			Width:       v.width,
+			Height:      v.height,
+			URL:         obj.URL,
+			ContentType: v.contentType,
+			Size:        obj.Size,
+		})
+	}
+	return variants, nil
+}
+
+// Srcset formats variants as an HTML srcset value.
+func Srcset(variants []Variant) string {
+	parts := make([]string, 0, len(variants))
+	for _, v := range variants {
+		parts = append(parts, v.URL+" "+strconv.Itoa(v.Width)+"w")
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,254 @@
+// Package page renders the html shell of the frontend with the metadata of an
+// article filled in, for crawlers and link previews that do not run
+// JavaScript.
+//
+// The shell is the index.html the SPA boots from. The title, description,
+// canonical url, Open Graph and Twitter tags and a JSON-LD BlogPosting go
+// into its head, the rendered article into the noscript of the app element.
+// The SPA still boots from the same scripts and replaces the app element as
+// usual.
+package page
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/djot"
+)
+
+const (
+	SiteName = "jst.dev"
+	// DescriptionLength caps the description, previews cut it off anyway.
+	DescriptionLength = 200
+)
+
+// Page is the metadata of an article page.
+type Page struct {
+	Lang        string
+	Title       string
+	Description string
+	Canonical   string // absolute url of the page
+	Image       string // absolute url, "" if there is none
+	Author      string
+	Tags        []string
+	Published   time.Time // zero if never published
+	Modified    time.Time
+	Alternates  []Alternate
+	NoIndex     bool          // for pages that are not public
+	Body        template.HTML // the article, rendered and sanitised
+}
+
+// Alternate is the page in another language.
+type Alternate struct {
+	Lang string
+	Href string
+}
+
+// Article builds the page of art. base is the site url without trailing
+// slash, hrefLangs the languages the article is available in to the public.
+func Article(art articles.Article, base string, hrefLangs []articles.HrefLang, now time.Time) Page {
+	p := Page{
+		Lang:        art.Language(),
+		Title:       art.Title,
+		Description: description(art),
+		Canonical:   articleURL(base, art.Slug),
+		Image:       absolute(base, art.Derived.FirstImage),
+		Author:      art.Author,
+		Tags:        art.Tags,
+		Modified:    time.UnixMilli(int64(max(art.UpdatedAt, art.PublishedAt))).UTC(),
+		NoIndex:     !art.IsPublic(now),
+		Body:        template.HTML(djot.ToHTML(art.Content)),
+	}
+	if art.PublishedAt > 0 {
+		p.Published = time.UnixMilli(int64(art.PublishedAt)).UTC()
+	}
+	if len(hrefLangs) > 1 {
+		for _, hl := range hrefLangs {
+			p.Alternates = append(p.Alternates, Alternate{Lang: hl.Lang, Href: articleURL(base, hl.Slug)})
+			if hl.Default {
+				p.Alternates = append(p.Alternates, Alternate{Lang: "x-default", Href: articleURL(base, hl.Slug)})
+			}
+		}
+	}
+	return p
+}
+
+// description is the leading paragraph, or the subtitle, or the start of the
+// content, cut to DescriptionLength.
+func description(art articles.Article) string {
+	text := strings.TrimSpace(art.Leading)
+	if text == "" {
+		text = strings.TrimSpace(art.Subtitle)
+	}
+	if text == "" {
+		text = djot.ToText(art.Content)
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= DescriptionLength {
+		return text
+	}
+	cut := string([]rune(text)[:DescriptionLength])
+	if i := strings.LastIndex(cut, " "); i > DescriptionLength/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+func articleURL(base, slug string) string {
+	return base + "/article/" + url.PathEscape(slug)
+}
+
+// absolute resolves dest against base. Destinations that are not safe to
+// link to come back empty.
+func absolute(base, dest string) string {
+	dest, ok := djot.SafeURL(dest)
+	if !ok {
+		return ""
+	}
+	b, err := url.Parse(base + "/")
+	if err != nil {
+		return ""
+	}
+	u, err := b.Parse(dest)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+// --- RENDERING ---
+
+var head = template.Must(template.New("head").Parse(`
+  <meta name="description" content="{{.Description}}" />
+  <link rel="canonical" href="{{.Canonical}}" />
+{{- range .Alternates}}
+  <link rel="alternate" hreflang="{{.Lang}}" href="{{.Href}}" />
+{{- end}}
+{{- if .NoIndex}}
+  <meta name="robots" content="noindex" />
+{{- end}}
+  <meta property="og:site_name" content="{{.SiteName}}" />
+  <meta property="og:type" content="article" />
+  <meta property="og:title" content="{{.Title}}" />
+  <meta property="og:description" content="{{.Description}}" />
+  <meta property="og:url" content="{{.Canonical}}" />
+  <meta property="og:locale" content="{{.Lang}}" />
+{{- if .Image}}
+  <meta property="og:image" content="{{.Image}}" />
+{{- end}}
+{{- if not .Published.IsZero}}
+  <meta property="article:published_time" content="{{.Published.Format "2006-01-02T15:04:05Z07:00"}}" />
+{{- end}}
+  <meta property="article:modified_time" content="{{.Modified.Format "2006-01-02T15:04:05Z07:00"}}" />
+{{- range .Tags}}
+  <meta property="article:tag" content="{{.}}" />
+{{- end}}
+  <meta name="twitter:card" content="{{if .Image}}summary_large_image{{else}}summary{{end}}" />
+  <meta name="twitter:title" content="{{.Title}}" />
+  <meta name="twitter:description" content="{{.Description}}" />
+{{- if .Image}}
+  <meta name="twitter:image" content="{{.Image}}" />
+{{- end}}
+  <script type="application/ld+json">{{.JSONLD}}</script>
+`))
+
+var body = template.Must(template.New("body").Parse(`<noscript>
+      <article>
+        <h1>{{.Title}}</h1>
+        {{.Body}}
+      </article>
+    </noscript>`))
+
+var (
+	htmlLang = regexp.MustCompile(`(<html[^>]*\slang=")[^"]*(")`)
+	title    = regexp.MustCompile(`(?s)<title>.*?</title>`)
+	noscript = regexp.MustCompile(`(?s)(<div id="app">\s*)<noscript>.*?</noscript>`)
+)
+
+// Render fills p into the shell index.
+func Render(index []byte, p Page) ([]byte, error) {
+	if !bytes.Contains(index, []byte("</head>")) || !noscript.Match(index) {
+		return nil, fmt.Errorf("render page: shell has no head or app element")
+	}
+	ld, err := jsonLD(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var h, b bytes.Buffer
+	err = head.Execute(&h, struct {
+		Page
+		SiteName string
+		JSONLD   template.JS
+	}{p, SiteName, template.JS(ld)})
+	if err != nil {
+		return nil, fmt.Errorf("render head: %w", err)
+	}
+	if err = body.Execute(&b, p); err != nil {
+		return nil, fmt.Errorf("render body: %w", err)
+	}
+
+	out := htmlLang.ReplaceAll(index, []byte("${1}"+template.HTMLEscapeString(p.Lang)+"${2}"))
+	pageTitle := template.HTMLEscapeString(p.Title + " - " + SiteName)
+	out = title.ReplaceAllLiteral(out, []byte("<title>"+pageTitle+"</title>"))
+	out = bytes.Replace(out, []byte("</head>"), append(h.Bytes(), []byte("</head>")...), 1)
+	loc := noscript.FindSubmatchIndex(out)
+	out = append(out[:loc[3]:loc[3]], append(b.Bytes(), out[loc[1]:]...)...)
+	return out, nil
+}
+
+// jsonLD describes the article as a schema.org BlogPosting. json.Marshal
+// escapes <, > and &, so the result is safe inside a script element.
+func jsonLD(p Page) ([]byte, error) {
+	type thing struct {
+		Type string `json:"@type"`
+		Name string `json:"name,omitempty"`
+		Id   string `json:"@id,omitempty"`
+	}
+	ld := struct {
+		Context       string   `json:"@context"`
+		Type          string   `json:"@type"`
+		Headline      string   `json:"headline"`
+		Description   string   `json:"description,omitempty"`
+		URL           string   `json:"url"`
+		MainEntity    thing    `json:"mainEntityOfPage"`
+		Image         string   `json:"image,omitempty"`
+		InLanguage    string   `json:"inLanguage,omitempty"`
+		Keywords      []string `json:"keywords,omitempty"`
+		DatePublished string   `json:"datePublished,omitempty"`
+		DateModified  string   `json:"dateModified"`
+		Author        *thing   `json:"author,omitempty"`
+		Publisher     thing    `json:"publisher"`
+	}{
+		Context:      "https://schema.org",
+		Type:         "BlogPosting",
+		Headline:     p.Title,
+		Description:  p.Description,
+		URL:          p.Canonical,
+		MainEntity:   thing{Type: "WebPage", Id: p.Canonical},
+		Image:        p.Image,
+		InLanguage:   p.Lang,
+		Keywords:     p.Tags,
+		DateModified: p.Modified.Format(time.RFC3339),
+		Publisher:    thing{Type: "Organization", Name: SiteName},
+	}
+	if !p.Published.IsZero() {
+		ld.DatePublished = p.Published.Format(time.RFC3339)
+	}
+	if p.Author != "" {
+		ld.Author = &thing{Type: "Person", Name: p.Author}
+	}
+	data, err := json.Marshal(ld)
+	if err != nil {
+		return nil, fmt.Errorf("marshal json-ld: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,126 @@
+package page
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"jst_dev/server/articles"
+)
+
+var now = time.UnixMilli(1_760_000_000_000)
+
+const shell = `<!doctype html>
+<html lang="en" class="bg-zinc-800">
+<head>
+  <title>🚧 jst_lustre</title>
+  <script type="module" src="/static/jst_lustre.min.mjs"></script>
+</head>
+<body>
+  <div id="app">
+    <noscript>
+      This is a lustre spa application. Please enable javascript to continue.
+    </noscript>
+  </div>
+</body>
+</html>`
+
+func testArticle() articles.Article {
+	at := int(now.UnixMilli())
+	return articles.Article{
+		Id:          uuid.New(),
+		Slug:        "hej-värld",
+		Title:       `Tags & "quotes" </title><script>`,
+		Leading:     "A lead with <b>markup</b>.",
+		Author:      "johan",
+		Status:      articles.StatusPublished,
+		PublishedAt: at - 1000,
+		UpdatedAt:   at,
+		Tags:        []string{"go"},
+		Lang:        "sv",
+		Content:     "## Hello\n\nSome *text* and <script>alert(1)</script>.\n\n![cat](/media/abc/960)",
+		Derived:     articles.Derive("![cat](/media/abc/960)"),
+	}
+}
+
+func TestArticle(t *testing.T) {
+	art := testArticle()
+	hrefLangs := []articles.HrefLang{
+		{Lang: "en", Slug: "hello-world", Default: true},
+		{Lang: "sv", Slug: art.Slug},
+	}
+	p := Article(art, "https://jst.dev", hrefLangs, now)
+	if p.Canonical != "https://jst.dev/article/hej-v%C3%A4rld" || p.Image != "https://jst.dev/media/abc/960" || p.Lang != "sv" {
+		t.Errorf("unexpected page %+v", p)
+	}
+	if len(p.Alternates) != 3 || p.Alternates[1].Lang != "x-default" || p.NoIndex {
+		t.Errorf("unexpected alternates %+v", p.Alternates)
+	}
+	if p = Article(art, "https://jst.dev", hrefLangs[:1], now); len(p.Alternates) != 0 {
+		t.Errorf("expected no alternates without translations, got %+v", p.Alternates)
+	}
+
+	art.Leading = strings.Repeat("word ", 100)
+	if d := description(art); len([]rune(d)) > DescriptionLength+1 || !strings.HasSuffix(d, "word…") {
+		t.Errorf("expected a description cut at a word, got %q", d)
+	}
+	art.Leading, art.Subtitle = "", ""
+	if d := description(art); !strings.HasPrefix(d, "Hello Some text") {
+		t.Errorf("expected the content as description, got %q", d)
+	}
+
+	art.Status, art.PublishedAt = articles.StatusDraft, 0
+	if p = Article(art, "https://jst.dev", nil, now); !p.NoIndex || !p.Published.IsZero() {
+		t.Errorf("expected an unindexed unpublished page, got %+v", p)
+	}
+}
+
+func TestRender(t *testing.T) {
+	p := Article(testArticle(), "https://jst.dev", nil, now)
+	out, err := Render([]byte(shell), p)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	html := string(out)
+
+	for _, want := range []string{
+		`<html lang="sv"`,
+		`<title>Tags &amp; &#34;quotes&#34; &lt;/title&gt;&lt;script&gt; - jst.dev</title>`,
+		`<link rel="canonical" href="https://jst.dev/article/hej-v%C3%A4rld" />`,
+		`<meta property="og:image" content="https://jst.dev/media/abc/960" />`,
+		`<meta name="twitter:card" content="summary_large_image" />`,
+		`<meta name="description" content="A lead with &lt;b&gt;markup&lt;/b&gt;." />`,
+		`<script type="module" src="/static/jst_lustre.min.mjs"></script>`,
+		`<h2 id="Hello">Hello</h2>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected %s in\n%s", want, html)
+		}
+	}
+	if strings.Contains(html, "<script>") || strings.Contains(html, "Please enable javascript") || strings.Contains(html, "noindex") {
+		t.Errorf("unexpected content in\n%s", html)
+	}
+	if strings.Count(html, "<noscript>") != 1 || strings.Index(html, "<noscript>") < strings.Index(html, `<div id="app">`) {
+		t.Errorf("expected the article in the noscript of the app\n%s", html)
+	}
+
+	m := regexp.MustCompile(`(?s)<script type="application/ld\+json">(.*?)</script>`).FindStringSubmatch(html)
+	if m == nil {
+		t.Fatalf("no json-ld in\n%s", html)
+	}
+	var ld map[string]any
+	if err := json.Unmarshal([]byte(m[1]), &ld); err != nil {
+		t.Fatalf("json-ld: %v\n%s", err, m[1])
+	}
+	if ld["@type"] != "BlogPosting" || ld["headline"] != testArticle().Title || ld["datePublished"] == nil {
+		t.Errorf("unexpected json-ld %v", ld)
+	}
+
+	if _, err := Render([]byte("<html></html>"), p); err == nil {
+		t.Errorf("expected a shell without app element to be refused")
+	}
+}
@@ -0,0 +1,321 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/jst_log"
+)
+
+// Presence says who has an article open in the editor and where in it they
+// are, edit locks say who is editing it. Both live in KV buckets whose
+// entries expire after a TTL unless they are written again, so an editor that
+// vanishes without saying goodbye (a crashed server, a connection nobody
+// noticed was lost) ages out. Clients keep their entries alive with a
+// heartbeat and follow the buckets with kv_sub. Expiry removes an entry
+// without telling watchers, which is why every entry carries expires_at:
+// clients drop entries once it has passed.
+//
+// Locks are soft. They keep two editors from overwriting each other by
+// accident, anyone who may change the article can still force a write.
+
+const (
+	BucketPresence = "presence"   // keyed {article id}.{user id}
+	BucketLocks    = "edit_locks" // keyed {article id}
+
+	PresenceTTL = 30 * time.Second
+	LockTTL     = 60 * time.Second
+
+	maxSection = 200 // runes of a section hint
+)
+
+var (
+	ErrInvalid = errors.New("invalid presence")
+	// ErrLocked is matched (errors.Is) by every LockedError.
+	ErrLocked = errors.New("article is locked")
+)
+
+// LockedError is returned when somebody else holds the edit lock.
+type LockedError struct {
+	Lock Lock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("article %s is locked by %s until %s", e.Lock.ArticleID, e.Lock.UserID, time.UnixMilli(int64(e.Lock.ExpiresAt)).Format(time.RFC3339))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Presence is a user with an article open.
+type Presence struct {
+	ArticleID uuid.UUID `json:"article_id"`
+	UserID    string    `json:"user_id"`
+	Section   string    `json:"section,omitempty"` // id of the heading the user is at
+	Cursor    int       `json:"cursor,omitempty"`  // offset into the content
+	UpdatedAt int       `json:"updated_at"`        // unix ms
+	ExpiresAt int       `json:"expires_at"`        // unix ms
+}
+
+// Lock is the edit lock of an article.
+type Lock struct {
+	ArticleID  uuid.UUID `json:"article_id"`
+	UserID     string    `json:"user_id"`
+	AcquiredAt int       `json:"acquired_at"` // unix ms
+	ExpiresAt  int       `json:"expires_at"`  // unix ms, moved on by every refresh
+	Revision   uint64    `json:"revision,omitempty"`
+}
+
+// Store keeps presence and edit locks in their KV buckets.
+type Store struct {
+	ctx      context.Context
+	presence jetstream.KeyValue
+	locks    jetstream.KeyValue
+	l        *jst_log.Logger
+	now      func() time.Time
+}
+
+// NewStore sets up the presence and lock buckets.
+func NewStore(ctx context.Context, nc *nats.Conn, l *jst_log.Logger) (*Store, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("jetstream new: %w", err)
+	}
+	presence, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      BucketPresence,
+		Description: "who has which article open",
+		History:     1,
+		TTL:         PresenceTTL,
+		Storage:     jetstream.MemoryStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create presence bucket: %w", err)
+	}
+	locks, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      BucketLocks,
+		Description: "soft edit locks of articles",
+		History:     1,
+		TTL:         LockTTL,
+		Storage:     jetstream.MemoryStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create lock bucket: %w", err)
+	}
+	return &Store{
+		ctx:      ctx,
+		presence: presence,
+		locks:    locks,
+		l:        l,
+		now:      time.Now,
+	}, nil
+}
+
+// --- PRESENCE ---
+
+// Touch records that the user has the article open, or still has. It has to
+// be repeated well within PresenceTTL.
+func (s *Store) Touch(p Presence) (Presence, error) {
+	if err := validUser(p.UserID); err != nil {
+		return p, err
+	}
+	if utf8.RuneCountInString(p.Section) > maxSection {
+		return p, fmt.Errorf("%w: section hint longer than %d", ErrInvalid, maxSection)
+	}
+	p.Cursor = max(p.Cursor, 0)
+	now := s.now()
+	p.UpdatedAt = int(now.UnixMilli())
+	p.ExpiresAt = int(now.Add(PresenceTTL).UnixMilli())
+	data, err := json.Marshal(p)
+	if err != nil {
+		return p, fmt.Errorf("marshal presence: %w", err)
+	}
+	if _, err := s.presence.Put(s.ctx, presenceKey(p.ArticleID, p.UserID), data); err != nil {
+		return p, fmt.Errorf("put presence: %w", err)
+	}
+	return p, nil
+}
+
+// Leave removes the user from the article.
+func (s *Store) Leave(articleID uuid.UUID, userID string) error {
+	if err := validUser(userID); err != nil {
+		return err
+	}
+	err := s.presence.Delete(s.ctx, presenceKey(articleID, userID))
+	if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		return fmt.Errorf("delete presence: %w", err)
+	}
+	return nil
+}
+
+// Present lists who has the article open, by user id.
+func (s *Store) Present(articleID uuid.UUID) ([]Presence, error) {
+	keys, err := s.presence.ListKeysFiltered(s.ctx, articleID.String()+".*")
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return []Presence{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list presence: %w", err)
+	}
+	now := int(s.now().UnixMilli())
+	present := []Presence{}
+	for key := range keys.Keys() {
+		entry, err := s.presence.Get(s.ctx, key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue // left since listing
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get presence: %w", err)
+		}
+		var p Presence
+		if err := json.Unmarshal(entry.Value(), &p); err != nil {
+			s.l.Warn("decode presence %s: %v", key, err)
+			continue
+		}
+		if p.ExpiresAt > now {
+			present = append(present, p)
+		}
+	}
+	slices.SortFunc(present, func(a, b Presence) int {
+		return strings.Compare(a.UserID, b.UserID)
+	})
+	return present, nil
+}
+
+// --- LOCKS ---
+
+// Lock takes the edit lock of the article for the user, or refreshes it if
+// they hold it already. A *LockedError is returned while someone else holds
+// it. The lock has to be refreshed well within LockTTL.
+func (s *Store) Lock(articleID uuid.UUID, userID string) (Lock, error) {
+	if err := validUser(userID); err != nil {
+		return Lock{}, err
+	}
+	now := s.now()
+	lock := Lock{
+		ArticleID:  articleID,
+		UserID:     userID,
+		AcquiredAt: int(now.UnixMilli()),
+		ExpiresAt:  int(now.Add(LockTTL).UnixMilli()),
+	}
+	current, err := s.Holder(articleID)
+	if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		return lock, err
+	}
+	if err == nil && current.UserID != userID {
+		return current, &LockedError{Lock: current}
+	}
+
+	if current.UserID == userID {
+		lock.AcquiredAt = current.AcquiredAt // a refresh
+	}
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return lock, fmt.Errorf("marshal lock: %w", err)
+	}
+	if current.Revision == 0 {
+		lock.Revision, err = s.locks.Create(s.ctx, articleID.String(), data)
+	} else {
+		lock.Revision, err = s.locks.Update(s.ctx, articleID.String(), data, current.Revision)
+	}
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		// someone got in between, whoever it was holds the lock now
+		if holder, herr := s.Holder(articleID); herr == nil && holder.UserID != userID {
+			return holder, &LockedError{Lock: holder}
+		}
+		return s.Lock(articleID, userID)
+	}
+	if err != nil {
+		return lock, fmt.Errorf("write lock: %w", err)
+	}
+	return lock, nil
+}
+
+// Unlock releases the edit lock of the article. Only its holder may, unless
+// force is set. Unlocking an article nobody holds is fine.
+func (s *Store) Unlock(articleID uuid.UUID, userID string, force bool) error {
+	current, err := s.Holder(articleID)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.UserID != userID && !force {
+		return &LockedError{Lock: current}
+	}
+	err = s.locks.Delete(s.ctx, articleID.String(), jetstream.LastRevision(current.Revision))
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return s.Unlock(articleID, userID, force) // refreshed or taken over since
+	}
+	if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		return fmt.Errorf("delete lock: %w", err)
+	}
+	return nil
+}
+
+// Holder returns the edit lock of the article. jetstream.ErrKeyNotFound is
+// returned if nobody holds it, also when the lock has expired but the bucket
+// did not get around to removing it yet.
+func (s *Store) Holder(articleID uuid.UUID) (Lock, error) {
+	entry, err := s.locks.Get(s.ctx, articleID.String())
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return Lock{}, fmt.Errorf("lock of %s: %w", articleID, jetstream.ErrKeyNotFound)
+		}
+		return Lock{}, fmt.Errorf("get lock: %w", err)
+	}
+	var lock Lock
+	if err := json.Unmarshal(entry.Value(), &lock); err != nil {
+		return lock, fmt.Errorf("decode lock: %w", err)
+	}
+	lock.Revision = entry.Revision()
+	if lock.ExpiresAt <= int(s.now().UnixMilli()) {
+		// expired, but the revision lets Lock replace it
+		return Lock{Revision: lock.Revision}, fmt.Errorf("lock of %s expired: %w", articleID, jetstream.ErrKeyNotFound)
+	}
+	return lock, nil
+}
+
+// Check returns a *LockedError if someone other than the user holds the edit
+// lock of the article.
+func (s *Store) Check(articleID uuid.UUID, userID string) error {
+	lock, err := s.Holder(articleID)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if lock.UserID != userID {
+		return &LockedError{Lock: lock}
+	}
+	return nil
+}
+
+// --- HELPERS ---
+
+var userPattern = regexp.MustCompile(`^[-_=a-zA-Z0-9]+$`)
+
+// validUser checks that the user id can be part of a key.
+func validUser(userID string) error {
+	if !userPattern.MatchString(userID) {
+		return fmt.Errorf("%w: user id %q", ErrInvalid, userID)
+	}
+	return nil
+}
+
+func presenceKey(articleID uuid.UUID, userID string) string {
+	return articleID.String() + "." + userID
+}
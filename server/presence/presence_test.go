@@ -0,0 +1,139 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/jst_log"
+)
+
+func testStore(t testing.TB) *Store {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{
+		ServerName: "test-presence",
+		NoLog:      true,
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL(), nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		nc.Close()
+		ns.Shutdown()
+	})
+	store, err := NewStore(ctx, nc, jst_log.NewLogger("test", jst_log.DefaultSubjects()))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	return store
+}
+
+func TestPresence(t *testing.T) {
+	s := testStore(t)
+	article := uuid.New()
+
+	if _, err := s.Touch(Presence{ArticleID: article, UserID: "no.dots"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected a user id unfit for a key to be refused, got %v", err)
+	}
+	for _, user := range []string{"bob", "alice"} {
+		if _, err := s.Touch(Presence{ArticleID: article, UserID: user, Section: "intro", Cursor: -4}); err != nil {
+			t.Fatalf("touch %s: %v", user, err)
+		}
+	}
+	if _, err := s.Touch(Presence{ArticleID: uuid.New(), UserID: "carol"}); err != nil {
+		t.Fatalf("touch elsewhere: %v", err)
+	}
+
+	present, err := s.Present(article)
+	if err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if len(present) != 2 || present[0].UserID != "alice" || present[1].Section != "intro" || present[1].Cursor != 0 {
+		t.Errorf("unexpected presence %+v", present)
+	}
+
+	if err := s.Leave(article, "alice"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if present, _ = s.Present(article); len(present) != 1 || present[0].UserID != "bob" {
+		t.Errorf("expected only bob left, got %+v", present)
+	}
+
+	// entries the bucket did not expire yet are left out once expires_at passed
+	s.now = func() time.Time { return time.Now().Add(PresenceTTL) }
+	if present, _ = s.Present(article); len(present) != 0 {
+		t.Errorf("expected expired presence to be left out, got %+v", present)
+	}
+}
+
+func TestLock(t *testing.T) {
+	s := testStore(t)
+	article := uuid.New()
+
+	if err := s.Check(article, "alice"); err != nil {
+		t.Errorf("expected an unlocked article, got %v", err)
+	}
+	lock, err := s.Lock(article, "alice")
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if lock.UserID != "alice" || lock.Revision == 0 {
+		t.Errorf("unexpected lock %+v", lock)
+	}
+
+	var lockedErr *LockedError
+	if _, err = s.Lock(article, "bob"); !errors.As(err, &lockedErr) || lockedErr.Lock.UserID != "alice" {
+		t.Errorf("expected bob to find the article locked by alice, got %v", err)
+	}
+	if err = s.Check(article, "bob"); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected bob to be refused, got %v", err)
+	}
+	if err = s.Check(article, "alice"); err != nil {
+		t.Errorf("expected alice to pass, got %v", err)
+	}
+
+	refreshed, err := s.Lock(article, "alice")
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if refreshed.AcquiredAt != lock.AcquiredAt || refreshed.Revision <= lock.Revision {
+		t.Errorf("expected a refresh of the same lock, got %+v after %+v", refreshed, lock)
+	}
+
+	if err = s.Unlock(article, "bob", false); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected bob to not release alice's lock, got %v", err)
+	}
+	if err = s.Unlock(article, "bob", true); err != nil {
+		t.Fatalf("forced unlock: %v", err)
+	}
+	if err = s.Unlock(article, "bob", false); err != nil {
+		t.Errorf("expected unlocking a free article to be fine, got %v", err)
+	}
+	if lock, err = s.Lock(article, "bob"); err != nil || lock.UserID != "bob" {
+		t.Fatalf("expected bob to take the released lock, got %+v, %v", lock, err)
+	}
+
+	// a lock whose holder stopped refreshing it is up for grabs
+	s.now = func() time.Time { return time.Now().Add(LockTTL) }
+	if lock, err = s.Lock(article, "alice"); err != nil || lock.UserID != "alice" {
+		t.Errorf("expected alice to take the expired lock, got %+v, %v", lock, err)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+// the NATS subject used by this package
+var Subj = struct {
+	// articles
+	ArticleGroup   string
+	ArticleQuery   string
+	ArticleRelated string
+}{
+	// articles
+	ArticleGroup:   "svc.search.articles",
+	ArticleQuery:   "query",
+	ArticleRelated: "related",
+}
+
+// ARTICLE SEARCH
+type ArticleQueryRequest struct {
+	Query string `json:"q"`
+	Limit int    `json:"limit,omitempty"` // Optional: defaults to 20
+	// IncludeHidden also returns drafts, scheduled and archived articles.
+	// Set it for editors only.
+	IncludeHidden bool `json:"include_hidden,omitempty"`
+}
+
+type ArticleQueryResponse struct {
+	Query   string          `json:"q"`
+	Total   int             `json:"total"`
+	Results []ArticleResult `json:"results"`
+}
+
+type ArticleResult struct {
+	ID          string   `json:"id"`
+	Slug        string   `json:"slug"`
+	Title       string   `json:"title"`
+	Subtitle    string   `json:"subtitle"`
+	Tags        []string `json:"tags"`
+	Status      string   `json:"status"`
+	PublishedAt int      `json:"published_at"`
+	Score       float64  `json:"score"`
+	// Snippet is an HTML fragment of the best matching text with the matched
+	// words wrapped in <mark>. Everything else is escaped. Empty for related
+	// articles.
+	Snippet string `json:"snippet"`
+	// SharedTags are the tags a related article has in common with the one
+	// it is related to. Not set for query results.
+	SharedTags []string `json:"shared_tags,omitempty"`
+}
+
+// RELATED ARTICLES
+type ArticleRelatedRequest struct {
+	ID    string `json:"id"`
+	Limit int    `json:"limit,omitempty"` // Optional: defaults to 5
+	// IncludeHidden also returns drafts, scheduled and archived articles.
+	// Set it for editors only.
+	IncludeHidden bool `json:"include_hidden,omitempty"`
+}
+
+type ArticleRelatedResponse struct {
+	ID      string          `json:"id"`
+	Total   int             `json:"total"`
+	Results []ArticleResult `json:"results"`
+}
@@ -0,0 +1,375 @@
+package search
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/search/api"
+)
+
+// field is one of the indexed article fields.
+type field int
+
+const (
+	fieldTitle field = iota
+	fieldSubtitle
+	fieldLeading
+	fieldTags
+	fieldContent
+	fieldCount
+)
+
+// boosts weighs a hit in each field. A title hit is worth five content hits.
+var boosts = [fieldCount]float64{
+	fieldTitle:    5,
+	fieldSubtitle: 3,
+	fieldLeading:  2,
+	fieldTags:     4,
+	fieldContent:  1,
+}
+
+const snippetLength = 160 // runes, roughly
+
+// Index is an in-memory inverted index over articles.
+type Index struct {
+	lock     sync.RWMutex
+	docs     map[uuid.UUID]*doc
+	postings map[string]map[uuid.UUID]*[fieldCount]int // term -> doc -> hits per field
+}
+
+type doc struct {
+	art   articles.Article
+	terms []string
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{
+		docs:     map[uuid.UUID]*doc{},
+		postings: map[string]map[uuid.UUID]*[fieldCount]int{},
+	}
+}
+
+// Put adds or replaces an article in the index.
+func (idx *Index) Put(art articles.Article) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.remove(art.Id)
+
+	fields := [fieldCount]string{
+		fieldTitle:    art.Title,
+		fieldSubtitle: art.Subtitle,
+		fieldLeading:  art.Leading,
+		fieldTags:     strings.Join(art.Tags, " "),
+		fieldContent:  art.Content,
+	}
+	d := &doc{art: art}
+	for f, text := range fields {
+		for _, term := range Terms(text) {
+			docs, ok := idx.postings[term]
+			if !ok {
+				docs = map[uuid.UUID]*[fieldCount]int{}
+				idx.postings[term] = docs
+			}
+			hits, ok := docs[art.Id]
+			if !ok {
+				hits = &[fieldCount]int{}
+				docs[art.Id] = hits
+				d.terms = append(d.terms, term)
+			}
+			hits[f]++
+		}
+	}
+	idx.docs[art.Id] = d
+}
+
+// Remove drops an article from the index.
+func (idx *Index) Remove(id uuid.UUID) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.remove(id)
+}
+
+// Len returns the number of indexed articles.
+func (idx *Index) Len() int {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	return len(idx.docs)
+}
+
+func (idx *Index) remove(id uuid.UUID) {
+	d, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range d.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// Search returns the articles matching every term of query, best match
+// first, and the total number of matches before limit is applied. Only
+// public articles are returned unless includeHidden is set.
+//
+// Each term scores idf * sum(boost * (1 + ln(hits))) over the fields it
+// appears in.
+func (idx *Index) Search(query string, limit int, includeHidden bool) ([]api.ArticleResult, int) {
+	terms := unique(Terms(query))
+	if len(terms) == 0 {
+		return []api.ArticleResult{}, 0
+	}
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	now := time.Now()
+	scores := map[uuid.UUID]float64{}
+	for i, term := range terms {
+		docs := idx.postings[term]
+		if len(docs) == 0 {
+			return []api.ArticleResult{}, 0
+		}
+		idf := math.Log(1 + float64(len(idx.docs))/float64(len(docs)))
+		next := map[uuid.UUID]float64{}
+		for id, hits := range docs {
+			prev, ok := scores[id]
+			if i > 0 && !ok {
+				continue // AND: must match every previous term
+			}
+			if !includeHidden && !idx.docs[id].art.IsPublic(now) {
+				continue
+			}
+			score := 0.0
+			for f, n := range hits {
+				if n > 0 {
+					score += boosts[f] * (1 + math.Log(float64(n)))
+				}
+			}
+			next[id] = prev + idf*score
+		}
+		scores = next
+	}
+
+	ids := make([]uuid.UUID, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return idx.docs[ids[i]].art.PublishedAt > idx.docs[ids[j]].art.PublishedAt
+	})
+	total := len(ids)
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	results := make([]api.ArticleResult, 0, len(ids))
+	for _, id := range ids {
+		art := idx.docs[id].art
+		snippet, ok := Snippet(art.Content, terms)
+		if !ok {
+			snippet, _ = Snippet(art.Leading, terms)
+		}
+		results = append(results, api.ArticleResult{
+			ID:          art.Id.String(),
+			Slug:        art.Slug,
+			Title:       art.Title,
+			Subtitle:    art.Subtitle,
+			Tags:        art.Tags,
+			Status:      string(art.CurrentStatus(now)),
+			PublishedAt: art.PublishedAt,
+			Score:       math.Round(scores[id]*1000) / 1000,
+			Snippet:     snippet,
+		})
+	}
+	return results, total
+}
+
+// --- TEXT ---
+
+// stopwords are dropped by Terms. Kept short on purpose, the field boosts do
+// most of the work.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "if": true, "in": true,
+	"into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "so": true, "that": true, "the": true, "their": true,
+	"then": true, "there": true, "these": true, "they": true, "this": true,
+	"to": true, "was": true, "will": true, "with": true,
+}
+
+// Terms splits text into lowercase, stemmed index terms.
+func Terms(text string) []string {
+	var terms []string
+	for _, word := range words(text) {
+		if term, ok := termOf(word.text); ok {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// termOf normalizes a single word, ok is false for stopwords and noise.
+func termOf(word string) (string, bool) {
+	word = strings.ToLower(word)
+	if utf8.RuneCountInString(word) < 2 || stopwords[word] {
+		return "", false
+	}
+	return Stem(word), true
+}
+
+// Stem strips the most common English inflections. It is not Porter, just
+// enough for "articles" to find "article" and "running" to find "run".
+func Stem(word string) string {
+	n := utf8.RuneCountInString(word)
+	cut := func(suffix, repl string, min int) (string, bool) {
+		if strings.HasSuffix(word, suffix) && n-utf8.RuneCountInString(suffix)+utf8.RuneCountInString(repl) >= min {
+			return strings.TrimSuffix(word, suffix) + repl, true
+		}
+		return word, false
+	}
+	for _, rule := range []struct {
+		suffix, repl string
+	}{
+		{"ational", "ate"},
+		{"ization", "ize"},
+		{"fulness", "ful"},
+		{"iveness", "ive"},
+		{"ements", "e"},
+		{"ement", "e"},
+		{"ments", ""},
+		{"ment", ""},
+		{"ness", ""},
+		{"ies", "y"},
+		{"ied", "y"},
+		{"ing", ""},
+		{"edly", ""},
+		{"ed", ""},
+		{"ly", ""},
+		{"es", ""},
+		{"s", ""},
+	} {
+		stem, ok := cut(rule.suffix, rule.repl, 3)
+		if !ok {
+			continue
+		}
+		if rule.suffix == "s" && (strings.HasSuffix(word, "ss") || strings.HasSuffix(word, "us")) {
+			return word
+		}
+		if rule.suffix == "es" && !strings.HasSuffix(stem, "sh") && !strings.HasSuffix(stem, "ch") && !strings.HasSuffix(stem, "x") && !strings.HasSuffix(stem, "ss") {
+			stem = strings.TrimSuffix(word, "s")
+		}
+		// running -> runn -> run
+		if (rule.suffix == "ing" || rule.suffix == "ed") && len(stem) > 3 && stem[len(stem)-1] == stem[len(stem)-2] && !strings.ContainsRune("lsz", rune(stem[len(stem)-1])) {
+			stem = stem[:len(stem)-1]
+		}
+		return stem
+	}
+	return word
+}
+
+type word struct {
+	text       string
+	start, end int // byte offsets
+}
+
+func words(text string) []word {
+	var (
+		ws    []word
+		start = -1
+	)
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			ws = append(ws, word{text[start:i], start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		ws = append(ws, word{text[start:], start, len(text)})
+	}
+	return ws
+}
+
+// Snippet cuts a window of text around the first word matching one of terms
+// and wraps every matching word in <mark>. ok is false if nothing matched, in
+// which case the start of the text is returned.
+func Snippet(text string, terms []string) (string, bool) {
+	want := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		want[t] = true
+	}
+	ws := words(text)
+	first := -1
+	for i, w := range ws {
+		if t, ok := termOf(w.text); ok && want[t] {
+			first = i
+			break
+		}
+	}
+
+	start, end := 0, len(text)
+	if first >= 0 {
+		start = ws[first].start
+		// back up a few words for context
+		for i := first - 1; i >= 0 && i >= first-6; i-- {
+			start = ws[i].start
+		}
+	}
+	if runes := []rune(text[start:]); len(runes) > snippetLength {
+		end = start + len(string(runes[:snippetLength]))
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, w := range ws {
+		if w.start < start || w.end > end {
+			continue
+		}
+		if t, ok := termOf(w.text); ok && want[t] {
+			b.WriteString(html.EscapeString(text[pos:w.start]))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(w.text))
+			b.WriteString("</mark>")
+			pos = w.end
+		}
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return strings.Join(strings.Fields(b.String()), " "), first >= 0
+}
+
+func unique(terms []string) []string {
+	seen := map[string]bool{}
+	out := terms[:0:0]
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
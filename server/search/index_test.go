@@ -0,0 +1,150 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"jst_dev/server/articles"
+)
+
+func TestTerms(t *testing.T) {
+	got := Terms("The Articles are running, NATS-all the way down!")
+	want := []string{"article", "run", "nat", "all", "way", "down"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestStem(t *testing.T) {
+	for word, want := range map[string]string{
+		"articles":  "article",
+		"boxes":     "box",
+		"classes":   "class",
+		"stories":   "story",
+		"running":   "run",
+		"falling":   "fall",
+		"happiness": "happi",
+		"class":     "class",
+		"go":        "go",
+		"gleam":     "gleam",
+	} {
+		if got := Stem(word); got != want {
+			t.Errorf("Stem(%q): expected %q, got %q", word, want, got)
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := NewIndex()
+	inTitle := articles.Article{Id: uuid.New(), Slug: "title", Title: "Gleam on the server"}
+	inContent := articles.Article{Id: uuid.New(), Slug: "content", Title: "Something else", Content: "I wrote some gleam once."}
+	unrelated := articles.Article{Id: uuid.New(), Slug: "unrelated", Title: "NATS", Tags: []string{"go"}}
+	idx.Put(inContent)
+	idx.Put(inTitle)
+	idx.Put(unrelated)
+
+	results, total := idx.Search("gleam", 10, true)
+	if total != 2 {
+		t.Fatalf("expected 2 hits, got %d", total)
+	}
+	if results[0].Slug != "title" || results[1].Slug != "content" {
+		t.Errorf("expected title hit before content hit, got %s, %s", results[0].Slug, results[1].Slug)
+	}
+
+	// every term must match
+	if _, total = idx.Search("gleam nats", 10, true); total != 0 {
+		t.Errorf("expected no article matching both terms, got %d", total)
+	}
+
+	// tags are indexed
+	if results, _ = idx.Search("go", 10, true); len(results) != 1 || results[0].Slug != "unrelated" {
+		t.Errorf("expected tag hit, got %v", results)
+	}
+
+	// removed and replaced articles
+	idx.Remove(inTitle.Id)
+	inContent.Content = "no longer about that"
+	idx.Put(inContent)
+	if _, total = idx.Search("gleam", 10, true); total != 0 {
+		t.Errorf("expected no hits after remove and replace, got %d", total)
+	}
+	if idx.Len() != 2 {
+		t.Errorf("expected 2 indexed articles, got %d", idx.Len())
+	}
+}
+
+func TestSearchHidden(t *testing.T) {
+	idx := NewIndex()
+	idx.Put(articles.Article{Id: uuid.New(), Slug: "draft", Title: "Gleam draft", Status: articles.StatusDraft})
+	idx.Put(articles.Article{Id: uuid.New(), Slug: "live", Title: "Gleam live", Status: articles.StatusPublished, PublishedAt: 1})
+
+	if results, total := idx.Search("gleam", 10, false); total != 1 || results[0].Slug != "live" {
+		t.Errorf("expected only the published article, got %v", results)
+	}
+	if _, total := idx.Search("gleam", 10, true); total != 2 {
+		t.Errorf("expected both articles for editors, got %d", total)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := strings.Repeat("filler words here. ", 20) + "Then <NATS> happened and the nats server was embedded. " + strings.Repeat("more filler. ", 20)
+	got, ok := Snippet(text, Terms("nats"))
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Errorf("expected snippet to be cut on both ends: %s", got)
+	}
+	if !strings.Contains(got, "&lt;<mark>NATS</mark>&gt; happened and the <mark>nats</mark> server") {
+		t.Errorf("expected escaped and highlighted snippet, got %s", got)
+	}
+
+	if _, ok = Snippet("nothing to see", Terms("nats")); ok {
+		t.Error("expected no match")
+	}
+}
+
+func TestRelated(t *testing.T) {
+	idx := NewIndex()
+	source := articles.Article{Id: uuid.New(), Slug: "source", Title: "Embedding a NATS server", Tags: []string{"nats", "go"},
+		Content: "Running the NATS server inside the Go binary keeps deployment simple."}
+	sameTags := articles.Article{Id: uuid.New(), Slug: "same-tags", Title: "Something else", Tags: []string{"go", "nats"},
+		Content: "Unrelated words about cooking."}
+	sameText := articles.Article{Id: uuid.New(), Slug: "same-text", Title: "Embedded NATS server", Tags: []string{"ops"},
+		Content: "The NATS server runs inside the binary, deployment stays simple."}
+	oneTag := articles.Article{Id: uuid.New(), Slug: "one-tag", Title: "Gleam frontend", Tags: []string{"go"},
+		Content: "Lustre and gleam in the browser."}
+	unrelated := articles.Article{Id: uuid.New(), Slug: "unrelated", Title: "Bread", Tags: []string{"food"},
+		Content: "Flour, water, salt."}
+	for _, art := range []articles.Article{source, sameTags, sameText, oneTag, unrelated} {
+		art.Status, art.PublishedAt = articles.StatusPublished, 1
+		idx.Put(art)
+	}
+	idx.Put(articles.Article{Id: uuid.New(), Slug: "draft", Title: "Embedding a NATS server, again", Tags: []string{"nats", "go"},
+		Status: articles.StatusDraft})
+
+	results, total, ok := idx.Related(source.Id, 10, false)
+	if !ok {
+		t.Fatal("expected the source to be indexed")
+	}
+	got := []string{}
+	for _, r := range results {
+		got = append(got, r.Slug)
+	}
+	if want := []string{"same-tags", "same-text", "one-tag"}; total != 3 || !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v (total %d)", want, got, total)
+	}
+	if !reflect.DeepEqual(results[0].SharedTags, []string{"nats", "go"}) || len(results[1].SharedTags) != 0 {
+		t.Errorf("unexpected shared tags %v, %v", results[0].SharedTags, results[1].SharedTags)
+	}
+
+	if results, total, _ = idx.Related(source.Id, 1, true); total != 4 || len(results) != 1 || results[0].Slug != "draft" {
+		t.Errorf("expected the draft first for editors, got %v (total %d)", results, total)
+	}
+	if _, _, ok = idx.Related(uuid.New(), 10, true); ok {
+		t.Error("expected unknown article not to be indexed")
+	}
+}
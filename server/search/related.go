@@ -0,0 +1,138 @@
+package search
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"jst_dev/server/search/api"
+)
+
+// relatedTagWeight weighs tag overlap against content similarity. Both are
+// between 0 and 1, so an article with the same tags and unrelated text ranks
+// like one with different tags and nearly the same text.
+const relatedTagWeight = 1.0
+
+// Related returns the articles most similar to the article id, best first,
+// and the number of articles with any similarity before limit is applied. ok
+// is false if the article is not indexed.
+//
+// Similarity is relatedTagWeight * the Jaccard index of the tag sets plus the
+// cosine similarity of the tf-idf term vectors of everything but the tags.
+// Only public articles are returned unless includeHidden is set.
+func (idx *Index) Related(id uuid.UUID, limit int, includeHidden bool) (results []api.ArticleResult, total int, ok bool) {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	source, ok := idx.docs[id]
+	if !ok {
+		return []api.ArticleResult{}, 0, false
+	}
+	now := time.Now()
+	vec := idx.vector(id)
+	norm := vectorNorm(vec)
+
+	scores := map[uuid.UUID]float64{}
+	shared := map[uuid.UUID][]string{}
+	for otherID, other := range idx.docs {
+		if otherID == id || (!includeHidden && !other.art.IsPublic(now)) {
+			continue
+		}
+		score := 0.0
+		common := sharedTags(source.art.Tags, other.art.Tags)
+		if len(common) > 0 {
+			union := len(source.art.Tags) + len(other.art.Tags) - len(common)
+			score += relatedTagWeight * float64(len(common)) / float64(union)
+		}
+		if norm > 0 {
+			otherVec := idx.vector(otherID)
+			dot := 0.0
+			for term, w := range vec {
+				dot += w * otherVec[term]
+			}
+			if dot > 0 {
+				score += dot / (norm * vectorNorm(otherVec))
+			}
+		}
+		if score > 0 {
+			scores[otherID] = score
+			shared[otherID] = common
+		}
+	}
+
+	ids := make([]uuid.UUID, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return idx.docs[ids[i]].art.PublishedAt > idx.docs[ids[j]].art.PublishedAt
+	})
+	total = len(ids)
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	results = make([]api.ArticleResult, 0, len(ids))
+	for _, id := range ids {
+		art := idx.docs[id].art
+		results = append(results, api.ArticleResult{
+			ID:          art.Id.String(),
+			Slug:        art.Slug,
+			Title:       art.Title,
+			Subtitle:    art.Subtitle,
+			Tags:        art.Tags,
+			Status:      string(art.CurrentStatus(now)),
+			PublishedAt: art.PublishedAt,
+			Score:       math.Round(scores[id]*1000) / 1000,
+			SharedTags:  shared[id],
+		})
+	}
+	return results, total, true
+}
+
+// vector returns the tf-idf weights of the terms of an indexed article,
+// leaving out the tags which are compared as a set. Hits are weighed by the
+// field boosts, so the title counts for more than a word in the content.
+func (idx *Index) vector(id uuid.UUID) map[string]float64 {
+	vec := map[string]float64{}
+	for _, term := range idx.docs[id].terms {
+		docs := idx.postings[term]
+		tf := 0.0
+		for f, n := range docs[id] {
+			if field(f) != fieldTags {
+				tf += boosts[f] * float64(n)
+			}
+		}
+		if tf == 0 {
+			continue
+		}
+		idf := math.Log(1 + float64(len(idx.docs))/float64(len(docs)))
+		vec[term] = (1 + math.Log(tf)) * idf
+	}
+	return vec
+}
+
+func vectorNorm(vec map[string]float64) float64 {
+	sum := 0.0
+	for _, w := range vec {
+		sum += w * w
+	}
+	return math.Sqrt(sum)
+}
+
+// sharedTags returns the tags of a that b carries as well, in the order of a.
+func sharedTags(a, b []string) []string {
+	shared := []string{}
+	for _, tag := range a {
+		if slices.Contains(b, tag) && !slices.Contains(shared, tag) {
+			shared = append(shared, tag)
+		}
+	}
+	return shared
+}
@@ -0,0 +1,223 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/search/api"
+)
+
+const (
+	defaultLimit        = 20
+	defaultRelatedLimit = 5
+)
+
+type SearchService struct {
+	l     *jst_log.Logger
+	nc    *nats.Conn
+	repo  articles.ArticleRepo
+	index *Index
+	ctx   context.Context
+}
+
+type Conf struct {
+	NatsConn    *nats.Conn
+	Logger      *jst_log.Logger
+	ArticleRepo articles.ArticleRepo
+}
+
+// New creates a new SearchService instance with the provided configuration.
+func New(ctx context.Context, c *Conf) (*SearchService, error) {
+	if c.ArticleRepo == nil {
+		return nil, fmt.Errorf("article repo is required")
+	}
+	return &SearchService{
+		l:     c.Logger,
+		nc:    c.NatsConn,
+		repo:  c.ArticleRepo,
+		index: NewIndex(),
+		ctx:   ctx,
+	}, nil
+}
+
+// Start keeps the index current from the article bucket and registers the
+// search endpoints.
+func (s *SearchService) Start(ctx context.Context) error {
+	if s.nc.Status() != nats.CONNECTED {
+		return fmt.Errorf("nats connection not connected: %s", s.nc.Status())
+	}
+
+	if err := s.articleWatcher(); err != nil {
+		return fmt.Errorf("failed to start article watcher: %w", err)
+	}
+
+	svcMetadata := map[string]string{}
+	svcMetadata["location"] = "unknown"
+	svcMetadata["environment"] = "development"
+	searchSvc, err := micro.AddService(s.nc, micro.Config{
+		Name:        "search",
+		Version:     "1.0.0",
+		Description: "full-text search over articles",
+		Metadata:    svcMetadata,
+	})
+	if err != nil {
+		return fmt.Errorf("add service: %w", err)
+	}
+
+	// ----------- Articles -----------
+	articleSvcGroup := searchSvc.AddGroup(api.Subj.ArticleGroup, micro.WithGroupQueueGroup(api.Subj.ArticleGroup))
+	if err = articleSvcGroup.AddEndpoint("article_query", s.handleArticleQuery(), micro.WithEndpointSubject(api.Subj.ArticleQuery)); err != nil {
+		return fmt.Errorf("add search endpoint (article_query): %w", err)
+	}
+	if err = articleSvcGroup.AddEndpoint("article_related", s.handleArticleRelated(), micro.WithEndpointSubject(api.Subj.ArticleRelated)); err != nil {
+		return fmt.Errorf("add search endpoint (article_related): %w", err)
+	}
+
+	return nil
+}
+
+// ----------- WATCHERS -----------
+
+func (s *SearchService) articleWatcher() error {
+	watcher, err := s.repo.WatchAll()
+	if err != nil {
+		return fmt.Errorf("failed to watch articles: %w", err)
+	}
+
+	go func() {
+		defer func() {
+			if err := watcher.Stop(); err != nil {
+				s.l.Warn("stop watcher: %v", err)
+			}
+		}()
+		for {
+			select {
+			case entry, ok := <-watcher.Updates():
+				if !ok {
+					s.l.Warn("watcher: channel closed")
+					return
+				}
+				if entry == nil {
+					s.l.Info("up to date. %d articles indexed", s.index.Len())
+					continue
+				}
+				id, err := uuid.Parse(entry.Key())
+				if err != nil {
+					s.l.Error("invalid article key %q: %s", entry.Key(), err.Error())
+					continue
+				}
+				switch entry.Operation() {
+				case jetstream.KeyValuePut:
+					art, err := articles.DecodeEntry(entry)
+					if err != nil {
+						s.l.Error("failed to decode article: %s", err.Error())
+						continue
+					}
+					art.Id = id
+					art.Rev = entry.Revision()
+					s.index.Put(art)
+				case jetstream.KeyValueDelete, jetstream.KeyValuePurge:
+					s.index.Remove(id)
+				default:
+					s.l.Error("unknown operation: %s", entry.Operation())
+				}
+			case <-s.ctx.Done():
+				s.l.Debug("watcher: context done")
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// ----------- HANDLERS -----------
+
+func (s *SearchService) handleArticleQuery() micro.HandlerFunc {
+	l := s.l.WithBreadcrumb("article_query")
+	return func(req micro.Request) {
+		var (
+			err     error
+			reqData api.ArticleQueryRequest
+		)
+
+		l.Debug("got request")
+		err = json.Unmarshal(req.Data(), &reqData)
+		if err != nil {
+			l.Warn("failed to unmarshal article query request: %s", err.Error())
+			if err := req.Error("INVALID_REQUEST", "invalid request", []byte(err.Error())); err != nil {
+				l.Error("failed to respond to article query request: %v", err)
+			}
+			return
+		}
+		if reqData.Query == "" {
+			l.Warn("query is empty")
+			if err := req.Error("INVALID_REQUEST", "query is empty", []byte("query is empty")); err != nil {
+				l.Error("failed to respond to article query request: %v", err)
+			}
+			return
+		}
+		if reqData.Limit <= 0 {
+			reqData.Limit = defaultLimit
+		}
+
+		results, total := s.index.Search(reqData.Query, reqData.Limit, reqData.IncludeHidden)
+		if err := req.RespondJSON(api.ArticleQueryResponse{
+			Query:   reqData.Query,
+			Total:   total,
+			Results: results,
+		}); err != nil {
+			l.Error("failed to respond to article query request: %v", err)
+		}
+	}
+}
+
+func (s *SearchService) handleArticleRelated() micro.HandlerFunc {
+	l := s.l.WithBreadcrumb("article_related")
+	return func(req micro.Request) {
+		var reqData api.ArticleRelatedRequest
+
+		l.Debug("got request")
+		if err := json.Unmarshal(req.Data(), &reqData); err != nil {
+			l.Warn("failed to unmarshal article related request: %s", err.Error())
+			if err := req.Error("INVALID_REQUEST", "invalid request", []byte(err.Error())); err != nil {
+				l.Error("failed to respond to article related request: %v", err)
+			}
+			return
+		}
+		id, err := uuid.Parse(reqData.ID)
+		if err != nil {
+			l.Warn("invalid article id %q", reqData.ID)
+			if err := req.Error("INVALID_REQUEST", "invalid article id", []byte(err.Error())); err != nil {
+				l.Error("failed to respond to article related request: %v", err)
+			}
+			return
+		}
+		if reqData.Limit <= 0 {
+			reqData.Limit = defaultRelatedLimit
+		}
+
+		results, total, ok := s.index.Related(id, reqData.Limit, reqData.IncludeHidden)
+		if !ok {
+			if err := req.Error("NOT_FOUND", "article not indexed", []byte("article not indexed")); err != nil {
+				l.Error("failed to respond to article related request: %v", err)
+			}
+			return
+		}
+		if err := req.RespondJSON(api.ArticleRelatedResponse{
+			ID:      reqData.ID,
+			Total:   total,
+			Results: results,
+		}); err != nil {
+			l.Error("failed to respond to article related request: %v", err)
+		}
+	}
+}
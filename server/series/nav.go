@@ -0,0 +1,76 @@
+package series
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/articles"
+)
+
+// Link points at an article of a series.
+type Link struct {
+	ID    uuid.UUID `json:"id"`
+	Slug  string    `json:"slug"`
+	Title string    `json:"title"`
+}
+
+// Navigation places an article in one of its series. Position and Total only
+// count the parts the reader may see, Prev and Next skip the others and are
+// nil at either end.
+type Navigation struct {
+	ID       uuid.UUID `json:"id"`
+	Title    string    `json:"title"`
+	Position int       `json:"position"` // 1-based
+	Total    int       `json:"total"`
+	Prev     *Link     `json:"prev"`
+	Next     *Link     `json:"next"`
+}
+
+// Parts returns the articles of ser that visible accepts, in series order.
+// Deleted articles are left out.
+func (s *Store) Parts(ser Series, visible func(articles.Article) bool) ([]Link, error) {
+	parts := make([]Link, 0, len(ser.Articles))
+	for _, id := range ser.Articles {
+		art, err := s.repo.Get(id)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !visible(art) {
+			continue
+		}
+		parts = append(parts, Link{ID: art.Id, Slug: art.Slug, Title: art.Title})
+	}
+	return parts, nil
+}
+
+// Navigation returns where the article sits in each series it is part of.
+// Series in which the article itself is not visible are left out.
+func (s *Store) Navigation(articleID uuid.UUID, visible func(articles.Article) bool) ([]Navigation, error) {
+	navs := []Navigation{}
+	for _, ser := range s.Of(articleID) {
+		parts, err := s.Parts(ser, visible)
+		if err != nil {
+			return nil, err
+		}
+		for i, part := range parts {
+			if part.ID != articleID {
+				continue
+			}
+			nav := Navigation{ID: ser.ID, Title: ser.Title, Position: i + 1, Total: len(parts)}
+			if i > 0 {
+				nav.Prev = &parts[i-1]
+			}
+			if i < len(parts)-1 {
+				nav.Next = &parts[i+1]
+			}
+			navs = append(navs, nav)
+			break
+		}
+	}
+	return navs, nil
+}
@@ -0,0 +1,328 @@
+package series
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+)
+
+// A series is an ordered list of article ids under a title. Series live in
+// their own KV bucket keyed by id. Every instance keeps all of them in memory,
+// current from a watcher, so looking up the series of an article on each
+// article request is cheap. Articles know nothing about the series they are
+// in; deleting an article only hides it from the series until it is
+// undeleted or removed from the list.
+
+const (
+	bucket         = "series"
+	maxTitle       = 200  // runes
+	maxDescription = 2000 // runes
+	maxArticles    = 200
+)
+
+var (
+	ErrNotFound = errors.New("series not found")
+	ErrInvalid  = errors.New("invalid series")
+	// ErrConflict is returned by Update and Delete when the series changed
+	// since the revision the caller based its changes on.
+	ErrConflict = errors.New("series revision conflict")
+)
+
+// Series is an ordered collection of articles, read in the order of Articles.
+type Series struct {
+	ID          uuid.UUID   `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Articles    []uuid.UUID `json:"articles"`
+	Revision    uint64      `json:"revision,omitempty"`
+	UpdatedAt   int         `json:"updated_at,omitempty"` // unix ms, from the kv entry
+	UpdatedBy   string      `json:"updated_by,omitempty"` // user id
+}
+
+// Contains reports whether the article is part of the series.
+func (s Series) Contains(id uuid.UUID) bool {
+	return slices.Contains(s.Articles, id)
+}
+
+// Store keeps series in a KV bucket and an in-memory copy of all of them.
+type Store struct {
+	ctx  context.Context
+	kv   jetstream.KeyValue
+	repo articles.ArticleRepo
+	l    *jst_log.Logger
+
+	lock   sync.RWMutex
+	series map[uuid.UUID]Series
+}
+
+// NewStore sets up the series bucket and starts keeping the in-memory copy
+// current until ctx is done. It returns once the copy is up to date.
+func NewStore(ctx context.Context, nc *nats.Conn, repo articles.ArticleRepo, l *jst_log.Logger) (*Store, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("jetstream new: %w", err)
+	}
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "article series",
+		History:     10,
+		MaxBytes:    10 * 1024 * 1024,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create series bucket: %w", err)
+	}
+	s := &Store{
+		ctx:    ctx,
+		kv:     kv,
+		repo:   repo,
+		l:      l,
+		series: map[uuid.UUID]Series{},
+	}
+	if err := s.watch(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// All returns every series, sorted by title.
+func (s *Store) All() []Series {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	all := make([]Series, 0, len(s.series))
+	for _, ser := range s.series {
+		all = append(all, ser)
+	}
+	slices.SortFunc(all, func(a, b Series) int {
+		if c := strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	return all
+}
+
+// Get returns the series with the given id.
+func (s *Store) Get(id uuid.UUID) (Series, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	ser, ok := s.series[id]
+	if !ok {
+		return Series{}, fmt.Errorf("get series %s: %w", id, ErrNotFound)
+	}
+	return ser, nil
+}
+
+// Of returns the series the article is part of, sorted by title.
+func (s *Store) Of(articleID uuid.UUID) []Series {
+	of := []Series{}
+	for _, ser := range s.All() {
+		if ser.Contains(articleID) {
+			of = append(of, ser)
+		}
+	}
+	return of
+}
+
+// Create stores a new series under a fresh id.
+func (s *Store) Create(ser Series) (Series, error) {
+	ser.ID = uuid.New()
+	if err := s.validate(&ser); err != nil {
+		return ser, err
+	}
+	data, err := json.Marshal(ser)
+	if err != nil {
+		return ser, fmt.Errorf("marshal series: %w", err)
+	}
+	rev, err := s.kv.Create(s.ctx, ser.ID.String(), data)
+	if err != nil {
+		return ser, fmt.Errorf("create series: %w", err)
+	}
+	ser.Revision = rev
+	ser.UpdatedAt = int(time.Now().UnixMilli())
+	s.put(ser)
+	return ser, nil
+}
+
+// Update replaces the series on top of ser.Revision (compare-and-swap). A
+// zero revision replaces whatever is current.
+func (s *Store) Update(ser Series) (Series, error) {
+	current, err := s.Get(ser.ID)
+	if err != nil {
+		return ser, err
+	}
+	if ser.Revision == 0 {
+		ser.Revision = current.Revision
+	}
+	if err := s.validate(&ser); err != nil {
+		return ser, err
+	}
+	expected := ser.Revision
+	ser.Revision, ser.UpdatedAt = 0, 0
+	data, err := json.Marshal(ser)
+	if err != nil {
+		return ser, fmt.Errorf("marshal series: %w", err)
+	}
+	rev, err := s.kv.Update(s.ctx, ser.ID.String(), data, expected)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return ser, fmt.Errorf("update series %s: expected revision %d: %w", ser.ID, expected, ErrConflict)
+	}
+	if err != nil {
+		return ser, fmt.Errorf("update series: %w", err)
+	}
+	ser.Revision = rev
+	ser.UpdatedAt = int(time.Now().UnixMilli())
+	s.put(ser)
+	return ser, nil
+}
+
+// Delete removes the series if it is still at revision, or whatever is
+// current if revision is 0. The articles are left alone.
+func (s *Store) Delete(id uuid.UUID, revision uint64) error {
+	current, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	if revision == 0 {
+		revision = current.Revision
+	}
+	err = s.kv.Delete(s.ctx, id.String(), jetstream.LastRevision(revision))
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return fmt.Errorf("delete series %s: expected revision %d: %w", id, revision, ErrConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("delete series: %w", err)
+	}
+	s.remove(id, 0)
+	return nil
+}
+
+// validate normalizes ser and checks that every listed article exists.
+func (s *Store) validate(ser *Series) error {
+	ser.Title = strings.TrimSpace(ser.Title)
+	ser.Description = strings.TrimSpace(ser.Description)
+	switch {
+	case ser.Title == "":
+		return fmt.Errorf("%w: title is required", ErrInvalid)
+	case utf8.RuneCountInString(ser.Title) > maxTitle:
+		return fmt.Errorf("%w: title longer than %d characters", ErrInvalid, maxTitle)
+	case utf8.RuneCountInString(ser.Description) > maxDescription:
+		return fmt.Errorf("%w: description longer than %d characters", ErrInvalid, maxDescription)
+	case len(ser.Articles) > maxArticles:
+		return fmt.Errorf("%w: more than %d articles", ErrInvalid, maxArticles)
+	}
+	if ser.Articles == nil {
+		ser.Articles = []uuid.UUID{}
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, id := range ser.Articles {
+		if seen[id] {
+			return fmt.Errorf("%w: article %s is listed twice", ErrInvalid, id)
+		}
+		seen[id] = true
+		if _, err := s.repo.Get(id); err != nil {
+			if errors.Is(err, jetstream.ErrKeyNotFound) {
+				return fmt.Errorf("%w: article %s does not exist", ErrInvalid, id)
+			}
+			return fmt.Errorf("get article %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// put stores ser in memory unless a newer revision is already there.
+func (s *Store) put(ser Series) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if old, ok := s.series[ser.ID]; ok && old.Revision > ser.Revision {
+		return
+	}
+	s.series[ser.ID] = ser
+}
+
+// remove drops the series from memory unless it was written after rev. A
+// zero rev always removes it.
+func (s *Store) remove(id uuid.UUID, rev uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if old, ok := s.series[id]; ok && rev != 0 && old.Revision > rev {
+		return
+	}
+	delete(s.series, id)
+}
+
+// watch keeps the in-memory copy current and returns once it caught up.
+func (s *Store) watch() error {
+	watcher, err := s.kv.WatchAll(s.ctx)
+	if err != nil {
+		return fmt.Errorf("watch series: %w", err)
+	}
+	ready := make(chan struct{})
+	go func() {
+		initial := ready
+		defer func() {
+			if err := watcher.Stop(); err != nil {
+				s.l.Warn("stop watcher: %v", err)
+			}
+		}()
+		for {
+			select {
+			case entry, ok := <-watcher.Updates():
+				if !ok {
+					s.l.Warn("watcher: channel closed")
+					return
+				}
+				if entry == nil {
+					if initial != nil {
+						s.l.Info("up to date. %d series", len(s.All()))
+						close(initial)
+						initial = nil
+					}
+					continue
+				}
+				id, err := uuid.Parse(entry.Key())
+				if err != nil {
+					s.l.Error("invalid series key %q: %s", entry.Key(), err.Error())
+					continue
+				}
+				switch entry.Operation() {
+				case jetstream.KeyValuePut:
+					var ser Series
+					if err := json.Unmarshal(entry.Value(), &ser); err != nil {
+						s.l.Error("failed to decode series %s: %s", id, err.Error())
+						continue
+					}
+					ser.ID = id
+					ser.Revision = entry.Revision()
+					ser.UpdatedAt = int(entry.Created().UnixMilli())
+					s.put(ser)
+				case jetstream.KeyValueDelete, jetstream.KeyValuePurge:
+					s.remove(id, entry.Revision())
+				}
+			case <-s.ctx.Done():
+				s.l.Debug("watcher: context done")
+				return
+			}
+		}
+	}()
+	select {
+	case <-ready:
+		return nil
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("watch series: not up to date after 10s")
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
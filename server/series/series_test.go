@@ -0,0 +1,180 @@
+package series
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+)
+
+func testStore(t testing.TB) (*Store, articles.ArticleRepo, *nats.Conn) {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{
+		ServerName: "test-series",
+		NoLog:      true,
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL(), nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		nc.Close()
+		ns.Shutdown()
+	})
+	l := jst_log.NewLogger("test", jst_log.DefaultSubjects())
+	repo, err := articles.Repo(ctx, nc, l)
+	if err != nil {
+		t.Fatalf("repo: %v", err)
+	}
+	store, err := NewStore(ctx, nc, repo, l)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	return store, repo, nc
+}
+
+func testParts(t testing.TB, repo articles.ArticleRepo, statuses ...articles.Status) []articles.Article {
+	t.Helper()
+	parts := []articles.Article{}
+	for i, status := range statuses {
+		art := articles.TestArticle()
+		art.Id = uuid.New()
+		art.Slug = "part-" + string(rune('a'+i))
+		art.Title = "Part " + string(rune('A'+i))
+		art.Status = status
+		if status == articles.StatusPublished {
+			art.PublishedAt = int(time.Now().Add(-time.Hour).UnixMilli())
+		}
+		art, err := repo.Create(art)
+		if err != nil {
+			t.Fatalf("create article: %v", err)
+		}
+		parts = append(parts, art)
+	}
+	return parts
+}
+
+func TestStore(t *testing.T) {
+	store, repo, nc := testStore(t)
+	parts := testParts(t, repo, articles.StatusPublished, articles.StatusPublished)
+
+	for _, invalid := range []Series{
+		{Title: "  "},
+		{Title: "twice", Articles: []uuid.UUID{parts[0].Id, parts[0].Id}},
+		{Title: "unknown", Articles: []uuid.UUID{uuid.New()}},
+	} {
+		if _, err := store.Create(invalid); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected %+v to be invalid, got %v", invalid, err)
+		}
+	}
+
+	ser, err := store.Create(Series{Title: " Building a blog ", Articles: []uuid.UUID{parts[0].Id}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if ser.Title != "Building a blog" || ser.Revision == 0 {
+		t.Errorf("unexpected series %+v", ser)
+	}
+	if got := store.Of(parts[0].Id); len(got) != 1 || got[0].ID != ser.ID {
+		t.Errorf("expected part A to be in the series, got %+v", got)
+	}
+	if got := store.Of(parts[1].Id); len(got) != 0 {
+		t.Errorf("expected part B in no series, got %+v", got)
+	}
+
+	stale := ser
+	ser.Articles = append(ser.Articles, parts[1].Id)
+	if ser, err = store.Update(ser); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	stale.Title = "stale"
+	if _, err = store.Update(stale); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	if err = store.Delete(ser.ID, stale.Revision); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected a conflict deleting a stale revision, got %v", err)
+	}
+
+	// another instance loads what is in the bucket
+	other, err := NewStore(context.Background(), nc, repo, store.l)
+	if err != nil {
+		t.Fatalf("second store: %v", err)
+	}
+	if got, err := other.Get(ser.ID); err != nil || len(got.Articles) != 2 || got.Revision != ser.Revision {
+		t.Errorf("expected the second store to load the series, got %+v (%v)", got, err)
+	}
+
+	if err = store.Delete(ser.ID, ser.Revision); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err = store.Get(ser.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the series to be gone, got %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(other.All()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(other.All()); n != 0 {
+		t.Errorf("expected the delete to reach the second store, %d series left", n)
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	store, repo, _ := testStore(t)
+	parts := testParts(t, repo, articles.StatusPublished, articles.StatusDraft, articles.StatusPublished, articles.StatusPublished)
+	ids := []uuid.UUID{}
+	for _, part := range parts {
+		ids = append(ids, part.Id)
+	}
+	ser, err := store.Create(Series{Title: "Parts", Articles: ids})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err = repo.Delete(parts[3].Id); err != nil {
+		t.Fatalf("delete article: %v", err)
+	}
+	public := func(art articles.Article) bool { return art.IsPublic(time.Now()) }
+	everything := func(articles.Article) bool { return true }
+
+	// the draft is skipped for readers, the deleted part for everyone
+	nav, err := store.Navigation(parts[0].Id, public)
+	if err != nil {
+		t.Fatalf("navigation: %v", err)
+	}
+	if len(nav) != 1 || nav[0].ID != ser.ID || nav[0].Position != 1 || nav[0].Total != 2 ||
+		nav[0].Prev != nil || nav[0].Next == nil || nav[0].Next.Slug != "part-c" {
+		t.Errorf("unexpected navigation for readers %+v", nav)
+	}
+	nav, _ = store.Navigation(parts[2].Id, everything)
+	if len(nav) != 1 || nav[0].Position != 3 || nav[0].Total != 3 ||
+		nav[0].Prev == nil || nav[0].Prev.Slug != "part-b" || nav[0].Next != nil {
+		t.Errorf("unexpected navigation for editors %+v", nav)
+	}
+
+	// no navigation where the article itself is hidden or in no series
+	if nav, _ = store.Navigation(parts[1].Id, public); len(nav) != 0 {
+		t.Errorf("expected no navigation for a hidden part, got %+v", nav)
+	}
+	if nav, _ = store.Navigation(uuid.New(), everything); len(nav) != 0 {
+		t.Errorf("expected no navigation outside a series, got %+v", nav)
+	}
+}
@@ -0,0 +1,354 @@
+package views
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/jst_log"
+)
+
+// Reading an article publishes a view event to the VIEWS stream. Events carry
+// no address: the visitor is a hash of address and user agent salted with a
+// random salt of the day. The salt is shared by every instance through a KV
+// bucket and expires a day after its day is over, after which nobody can tell
+// which visitor a hash stood for or link the visitors of two days. A durable
+// consumer (see Aggregate) folds the events into per-article per-day counts
+// in the views bucket, where admins read them and can follow them with
+// kv_sub.
+
+const (
+	Stream        = "VIEWS"
+	SubjectPrefix = "views.article." // + article id
+	Bucket        = "views"          // counts, keyed {article id}.{day}
+
+	bucketSeen  = "views_seen"  // visitors already counted, keyed {day}.{article id}.{visitor}
+	bucketSalts = "views_salts" // salt of the day, keyed {day}
+	consumer    = "views_aggregator"
+	dayLayout   = "2006-01-02" // UTC
+	retention   = 48 * time.Hour
+)
+
+var ErrInvalid = errors.New("invalid view")
+
+// View is one read of an article.
+type View struct {
+	ArticleID uuid.UUID `json:"article_id"`
+	Visitor   string    `json:"visitor"` // salted hash, unique per day
+	At        int       `json:"at"`      // unix ms
+}
+
+// Day returns the UTC day the view happened on.
+func (v View) Day() string {
+	return time.UnixMilli(int64(v.At)).UTC().Format(dayLayout)
+}
+
+// DayCount is what an article got on one day.
+type DayCount struct {
+	ArticleID uuid.UUID `json:"article_id"`
+	Day       string    `json:"day"`      // YYYY-MM-DD, UTC
+	Views     int       `json:"views"`    // every read
+	Visitors  int       `json:"visitors"` // distinct readers
+	Revision  uint64    `json:"revision,omitempty"`
+}
+
+// Store records views and keeps their counts.
+type Store struct {
+	ctx    context.Context
+	js     jetstream.JetStream
+	nc     *nats.Conn
+	counts jetstream.KeyValue
+	seen   jetstream.KeyValue
+	salts  jetstream.KeyValue
+	l      *jst_log.Logger
+	now    func() time.Time
+
+	lock    sync.Mutex
+	saltDay string
+	salt    []byte
+}
+
+// NewStore sets up the stream and the buckets.
+func NewStore(ctx context.Context, nc *nats.Conn, l *jst_log.Logger) (*Store, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("jetstream new: %w", err)
+	}
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        Stream,
+		Description: "article views waiting to be counted",
+		Subjects:    []string{SubjectPrefix + "*"},
+		Retention:   jetstream.WorkQueuePolicy,
+		MaxAge:      7 * 24 * time.Hour,
+		Storage:     jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create views stream: %w", err)
+	}
+	counts, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      Bucket,
+		Description: "article views per day",
+		History:     1,
+		Storage:     jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create views bucket: %w", err)
+	}
+	seen, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucketSeen,
+		Description: "visitors counted today",
+		History:     1,
+		TTL:         retention,
+		Storage:     jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create seen bucket: %w", err)
+	}
+	salts, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucketSalts,
+		Description: "salt of the day for visitor hashes",
+		History:     1,
+		TTL:         retention,
+		Storage:     jetstream.MemoryStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create salt bucket: %w", err)
+	}
+	return &Store{
+		ctx:    ctx,
+		js:     js,
+		nc:     nc,
+		counts: counts,
+		seen:   seen,
+		salts:  salts,
+		l:      l,
+		now:    time.Now,
+	}, nil
+}
+
+// --- RECORDING ---
+
+// Record publishes a view of the article by the visitor at address with
+// userAgent. Neither is published, only their hash.
+func (s *Store) Record(articleID uuid.UUID, address, userAgent string) error {
+	now := s.now()
+	visitor, err := s.visitor(now, address, userAgent)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(View{ArticleID: articleID, Visitor: visitor, At: int(now.UnixMilli())})
+	if err != nil {
+		return fmt.Errorf("marshal view: %w", err)
+	}
+	// a plain publish, readers do not wait for the stream to acknowledge
+	if err := s.nc.Publish(SubjectPrefix+articleID.String(), data); err != nil {
+		return fmt.Errorf("publish view: %w", err)
+	}
+	return nil
+}
+
+// visitor hashes address and userAgent with the salt of the day.
+func (s *Store) visitor(now time.Time, address, userAgent string) (string, error) {
+	salt, err := s.saltOf(now.UTC().Format(dayLayout))
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(address))
+	h.Write([]byte{0})
+	h.Write([]byte(userAgent))
+	return hex.EncodeToString(h.Sum(nil))[:32], nil
+}
+
+// saltOf returns the salt of day, making it if this is the first instance
+// to need it.
+func (s *Store) saltOf(day string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.saltDay == day {
+		return s.salt, nil
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("make salt: %w", err)
+	}
+	_, err := s.salts.Create(s.ctx, day, salt)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		entry, getErr := s.salts.Get(s.ctx, day)
+		if getErr != nil {
+			return nil, fmt.Errorf("get salt: %w", getErr)
+		}
+		salt, err = entry.Value(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create salt: %w", err)
+	}
+	s.saltDay, s.salt = day, salt
+	return salt, nil
+}
+
+// --- COUNTING ---
+
+// Aggregate starts counting the views in the stream until ctx is done. Every
+// instance shares the one durable consumer, so each view is counted by one of
+// them. Counting is at least once: a view whose acknowledgement got lost is
+// counted again.
+func (s *Store) Aggregate(ctx context.Context) error {
+	cons, err := s.js.CreateOrUpdateConsumer(ctx, Stream, jetstream.ConsumerConfig{
+		Durable:     consumer,
+		Description: "counts article views",
+		AckPolicy:   jetstream.AckExplicitPolicy,
+		MaxDeliver:  5,
+	})
+	if err != nil {
+		return fmt.Errorf("create views consumer: %w", err)
+	}
+	consumed, err := cons.Consume(func(msg jetstream.Msg) {
+		var view View
+		if err := json.Unmarshal(msg.Data(), &view); err != nil || view.ArticleID == uuid.Nil {
+			s.l.Warn("dropping malformed view: %s", msg.Data())
+			_ = msg.Term()
+			return
+		}
+		if err := s.count(view); err != nil {
+			s.l.Error("count view of %s: %v", view.ArticleID, err)
+			_ = msg.Nak()
+			return
+		}
+		_ = msg.Ack()
+	})
+	if err != nil {
+		return fmt.Errorf("consume views: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		consumed.Stop()
+	}()
+	return nil
+}
+
+// count adds the view to the count of its article and day.
+func (s *Store) count(view View) error {
+	if !validVisitor(view.Visitor) {
+		return fmt.Errorf("%w: visitor %q", ErrInvalid, view.Visitor)
+	}
+	day := view.Day()
+	_, err := s.seen.Create(s.ctx, day+"."+view.ArticleID.String()+"."+view.Visitor, nil)
+	unique := err == nil
+	if err != nil && !errors.Is(err, jetstream.ErrKeyExists) {
+		return fmt.Errorf("check visitor: %w", err)
+	}
+
+	key := view.ArticleID.String() + "." + day
+	for {
+		current := DayCount{ArticleID: view.ArticleID, Day: day}
+		entry, err := s.counts.Get(s.ctx, key)
+		if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+			return fmt.Errorf("get count: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(entry.Value(), &current); err != nil {
+				return fmt.Errorf("decode count: %w", err)
+			}
+			current.Revision = entry.Revision()
+		}
+		current.Views++
+		if unique {
+			current.Visitors++
+		}
+		rev := current.Revision
+		current.Revision = 0
+		data, err := json.Marshal(current)
+		if err != nil {
+			return fmt.Errorf("marshal count: %w", err)
+		}
+		if rev == 0 {
+			_, err = s.counts.Create(s.ctx, key, data)
+		} else {
+			_, err = s.counts.Update(s.ctx, key, data, rev)
+		}
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			continue // another instance counted in between
+		}
+		if err != nil {
+			return fmt.Errorf("write count: %w", err)
+		}
+		return nil
+	}
+}
+
+// --- STATS ---
+
+// Stats are the counts of an article over a range of days.
+type Stats struct {
+	ArticleID uuid.UUID  `json:"article_id"`
+	From      string     `json:"from"` // first day, YYYY-MM-DD
+	To        string     `json:"to"`   // last day, inclusive
+	Views     int        `json:"views"`
+	Visitors  int        `json:"visitors"` // summed over days, a reader coming back tomorrow counts twice
+	Days      []DayCount `json:"days"`     // every day of the range, oldest first
+}
+
+// Stats returns the counts of the article from the day of from up to and
+// including the day of to.
+func (s *Store) Stats(articleID uuid.UUID, from, to time.Time) (Stats, error) {
+	first, last := from.UTC().Format(dayLayout), to.UTC().Format(dayLayout)
+	stats := Stats{ArticleID: articleID, From: first, To: last, Days: []DayCount{}}
+	if first > last {
+		return stats, fmt.Errorf("%w: from after to", ErrInvalid)
+	}
+	counts := map[string]DayCount{}
+	keys, err := s.counts.ListKeysFiltered(s.ctx, articleID.String()+".*")
+	if err != nil && !errors.Is(err, jetstream.ErrNoKeysFound) {
+		return stats, fmt.Errorf("list counts: %w", err)
+	}
+	if err == nil {
+		for key := range keys.Keys() {
+			_, day, _ := strings.Cut(key, ".")
+			if day < first || day > last {
+				continue
+			}
+			entry, err := s.counts.Get(s.ctx, key)
+			if err != nil {
+				return stats, fmt.Errorf("get count: %w", err)
+			}
+			var c DayCount
+			if err := json.Unmarshal(entry.Value(), &c); err != nil {
+				return stats, fmt.Errorf("decode count: %w", err)
+			}
+			c.Revision = entry.Revision()
+			counts[day] = c
+		}
+	}
+	for d := from.UTC(); d.Format(dayLayout) <= last; d = d.AddDate(0, 0, 1) {
+		day := d.Format(dayLayout)
+		c, ok := counts[day]
+		if !ok {
+			c = DayCount{ArticleID: articleID, Day: day}
+		}
+		stats.Views += c.Views
+		stats.Visitors += c.Visitors
+		stats.Days = append(stats.Days, c)
+	}
+	return stats, nil
+}
+
+func validVisitor(visitor string) bool {
+	if len(visitor) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(visitor)
+	return err == nil
+}
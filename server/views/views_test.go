@@ -0,0 +1,113 @@
+package views
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/jst_log"
+)
+
+func testStore(t testing.TB) *Store {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{
+		ServerName: "test-views",
+		NoLog:      true,
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL(), nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		nc.Close()
+		ns.Shutdown()
+	})
+	store, err := NewStore(ctx, nc, jst_log.NewLogger("test", jst_log.DefaultSubjects()))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	if err := store.Aggregate(ctx); err != nil {
+		t.Fatalf("aggregate: %v", err)
+	}
+	return store
+}
+
+func TestVisitor(t *testing.T) {
+	s := testStore(t)
+	today := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	a, _ := s.visitor(today, "203.0.113.7", "firefox")
+	again, _ := s.visitor(today.Add(time.Hour), "203.0.113.7", "firefox")
+	other, _ := s.visitor(today, "203.0.113.8", "firefox")
+	tomorrow, err := s.visitor(today.AddDate(0, 0, 1), "203.0.113.7", "firefox")
+	if err != nil {
+		t.Fatalf("visitor: %v", err)
+	}
+	if a != again || a == other || a == tomorrow || !validVisitor(a) {
+		t.Errorf("expected one hash per visitor and day, got %s %s %s %s", a, again, other, tomorrow)
+	}
+
+	// a second instance uses the salt the first one made
+	s.saltDay = ""
+	if b, _ := s.visitor(today, "203.0.113.7", "firefox"); b != a {
+		t.Errorf("expected the shared salt of the day, got %s and %s", a, b)
+	}
+}
+
+func TestCount(t *testing.T) {
+	s := testStore(t)
+	article := uuid.New()
+	day := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+
+	record := func(at time.Time, address string) {
+		t.Helper()
+		s.now = func() time.Time { return at }
+		if err := s.Record(article, address, "test agent"); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	record(day, "203.0.113.1")
+	record(day.Add(time.Hour), "203.0.113.1")
+	record(day.Add(2*time.Hour), "203.0.113.2")
+	record(day.AddDate(0, 0, 1), "203.0.113.1")
+
+	var stats Stats
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var err error
+		stats, err = s.Stats(article, day.AddDate(0, 0, -1), day.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatalf("stats: %v", err)
+		}
+		if stats.Views == 4 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if stats.Views != 4 || stats.Visitors != 3 || len(stats.Days) != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.Days[0].Views != 0 || stats.Days[1].Views != 3 || stats.Days[1].Visitors != 2 || stats.Days[2].Day != "2026-10-16" {
+		t.Errorf("unexpected days %+v", stats.Days)
+	}
+
+	if _, err := s.Stats(article, day, day.AddDate(0, 0, -1)); err == nil {
+		t.Errorf("expected a reversed range to be refused")
+	}
+}
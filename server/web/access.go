@@ -0,0 +1,182 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/who"
+	whoApi "jst_dev/server/who/api"
+)
+
+// accessResp is who owns and collaborates on an article.
+type accessResp struct {
+	ID            uuid.UUID               `json:"id"`
+	Revision      uint64                  `json:"revision"`
+	Owners        []string                `json:"owners"`
+	Collaborators []articles.Collaborator `json:"collaborators"`
+}
+
+func accessOf(art articles.Article) accessResp {
+	resp := accessResp{
+		ID:            art.Id,
+		Revision:      art.Rev,
+		Owners:        art.Owners,
+		Collaborators: art.Collaborators,
+	}
+	if resp.Owners == nil {
+		resp.Owners = []string{}
+	}
+	if resp.Collaborators == nil {
+		resp.Collaborators = []articles.Collaborator{}
+	}
+	return resp
+}
+
+// handleArticleAccess returns the owners and collaborators of an article to
+// those who may change it.
+func handleArticleAccess(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
+	logger := l.WithBreadcrumb("article").WithBreadcrumb("access")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		art, err := repo.Get(idUuid)
+		if err != nil || !canReadArticle(r, acl, art) {
+			http.NotFound(w, r)
+			return
+		}
+		if !canChangeArticle(r, acl, art) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("ETag", etag(art.Rev))
+		respJson(w, accessOf(art), http.StatusOK)
+	})
+}
+
+// handleArticleAccessUpdate replaces the owners and collaborators of an
+// article. Only owners and editors may share, every listed user must exist.
+// Honours If-Match like article updates do.
+func handleArticleAccessUpdate(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog, nc *nats.Conn) http.Handler {
+	type Req struct {
+		Owners        []string                `json:"owners"`
+		Collaborators []articles.Collaborator `json:"collaborators"`
+		Revision      uint64                  `json:"revision"`
+	}
+
+	logger := l.WithBreadcrumb("article").WithBreadcrumb("access_update")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		art, err := repo.Get(idUuid)
+		if err != nil || !canReadArticle(r, acl, art) {
+			http.NotFound(w, r)
+			return
+		}
+		if !canShareArticle(r, acl, art) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var req Req
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := articles.ValidateAccess(req.Owners, req.Collaborators); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		users := append([]string{}, req.Owners...)
+		for _, c := range req.Collaborators {
+			users = append(users, c.UserID)
+		}
+		for _, userID := range users {
+			exists, err := userExists(nc, userID)
+			if err != nil {
+				logger.Error("failed to look up user %s: %v", userID, err)
+				http.Error(w, "failed to look up users", http.StatusInternalServerError)
+				return
+			}
+			if !exists {
+				http.Error(w, fmt.Sprintf("unknown user %q", userID), http.StatusBadRequest)
+				return
+			}
+		}
+
+		expected := req.Revision
+		ifMatch, present, err := parseIfMatch(r.Header.Get("If-Match"))
+		if err != nil {
+			http.Error(w, "invalid If-Match header", http.StatusBadRequest)
+			return
+		}
+		if present {
+			expected = ifMatch
+		}
+
+		art, err = articles.SetAccess(repo, idUuid, expected, req.Owners, req.Collaborators)
+		if errors.Is(err, articles.ErrRevisionConflict) {
+			http.Error(w, "revision conflict", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to set access: %v", err)
+			http.Error(w, "failed to set access", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("user %s: set access of %s to %d owners, %d collaborators", user.ID, id, len(art.Owners), len(art.Collaborators))
+		w.Header().Set("ETag", etag(art.Rev))
+		respJson(w, accessOf(art), http.StatusOK)
+	})
+}
+
+// userExists asks the who service whether there is a user with the id.
+func userExists(nc *nats.Conn, id string) (bool, error) {
+	reqBytes, err := json.Marshal(whoApi.UserGetRequest{ID: id})
+	if err != nil {
+		return false, err
+	}
+	msg, err := nc.Request(whoApi.Subj.UserGroup+"."+whoApi.Subj.UserGet, reqBytes, 5*time.Second)
+	if err != nil {
+		return false, err
+	}
+	switch msg.Header.Get("Nats-Service-Error-Code") {
+	case "":
+		return true, nil
+	case "USER_NOT_FOUND":
+		return false, nil
+	default:
+		return false, fmt.Errorf("who: %s", msg.Header.Get("Nats-Service-Error"))
+	}
+}
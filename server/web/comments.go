@@ -0,0 +1,179 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	commentsApi "jst_dev/server/comments/api"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/who"
+	whoApi "jst_dev/server/who/api"
+)
+
+// handleCommentList lists the comments of an article as threads. Editors also
+// get pending and rejected comments, and the comments of articles that are
+// not public; everyone else gets a 404 for those.
+func handleCommentList(l *jst_log.Logger, nc *nats.Conn) http.Handler {
+	logger := l.WithBreadcrumb("comments").WithBreadcrumb("list")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+
+		var resp commentsApi.CommentListResponse
+		if !commentsRequest(logger, nc, w, commentsApi.Subj.CommentList, commentsApi.CommentListRequest{
+			ArticleID:     id,
+			IncludeHidden: canEditArticles(r),
+		}, &resp) {
+			return
+		}
+		respJson(w, resp, http.StatusOK)
+	})
+}
+
+// handleCommentCreate adds a comment to an article. Logged in users comment
+// under their username, anonymous readers must give a name. Comments wait
+// for moderation unless an editor wrote them.
+func handleCommentCreate(l *jst_log.Logger, nc *nats.Conn) http.Handler {
+	type Req struct {
+		ParentID string `json:"parent_id"`
+		Name     string `json:"name"`
+		Body     string `json:"body"`
+	}
+
+	logger := l.WithBreadcrumb("comments").WithBreadcrumb("create")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+
+		var req Req
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		create := commentsApi.CommentCreateRequest{
+			ArticleID: id,
+			ParentID:  req.ParentID,
+			Name:      req.Name,
+			Body:      req.Body,
+		}
+		if user, ok := r.Context().Value(who.UserKey).(whoApi.User); ok && user.ID != "" {
+			create.UserID = user.ID
+			create.AutoApprove = canEditArticles(r)
+		}
+
+		var resp commentsApi.Comment
+		if !commentsRequest(logger, nc, w, commentsApi.Subj.CommentCreate, create, &resp) {
+			return
+		}
+		if resp.Status == commentsApi.StatusPending {
+			respJson(w, resp, http.StatusAccepted)
+			return
+		}
+		respJson(w, resp, http.StatusCreated)
+	})
+}
+
+// handleCommentQueue lists pending comments of all articles, oldest first.
+func handleCommentQueue(l *jst_log.Logger, nc *nats.Conn) http.Handler {
+	logger := l.WithBreadcrumb("comments").WithBreadcrumb("queue")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called")
+		if !canEditArticles(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		limit := 0
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			var err error
+			if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+				http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var resp commentsApi.CommentQueueResponse
+		if !commentsRequest(logger, nc, w, commentsApi.Subj.CommentQueue, commentsApi.CommentQueueRequest{Limit: limit}, &resp) {
+			return
+		}
+		respJson(w, resp, http.StatusOK)
+	})
+}
+
+// handleCommentModerate approves, rejects or deletes a comment.
+func handleCommentModerate(l *jst_log.Logger, nc *nats.Conn, action commentsApi.Action) http.Handler {
+	logger := l.WithBreadcrumb("comments").WithBreadcrumb(string(action))
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, commentID := r.PathValue("id"), r.PathValue("comment")
+		logger.Debug("called with id: %s, comment: %s", id, commentID)
+		user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+		if !ok || !canEditArticles(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var resp commentsApi.Comment
+		if !commentsRequest(logger, nc, w, commentsApi.Subj.CommentModerate, commentsApi.CommentModerateRequest{
+			ArticleID: id,
+			ID:        commentID,
+			Action:    action,
+			By:        user.ID,
+		}, &resp) {
+			return
+		}
+		logger.Info("user %s: %s comment %s", user.ID, action, commentID)
+		if action == commentsApi.ActionDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		respJson(w, resp, http.StatusOK)
+	})
+}
+
+// commentsRequest sends req to a comments endpoint and decodes the reply
+// into resp. On failure the error response is written and false returned.
+func commentsRequest(logger *jst_log.Logger, nc *nats.Conn, w http.ResponseWriter, endpoint string, req any, resp any) bool {
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		logger.Error("failed to marshal request: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return false
+	}
+	msg, err := nc.Request(commentsApi.Subj.CommentGroup+"."+endpoint, reqBytes, 5*time.Second)
+	if err != nil {
+		logger.Error("failed to reach comments service: %v", err)
+		http.Error(w, "comments unavailable", http.StatusInternalServerError)
+		return false
+	}
+	if msg.Header.Get("Nats-Service-Error") != "" {
+		errorMsg := string(msg.Data)
+		switch msg.Header.Get("Nats-Service-Error-Code") {
+		case "INVALID_REQUEST":
+			http.Error(w, errorMsg, http.StatusBadRequest)
+		case "NOT_FOUND":
+			http.Error(w, errorMsg, http.StatusNotFound)
+		case "CONFLICT":
+			http.Error(w, errorMsg, http.StatusConflict)
+		default:
+			http.Error(w, "service error", http.StatusInternalServerError)
+		}
+		return false
+	}
+	if err := json.Unmarshal(msg.Data, resp); err != nil {
+		logger.Error("failed to unmarshal response: %v", err)
+		http.Error(w, "failed to parse response", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package web
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/feed"
+	"jst_dev/server/jst_log"
+)
+
+// handleFeed serves the site wide feeds: /feed/rss.xml, /feed/atom.atom and
+// /feed/feed.json. Any name works, the extension picks the format. Links are
+// absolute against site, the public url of the site.
+func handleFeed(l *jst_log.Logger, repo articles.ArticleRepo, site string) http.Handler {
+	logger := l.WithBreadcrumb("feed")
+	logger.Debug("ready")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format, ok := feed.FormatFromExt(path.Ext(r.PathValue("file")))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		serveFeed(logger, w, r, repo, site, format, "")
+	})
+}
+
+// handleFeedTag serves per-tag feeds, e.g. /feed/tag/go.xml.
+func handleFeedTag(l *jst_log.Logger, repo articles.ArticleRepo, site string) http.Handler {
+	logger := l.WithBreadcrumb("feed").WithBreadcrumb("tag")
+	logger.Debug("ready")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file := r.PathValue("file")
+		ext := path.Ext(file)
+		format, ok := feed.FormatFromExt(ext)
+		tag := strings.TrimSuffix(file, ext)
+		if !ok || tag == "" {
+			http.NotFound(w, r)
+			return
+		}
+		serveFeed(logger, w, r, repo, site, format, tag)
+	})
+}
+
+func serveFeed(logger *jst_log.Logger, w http.ResponseWriter, r *http.Request, repo articles.ArticleRepo, site string, format feed.Format, tag string) {
+	all, err := repo.AllNoContent()
+	if err != nil {
+		logger.Error("failed to get articles: %s", err.Error())
+		http.Error(w, "failed to get articles", http.StatusInternalServerError)
+		return
+	}
+	arts := feed.Select(all, tag, time.Now(), feed.DefaultLimit)
+
+	lastModified, etag := feed.Validators(arts)
+	etag = strings.TrimSuffix(etag, "\"") + "-" + string(format) + "\""
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	title := feed.DefaultTitle
+	if tag != "" {
+		title += " - " + tag
+	}
+	data, err := feed.New(title, feed.DefaultDescription, site, site+r.URL.Path, arts).Render(format)
+	if err != nil {
+		logger.Error("failed to render %s feed: %s", format, err.Error())
+		http.Error(w, "failed to render feed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	if _, err := w.Write(data); err != nil {
+		logger.Warn("failed to write feed: %s", err.Error())
+	}
+}
+
+// notModified evaluates the conditional request headers. If-None-Match wins
+// over If-Modified-Since when both are present (RFC 9110 13.2.2).
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(ims)
+}
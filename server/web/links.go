@@ -0,0 +1,120 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/ntfy"
+	shortUrlApi "jst_dev/server/urlShort/api"
+	"jst_dev/server/who"
+	whoApi "jst_dev/server/who/api"
+)
+
+// handleLinksBroken checks every internal link of every article and answers
+// the broken ones, for admins. With ?notify=true a summary is also sent to
+// the admin through ntfy when something is broken.
+func handleLinksBroken(l *jst_log.Logger, links *articles.LinkIndex, nc *nats.Conn) http.Handler {
+	logger := l.WithBreadcrumb("links").WithBreadcrumb("broken")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called")
+		user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+		if !ok || !canEditArticles(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		report, err := links.Check(time.Now(), shortCodeAlive(nc))
+		if err != nil {
+			logger.Error("failed to check links: %s", err.Error())
+			http.Error(w, "failed to check links", http.StatusBadGateway)
+			return
+		}
+		logger.Info("%d of %d links broken", len(report.Broken), report.Links)
+		if r.URL.Query().Get("notify") == "true" && len(report.Broken) > 0 {
+			if err := notifyBrokenLinks(nc, user.ID, report); err != nil {
+				logger.Warn("failed to notify: %s", err.Error())
+			}
+		}
+		respJson(w, report, http.StatusOK)
+	})
+}
+
+// shortCodeAlive asks the short url service whether a code still redirects.
+// Unknown and inactive codes are dead.
+func shortCodeAlive(nc *nats.Conn) func(code string) (bool, error) {
+	return func(code string) (bool, error) {
+		reqBytes, err := json.Marshal(shortUrlApi.ShortUrlGetRequest{ShortCode: code})
+		if err != nil {
+			return false, fmt.Errorf("marshal request: %w", err)
+		}
+		msg, err := nc.Request(shortUrlApi.Subj.ShortUrlGroup+"."+shortUrlApi.Subj.ShortUrlGet, reqBytes, 5*time.Second)
+		if err != nil {
+			return false, fmt.Errorf("get short url: %w", err)
+		}
+		if msg.Header.Get("Nats-Service-Error") != "" {
+			if msg.Header.Get("Nats-Service-Error-Code") == "NOT_FOUND" {
+				return false, nil
+			}
+			return false, fmt.Errorf("get short url: %s", msg.Header.Get("Nats-Service-Error"))
+		}
+		var shortUrl shortUrlApi.ShortUrl
+		if err := json.Unmarshal(msg.Data, &shortUrl); err != nil {
+			return false, fmt.Errorf("unmarshal short url: %w", err)
+		}
+		return shortUrl.IsActive, nil
+	}
+}
+
+// notifyBrokenLinks sends a summary of the report to the user through ntfy.
+func notifyBrokenLinks(nc *nats.Conn, userID string, report articles.LinkReport) error {
+	lines := []string{}
+	for i, broken := range report.Broken {
+		if i == 10 {
+			lines = append(lines, fmt.Sprintf("… and %d more", len(report.Broken)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s (%s)", broken.ArticleSlug, broken.Dest, strings.ReplaceAll(broken.Problem, "_", " ")))
+	}
+	notification := ntfy.Notification{
+		ID:       uuid.NewString(),
+		UserID:   userID,
+		Title:    fmt.Sprintf("%d broken links in %d articles", len(report.Broken), countArticles(report.Broken)),
+		Message:  strings.Join(lines, "\n"),
+		Category: "links",
+		Priority: ntfy.PriorityNormal,
+		Data: map[string]interface{}{
+			"broken":     len(report.Broken),
+			"checked_at": report.CheckedAt,
+		},
+		CreatedAt: time.Now(),
+	}
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("marshal notification: %w", err)
+	}
+	msg, err := nc.Request(ntfy.SubjectNotification, data, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("send notification: %w", err)
+	}
+	if msg.Header.Get("Nats-Service-Error") != "" {
+		return fmt.Errorf("send notification: %s", msg.Header.Get("Nats-Service-Error"))
+	}
+	return nil
+}
+
+func countArticles(broken []articles.BrokenLink) int {
+	ids := map[uuid.UUID]struct{}{}
+	for _, b := range broken {
+		ids[b.ArticleId] = struct{}{}
+	}
+	return len(ids)
+}
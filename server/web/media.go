@@ -0,0 +1,361 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/media"
+	mediaApi "jst_dev/server/media/api"
+	"jst_dev/server/who"
+	whoApi "jst_dev/server/who/api"
+)
+
+var mediaHash = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// handleMediaUpload stores the request body as an attachment of the article.
+// The body is either the raw file or multipart/form-data with a "file" part.
+// Responds 201 with the stored object, 200 if the same file was already
+// stored. Images are queued for the variant worker.
+func handleMediaUpload(l *jst_log.Logger, repo articles.ArticleRepo, store *media.Store, acl *who.WhoProlog, nc *nats.Conn) http.Handler {
+	logger := l.WithBreadcrumb("media").WithBreadcrumb("upload")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		art, err := repo.Get(idUuid)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !canChangeArticle(r, acl, art) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		// room for the multipart framing around the file
+		body := http.MaxBytesReader(w, r.Body, media.MaxSize+64*1024)
+		var src io.Reader = body
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+			mr, err := r.MultipartReader()
+			if err != nil {
+				http.Error(w, "invalid multipart body", http.StatusBadRequest)
+				return
+			}
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					http.Error(w, "missing \"file\" part", http.StatusBadRequest)
+					return
+				}
+				if part.FormName() == "file" {
+					src = part
+					break
+				}
+			}
+		}
+		data, err := io.ReadAll(io.LimitReader(src, media.MaxSize+1))
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) || len(data) > media.MaxSize {
+			http.Error(w, media.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read upload", http.StatusBadRequest)
+			return
+		}
+		if len(data) == 0 {
+			http.Error(w, "empty upload", http.StatusBadRequest)
+			return
+		}
+
+		obj, created, err := store.Put(data, idUuid, user.ID)
+		switch {
+		case errors.Is(err, media.ErrUnsupportedType):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		case errors.Is(err, media.ErrTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			logger.Error("failed to store media: %s", err.Error())
+			http.Error(w, "failed to store media", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", obj.URL)
+		if !created {
+			respJson(w, obj, http.StatusOK)
+			return
+		}
+		logger.Info("user %s uploaded %s (%s, %d bytes) to article %s", user.ID, obj.Hash, obj.ContentType, obj.Size, id)
+		if media.Resizable(obj.ContentType) {
+			if err := media.RequestVariants(nc, obj.Hash); err != nil {
+				logger.Error("failed to queue variants for %s: %s", obj.Hash, err.Error())
+			}
+		}
+		respJson(w, obj, http.StatusCreated)
+	})
+}
+
+// handleArticleMedia lists the attachments an article links to or that were
+// uploaded for it. Links to attachments that are not stored are listed as
+// missing.
+func handleArticleMedia(l *jst_log.Logger, repo articles.ArticleRepo, store *media.Store, acl *who.WhoProlog) http.Handler {
+	type Item struct {
+		media.Object
+		Referenced bool `json:"referenced"`
+	}
+	type Resp struct {
+		Media   []Item   `json:"media"`
+		Missing []string `json:"missing"`
+	}
+
+	logger := l.WithBreadcrumb("media").WithBreadcrumb("article")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		art, err := repo.Get(idUuid)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !canChangeArticle(r, acl, art) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		resp := Resp{Media: []Item{}, Missing: []string{}}
+		seen := map[string]bool{}
+		for _, hash := range media.Refs(art) {
+			seen[hash] = true
+			obj, err := store.Info(hash)
+			if errors.Is(err, media.ErrNotFound) {
+				resp.Missing = append(resp.Missing, hash)
+				continue
+			}
+			if err != nil {
+				logger.Error("failed to get media info: %s", err.Error())
+				http.Error(w, "failed to get media", http.StatusInternalServerError)
+				return
+			}
+			resp.Media = append(resp.Media, Item{Object: obj, Referenced: true})
+		}
+		objs, err := store.List()
+		if err != nil {
+			logger.Error("failed to list media: %s", err.Error())
+			http.Error(w, "failed to list media", http.StatusInternalServerError)
+			return
+		}
+		for _, obj := range objs {
+			if obj.Article == idUuid && !obj.IsVariant() && !seen[obj.Hash] {
+				resp.Media = append(resp.Media, Item{Object: obj})
+			}
+		}
+		respJson(w, resp, http.StatusOK)
+	})
+}
+
+// handleMedia serves an attachment, or with a {width} path value one of its
+// variants. Names are content hashes and variants are made once, so
+// responses are cached for good and revalidated by ETag.
+func handleMedia(l *jst_log.Logger, store *media.Store) http.Handler {
+	logger := l.WithBreadcrumb("media").WithBreadcrumb("get")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.ToLower(r.PathValue("hash"))
+		if !mediaHash.MatchString(hash) {
+			http.NotFound(w, r)
+			return
+		}
+		width := 0
+		if ws := r.PathValue("width"); ws != "" {
+			var err error
+			if width, err = strconv.Atoi(ws); err != nil || width <= 0 {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		tag := "\"" + hash + "\""
+		if width > 0 {
+			tag = "\"" + hash + "-" + strconv.Itoa(width) + "\""
+		}
+		w.Header().Set("ETag", tag)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		if match := r.Header.Get("If-None-Match"); match == tag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		obj, rc, err := store.Open(hash, width)
+		if errors.Is(err, media.ErrNotFound) {
+			w.Header().Del("ETag")
+			w.Header().Set("Cache-Control", "no-store")
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to open media: %s", err.Error())
+			w.Header().Del("ETag")
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "failed to get media", http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+
+		w.Header().Set("Content-Type", obj.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatUint(obj.Size, 10))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if _, err := io.Copy(w, rc); err != nil {
+			logger.Warn("failed to write media %s: %s", hash, err.Error())
+		}
+	})
+}
+
+// handleMediaVariants lists the variants of an image with a srcset value for
+// them. The list is empty while the worker has not made them yet.
+func handleMediaVariants(l *jst_log.Logger, store *media.Store) http.Handler {
+	logger := l.WithBreadcrumb("media").WithBreadcrumb("variants")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.ToLower(r.PathValue("hash"))
+		logger.Debug("called with hash: %s", hash)
+		if !mediaHash.MatchString(hash) {
+			http.NotFound(w, r)
+			return
+		}
+		if _, err := store.Info(hash); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		variants, err := store.Variants(hash)
+		if err != nil {
+			logger.Error("failed to list variants: %s", err.Error())
+			http.Error(w, "failed to list variants", http.StatusInternalServerError)
+			return
+		}
+		respJson(w, mediaApi.VariantsResponse{
+			Hash:     hash,
+			Variants: variants,
+			Srcset:   media.Srcset(variants),
+		}, http.StatusOK)
+	})
+}
+
+// handleMediaVariantsGenerate asks the variant worker to make the variants of
+// an image now and waits for them, for images uploaded before the worker
+// existed or whose job got lost.
+func handleMediaVariantsGenerate(l *jst_log.Logger, nc *nats.Conn) http.Handler {
+	logger := l.WithBreadcrumb("media").WithBreadcrumb("variants").WithBreadcrumb("generate")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.ToLower(r.PathValue("hash"))
+		logger.Debug("called with hash: %s", hash)
+		if !canEditArticles(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if !mediaHash.MatchString(hash) {
+			http.NotFound(w, r)
+			return
+		}
+		reqBytes, err := json.Marshal(mediaApi.VariantsRequest{Hash: hash})
+		if err != nil {
+			logger.Error("failed to marshal request: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		msg, err := nc.Request(mediaApi.Subj.ImageGroup+"."+mediaApi.Subj.ImageVariants, reqBytes, 30*time.Second)
+		if err != nil {
+			logger.Error("failed to generate variants: %v", err)
+			http.Error(w, "failed to generate variants", http.StatusInternalServerError)
+			return
+		}
+		if msg.Header.Get("Nats-Service-Error") != "" {
+			switch msg.Header.Get("Nats-Service-Error-Code") {
+			case "NOT_FOUND":
+				http.NotFound(w, r)
+			case "INVALID_REQUEST":
+				http.Error(w, msg.Header.Get("Nats-Service-Error"), http.StatusUnprocessableEntity)
+			default:
+				http.Error(w, "service error", http.StatusInternalServerError)
+			}
+			return
+		}
+		var resp mediaApi.VariantsResponse
+		if err := json.Unmarshal(msg.Data, &resp); err != nil {
+			logger.Error("failed to unmarshal response: %v", err)
+			http.Error(w, "failed to parse response", http.StatusInternalServerError)
+			return
+		}
+		respJson(w, resp, http.StatusOK)
+	})
+}
+
+// handleMediaGC runs media garbage collection, reporting what was (or on a
+// dry run would be) deleted. ?grace= overrides the default grace period.
+func handleMediaGC(l *jst_log.Logger, repo articles.ArticleRepo, store *media.Store, dryRun bool) http.Handler {
+	logger := l.WithBreadcrumb("media").WithBreadcrumb("gc")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called (dry run: %t)", dryRun)
+		if !canEditArticles(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		grace := media.DefaultGCGrace
+		if g := r.URL.Query().Get("grace"); g != "" {
+			var err error
+			if grace, err = time.ParseDuration(g); err != nil || grace < 0 {
+				http.Error(w, "invalid grace duration", http.StatusBadRequest)
+				return
+			}
+		}
+		report, err := store.GC(repo, time.Now().Add(-grace), dryRun)
+		if err != nil {
+			logger.Error("failed to collect media: %s", err.Error())
+			http.Error(w, "failed to collect media", http.StatusInternalServerError)
+			return
+		}
+		if !dryRun {
+			logger.Info("deleted %d unreferenced media objects", len(report.Unreferenced))
+		}
+		respJson(w, report, http.StatusOK)
+	})
+}
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/page"
+	"jst_dev/server/who"
+)
+
+// handleArticlePage serves the frontend shell for /article/{slug} with the
+// metadata and content of the article filled in, see package page. Articles
+// the reader may not see get the plain shell, with a 404 so that crawlers
+// drop them; the SPA shows its own not found page. Urls in the metadata are
+// absolute against site, the public url of the site. The page of a source
+// redirects to the published translation Accept-Language prefers.
+func handleArticlePage(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog, embeddedFS fs.FS, site string) http.Handler {
+	logger := l.WithBreadcrumb("article").WithBreadcrumb("page")
+
+	shell, err := fs.ReadFile(embeddedFS, "index.html")
+	if err != nil {
+		logger.Error("static file not found: index.html")
+		panic(fmt.Sprintf("static file not found: index.html: %v", err))
+	}
+	logger.Debug("ready")
+
+	serve := func(w http.ResponseWriter, data []byte, status int) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		if _, err := w.Write(data); err != nil {
+			logger.Warn("failed to write page: %s", err.Error())
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug := r.PathValue("slug")
+		logger.Debug("called with slug: %s", slug)
+		now := time.Now()
+
+		art, err := repo.GetBySLug(slug)
+		if errors.Is(err, jetstream.ErrKeyNotFound) || (err == nil && !canReadArticle(r, acl, art)) {
+			w.Header().Set("Cache-Control", "no-cache")
+			serve(w, shell, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to get article: %s", err.Error())
+			serve(w, shell, http.StatusOK)
+			return
+		}
+
+		family, err := articles.GetFamily(repo, art.Id)
+		if err != nil {
+			logger.Warn("failed to get translations: %s", err.Error())
+			family = articles.Family{Source: art}
+		}
+		family = family.Filter(func(variant articles.Article) bool {
+			return variant.IsPublic(now)
+		})
+		w.Header().Add("Vary", "Accept-Language")
+
+		// readers following a link to the source are sent to the language
+		// they ask for, links to a translation stay put
+		if art.TranslationOf == nil {
+			available := []string{}
+			for _, variant := range family.Variants() {
+				available = append(available, variant.Language())
+			}
+			lang, _ := articles.Negotiate(r.Header.Get("Accept-Language"), available)
+			if variant, ok := family.Variant(lang); ok && variant.Id != art.Id {
+				http.Redirect(w, r, "/article/"+url.PathEscape(variant.Slug), http.StatusFound)
+				return
+			}
+		}
+
+		data, err := page.Render(shell, page.Article(art, site, family.HrefLangs(), now))
+		if err != nil {
+			logger.Error("failed to render page: %s", err.Error())
+			serve(w, shell, http.StatusOK)
+			return
+		}
+		if art.IsPublic(now) {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "private, no-cache")
+		}
+		w.Header().Set("Content-Language", art.Language())
+		serve(w, data, http.StatusOK)
+	})
+}
@@ -0,0 +1,199 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/presence"
+)
+
+// Presence and edit locks over the websocket. Clients that open an article in
+// the editor send presence every few seconds, well within presence.PresenceTTL,
+// and take the lock before they start typing. Both are dropped when the
+// client leaves the article or disconnects. Everyone follows the changes with
+// kv_sub on the presence and edit_locks buckets.
+
+// handlePresence records that the user has the article open and where, and
+// refreshes their edit lock on it if they hold it.
+func (c *rtClient) handlePresence(target string, data json.RawMessage, inbox string) {
+	var hint struct {
+		Section string `json:"section"`
+		Cursor  int    `json:"cursor"`
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &hint); err != nil {
+			c.reply(inbox, map[string]string{"error": "bad presence"})
+			return
+		}
+	}
+	id, err := c.editable(target)
+	if err != nil {
+		c.reply(inbox, map[string]string{"error": err.Error()})
+		return
+	}
+	p, err := c.presence.Touch(presence.Presence{ArticleID: id, UserID: c.id, Section: hint.Section, Cursor: hint.Cursor})
+	if err != nil {
+		c.log.Warn("presence %s: %v", id, err)
+		c.reply(inbox, map[string]string{"error": "presence failed"})
+		return
+	}
+	c.mu.Lock()
+	c.open[id] = true
+	holds := c.locked[id]
+	c.mu.Unlock()
+
+	if holds {
+		if _, err := c.presence.Lock(id, c.id); err != nil {
+			// taken over by a forced unlock, the client sees it in edit_locks
+			c.log.Info("lock %s lost: %v", id, err)
+			c.mu.Lock()
+			delete(c.locked, id)
+			c.mu.Unlock()
+		}
+	}
+	c.reply(inbox, p)
+}
+
+// handlePresenceLeave removes the user from the article and releases their
+// edit lock on it.
+func (c *rtClient) handlePresenceLeave(target string, inbox string) {
+	id, err := uuid.Parse(target)
+	if err != nil {
+		c.reply(inbox, map[string]string{"error": "bad article id"})
+		return
+	}
+	c.leave(id)
+	c.reply(inbox, map[string]bool{"ok": true})
+}
+
+// handleLock takes or refreshes the edit lock of the article. The reply is
+// the lock, or the lock of whoever holds it with the error "locked".
+func (c *rtClient) handleLock(target string, inbox string) {
+	var lockedErr *presence.LockedError
+	id, err := c.editable(target)
+	if err != nil {
+		c.reply(inbox, map[string]string{"error": err.Error()})
+		return
+	}
+	lock, err := c.presence.Lock(id, c.id)
+	if errors.As(err, &lockedErr) {
+		c.reply(inbox, map[string]any{"error": "locked", "lock": lockedErr.Lock})
+		return
+	}
+	if err != nil {
+		c.log.Warn("lock %s: %v", id, err)
+		c.reply(inbox, map[string]string{"error": "lock failed"})
+		return
+	}
+	c.mu.Lock()
+	c.locked[id] = true
+	c.mu.Unlock()
+	c.reply(inbox, map[string]any{"lock": lock})
+}
+
+// handleUnlock releases the edit lock of the article. With data
+// {"force": true} it releases someone else's lock.
+func (c *rtClient) handleUnlock(target string, data json.RawMessage, inbox string) {
+	var opts struct {
+		Force bool `json:"force"`
+	}
+	if len(data) > 0 {
+		_ = json.Unmarshal(data, &opts)
+	}
+	id, err := c.editable(target)
+	if err != nil {
+		c.reply(inbox, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := c.presence.Unlock(id, c.id, opts.Force); err != nil {
+		if errors.Is(err, presence.ErrLocked) {
+			c.reply(inbox, map[string]string{"error": "locked"})
+			return
+		}
+		c.log.Warn("unlock %s: %v", id, err)
+		c.reply(inbox, map[string]string{"error": "unlock failed"})
+		return
+	}
+	if opts.Force {
+		c.log.Info("user %s forced the lock of %s open", c.id, id)
+	}
+	c.mu.Lock()
+	delete(c.locked, id)
+	c.mu.Unlock()
+	c.reply(inbox, map[string]bool{"ok": true})
+}
+
+// leave drops the presence of the client on the article and the lock it
+// holds there.
+func (c *rtClient) leave(id uuid.UUID) {
+	c.mu.Lock()
+	_, open := c.open[id]
+	holds := c.locked[id]
+	delete(c.open, id)
+	delete(c.locked, id)
+	c.mu.Unlock()
+
+	if open {
+		if err := c.presence.Leave(id, c.id); err != nil {
+			c.log.Warn("leave %s: %v", id, err)
+		}
+	}
+	if holds {
+		if err := c.presence.Unlock(id, c.id, false); err != nil && !errors.Is(err, presence.ErrLocked) {
+			c.log.Warn("unlock %s: %v", id, err)
+		}
+	}
+}
+
+// leaveAll leaves every article the client is in, on disconnect.
+func (c *rtClient) leaveAll() {
+	c.mu.Lock()
+	ids := make([]uuid.UUID, 0, len(c.open)+len(c.locked))
+	for id := range c.open {
+		ids = append(ids, id)
+	}
+	for id := range c.locked {
+		if !c.open[id] {
+			ids = append(ids, id)
+		}
+	}
+	c.mu.Unlock()
+	for _, id := range ids {
+		c.leave(id)
+	}
+}
+
+// editable parses the article id and checks that the user may edit it.
+func (c *rtClient) editable(target string) (uuid.UUID, error) {
+	if c.id == "" {
+		return uuid.Nil, fmt.Errorf("not logged in")
+	}
+	id, err := uuid.Parse(target)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("bad article id")
+	}
+	art, err := c.srv.repo.Get(id)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return uuid.Nil, fmt.Errorf("article not found")
+	}
+	if err != nil {
+		c.log.Error("get article %s: %v", id, err)
+		return uuid.Nil, fmt.Errorf("article unavailable")
+	}
+	if !c.canChange(art) {
+		return uuid.Nil, fmt.Errorf("forbidden")
+	}
+	return id, nil
+}
+
+// reply answers a request that came with an inbox.
+func (c *rtClient) reply(inbox string, data any) {
+	if inbox == "" {
+		return
+	}
+	c.send(serverMsg{Op: "reply", Inbox: inbox, Data: data})
+}
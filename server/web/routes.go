@@ -3,22 +3,34 @@ package web
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"slices"
 
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 
 	"jst_dev/server/articles"
+	"jst_dev/server/bundle"
+	commentsApi "jst_dev/server/comments/api"
 	"jst_dev/server/jst_log"
+	"jst_dev/server/media"
 	"jst_dev/server/ntfy"
+	"jst_dev/server/presence"
+	searchApi "jst_dev/server/search/api"
+	"jst_dev/server/series"
 	shortUrlApi "jst_dev/server/urlShort/api"
+	"jst_dev/server/views"
 	"jst_dev/server/who"
 	whoApi "jst_dev/server/who/api"
 )
@@ -28,15 +40,66 @@ const (
 	audience   = "jst_dev.who"
 )
 
-func routes(mux *http.ServeMux, l *jst_log.Logger, repo articles.ArticleRepo, nc *nats.Conn, embeddedFS fs.FS, jwtSecret string, dev bool, slow time.Duration) {
+func routes(mux *http.ServeMux, l *jst_log.Logger, repo articles.ArticleRepo, tags *articles.TagIndex, links *articles.LinkIndex, mediaStore *media.Store, seriesStore *series.Store, presenceStore *presence.Store, viewStore *views.Store, acl *who.WhoProlog, nc *nats.Conn, embeddedFS fs.FS, jwtSecret string, siteURL string, dev bool, slow time.Duration) {
 	// Add routes with their respective handlers
-	mux.Handle("GET /api/article", handleArticleList(l, repo))
+	mux.Handle("GET /api/article", handleArticleList(l, repo, acl))
+	mux.Handle("GET /api/article/search", handleArticleSearch(l, nc))
 	mux.Handle("POST /api/article", handleArticleNew(l, repo, nc))
-	mux.Handle("GET /api/article/{id}", handleArticle(l, repo))
-	mux.Handle("PUT /api/article/{id}", handleArticleUpdate(l, repo))
-	mux.Handle("DELETE /api/article/{id}", handleArticleDelete(l, repo))
-	mux.Handle("GET /api/article/{id}/revisions", handleArticleRevisions(l, repo))
-	mux.Handle("GET /api/article/{id}/revisions/{revision}", handleArticleRevision(l, repo))
+	mux.Handle("GET /api/article/{id}", handleArticle(l, repo, links, seriesStore, viewStore, acl))
+	mux.Handle("PUT /api/article/{id}", handleArticleUpdate(l, repo, presenceStore, acl))
+	mux.Handle("DELETE /api/article/{id}", handleArticleDelete(l, repo, acl))
+	mux.Handle("GET /api/article/trash", handleArticleTrash(l, repo, acl))
+	mux.Handle("GET /api/article/migrations", handleArticleMigrate(l, repo, true))
+	mux.Handle("POST /api/article/migrations", handleArticleMigrate(l, repo, false))
+	mux.Handle("GET /api/article/export", handleArticleExport(l, repo))
+	mux.Handle("POST /api/article/import", handleArticleImport(l, repo))
+	mux.Handle("POST /api/article/{id}/undelete", handleArticleUndelete(l, repo, acl))
+	mux.Handle("GET /api/article/{id}/revisions", handleArticleRevisions(l, repo, acl))
+	mux.Handle("GET /api/article/{id}/revisions/{revision}", handleArticleRevision(l, repo, acl))
+	mux.Handle("GET /api/article/{id}/revisions/{from}/diff/{to}", handleArticleRevisionDiff(l, repo, acl))
+	mux.Handle("POST /api/article/{id}/revisions/{revision}/restore", handleArticleRestore(l, repo, acl))
+	mux.Handle("GET /api/article/{id}/access", handleArticleAccess(l, repo, acl))
+	mux.Handle("PUT /api/article/{id}/access", handleArticleAccessUpdate(l, repo, acl, nc))
+	mux.Handle("GET /api/article/{id}/related", handleArticleRelated(l, repo, acl, nc))
+	mux.Handle("GET /api/article/{id}/stats", handleArticleStats(l, repo, viewStore))
+	mux.Handle("GET /api/article/translations", handleTranslationList(l, repo, acl))
+	mux.Handle("GET /api/article/{id}/translations", handleArticleTranslations(l, repo, acl))
+	mux.Handle("POST /api/article/{id}/translations", handleArticleTranslate(l, repo, acl))
+
+	// comments
+	mux.Handle("GET /api/article/{id}/comments", handleCommentList(l, nc))
+	mux.Handle("POST /api/article/{id}/comments", handleCommentCreate(l, nc))
+	mux.Handle("POST /api/article/{id}/comments/{comment}/approve", handleCommentModerate(l, nc, commentsApi.ActionApprove))
+	mux.Handle("POST /api/article/{id}/comments/{comment}/reject", handleCommentModerate(l, nc, commentsApi.ActionReject))
+	mux.Handle("DELETE /api/article/{id}/comments/{comment}", handleCommentModerate(l, nc, commentsApi.ActionDelete))
+	mux.Handle("GET /api/comments/queue", handleCommentQueue(l, nc))
+
+	// media
+	mux.Handle("GET /api/article/{id}/media", handleArticleMedia(l, repo, mediaStore, acl))
+	mux.Handle("POST /api/article/{id}/media", handleMediaUpload(l, repo, mediaStore, acl, nc))
+	mux.Handle("GET /api/media/gc", handleMediaGC(l, repo, mediaStore, true))
+	mux.Handle("POST /api/media/gc", handleMediaGC(l, repo, mediaStore, false))
+	mux.Handle("GET /api/media/{hash}/variants", handleMediaVariants(l, mediaStore))
+	mux.Handle("POST /api/media/{hash}/variants", handleMediaVariantsGenerate(l, nc))
+	mux.Handle("GET /media/{hash}", handleMedia(l, mediaStore))
+	mux.Handle("GET /media/{hash}/{width}", handleMedia(l, mediaStore))
+
+	// series
+	mux.Handle("GET /api/series", handleSeriesList(l, seriesStore, acl))
+	mux.Handle("POST /api/series", handleSeriesSave(l, seriesStore, true))
+	mux.Handle("GET /api/series/{id}", handleSeries(l, seriesStore, acl))
+	mux.Handle("PUT /api/series/{id}", handleSeriesSave(l, seriesStore, false))
+	mux.Handle("DELETE /api/series/{id}", handleSeriesDelete(l, seriesStore))
+
+	// feeds
+	mux.Handle("GET /api/tags", handleTags(l, tags))
+	mux.Handle("POST /api/tags/rename", handleTagsRewrite(l, repo, nc, "rename"))
+	mux.Handle("POST /api/tags/merge", handleTagsRewrite(l, repo, nc, "merge"))
+
+	mux.Handle("GET /api/links/broken", handleLinksBroken(l, links, nc))
+
+	mux.Handle("GET /feed/{file}", handleFeed(l, repo, siteURL))
+	mux.Handle("GET /feed/tag/{file}", handleFeedTag(l, repo, siteURL))
 
 	// auth
 	mux.Handle("POST /api/auth", handleAuth(l, nc, jwtSecret))
@@ -63,7 +126,7 @@ func routes(mux *http.ServeMux, l *jst_log.Logger, repo articles.ArticleRepo, nc
 
 	// realtime websocket bridge
 	mux.Handle("GET /ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		HandleRealtimeWebSocket(l.WithBreadcrumb("ws"), nc, slow, w, r)
+		HandleRealtimeWebSocket(l.WithBreadcrumb("ws"), nc, repo, presenceStore, acl, slow, w, r)
 	}))
 
 	// web
@@ -74,6 +137,7 @@ func routes(mux *http.ServeMux, l *jst_log.Logger, repo articles.ArticleRepo, nc
 		mux.Handle("/", handleProxy(l.WithBreadcrumb("proxy_frontend"), "http://127.0.0.1:1234"))
 	} else {
 		mux.Handle("GET /", handleStaticFsFile(l, embeddedFS, "index.html"))
+		mux.Handle("GET /article/{slug}", handleArticlePage(l, repo, acl, embeddedFS, siteURL))
 		mux.Handle("GET /static/", handleStaticFs(l, embeddedFS))
 	}
 }
@@ -102,7 +166,8 @@ func cors(_ *jst_log.Logger, next http.Handler) http.Handler {
 		}
 
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 		w.Header().Set("Access-Control-Allow-Credentials", "true") // Required for cookies
 		next.ServeHTTP(w, r)
 	})
@@ -544,6 +609,10 @@ func handleSeed(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
 
 		art := articles.TestArticle()
 		_, err := repo.Create(art)
+		if errors.Is(err, articles.ErrSlugTaken) {
+			http.Error(w, "already seeded", http.StatusConflict)
+			return
+		}
 		if err != nil {
 			logger.Error("failed to put test article in repo: %s", err.Error())
 			http.Error(w, "failed to put test article in repo", http.StatusInternalServerError)
@@ -588,29 +657,125 @@ func handlePurge(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
 // - articles
 
 // handleArticleList creates a handler for listing all articles
-func handleArticleList(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
-	type Resp struct {
-		Articles []articles.Article `json:"articles"`
-	}
-
+//
+// Readers without edit rights only get published articles and those shared
+// with them.
+func handleArticleList(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
 	logger := l.WithBreadcrumb("articles").WithBreadcrumb("list")
 	logger.Debug("ready")
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("called")
-		articles, err := repo.AllNoContent()
+		query, err := articles.ParseListQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query.IncludeHidden = canEditArticles(r)
+		query.CanRead = func(art articles.Article) bool {
+			return canReadArticle(r, acl, art)
+		}
+
+		all, err := repo.AllNoContent()
 		if err != nil {
 			logger.Error("failed to get all articles: %s", err.Error())
 			http.Error(w, "failed to get all articles", http.StatusInternalServerError)
 			return
 		}
-		logger.Debug("articles count: %d", len(articles))
-		respJson(w, Resp{Articles: articles}, http.StatusOK)
+		page, err := articles.List(all, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i := range page.Articles {
+			if !canEditArticles(r) {
+				page.Articles[i].Collaborators = nil // shared with whom is for those who share
+			}
+		}
+		logger.Debug("articles count: %d (of %d)", len(page.Articles), len(all))
+		respJson(w, page, http.StatusOK)
+	})
+}
+
+// handleArticleSearch creates a handler for full-text search over articles
+func handleArticleSearch(l *jst_log.Logger, nc *nats.Conn) http.Handler {
+	logger := l.WithBreadcrumb("articles").WithBreadcrumb("search")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called")
+
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" {
+			http.Error(w, "q is required", http.StatusBadRequest)
+			return
+		}
+		limit := 0
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			if l, err := fmt.Sscanf(limitStr, "%d", &limit); err != nil || l != 1 {
+				http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		reqBytes, err := json.Marshal(searchApi.ArticleQueryRequest{
+			Query:         q,
+			Limit:         limit,
+			IncludeHidden: canEditArticles(r),
+		})
+		if err != nil {
+			logger.Error("failed to marshal request: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Send request to search service
+		msg, err := nc.Request(
+			searchApi.Subj.ArticleGroup+"."+searchApi.Subj.ArticleQuery,
+			reqBytes,
+			5*time.Second,
+		)
+		if err != nil {
+			logger.Error("failed to search articles: %v", err)
+			http.Error(w, "failed to search articles", http.StatusInternalServerError)
+			return
+		}
+
+		// Check for service errors
+		if msg.Header.Get("Nats-Service-Error") != "" {
+			errorCode := msg.Header.Get("Nats-Service-Error-Code")
+			if errorCode == "INVALID_REQUEST" {
+				http.Error(w, string(msg.Data), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "service error", http.StatusInternalServerError)
+			return
+		}
+
+		// Parse response
+		var resp searchApi.ArticleQueryResponse
+		if err := json.Unmarshal(msg.Data, &resp); err != nil {
+			logger.Error("failed to unmarshal response: %v", err)
+			http.Error(w, "failed to parse response", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Debug("found %d articles for %q", resp.Total, q)
+		respJson(w, resp, http.StatusOK)
 	})
 }
 
-// handleArticle creates a handler for getting a single article by slug
-func handleArticle(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
+// handleArticle creates a handler for getting a single article by slug. The
+// response carries the prev/next navigation of every series it is part of
+// and the articles linking to it.
+func handleArticle(l *jst_log.Logger, repo articles.ArticleRepo, links *articles.LinkIndex, seriesStore *series.Store, viewStore *views.Store, acl *who.WhoProlog) http.Handler {
+	type Resp struct {
+		articles.Article
+		Series     []series.Navigation `json:"series"`
+		HrefLang   []articles.HrefLang `json:"hreflang"`    // the article in every language the reader may read
+		LinkedFrom []articles.Backlink `json:"linked_from"` // articles the reader may read that link here
+	}
+
 	logger := l.WithBreadcrumb("article").WithBreadcrumb("get")
 	logger.Debug("ready")
 
@@ -626,18 +791,61 @@ func handleArticle(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
 		}
 		logger.Debug("idUuid: %s", idUuid)
 		art, err = repo.Get(idUuid)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			logger.Info("not found, article \"%s\"", id)
+			http.NotFound(w, r)
+			return
+		}
 		if err != nil {
 			logger.Error("failed to get article: %s", err.Error())
 			http.Error(w, "failed to get article", http.StatusInternalServerError)
 			return
 		}
-		if art.Id == uuid.Nil {
+		if art.Id == uuid.Nil || !canReadArticle(r, acl, art) {
 			logger.Info("not found, article \"%s\"", id)
 			http.NotFound(w, r)
 			return
 		}
-		logger.Debug("article: %s (rev: %d)", art.Slug, art.Rev)
-		respJson(w, art, http.StatusOK)
+
+		// ?lang= picks a language of the family. Asking by id gets that
+		// article otherwise, Accept-Language is for the reader pages (see
+		// handleArticlePage)
+		family, err := articles.GetFamily(repo, art.Id)
+		if err != nil {
+			logger.Warn("failed to get translations: %s", err.Error())
+			family = articles.Family{Source: art}
+		}
+		family = family.Filter(func(variant articles.Article) bool {
+			return canReadArticle(r, acl, variant)
+		})
+		lang := r.URL.Query().Get("lang")
+		if variant, ok := family.Variant(lang); ok && variant.Id != art.Id {
+			art, err = repo.Get(variant.Id)
+			if err != nil {
+				logger.Error("failed to get translation: %s", err.Error())
+				http.Error(w, "failed to get article", http.StatusInternalServerError)
+				return
+			}
+		}
+		logger.Debug("article: %s (rev: %d, lang: %s)", art.Slug, art.Rev, art.Language())
+		canChange := canChangeArticle(r, acl, art)
+		if !canChange {
+			art.Collaborators = nil // shared with whom is for those who share
+		}
+		recordView(r, logger, viewStore, art, canChange)
+		nav, err := seriesStore.Navigation(art.Id, func(part articles.Article) bool {
+			return canReadArticle(r, acl, part)
+		})
+		if err != nil {
+			logger.Error("failed to get series navigation: %s", err.Error())
+			nav = []series.Navigation{}
+		}
+		linkedFrom := links.LinkedFrom(art, func(from articles.Article) bool {
+			return canReadArticle(r, acl, from)
+		})
+		w.Header().Set("ETag", etag(art.Rev))
+		w.Header().Set("Content-Language", art.Language())
+		respJson(w, Resp{Article: art, Series: nav, HrefLang: family.HrefLangs(), LinkedFrom: linkedFrom}, http.StatusOK)
 	})
 }
 
@@ -661,11 +869,12 @@ func handleArticleNew(l *jst_log.Logger, repo articles.ArticleRepo, nc *nats.Con
 			http.Error(w, "not allowed", http.StatusForbidden)
 			return
 		}
-		if !user.Permissions.Includes(whoApi.PermissionPostEditAny) {
-			logger.Warn("user does not have create_article permission")
+		if !user.Permissions.Includes(whoApi.PermissionPostCreate) && !user.Permissions.Includes(whoApi.PermissionPostEditAny) {
+			logger.Warn("user does not have post_create permission")
 			http.Error(w, "not allowed", http.StatusForbidden)
 			return
 		}
+		owner := user.ID
 
 		// get full user
 		whoReq, err := json.Marshal(whoApi.UserGetRequest{
@@ -702,6 +911,8 @@ func handleArticleNew(l *jst_log.Logger, repo articles.ArticleRepo, nc *nats.Con
 		art.Title = "new article"
 		art.Subtitle = ""
 		art.Leading = "One paragraph summary/ eyecatching synopsis."
+		art.Status = articles.StatusDraft
+		art.Owners = []string{owner}
 		art_created, err := repo.Create(art)
 		if err != nil {
 			logger.Error("failed to Create new article in repo: %v", err)
@@ -721,12 +932,42 @@ func handleArticleNew(l *jst_log.Logger, repo articles.ArticleRepo, nc *nats.Con
 }
 
 // handleArticleUpdate creates a handler for updating an existing article
-func handleArticleUpdate(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
+//
+// The write is a compare-and-swap against the revision the client based its
+// edit on. That revision is taken from the If-Match header when present and
+// from the "revision" field of the body otherwise. Without either the write
+// is refused with 428, a blind overwrite is what the check is there to stop.
+// On a mismatch the handler answers 409 with the current article so the
+// editor can offer a merge.
+//
+// The editor publishes by setting published_at and unpublishes by clearing
+// it, without sending a status. A body without status gets the one its
+// published_at stands for (see articles.StatusFor) when that changed.
+//
+// While someone else holds the edit lock of the article the write is refused
+// with 423 and the lock, unless ?force=true.
+func handleArticleUpdate(l *jst_log.Logger, repo articles.ArticleRepo, locks *presence.Store, acl *who.WhoProlog) http.Handler {
+	type ConflictResp struct {
+		Error            string           `json:"error"`
+		ExpectedRevision uint64           `json:"expected_revision"`
+		CurrentRevision  uint64           `json:"current_revision"`
+		Current          articles.Article `json:"current"`
+	}
+	type LockedResp struct {
+		Error string        `json:"error"`
+		Lock  presence.Lock `json:"lock"`
+	}
+
 	logger := l.WithBreadcrumb("article").WithBreadcrumb("save")
 	logger.Debug("ready")
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var art articles.Article
+		var (
+			art         articles.Article
+			conflictErr *articles.RevisionConflictError
+			slugErr     *articles.SlugTakenError
+			lockedErr   *presence.LockedError
+		)
 		logger.Debug("called")
 		id := r.PathValue("id")
 		idUuid, err := uuid.Parse(id)
@@ -742,48 +983,147 @@ func handleArticleUpdate(l *jst_log.Logger, repo articles.ArticleRepo) http.Hand
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		hasPermission := slices.Contains(user.Permissions, whoApi.PermissionPostEditAny)
-		if !hasPermission {
-			http.Error(w, "forbidden", http.StatusForbidden)
-			return
-		}
 
-		logger.Debug("permissions ok")
 		// Get current article to verify it exists.
-		// TODO: not necessary as we can check the update error
-		art, err = repo.Get(idUuid)
+		current, err := repo.Get(idUuid)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			logger.Info("article not found: %s", id)
+			http.Error(w, "article not found", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			logger.Error("failed to get current article: %s", err.Error())
 			http.Error(w, "failed to get current article", http.StatusInternalServerError)
 			return
 		}
-		if art.Id == uuid.Nil {
+		if current.Id == uuid.Nil {
 			logger.Error("article not found: %s", id)
 			http.Error(w, "article not found", http.StatusNotFound)
 			return
 		}
+		if !canChangeArticle(r, acl, current) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		logger.Debug("permissions ok")
+
+		// Locks are soft, a failing lookup does not stop the write
+		err = locks.Check(idUuid, user.ID)
+		if errors.As(err, &lockedErr) {
+			if r.URL.Query().Get("force") != "true" {
+				logger.Info("refused write of %s: %s", user.ID, lockedErr.Error())
+				respJson(w, LockedResp{Error: "locked", Lock: lockedErr.Lock}, http.StatusLocked)
+				return
+			}
+			logger.Info("user %s forced a write: %s", user.ID, lockedErr.Error())
+		} else if err != nil {
+			logger.Warn("failed to check edit lock: %s", err.Error())
+		}
 
-		// Decode request body
-		if err := json.NewDecoder(r.Body).Decode(&art); err != nil {
+		// Decode request body, over the current article but not its revision
+		var given struct {
+			Status *articles.Status `json:"status"`
+		}
+		body, err := io.ReadAll(r.Body)
+		art = current
+		art.Rev = 0
+		if err == nil {
+			err = json.Unmarshal(body, &art)
+		}
+		if err == nil {
+			err = json.Unmarshal(body, &given)
+		}
+		if err != nil {
 			logger.Warn("Failed to decode request", "error", err)
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
+		// The editor publishes and unpublishes through published_at alone.
+		// Without a status a changed publish time decides it, an unchanged
+		// one keeps what the article is now.
+		now := time.Now()
+		if given.Status == nil || *given.Status == "" {
+			art.Status = current.CurrentStatus(now)
+			if art.PublishedAt != current.PublishedAt {
+				art.Status = articles.StatusFor(art.PublishedAt, now)
+			}
+		}
+
+		// Resolve the revision the client expects to replace
+		expected := art.Rev
+		ifMatch, present, err := parseIfMatch(r.Header.Get("If-Match"))
+		if err != nil {
+			logger.Warn("bad If-Match header: %s", err.Error())
+			http.Error(w, "invalid If-Match header", http.StatusBadRequest)
+			return
+		}
+		if present {
+			expected = ifMatch
+			if ifMatch == 0 { // "*" matches whatever is current
+				expected = current.Rev
+			}
+		}
+		if expected == 0 {
+			http.Error(w, "If-Match header or revision required", http.StatusPreconditionRequired)
+			return
+		}
+
+		if err := articles.PrepareStatus(&art, now); err != nil {
+			logger.Warn("invalid status: %s", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := articles.CheckLang(repo, current, art.Language()); err != nil {
+			logger.Warn("invalid language: %s", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if current.TranslationOf == nil {
+			art.SourceRevision = 0
+		}
 
 		// Update article using client's revision - preserve all fields
 		art, err = repo.Update(articles.Article{
-			Id:            idUuid,
-			StructVersion: 1,
-			Rev:           uint64(art.Rev), // Use client's revision, NATS will handle CAS (Compare and Swap)
-			Slug:          art.Slug,
-			Title:         art.Title,
-			Subtitle:      art.Subtitle,
-			Leading:       art.Leading,
-			Author:        art.Author,      // Preserve author
-			PublishedAt:   art.PublishedAt, // Preserve published date
-			Tags:          art.Tags,        // Preserve tags
-			Content:       art.Content,
+			Id:             idUuid,
+			StructVersion:  articles.CurrentStructVersion,
+			Rev:            expected,
+			Slug:           art.Slug,
+			Title:          art.Title,
+			Subtitle:       art.Subtitle,
+			Leading:        art.Leading,
+			Author:         art.Author,      // Preserve author
+			Status:         art.Status,      // Preserve status
+			PublishedAt:    art.PublishedAt, // Preserve published date
+			Tags:           art.Tags,        // Preserve tags
+			Content:        art.Content,
+			Owners:         current.Owners, // changed through the access endpoint only
+			Collaborators:  current.Collaborators,
+			Lang:           art.Language(),
+			TranslationOf:  current.TranslationOf, // set when the translation is made
+			SourceRevision: art.SourceRevision,    // the translator marks it up to date
 		})
+		if errors.As(err, &conflictErr) {
+			logger.Info("revision conflict: %s", conflictErr.Error())
+			latest, getErr := repo.Get(idUuid)
+			if getErr != nil {
+				logger.Error("failed to get current article after conflict: %s", getErr.Error())
+			}
+			if latest.Rev != 0 {
+				w.Header().Set("ETag", etag(latest.Rev))
+			}
+			respJson(w, ConflictResp{
+				Error:            "revision conflict",
+				ExpectedRevision: conflictErr.Expected,
+				CurrentRevision:  latest.Rev,
+				Current:          latest,
+			}, http.StatusConflict)
+			return
+		}
+		if errors.As(err, &slugErr) {
+			logger.Info("slug taken: %s", slugErr.Error())
+			http.Error(w, fmt.Sprintf("slug %q is already in use", slugErr.Slug), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			logger.Error("failed to save article in repo: %v", err)
 			http.Error(w, fmt.Sprintf("failed to save article in repo: %s", err.Error()), http.StatusInternalServerError)
@@ -792,12 +1132,13 @@ func handleArticleUpdate(l *jst_log.Logger, repo articles.ArticleRepo) http.Hand
 
 		logger.Debug("updated article with slug: %s", art.Slug)
 		// Return the new revision number
+		w.Header().Set("ETag", etag(art.Rev))
 		respJson(w, art, http.StatusOK)
 	})
 }
 
 // handleArticleDelete creates a handler for deleting an article
-func handleArticleDelete(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
+func handleArticleDelete(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
 	logger := l.WithBreadcrumb("article").WithBreadcrumb("delete")
 	logger.Debug("ready")
 
@@ -812,13 +1153,21 @@ func handleArticleDelete(l *jst_log.Logger, repo articles.ArticleRepo) http.Hand
 		}
 		logger.Debug("idUuid: %s", idUuid)
 		// Check user permissions
-		user, ok := r.Context().Value(who.UserKey).(whoApi.User)
-		if !ok {
+		if _, ok := r.Context().Value(who.UserKey).(whoApi.User); !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		hasPermission := slices.Contains(user.Permissions, whoApi.PermissionPostEditAny)
-		if !hasPermission {
+		current, err := repo.Get(idUuid)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			http.Error(w, "article not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to get current article: %s", err.Error())
+			http.Error(w, "failed to get current article", http.StatusInternalServerError)
+			return
+		}
+		if !canDeleteArticle(r, acl, current) {
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
@@ -836,7 +1185,7 @@ func handleArticleDelete(l *jst_log.Logger, repo articles.ArticleRepo) http.Hand
 }
 
 // handleArticleRevisions creates a handler for getting all revisions of an article
-func handleArticleRevisions(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
+func handleArticleRevisions(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
 	logger := l.WithBreadcrumb("article_revisions").WithBreadcrumb("list")
 	logger.Debug("ready")
 
@@ -850,6 +1199,13 @@ func handleArticleRevisions(l *jst_log.Logger, repo articles.ArticleRepo) http.H
 			return
 		}
 		logger.Debug("idUuid: %s", idUuid)
+		if !canEditArticles(r) {
+			current, err := repo.Get(idUuid)
+			if err != nil || !canReadArticle(r, acl, current) {
+				http.NotFound(w, r)
+				return
+			}
+		}
 		revisions, err := repo.GetHistory(idUuid)
 		if err != nil {
 			logger.Error("failed to get article revisions: %s", err.Error())
@@ -863,7 +1219,7 @@ func handleArticleRevisions(l *jst_log.Logger, repo articles.ArticleRepo) http.H
 }
 
 // handleArticleRevision creates a handler for getting a specific revision of an article
-func handleArticleRevision(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
+func handleArticleRevision(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
 	logger := l.WithBreadcrumb("article_revisions").WithBreadcrumb("get")
 	logger.Debug("ready")
 
@@ -889,7 +1245,21 @@ func handleArticleRevision(l *jst_log.Logger, repo articles.ArticleRepo) http.Ha
 			return
 		}
 
+		if !canEditArticles(r) {
+			current, err := repo.Get(idUuid)
+			if err != nil || !canReadArticle(r, acl, current) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
 		art, err = repo.GetRevision(idUuid, rev)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			// never written, compacted away or the delete marker
+			logger.Info("not found, article \"%s\" revision %d", id, rev)
+			http.NotFound(w, r)
+			return
+		}
 		if err != nil {
 			logger.Error("failed to get article revision: %s", err.Error())
 			http.Error(w, "failed to get article revision", http.StatusInternalServerError)
@@ -1541,6 +1911,83 @@ func handleNotificationSend(l *jst_log.Logger, nc *nats.Conn) http.Handler {
 
 // --- HELPERS ---
 
+// canEditArticles reports whether the request comes from a user allowed to
+// edit (and thus see unpublished) articles.
+func canEditArticles(r *http.Request) bool {
+	user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+	return ok && user.Permissions.Includes(whoApi.PermissionPostEditAny)
+}
+
+// canReadArticle applies the visibility rules: published articles are public,
+// everything else is for those the policy lets read it.
+func canReadArticle(r *http.Request, acl *who.WhoProlog, art articles.Article) bool {
+	return art.IsPublic(time.Now()) || articleAllowed(r, acl, who.CheckRead, art)
+}
+
+// canChangeArticle reports whether the user may edit art: owners, change
+// collaborators and editors.
+func canChangeArticle(r *http.Request, acl *who.WhoProlog, art articles.Article) bool {
+	return articleAllowed(r, acl, who.CheckChange, art)
+}
+
+// canDeleteArticle reports whether the user may delete art: owners and
+// editors.
+func canDeleteArticle(r *http.Request, acl *who.WhoProlog, art articles.Article) bool {
+	return articleAllowed(r, acl, who.CheckDelete, art)
+}
+
+// canUndeleteArticle reports whether the user may bring back art, the last
+// revision of a deleted article: those canDeleteArticle allowed while it
+// was there.
+func canUndeleteArticle(r *http.Request, acl *who.WhoProlog, art articles.Article) bool {
+	return canDeleteArticle(r, acl, art)
+}
+
+// canShareArticle reports whether the user may change who owns and
+// collaborates on art: owners and editors.
+func canShareArticle(r *http.Request, acl *who.WhoProlog, art articles.Article) bool {
+	return articleAllowed(r, acl, who.CheckShare, art)
+}
+
+// articleAllowed asks the policy about the user of the request and art.
+// Anonymous requests and policy errors are denied.
+func articleAllowed(r *http.Request, acl *who.WhoProlog, check who.Check, art articles.Article) bool {
+	user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+	if !ok || user.ID == "" {
+		return false
+	}
+	allowed, err := acl.Allowed(check, user, art)
+	return err == nil && allowed
+}
+
+// etag formats a KV revision as a strong entity tag.
+func etag(rev uint64) string {
+	return fmt.Sprintf("\"%d\"", rev)
+}
+
+// parseIfMatch reads the revision from an If-Match header produced by etag.
+// present is false if the header is empty. "*" yields revision 0, meaning
+// "whatever is current".
+func parseIfMatch(header string) (rev uint64, present bool, err error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false, nil
+	}
+	if header == "*" {
+		return 0, true, nil
+	}
+	if strings.Contains(header, ",") {
+		return 0, true, fmt.Errorf("multiple entity tags not supported: %s", header)
+	}
+	tag := strings.TrimPrefix(header, "W/")
+	tag = strings.Trim(tag, "\"")
+	rev, err = strconv.ParseUint(tag, 10, 64)
+	if err != nil || rev == 0 {
+		return 0, true, fmt.Errorf("not a revision: %s", header)
+	}
+	return rev, true, nil
+}
+
 // respJson builds and writes a JSON response
 func respJson(w http.ResponseWriter, content any, code int) {
 	respBytes, err := json.Marshal(content)
@@ -1555,3 +2002,441 @@ func respJson(w http.ResponseWriter, content any, code int) {
 		return
 	}
 }
+
+// handleArticleRevisionDiff creates a handler for comparing two revisions of an
+// article. With ?format=unified the content diff is returned as plain text.
+func handleArticleRevisionDiff(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
+	logger := l.WithBreadcrumb("article_revisions").WithBreadcrumb("diff")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s, %s..%s", id, r.PathValue("from"), r.PathValue("to"))
+
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			logger.Error("failed to parse id: %s", err.Error())
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		fromRev, err := strconv.ParseUint(r.PathValue("from"), 10, 64)
+		if err != nil {
+			http.Error(w, "failed to parse revision", http.StatusBadRequest)
+			return
+		}
+		toRev, err := strconv.ParseUint(r.PathValue("to"), 10, 64)
+		if err != nil {
+			http.Error(w, "failed to parse revision", http.StatusBadRequest)
+			return
+		}
+
+		if !canEditArticles(r) {
+			current, err := repo.Get(idUuid)
+			if err != nil || !canReadArticle(r, acl, current) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		var revs [2]articles.Article
+		for i, rev := range []uint64{fromRev, toRev} {
+			revs[i], err = repo.GetRevision(idUuid, rev)
+			if errors.Is(err, jetstream.ErrKeyNotFound) || (err == nil && revs[i].Id == uuid.Nil) {
+				logger.Info("not found, article \"%s\" revision %d", id, rev)
+				http.Error(w, fmt.Sprintf("revision %d not found", rev), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				logger.Error("failed to get article revision: %s", err.Error())
+				http.Error(w, "failed to get article revision", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		diff := articles.DiffRevisions(revs[0], revs[1])
+		if r.URL.Query().Get("format") == "unified" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			if _, err := w.Write([]byte(diff.Content.Unified)); err != nil {
+				logger.Warn("failed to write diff: %s", err.Error())
+			}
+			return
+		}
+		respJson(w, diff, http.StatusOK)
+	})
+}
+
+// handleArticleRestore creates a handler for restoring an old revision of an
+// article as its new head. The write is a compare-and-swap against the head
+// given in If-Match, or the current head if the header is absent.
+func handleArticleRestore(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
+	type ConflictResp struct {
+		Error            string           `json:"error"`
+		ExpectedRevision uint64           `json:"expected_revision"`
+		CurrentRevision  uint64           `json:"current_revision"`
+		Current          articles.Article `json:"current"`
+	}
+
+	logger := l.WithBreadcrumb("article_revisions").WithBreadcrumb("restore")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			conflictErr *articles.RevisionConflictError
+			slugErr     *articles.SlugTakenError
+		)
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s and revision: %s", id, r.PathValue("revision"))
+
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			logger.Error("failed to parse id: %s", err.Error())
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		rev, err := strconv.ParseUint(r.PathValue("revision"), 10, 64)
+		if err != nil {
+			http.Error(w, "failed to parse revision", http.StatusBadRequest)
+			return
+		}
+
+		user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		current, err := repo.Get(idUuid)
+		if errors.Is(err, jetstream.ErrKeyNotFound) || (err == nil && current.Id == uuid.Nil) {
+			http.Error(w, "article not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to get current article: %s", err.Error())
+			http.Error(w, "failed to get current article", http.StatusInternalServerError)
+			return
+		}
+		if !canChangeArticle(r, acl, current) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if rev == current.Rev {
+			http.Error(w, fmt.Sprintf("revision %d is the current revision", rev), http.StatusBadRequest)
+			return
+		}
+
+		expected := current.Rev
+		ifMatch, present, err := parseIfMatch(r.Header.Get("If-Match"))
+		if err != nil {
+			logger.Warn("bad If-Match header: %s", err.Error())
+			http.Error(w, "invalid If-Match header", http.StatusBadRequest)
+			return
+		}
+		if present && ifMatch != 0 {
+			expected = ifMatch
+		}
+
+		art, err := articles.Restore(repo, idUuid, rev, expected, user.ID)
+		if errors.Is(err, articles.ErrRevisionUnavailable) {
+			logger.Info("revision %d of %s is unavailable: %s", rev, id, err.Error())
+			http.Error(w, fmt.Sprintf("revision %d is no longer available", rev), http.StatusGone)
+			return
+		}
+		if errors.As(err, &conflictErr) {
+			logger.Info("revision conflict: %s", conflictErr.Error())
+			latest, getErr := repo.Get(idUuid)
+			if getErr != nil {
+				logger.Error("failed to get current article after conflict: %s", getErr.Error())
+			}
+			if latest.Rev != 0 {
+				w.Header().Set("ETag", etag(latest.Rev))
+			}
+			respJson(w, ConflictResp{
+				Error:            "revision conflict",
+				ExpectedRevision: conflictErr.Expected,
+				CurrentRevision:  latest.Rev,
+				Current:          latest,
+			}, http.StatusConflict)
+			return
+		}
+		if errors.As(err, &slugErr) {
+			logger.Info("slug taken: %s", slugErr.Error())
+			http.Error(w, fmt.Sprintf("slug %q is already in use", slugErr.Slug), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to restore article: %v", err)
+			http.Error(w, "failed to restore article", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("user %s restored article %s to revision %d (new revision %d)", user.ID, art.Slug, rev, art.Rev)
+		w.Header().Set("ETag", etag(art.Rev))
+		respJson(w, art, http.StatusOK)
+	})
+}
+
+// handleArticleTrash creates a handler listing deleted articles that can
+// still be undeleted, those the user may undelete: all for editors, their
+// own for owners
+func handleArticleTrash(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
+	logger := l.WithBreadcrumb("article").WithBreadcrumb("trash")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called")
+		if userIDFromRequest(r) == "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		trash, err := repo.Trash()
+		if err != nil {
+			logger.Error("failed to list trash: %s", err.Error())
+			http.Error(w, "failed to list trash", http.StatusInternalServerError)
+			return
+		}
+		if !canEditArticles(r) {
+			trash = slices.DeleteFunc(trash, func(art articles.Article) bool {
+				return !canUndeleteArticle(r, acl, art)
+			})
+		}
+		respJson(w, trash, http.StatusOK)
+	})
+}
+
+// handleArticleUndelete creates a handler for bringing a deleted article back,
+// for editors and the owners of its last revision
+func handleArticleUndelete(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
+	logger := l.WithBreadcrumb("article").WithBreadcrumb("undelete")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var slugErr *articles.SlugTakenError
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			logger.Error("failed to parse id: %s", err.Error())
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		if !canEditArticles(r) {
+			trash, err := repo.Trash()
+			if err != nil {
+				logger.Error("failed to list trash: %s", err.Error())
+				http.Error(w, "failed to list trash", http.StatusInternalServerError)
+				return
+			}
+			i := slices.IndexFunc(trash, func(art articles.Article) bool { return art.Id == idUuid })
+			if i < 0 || !canUndeleteArticle(r, acl, trash[i]) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+
+		art, err := repo.Undelete(idUuid)
+		switch {
+		case errors.Is(err, articles.ErrNotDeleted):
+			http.Error(w, "article is not deleted", http.StatusConflict)
+			return
+		case errors.Is(err, jetstream.ErrKeyNotFound), errors.Is(err, articles.ErrRevisionUnavailable):
+			http.Error(w, "article not found in trash", http.StatusNotFound)
+			return
+		case errors.As(err, &slugErr):
+			logger.Info("slug taken: %s", slugErr.Error())
+			http.Error(w, fmt.Sprintf("slug %q is already in use", slugErr.Slug), http.StatusConflict)
+			return
+		case errors.Is(err, articles.ErrRevisionConflict):
+			http.Error(w, "article changed while undeleting", http.StatusConflict)
+			return
+		case err != nil:
+			logger.Error("failed to undelete article: %s", err.Error())
+			http.Error(w, "failed to undelete article", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("undeleted article %s (%s)", art.Slug, id)
+		w.Header().Set("ETag", etag(art.Rev))
+		respJson(w, art, http.StatusOK)
+	})
+}
+
+// handleTags creates a handler listing tags with their article counts
+func handleTags(l *jst_log.Logger, tags *articles.TagIndex) http.Handler {
+	type Resp struct {
+		Tags []articles.TagCount `json:"tags"`
+	}
+
+	logger := l.WithBreadcrumb("tags").WithBreadcrumb("list")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called")
+		respJson(w, Resp{Tags: tags.Tags(canEditArticles(r), time.Now())}, http.StatusOK)
+	})
+}
+
+// handleTagsRewrite creates a handler renaming a tag, or merging several tags
+// into one, on every article carrying them
+func handleTagsRewrite(l *jst_log.Logger, repo articles.ArticleRepo, nc *nats.Conn, op string) http.Handler {
+	type Req struct {
+		From []string `json:"from"`
+		To   string   `json:"to"`
+	}
+
+	logger := l.WithBreadcrumb("tags").WithBreadcrumb(op)
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		logger.Debug("called")
+		user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !slices.Contains(user.Permissions, whoApi.PermissionPostEditAny) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.To == "" || len(req.From) == 0 || (op == "rename" && len(req.From) != 1) {
+			http.Error(w, "from and to are required, rename takes a single from tag", http.StatusBadRequest)
+			return
+		}
+
+		batch, err := articles.RewriteTags(repo, nc, op, req.From, req.To, user.ID)
+		if errors.Is(err, articles.ErrTagRewriteNotRecorded) {
+			// the articles are rewritten, the missing record is no reason
+			// to have the editor run it again
+			logger.Error("%s %v -> %q by %s: %s", op, req.From, req.To, user.ID, err.Error())
+			err = nil
+		}
+		if err != nil {
+			logger.Error("failed to %s tags: %s", op, err.Error())
+			http.Error(w, fmt.Sprintf("failed to %s tags", op), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("%s %v -> %q by %s: %d rewritten, %d failed, recorded at %d", op, req.From, req.To, user.ID, len(batch.Rewritten), len(batch.Failed), batch.Seq)
+		status := http.StatusOK
+		if len(batch.Failed) > 0 {
+			status = http.StatusMultiStatus
+		}
+		respJson(w, batch, status)
+	})
+}
+
+// handleArticleMigrate creates a handler that upgrades stored articles to the
+// current struct version, or with dryRun reports how many would change
+func handleArticleMigrate(l *jst_log.Logger, repo articles.ArticleRepo, dryRun bool) http.Handler {
+	logger := l.WithBreadcrumb("article").WithBreadcrumb("migrate")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called (dry run: %t)", dryRun)
+		if !canEditArticles(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		report, err := repo.Migrate(dryRun)
+		if err != nil {
+			logger.Error("failed to migrate articles: %s", err.Error())
+			http.Error(w, "failed to migrate articles", http.StatusInternalServerError)
+			return
+		}
+		if !dryRun {
+			logger.Info("migrated %d of %d outdated articles", report.Migrated, report.Outdated)
+		}
+		respJson(w, report, http.StatusOK)
+	})
+}
+
+// handleArticleExport creates a handler that downloads articles as a djot
+// bundle, ?format=tar|zip and optionally ?tag=
+func handleArticleExport(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
+	logger := l.WithBreadcrumb("article").WithBreadcrumb("export")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called")
+		if !canEditArticles(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		format, err := bundle.ParseFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tag := r.URL.Query().Get("tag")
+		arts, err := bundle.Collect(repo, tag)
+		if err != nil {
+			logger.Error("failed to collect articles: %s", err.Error())
+			http.Error(w, "failed to collect articles", http.StatusInternalServerError)
+			return
+		}
+
+		name := "articles"
+		if tag != "" {
+			name += "-" + strings.NewReplacer("/", "_", `"`, "_").Replace(tag)
+		}
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+		if err := bundle.Export(w, format, arts); err != nil {
+			// headers are gone, all we can do is cut the archive short
+			logger.Error("failed to export articles: %s", err.Error())
+			return
+		}
+		logger.Info("exported %d articles", len(arts))
+	})
+}
+
+// handleArticleImport creates a handler that creates or updates articles from
+// an uploaded djot bundle, ?dry_run=true only reports what would change
+func handleArticleImport(l *jst_log.Logger, repo articles.ArticleRepo) http.Handler {
+	const maxBundleSize = 64 << 20
+
+	logger := l.WithBreadcrumb("article").WithBreadcrumb("import")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called")
+		user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !slices.Contains(user.Permissions, whoApi.PermissionPostEditAny) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBundleSize))
+		if err != nil {
+			http.Error(w, "failed to read bundle", http.StatusRequestEntityTooLarge)
+			return
+		}
+		format := bundle.Detect(data)
+		if f := r.URL.Query().Get("format"); f != "" {
+			if format, err = bundle.ParseFormat(f); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		report, err := bundle.Import(repo, data, format, dryRun, user.ID)
+		if err != nil {
+			logger.Warn("failed to import bundle: %s", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !dryRun {
+			logger.Info("user %s imported bundle: %d created, %d updated, %d conflicts, %d failed", user.ID, report.Created, report.Updated, report.Conflicts, report.Failed)
+		}
+		respJson(w, report, http.StatusOK)
+	})
+}
@@ -0,0 +1,309 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/uuid"
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/presence"
+	"jst_dev/server/series"
+	"jst_dev/server/views"
+	"jst_dev/server/who"
+	whoApi "jst_dev/server/who/api"
+)
+
+type testEnv struct {
+	nc    *nats.Conn
+	repo  articles.ArticleRepo
+	locks *presence.Store
+	acl   *who.WhoProlog
+	l     *jst_log.Logger
+}
+
+func newTestEnv(t testing.TB) testEnv {
+	t.Helper()
+	ns, err := natsServer.NewServer(&natsServer.Options{
+		ServerName: "test-web",
+		NoLog:      true,
+		DontListen: true,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL(), nats.InProcessServer(ns))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		nc.Close()
+		ns.Shutdown()
+	})
+	l := jst_log.NewLogger("test", jst_log.DefaultSubjects())
+	repo, err := articles.Repo(ctx, nc, l)
+	if err != nil {
+		t.Fatalf("repo: %v", err)
+	}
+	locks, err := presence.NewStore(ctx, nc, l)
+	if err != nil {
+		t.Fatalf("presence: %v", err)
+	}
+	acl, err := who.NewProlog(l)
+	if err != nil {
+		t.Fatalf("prolog: %v", err)
+	}
+	return testEnv{nc: nc, repo: repo, locks: locks, acl: acl, l: l}
+}
+
+// serve sends a request as user, anonymous if user.ID is empty, through a
+// mux with pattern routed to h.
+func serve(h http.Handler, pattern string, user whoApi.User, method, path string, body any) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(data))
+	if user.ID != "" {
+		r = r.WithContext(context.WithValue(r.Context(), who.UserKey, user))
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pattern, h)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+// frontendArticle is the body the editor sends on save, see encoder in
+// jst_lustre/src/article.gleam. It has no status.
+func frontendArticle(art articles.Article, publishedAt int) map[string]any {
+	return map[string]any{
+		"struct_version": 1,
+		"id":             art.Id.String(),
+		"revision":       art.Rev,
+		"slug":           art.Slug,
+		"title":          art.Title,
+		"leading":        art.Leading,
+		"subtitle":       art.Subtitle,
+		"author":         art.Author,
+		"published_at":   publishedAt,
+		"tags":           art.Tags,
+		"content":        art.Content,
+	}
+}
+
+func TestArticleUpdatePublish(t *testing.T) {
+	env := newTestEnv(t)
+	h := handleArticleUpdate(env.l, env.repo, env.locks, env.acl)
+	editor := whoApi.User{ID: "editor", Permissions: whoApi.Permissions{whoApi.PermissionPostEditAny}}
+
+	events := make(chan *nats.Msg, 4)
+	sub, err := env.nc.ChanSubscribe(articles.SubjectPublished, events)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	art := articles.TestArticle()
+	art.Status, art.PublishedAt = articles.StatusDraft, 0 // as handleArticleNew makes them
+	art.Owners = []string{"editor"}
+	art, err = env.repo.Create(art)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	save := func(art articles.Article, publishedAt int) articles.Article {
+		t.Helper()
+		w := serve(h, "PUT /api/article/{id}", editor, http.MethodPut, "/api/article/"+art.Id.String(), frontendArticle(art, publishedAt))
+		if w.Code != http.StatusOK {
+			t.Fatalf("save: %d %s", w.Code, w.Body)
+		}
+		var saved articles.Article
+		if err := json.Unmarshal(w.Body.Bytes(), &saved); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return saved
+	}
+
+	// editing a draft keeps it a draft
+	art.Title = "edited"
+	art = save(art, 0)
+	if art.Status != articles.StatusDraft || art.Title != "edited" {
+		t.Errorf("expected an edited draft, got %s %q", art.Status, art.Title)
+	}
+
+	// "Publish" sets published_at to now
+	publishedAt := int(time.Now().UnixMilli())
+	art = save(art, publishedAt)
+	if art.Status != articles.StatusPublished || art.PublishedAt != publishedAt {
+		t.Errorf("expected a published article, got %s at %d", art.Status, art.PublishedAt)
+	}
+	select {
+	case msg := <-events:
+		var ev articles.PublishedEvent
+		if err := json.Unmarshal(msg.Data, &ev); err != nil || ev.Id != art.Id || ev.Rev != art.Rev {
+			t.Errorf("unexpected published event %s (%v)", msg.Data, err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected a published event")
+	}
+
+	// saving a published article keeps it published and does not announce
+	art = save(art, art.PublishedAt)
+	if art.Status != articles.StatusPublished {
+		t.Errorf("expected the article to stay published, got %s", art.Status)
+	}
+	select {
+	case msg := <-events:
+		t.Errorf("unexpected published event %s", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// "Unpublish" clears published_at
+	art = save(art, 0)
+	if art.Status != articles.StatusDraft || art.PublishedAt != 0 {
+		t.Errorf("expected a draft again, got %s at %d", art.Status, art.PublishedAt)
+	}
+
+	// a publish time in the future schedules
+	later := int(time.Now().Add(time.Hour).UnixMilli())
+	art = save(art, later)
+	if art.Status != articles.StatusScheduled || art.PublishedAt != later {
+		t.Errorf("expected a scheduled article, got %s at %d", art.Status, art.PublishedAt)
+	}
+
+	// an explicit status still has to make sense
+	body := frontendArticle(art, 0)
+	body["status"] = articles.StatusScheduled
+	w := serve(h, "PUT /api/article/{id}", editor, http.MethodPut, "/api/article/"+art.Id.String(), body)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected scheduling without a publish time to fail, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestArticleLanguage(t *testing.T) {
+	env := newTestEnv(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	seriesStore, err := series.NewStore(ctx, env.nc, env.repo, env.l)
+	if err != nil {
+		t.Fatalf("series: %v", err)
+	}
+	viewStore, err := views.NewStore(ctx, env.nc, env.l)
+	if err != nil {
+		t.Fatalf("views: %v", err)
+	}
+	get := handleArticle(env.l, env.repo, articles.NewLinkIndex(), seriesStore, viewStore, env.acl)
+	shell := fstest.MapFS{"index.html": {Data: []byte("<html><head></head><body></body></html>")}}
+	page := handleArticlePage(env.l, env.repo, env.acl, shell, "https://example.com")
+
+	source := articles.TestArticle()
+	source.Status, source.PublishedAt = articles.StatusPublished, int(time.Now().UnixMilli())
+	source, err = env.repo.Create(source)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	sv, err := articles.NewTranslation(env.repo, source.Id, "sv", articles.Article{})
+	if err != nil {
+		t.Fatalf("translate: %v", err)
+	}
+	sv.Status, sv.PublishedAt = articles.StatusPublished, source.PublishedAt
+	if sv, err = env.repo.Update(sv); err != nil {
+		t.Fatalf("publish translation: %v", err)
+	}
+
+	fetch := func(path, acceptLanguage string) articles.Article {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Language", acceptLanguage)
+		mux := http.NewServeMux()
+		mux.Handle("GET /api/article/{id}", get)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("get %s: %d %s", path, w.Code, w.Body)
+		}
+		var art articles.Article
+		if err := json.Unmarshal(w.Body.Bytes(), &art); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return art
+	}
+
+	// asking by id gets that article whatever the browser prefers
+	if art := fetch("/api/article/"+source.Id.String(), "sv"); art.Id != source.Id {
+		t.Errorf("expected the source by id, got %s (%s)", art.Id, art.Language())
+	}
+	if art := fetch("/api/article/"+source.Id.String()+"?lang=sv", "en"); art.Id != sv.Id {
+		t.Errorf("expected ?lang= to pick the translation, got %s (%s)", art.Id, art.Language())
+	}
+
+	open := func(slug, acceptLanguage string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/article/"+slug, nil)
+		r.Header.Set("Accept-Language", acceptLanguage)
+		mux := http.NewServeMux()
+		mux.Handle("GET /article/{slug}", page)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	// the reader page of the source sends readers to their language
+	w := open(source.Slug, "sv-SE,sv;q=0.9,en;q=0.5")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/article/"+sv.Slug {
+		t.Errorf("expected a redirect to the translation, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if w = open(source.Slug, "en"); w.Code != http.StatusOK {
+		t.Errorf("expected the source page, got %d", w.Code)
+	}
+	if w = open(sv.Slug, "en"); w.Code != http.StatusOK {
+		t.Errorf("expected links to a translation to stay put, got %d", w.Code)
+	}
+}
+
+func TestArticleNotFound(t *testing.T) {
+	env := newTestEnv(t)
+	update := handleArticleUpdate(env.l, env.repo, env.locks, env.acl)
+	revision := handleArticleRevision(env.l, env.repo, env.acl)
+	editor := whoApi.User{ID: "editor", Permissions: whoApi.Permissions{whoApi.PermissionPostEditAny}}
+
+	missing := articles.TestArticle()
+	missing.Id = uuid.New()
+	w := serve(update, "PUT /api/article/{id}", editor, http.MethodPut, "/api/article/"+missing.Id.String(), frontendArticle(missing, 0))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected saving a missing article to be 404, got %d %s", w.Code, w.Body)
+	}
+
+	art, err := env.repo.Create(articles.TestArticle())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err = env.repo.Delete(art.Id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	// the revision after the last put is the delete marker
+	for rev, want := range map[uint64]int{art.Rev: http.StatusOK, art.Rev + 1: http.StatusNotFound, art.Rev + 100: http.StatusNotFound} {
+		path := fmt.Sprintf("/api/article/%s/revisions/%d", art.Id, rev)
+		w := serve(revision, "GET /api/article/{id}/revisions/{revision}", editor, http.MethodGet, path, nil)
+		if w.Code != want {
+			t.Errorf("revision %d: got %d %s, want %d", rev, w.Code, w.Body, want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+	searchApi "jst_dev/server/search/api"
+	"jst_dev/server/series"
+	"jst_dev/server/who"
+	whoApi "jst_dev/server/who/api"
+)
+
+// seriesResp is a series with the articles the reader may see. For readers
+// who are not editors Articles only lists those as well.
+type seriesResp struct {
+	series.Series
+	Parts []series.Link `json:"parts"`
+}
+
+// seriesView builds the response for ser as seen by the request, ok is false
+// if the reader may not see any of its articles.
+func seriesView(r *http.Request, store *series.Store, acl *who.WhoProlog, ser series.Series) (seriesResp, bool, error) {
+	parts, err := store.Parts(ser, func(art articles.Article) bool {
+		return canReadArticle(r, acl, art)
+	})
+	if err != nil {
+		return seriesResp{}, false, err
+	}
+	if !canEditArticles(r) {
+		if len(parts) == 0 {
+			return seriesResp{}, false, nil
+		}
+		ser.Articles = make([]uuid.UUID, 0, len(parts))
+		for _, part := range parts {
+			ser.Articles = append(ser.Articles, part.ID)
+		}
+		ser.UpdatedBy = ""
+	}
+	return seriesResp{Series: ser, Parts: parts}, true, nil
+}
+
+// handleSeriesList lists every series with at least one article the reader
+// may see.
+func handleSeriesList(l *jst_log.Logger, store *series.Store, acl *who.WhoProlog) http.Handler {
+	logger := l.WithBreadcrumb("series").WithBreadcrumb("list")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called")
+		list := []seriesResp{}
+		for _, ser := range store.All() {
+			view, ok, err := seriesView(r, store, acl, ser)
+			if err != nil {
+				logger.Error("failed to get parts of series %s: %v", ser.ID, err)
+				http.Error(w, "failed to list series", http.StatusInternalServerError)
+				return
+			}
+			if ok {
+				list = append(list, view)
+			}
+		}
+		respJson(w, list, http.StatusOK)
+	})
+}
+
+// handleSeries returns a single series.
+func handleSeries(l *jst_log.Logger, store *series.Store, acl *who.WhoProlog) http.Handler {
+	logger := l.WithBreadcrumb("series").WithBreadcrumb("get")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		ser, err := store.Get(idUuid)
+		if errors.Is(err, series.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		view, ok, err := seriesView(r, store, acl, ser)
+		if err != nil {
+			logger.Error("failed to get parts of series %s: %v", id, err)
+			http.Error(w, "failed to get series", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", etag(ser.Revision))
+		respJson(w, view, http.StatusOK)
+	})
+}
+
+// handleSeriesSave creates a series, or with create false replaces the
+// series {id}. Replacing honours If-Match like article updates do.
+func handleSeriesSave(l *jst_log.Logger, store *series.Store, create bool) http.Handler {
+	type Req struct {
+		Title       string      `json:"title"`
+		Description string      `json:"description"`
+		Articles    []uuid.UUID `json:"articles"`
+		Revision    uint64      `json:"revision"`
+	}
+
+	action := "update"
+	if create {
+		action = "create"
+	}
+	logger := l.WithBreadcrumb("series").WithBreadcrumb(action)
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called")
+		user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+		if !ok || !canEditArticles(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var req Req
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		ser := series.Series{
+			Title:       req.Title,
+			Description: req.Description,
+			Articles:    req.Articles,
+			Revision:    req.Revision,
+			UpdatedBy:   user.ID,
+		}
+
+		var err error
+		if create {
+			ser, err = store.Create(ser)
+		} else {
+			if ser.ID, err = uuid.Parse(r.PathValue("id")); err != nil {
+				http.Error(w, "failed to parse id", http.StatusBadRequest)
+				return
+			}
+			ifMatch, present, parseErr := parseIfMatch(r.Header.Get("If-Match"))
+			if parseErr != nil {
+				http.Error(w, "invalid If-Match header", http.StatusBadRequest)
+				return
+			}
+			if present {
+				ser.Revision = ifMatch
+			}
+			ser, err = store.Update(ser)
+		}
+		if !seriesError(logger, w, r, err) {
+			return
+		}
+
+		logger.Info("user %s: %s series %s (%s)", user.ID, action, ser.ID, ser.Title)
+		w.Header().Set("ETag", etag(ser.Revision))
+		if create {
+			respJson(w, ser, http.StatusCreated)
+			return
+		}
+		respJson(w, ser, http.StatusOK)
+	})
+}
+
+// handleSeriesDelete deletes a series. Its articles are left alone.
+func handleSeriesDelete(l *jst_log.Logger, store *series.Store) http.Handler {
+	logger := l.WithBreadcrumb("series").WithBreadcrumb("delete")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		user, ok := r.Context().Value(who.UserKey).(whoApi.User)
+		if !ok || !canEditArticles(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		rev, _, err := parseIfMatch(r.Header.Get("If-Match"))
+		if err != nil {
+			http.Error(w, "invalid If-Match header", http.StatusBadRequest)
+			return
+		}
+		if !seriesError(logger, w, r, store.Delete(idUuid, rev)) {
+			return
+		}
+		logger.Info("user %s: deleted series %s", user.ID, id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// seriesError writes the response for a failed store call and returns
+// false, or returns true if err is nil.
+func seriesError(logger *jst_log.Logger, w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, series.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, series.ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, series.ErrConflict):
+		http.Error(w, "revision conflict", http.StatusConflict)
+	default:
+		logger.Error("series store: %v", err)
+		http.Error(w, "failed to save series", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// handleArticleRelated lists the articles most related to {id} by shared
+// tags and content.
+func handleArticleRelated(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog, nc *nats.Conn) http.Handler {
+	logger := l.WithBreadcrumb("article").WithBreadcrumb("related")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		art, err := repo.Get(idUuid)
+		if err != nil || !canReadArticle(r, acl, art) {
+			http.NotFound(w, r)
+			return
+		}
+		limit := 0
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+				http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		reqBytes, err := json.Marshal(searchApi.ArticleRelatedRequest{
+			ID:            id,
+			Limit:         limit,
+			IncludeHidden: canEditArticles(r),
+		})
+		if err != nil {
+			logger.Error("failed to marshal request: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		msg, err := nc.Request(searchApi.Subj.ArticleGroup+"."+searchApi.Subj.ArticleRelated, reqBytes, 5*time.Second)
+		if err != nil {
+			logger.Error("failed to get related articles: %v", err)
+			http.Error(w, "failed to get related articles", http.StatusInternalServerError)
+			return
+		}
+		if msg.Header.Get("Nats-Service-Error") != "" {
+			switch msg.Header.Get("Nats-Service-Error-Code") {
+			case "INVALID_REQUEST":
+				http.Error(w, string(msg.Data), http.StatusBadRequest)
+			case "NOT_FOUND":
+				http.NotFound(w, r)
+			default:
+				http.Error(w, "service error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		var resp searchApi.ArticleRelatedResponse
+		if err := json.Unmarshal(msg.Data, &resp); err != nil {
+			logger.Error("failed to unmarshal response: %v", err)
+			http.Error(w, "failed to parse response", http.StatusInternalServerError)
+			return
+		}
+		respJson(w, resp, http.StatusOK)
+	})
+}
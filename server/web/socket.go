@@ -11,10 +11,15 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/nats-io/nats.go"
 
+	"jst_dev/server/articles"
+	commentsApi "jst_dev/server/comments/api"
 	"jst_dev/server/jst_log"
+	"jst_dev/server/presence"
+	"jst_dev/server/views"
 	"jst_dev/server/who"
 	whoApi "jst_dev/server/who/api"
 )
@@ -93,8 +98,9 @@ type capabilities struct {
 
 // Server
 type server struct {
-	nc *nats.Conn
-	js nats.JetStreamContext
+	nc   *nats.Conn
+	js   nats.JetStreamContext
+	repo articles.ArticleRepo
 }
 
 var upgrader = websocket.Upgrader{
@@ -114,7 +120,7 @@ var upgrader = websocket.Upgrader{
 }
 
 // HandleRealtimeWebSocket upgrades the connection and serves the realtime bridge
-func HandleRealtimeWebSocket(l *jst_log.Logger, nc *nats.Conn, slow time.Duration, w http.ResponseWriter, r *http.Request) {
+func HandleRealtimeWebSocket(l *jst_log.Logger, nc *nats.Conn, repo articles.ArticleRepo, presenceStore *presence.Store, acl *who.WhoProlog, slow time.Duration, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		l.Error("ws upgrade: %v", err)
@@ -129,12 +135,12 @@ func HandleRealtimeWebSocket(l *jst_log.Logger, nc *nats.Conn, slow time.Duratio
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	s := &server{nc: nc, js: js}
+	s := &server{nc: nc, js: js, repo: repo}
 
 	userID := userIDFromRequest(r)
 	c := &rtClient{
 		id:         userID,
-		caps:       authorizeInitial(l, s, userID),
+		caps:       authorizeInitial(s, userID, canEditArticles(r)),
 		conn:       conn,
 		srv:        s,
 		subs:       make(map[string]*nats.Subscription),
@@ -144,6 +150,16 @@ func HandleRealtimeWebSocket(l *jst_log.Logger, nc *nats.Conn, slow time.Duratio
 		cancel:     cancel,
 		log:        l,
 		slow:       slow,
+		editor:     canEditArticles(r),
+		canRead: func(art articles.Article) bool {
+			return canReadArticle(r, acl, art)
+		},
+		canChange: func(art articles.Article) bool {
+			return canChangeArticle(r, acl, art)
+		},
+		presence: presenceStore,
+		open:     make(map[uuid.UUID]bool),
+		locked:   make(map[uuid.UUID]bool),
 	}
 
 	go c.watchAuthKV()
@@ -161,25 +177,43 @@ func userIDFromRequest(r *http.Request) string {
 }
 
 // Authorization bootstrap TODO: implement proper
-func authorizeInitial(l *jst_log.Logger, s *server, userID string) capabilities {
+func authorizeInitial(s *server, userID string, editor bool) capabilities {
 	caps := capabilities{
 		Subjects: []string{"time.seconds"},
-		Buckets:  map[string][]string{"article": {">"}, "url_short": {">"}},
+		Buckets:  map[string][]string{},
 		Commands: []string{},
 		Streams:  map[string][]string{},
 	}
-	if userID == "" {
-		return caps
+	if userID != "" {
+		if kv, err := s.js.KeyValue("auth.users"); err == nil {
+			if entry, err := kv.Get(userID); err == nil && entry != nil {
+				_ = json.Unmarshal(entry.Value(), &caps)
+			}
+		}
 	}
-	kv, err := s.js.KeyValue("auth.users")
-	if err != nil {
-		return caps
+	return withSessionBuckets(caps, userID, editor)
+}
+
+// withSessionBuckets adds the buckets every session may watch whatever the
+// auth KV says: articles, short urls and comments for everyone, presence and
+// locks for logged in users and live view counts for editors. Both the
+// initial capabilities and every update from the auth KV go through it.
+func withSessionBuckets(caps capabilities, userID string, editor bool) capabilities {
+	buckets := map[string][]string{}
+	for bucket, keys := range caps.Buckets {
+		buckets[bucket] = keys
 	}
-	entry, err := kv.Get(userID)
-	if err != nil || entry == nil {
-		return caps
+	for _, bucket := range []string{"article", "url_short", "comments"} {
+		buckets[bucket] = []string{">"}
 	}
-	_ = json.Unmarshal(entry.Value(), &caps)
+	if userID != "" {
+		buckets[presence.BucketPresence] = []string{">"}
+		buckets[presence.BucketLocks] = []string{">"}
+	}
+	if editor {
+		buckets[views.Bucket] = []string{">"}
+	}
+	caps.Buckets = buckets
 	return caps
 }
 
@@ -197,6 +231,12 @@ type rtClient struct {
 	cancel     context.CancelFunc
 	log        *jst_log.Logger
 	slow       time.Duration
+	editor     bool // may see unpublished articles
+	canRead    func(articles.Article) bool
+	canChange  func(articles.Article) bool
+	presence   *presence.Store
+	open       map[uuid.UUID]bool // articles the client sent presence for
+	locked     map[uuid.UUID]bool // articles the client holds the edit lock of
 }
 
 func (c *rtClient) writeLoop() {
@@ -239,6 +279,7 @@ func (c *rtClient) readLoop() {
 		c.cancel()
 		_ = c.conn.Close()
 		c.unsubscribeAll()
+		c.leaveAll()
 	}()
 
 	for {
@@ -275,6 +316,21 @@ func (c *rtClient) readLoop() {
 			_ = json.Unmarshal(m.Data, &opts)
 			c.handleJSSub(m.Target, opts.StartSeq, opts.Batch, opts.Filter)
 		// case "js_unsub":
+		case "article_list":
+			var query articles.ListQuery
+			if err := json.Unmarshal(m.Data, &query); len(m.Data) > 0 && err != nil {
+				c.send(serverMsg{Op: "reply", Inbox: m.Inbox, Data: map[string]string{"error": "bad query"}})
+				continue
+			}
+			c.handleArticleList(query, m.Inbox)
+		case "presence":
+			c.handlePresence(m.Target, m.Data, m.Inbox)
+		case "presence_leave":
+			c.handlePresenceLeave(m.Target, m.Inbox)
+		case "lock":
+			c.handleLock(m.Target, m.Inbox)
+		case "unlock":
+			c.handleUnlock(m.Target, m.Data, m.Inbox)
 		default:
 			c.log.Warn("Unknown operation: %s", m.Op)
 		}
@@ -439,6 +495,20 @@ func (c *rtClient) handleKVSub(bucket, pattern string) {
 				default:
 					opStr = "unknown"
 				}
+				value := string(entry.Value())
+				if bucket == "article" && opStr == "put" {
+					visible, ok := c.articleEntry(entry.Key(), entry.Value())
+					if !ok {
+						// readers learn that the article is gone, not what is in it
+						opStr, visible = "delete", ""
+					}
+					value = visible
+				}
+				if bucket == "comments" && opStr == "put" && !c.editor && !c.commentVisible(entry.Value()) {
+					// pending and rejected comments are for moderators only,
+					// those on articles the client may not read for nobody
+					opStr, value = "delete", ""
+				}
 				c.send(serverMsg{
 					Op:     "kv_msg",
 					Target: bucket,
@@ -446,7 +516,7 @@ func (c *rtClient) handleKVSub(bucket, pattern string) {
 						Op:    opStr,
 						Rev:   entry.Revision(),
 						Key:   entry.Key(),
-						Value: string(entry.Value()),
+						Value: value,
 					},
 				})
 			}
@@ -454,6 +524,56 @@ func (c *rtClient) handleKVSub(bucket, pattern string) {
 	}()
 }
 
+// articleEntry decodes an article entry and applies the same rules as
+// GET /api/article/{id}: the article must be readable by the client, and who
+// it is shared with is only for those who may change it. It returns the value
+// to forward, false if the client may not see the article. Undecodable
+// entries are not shown.
+func (c *rtClient) articleEntry(key string, data []byte) (string, bool) {
+	art, err := articles.Decode(data)
+	if err != nil {
+		return "", false
+	}
+	if id, err := uuid.Parse(key); err == nil {
+		art.Id = id
+	}
+	if !c.editor && !c.canRead(art) {
+		return "", false
+	}
+	if c.canChange(art) {
+		return string(data), true
+	}
+	art.Owners, art.Collaborators = nil, nil
+	redacted, err := json.Marshal(art)
+	if err != nil {
+		return "", false
+	}
+	return string(redacted), true
+}
+
+// commentVisible tells whether a comment entry may be shown to a reader: it
+// must be approved and on an article the client may read, as for
+// GET /api/article/{id}. Comments on missing or deleted articles are not
+// shown.
+func (c *rtClient) commentVisible(data []byte) bool {
+	var comment commentsApi.Comment
+	if err := json.Unmarshal(data, &comment); err != nil {
+		return false
+	}
+	if comment.Status != commentsApi.StatusApproved {
+		return false
+	}
+	id, err := uuid.Parse(comment.ArticleID)
+	if err != nil {
+		return false
+	}
+	art, err := c.srv.repo.Get(id)
+	if err != nil {
+		return false
+	}
+	return c.canRead(art)
+}
+
 func (c *rtClient) handleJSSub(stream string, startSeq uint64, batch int, filter string) {
 	if !c.isAllowedStream(stream, filter) {
 		return
@@ -562,6 +682,7 @@ func (c *rtClient) watchAuthKV() {
 				if err := json.Unmarshal(entry.Value(), &newCaps); err != nil {
 					continue
 				}
+				newCaps = withSessionBuckets(newCaps, c.id, c.editor)
 				c.applyCapabilities(newCaps)
 				c.send(serverMsg{Op: "cap_update", Data: newCaps})
 			}
@@ -600,65 +721,33 @@ func (c *rtClient) applyCapabilities(newCaps capabilities) {
 
 // ---- Article Handlers ----
 
-// func (c *rtClient) handleArticleList(inbox string) {
-// 	if !c.isAllowedKV("article", ">") {
-// 		c.send(serverMsg{Op: "reply", Inbox: inbox, Data: map[string]string{"error": "insufficient permissions"}})
-// 		return
-// 	}
-
-// 	go func() {
-// 		kv, err := c.srv.js.KeyValue("article")
-// 		if err != nil {
-// 			c.send(serverMsg{Op: "reply", Inbox: inbox, Data: map[string]string{"error": "failed to access article bucket"}})
-// 			return
-// 		}
-
-// 		keys, err := kv.ListKeys()
-// 		if err != nil {
-// 			c.send(serverMsg{Op: "reply", Inbox: inbox, Data: map[string]string{"error": "failed to list articles"}})
-// 			return
-// 		}
-
-// 		var articles []articleResponse
-// 		for key := range keys.Keys() {
-// 			entry, err := kv.Get(key)
-// 			if err != nil {
-// 				continue // Skip articles we can't read
-// 			}
-
-// 			var art struct {
-// 				ID            string   `json:"id"`
-// 				Slug          string   `json:"slug"`
-// 				Title         string   `json:"title"`
-// 				Subtitle      string   `json:"subtitle"`
-// 				Leading       string   `json:"leading"`
-// 				Author        string   `json:"author"`
-// 				PublishedAt   int      `json:"published_at"`
-// 				Tags          []string `json:"tags"`
-// 				StructVersion int      `json:"struct_version"`
-// 			}
-
-// 			if err := json.Unmarshal(entry.Value(), &art); err != nil {
-// 				continue
-// 			}
-
-// 			articles = append(articles, articleResponse{
-// 				ID:            art.ID,
-// 				Slug:          art.Slug,
-// 				Title:         art.Title,
-// 				Subtitle:      art.Subtitle,
-// 				Leading:       art.Leading,
-// 				Author:        art.Author,
-// 				PublishedAt:   art.PublishedAt,
-// 				Tags:          art.Tags,
-// 				Revision:      entry.Revision(),
-// 				StructVersion: art.StructVersion,
-// 			})
-// 		}
-
-// 		c.send(serverMsg{Op: "reply", Inbox: inbox, Data: articleListResponse{Articles: articles}})
-// 	}()
-// }
+// handleArticleList replies with a page of the article listing, with the same
+// query and cursor semantics as GET /api/article.
+func (c *rtClient) handleArticleList(query articles.ListQuery, inbox string) {
+	if !c.isAllowedKV("article", ">") {
+		c.send(serverMsg{Op: "reply", Inbox: inbox, Data: map[string]string{"error": "insufficient permissions"}})
+		return
+	}
+	query.IncludeHidden = c.editor
+	query.CanRead = c.canRead
+	all, err := c.srv.repo.AllNoContent()
+	if err != nil {
+		c.log.Error("list articles: %v", err)
+		c.send(serverMsg{Op: "reply", Inbox: inbox, Data: map[string]string{"error": "failed to list articles"}})
+		return
+	}
+	page, err := articles.List(all, query)
+	if err != nil {
+		c.send(serverMsg{Op: "reply", Inbox: inbox, Data: map[string]string{"error": err.Error()}})
+		return
+	}
+	if !c.editor {
+		for i := range page.Articles {
+			page.Articles[i].Collaborators = nil // shared with whom is for those who share
+		}
+	}
+	c.send(serverMsg{Op: "reply", Inbox: inbox, Data: page})
+}
 
 // func (c *rtClient) handleArticleGet(id string, inbox string) {
 // 	if !c.isAllowedKV("article", id) {
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"jst_dev/server/articles"
+	commentsApi "jst_dev/server/comments/api"
+)
+
+func TestCommentVisible(t *testing.T) {
+	env := newTestEnv(t)
+	r := httptest.NewRequest("GET", "/ws", nil)
+	c := &rtClient{
+		srv: &server{nc: env.nc, repo: env.repo},
+		canRead: func(art articles.Article) bool {
+			return canReadArticle(r, env.acl, art)
+		},
+	}
+
+	create := func(status articles.Status) articles.Article {
+		t.Helper()
+		art := articles.TestArticle()
+		art.Id = uuid.New()
+		art.Slug = art.Id.String()
+		art.Status = status
+		art, err := env.repo.Create(art)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		return art
+	}
+	comment := func(art articles.Article, status commentsApi.Status) []byte {
+		data, _ := json.Marshal(commentsApi.Comment{ID: uuid.NewString(), ArticleID: art.Id.String(), Status: status})
+		return data
+	}
+
+	published := create(articles.StatusPublished)
+	draft := create(articles.StatusDraft)
+	trashed := create(articles.StatusPublished)
+	if err := env.repo.Delete(trashed.Id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		data []byte
+		want bool
+	}{
+		"approved on published": {comment(published, commentsApi.StatusApproved), true},
+		"pending on published":  {comment(published, commentsApi.StatusPending), false},
+		"approved on draft":     {comment(draft, commentsApi.StatusApproved), false},
+		"approved on trashed":   {comment(trashed, commentsApi.StatusApproved), false},
+		"approved on missing":   {comment(articles.Article{Id: uuid.New()}, commentsApi.StatusApproved), false},
+	} {
+		if got := c.commentVisible(tc.data); got != tc.want {
+			t.Errorf("%s: got %v, want %v", name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/who"
+)
+
+// familyResp is a source article with the state of every language.
+type familyResp struct {
+	ID           uuid.UUID              `json:"id"`
+	Slug         string                 `json:"slug"`
+	Title        string                 `json:"title"`
+	Lang         string                 `json:"lang"`
+	Translations []articles.Translation `json:"translations"`
+}
+
+// familyView is the family as the reader of r may see it. False if the
+// source is gone or hidden from them.
+func familyView(r *http.Request, acl *who.WhoProlog, f articles.Family) (familyResp, bool) {
+	f = f.Filter(func(art articles.Article) bool {
+		return canReadArticle(r, acl, art)
+	})
+	if f.Source.Id == uuid.Nil {
+		return familyResp{}, false
+	}
+	return familyResp{
+		ID:           f.Source.Id,
+		Slug:         f.Source.Slug,
+		Title:        f.Source.Title,
+		Lang:         f.Source.Language(),
+		Translations: f.Status(),
+	}, true
+}
+
+// handleTranslationList lists source articles with the state of their
+// translations. ?lang= only looks at one language, ?state= only lists
+// families with a translation in that state, e.g. missing or stale.
+func handleTranslationList(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
+	type Resp struct {
+		Families []familyResp `json:"families"`
+		Total    int          `json:"total"`
+	}
+
+	logger := l.WithBreadcrumb("translations").WithBreadcrumb("list")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("called")
+		lang := r.URL.Query().Get("lang")
+		if lang != "" && !articles.ValidLang(lang) {
+			http.Error(w, fmt.Sprintf("unknown language %q", lang), http.StatusBadRequest)
+			return
+		}
+		state := articles.TranslationState(r.URL.Query().Get("state"))
+		if state != "" && !slices.Contains([]articles.TranslationState{articles.TranslationMissing, articles.TranslationStale, articles.TranslationCurrent}, state) {
+			http.Error(w, "state must be missing, stale or current", http.StatusBadRequest)
+			return
+		}
+
+		all, err := repo.AllNoContent()
+		if err != nil {
+			logger.Error("failed to get all articles: %s", err.Error())
+			http.Error(w, "failed to get all articles", http.StatusInternalServerError)
+			return
+		}
+		resp := Resp{Families: []familyResp{}}
+		for _, f := range articles.Families(all) {
+			view, ok := familyView(r, acl, f)
+			if !ok {
+				continue
+			}
+			if lang != "" {
+				view.Translations = slices.DeleteFunc(view.Translations, func(t articles.Translation) bool {
+					return t.State != articles.TranslationSource && t.Lang != lang
+				})
+			}
+			if state != "" && !slices.ContainsFunc(view.Translations, func(t articles.Translation) bool { return t.State == state }) {
+				continue
+			}
+			resp.Families = append(resp.Families, view)
+		}
+		slices.SortFunc(resp.Families, func(a, b familyResp) int {
+			return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+		})
+		resp.Total = len(resp.Families)
+		respJson(w, resp, http.StatusOK)
+	})
+}
+
+// handleArticleTranslations answers the state of every language of the
+// article's family. The article may be the source or any translation.
+func handleArticleTranslations(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
+	logger := l.WithBreadcrumb("translations").WithBreadcrumb("get")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		family, err := articles.GetFamily(repo, idUuid)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to get translations: %s", err.Error())
+			http.Error(w, "failed to get translations", http.StatusInternalServerError)
+			return
+		}
+		view, ok := familyView(r, acl, family)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		respJson(w, view, http.StatusOK)
+	})
+}
+
+// handleArticleTranslate creates the translation of an article into another
+// language. Fields left out of the body are copied from the source, the new
+// translation is a draft owned by the owners of the source.
+func handleArticleTranslate(l *jst_log.Logger, repo articles.ArticleRepo, acl *who.WhoProlog) http.Handler {
+	type Req struct {
+		Lang     string   `json:"lang"`
+		Slug     string   `json:"slug"`
+		Title    string   `json:"title"`
+		Subtitle string   `json:"subtitle"`
+		Leading  string   `json:"leading"`
+		Content  string   `json:"content"`
+		Tags     []string `json:"tags"`
+	}
+
+	logger := l.WithBreadcrumb("translations").WithBreadcrumb("create")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var slugErr *articles.SlugTakenError
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		source, err := repo.Get(idUuid)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to get article: %s", err.Error())
+			http.Error(w, "failed to get article", http.StatusInternalServerError)
+			return
+		}
+		if !canChangeArticle(r, acl, source) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var req Req
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 5*1024*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		art, err := articles.NewTranslation(repo, source.Id, req.Lang, articles.Article{
+			Slug:     req.Slug,
+			Title:    req.Title,
+			Subtitle: req.Subtitle,
+			Leading:  req.Leading,
+			Content:  req.Content,
+			Tags:     req.Tags,
+		})
+		if errors.Is(err, articles.ErrInvalidTranslation) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.As(err, &slugErr) {
+			http.Error(w, fmt.Sprintf("slug %q is already in use", slugErr.Slug), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to create translation: %s", err.Error())
+			http.Error(w, "failed to create translation", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("translated %s into %s: %s", source.Id, art.Lang, art.Id)
+		w.Header().Set("ETag", etag(art.Rev))
+		respJson(w, art, http.StatusCreated)
+	})
+}
@@ -0,0 +1,116 @@
+package web
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/views"
+)
+
+// maxStatsDays caps the range of a stats request.
+const maxStatsDays = 366
+
+// handleArticleStats answers the views of an article per day, for admins.
+// ?from= and ?to= are days (YYYY-MM-DD, UTC), both included. The default is
+// the last 30 days.
+func handleArticleStats(l *jst_log.Logger, repo articles.ArticleRepo, viewStore *views.Store) http.Handler {
+	logger := l.WithBreadcrumb("article").WithBreadcrumb("stats")
+	logger.Debug("ready")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		logger.Debug("called with id: %s", id)
+		if !canEditArticles(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		idUuid, err := uuid.Parse(id)
+		if err != nil {
+			http.Error(w, "failed to parse id", http.StatusBadRequest)
+			return
+		}
+		if _, err := repo.Get(idUuid); errors.Is(err, jetstream.ErrKeyNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+
+		to := time.Now().UTC()
+		if s := r.URL.Query().Get("to"); s != "" {
+			if to, err = time.Parse(time.DateOnly, s); err != nil {
+				http.Error(w, "to must be YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+		from := to.AddDate(0, 0, -29)
+		if s := r.URL.Query().Get("from"); s != "" {
+			if from, err = time.Parse(time.DateOnly, s); err != nil {
+				http.Error(w, "from must be YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+		if from.After(to) || to.Sub(from) >= maxStatsDays*24*time.Hour {
+			http.Error(w, "from must be before to, at most a year apart", http.StatusBadRequest)
+			return
+		}
+
+		stats, err := viewStore.Stats(idUuid, from, to)
+		if err != nil {
+			logger.Error("failed to get stats: %s", err.Error())
+			http.Error(w, "failed to get stats", http.StatusInternalServerError)
+			return
+		}
+		respJson(w, stats, http.StatusOK)
+	})
+}
+
+// recordView counts a read of art, unless it is not public yet, is read by
+// someone who may edit it or by a crawler. Failures are logged, the reader
+// does not notice.
+func recordView(r *http.Request, logger *jst_log.Logger, viewStore *views.Store, art articles.Article, canChange bool) {
+	userAgent := r.Header.Get("User-Agent")
+	if canChange || !art.IsPublic(time.Now()) || isCrawler(userAgent) {
+		return
+	}
+	if err := viewStore.Record(art.Id, clientIP(r), userAgent); err != nil {
+		logger.Warn("failed to record view: %s", err.Error())
+	}
+}
+
+// clientIP is the address of the reader, as seen by the proxy in front of us
+// if there is one.
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("Fly-Client-IP"); ip != "" {
+		return ip
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isCrawler recognises bots that say so in their user agent.
+func isCrawler(userAgent string) bool {
+	if userAgent == "" {
+		return true
+	}
+	ua := strings.ToLower(userAgent)
+	for _, word := range []string{"bot", "crawler", "spider", "slurp", "preview", "curl", "wget"} {
+		if strings.Contains(ua, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -13,6 +13,11 @@ import (
 
 	"jst_dev/server/articles"
 	"jst_dev/server/jst_log"
+	"jst_dev/server/media"
+	"jst_dev/server/presence"
+	"jst_dev/server/series"
+	"jst_dev/server/views"
+	"jst_dev/server/who"
 )
 
 type httpServer struct {
@@ -20,6 +25,13 @@ type httpServer struct {
 	l           *jst_log.Logger
 	ctx         context.Context
 	articleRepo articles.ArticleRepo
+	tags        *articles.TagIndex
+	links       *articles.LinkIndex
+	media       *media.Store
+	series      *series.Store
+	presence    *presence.Store
+	views       *views.Store
+	acl         *who.WhoProlog
 	mux         *http.ServeMux // For defining routes
 	handler     http.Handler   // Final wrapped handler for serving requests
 	embedFs     fs.FS
@@ -29,9 +41,9 @@ type httpServer struct {
 //go:embed static
 var embedded embed.FS
 
-// New initializes and returns a new httpServer instance with embedded static files and an article repository.
+// New initializes and returns a new httpServer instance with embedded static files, an article repository, the tag and link indexes, the media store, the series store, presence and edit locks, view counts, the access policy and the public url of the site.
 // Returns nil if the static files or article repository cannot be initialized.
-func New(ctx context.Context, nc *nats.Conn, jwtSecret string, l *jst_log.Logger, articleRepo articles.ArticleRepo, dev bool, slow time.Duration) *httpServer {
+func New(ctx context.Context, nc *nats.Conn, jwtSecret string, l *jst_log.Logger, articleRepo articles.ArticleRepo, tags *articles.TagIndex, links *articles.LinkIndex, mediaStore *media.Store, seriesStore *series.Store, presenceStore *presence.Store, viewStore *views.Store, acl *who.WhoProlog, siteURL string, dev bool, slow time.Duration) *httpServer {
 	fs, err := fs.Sub(embedded, "static")
 	if err != nil {
 		l.Error("Failed to load static folder")
@@ -44,12 +56,19 @@ func New(ctx context.Context, nc *nats.Conn, jwtSecret string, l *jst_log.Logger
 		l:           l,
 		embedFs:     fs,
 		articleRepo: articleRepo,
+		tags:        tags,
+		links:       links,
+		media:       mediaStore,
+		series:      seriesStore,
+		presence:    presenceStore,
+		views:       viewStore,
+		acl:         acl,
 		mux:         http.NewServeMux(),
 		slow:        slow,
 	}
 
 	// Set up routes on the mux
-	routes(s.mux, l.WithBreadcrumb("route"), s.articleRepo, nc, s.embedFs, jwtSecret, dev, s.slow)
+	routes(s.mux, l.WithBreadcrumb("route"), s.articleRepo, s.tags, s.links, s.media, s.series, s.presence, s.views, s.acl, nc, s.embedFs, jwtSecret, siteURL, dev, s.slow)
 
 	// Apply global middleware to create the final handler
 	// note: last added is first called
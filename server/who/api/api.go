@@ -104,7 +104,8 @@ type Permissions []Permission
 
 const (
 	// post
-	PermissionPostEditAny Permission = "post_edit_any"
+	PermissionPostCreate  Permission = "post_create"   // write articles of your own
+	PermissionPostEditAny Permission = "post_edit_any" // every article, the admin group of the policy
 	// PermissionPostViewAny   Permission = "post_view_any"
 	// PermissionPostDeleteAny Permission = "post_delete_any"
 
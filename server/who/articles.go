@@ -0,0 +1,75 @@
+package who
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/who/api"
+)
+
+// Articles are policy resources named by ArticleResource. Their owners and
+// collaborators are asserted from the article being checked (see Allowed),
+// users join the admin group through the post_edit_any permission (see
+// Groups).
+
+// GroupAdmin may do anything with any resource.
+const GroupAdmin = "admin"
+
+// Check is a question Allowed asks the policy about a user and an article.
+type Check string
+
+const (
+	CheckRead   Check = `read(?, ?).`
+	CheckChange Check = `change(?, ?).`
+	CheckDelete Check = `delete(?, ?).`
+	CheckShare  Check = `share(?, ?).`
+)
+
+// ArticleResource is the name of an article in the policy.
+func ArticleResource(id uuid.UUID) string {
+	return "article/" + id.String()
+}
+
+// Groups returns the policy groups of user.
+func Groups(user api.User) []string {
+	if user.Permissions.Includes(api.PermissionPostEditAny) {
+		return []string{GroupAdmin}
+	}
+	return []string{}
+}
+
+// Allowed asks check about user and art. The groups of user and the owners
+// and collaborators of art, as given, are asserted for the one question and
+// retracted after it, all under the lock, so concurrent checks never see
+// each other's facts.
+func (w *WhoProlog) Allowed(check Check, user api.User, art articles.Article) (bool, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	res := ArticleResource(art.Id)
+	defer func() {
+		_ = w.setOwners(res)
+		_ = w.setGrants(res)
+		_ = w.setGroups(user.ID)
+	}()
+	if err := w.setGroups(user.ID, Groups(user)...); err != nil {
+		return false, err
+	}
+	if err := w.setOwners(res, art.Owners...); err != nil {
+		return false, err
+	}
+	grants := make([]Grant, 0, len(art.Collaborators))
+	for _, c := range art.Collaborators {
+		grants = append(grants, Grant{Right: string(c.Access), User: c.UserID})
+	}
+	if err := w.setGrants(res, grants...); err != nil {
+		return false, err
+	}
+	allowed, err := w.query(string(check), user.ID, res)
+	if err != nil {
+		return false, fmt.Errorf("allowed: %w", err)
+	}
+	return allowed, nil
+}
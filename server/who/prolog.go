@@ -2,6 +2,7 @@ package who
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/ichiban/prolog"
 
@@ -10,35 +11,39 @@ import (
 
 var (
 	db = `
-% user: johan
-owns(johan, johan_docs).
-in_group(johan, admin).
-
-% Resource properties
-is_public(public_docs).
+% Facts are asserted at runtime, see SetOwners, SetGrants and SetGroups:
+%  owns(User, Res).          User owns Res
+%  grant(Right, User, Res).  Res was shared with User, Right is read, change or create
+%  in_group(User, Group).    User is a member of Group
+%  is_public(Res).           anyone may read Res
+% Go strings passed to queries are atoms, so they unify with read, admin and co.
+:- set_prolog_flag(double_quotes, atom).
+:- dynamic(owns/2).
+:- dynamic(grant/3).
+:- dynamic(in_group/2).
+:- dynamic(is_public/1).
 
 % read if:
 %  - Is public
 %  - Is owner
-%  - Granted read rights by owner
-%  - Granted read rights by admin
+%  - Granted read or change rights (only owners and admins grant)
 %  - Is admin
 
 read(User, Res) :-
 	is_public(Res);
-	owns(User,Res);
-	granted_read(User, Res, owns(_,Res));
-	granted_read(User, Res, in_group(_,admin));
+	owns(User, Res);
+	grant(read, User, Res);
+	grant(change, User, Res);
 	in_group(User, admin).
 
 % change if:
 %  - Is owner
-%  - Granted change rights by owner
+%  - Granted change rights
 %  - Is admin
 
 change(User, Res) :-
 	owns(User, Res);
-	granted_change(User, Res, owns(_,Res));
+	grant(change, User, Res);
 	in_group(User, admin).
 
 % delete if:
@@ -49,6 +54,14 @@ delete(User, Res) :-
 	owns(User, Res);
 	in_group(User, admin).
 
+% share (change owners and grants) if:
+%  - Is owner
+%  - Is admin
+
+share(User, Res) :-
+	owns(User, Res);
+	in_group(User, admin).
+
 % create if:
 %  - Has create permission in parent
 %  - Is admin
@@ -58,25 +71,18 @@ create(User, Res) :-
 	in_group(User, admin).
 
 % Helper predicates
-granted_read(_, _, false) :- !, fail.
-granted_read(User, Res, true) :- grant(read, User, Res).
-
-granted_change(_, _, false) :- !, fail.
-granted_change(User, Res, true) :- grant(change, User, Res).
-
 has_create_permission(User, Parent) :- grant(create, User, Parent).
-
-% Example grants
-grant(read, alice, johan_docs).
-parent(new_doc) :- root_folder.
-root_folder.
 `
 )
 
+// WhoProlog answers access questions from the rules in db and the facts fed
+// to it. The interpreter is not safe for concurrent use, every call holds
+// the lock.
 type WhoProlog struct {
-	db string
-	p  *prolog.Interpreter
-	l  *jst_log.Logger
+	db   string
+	lock sync.Mutex
+	p    *prolog.Interpreter
+	l    *jst_log.Logger
 }
 
 // NewProlog initializes a WhoProlog instance with an embedded Prolog database for access control.
@@ -137,7 +143,101 @@ func (w *WhoProlog) Create(user string, res string) (bool, error) {
 	return allowed, nil
 }
 
+func (w *WhoProlog) Share(user string, res string) (bool, error) {
+	allowed, err := w.test(`share(?, ?).`, user, res)
+	if err != nil {
+		return false, fmt.Errorf("share: %w", err)
+	}
+	return allowed, nil
+}
+
+// --- FACTS ---
+
+// Grant is a right on a resource given to a user.
+type Grant struct {
+	Right string // read, change or create
+	User  string
+}
+
+// SetOwners replaces the owners of res.
+func (w *WhoProlog) SetOwners(res string, owners ...string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.setOwners(res, owners...)
+}
+
+// setOwners is SetOwners for callers holding the lock.
+func (w *WhoProlog) setOwners(res string, owners ...string) error {
+	if err := w.exec(`retractall(owns(_, ?)).`, res); err != nil {
+		return fmt.Errorf("set owners: %w", err)
+	}
+	for _, user := range owners {
+		if err := w.exec(`assertz(owns(?, ?)).`, user, res); err != nil {
+			return fmt.Errorf("set owners: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetGrants replaces the grants on res.
+func (w *WhoProlog) SetGrants(res string, grants ...Grant) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.setGrants(res, grants...)
+}
+
+// setGrants is SetGrants for callers holding the lock.
+func (w *WhoProlog) setGrants(res string, grants ...Grant) error {
+	if err := w.exec(`retractall(grant(_, _, ?)).`, res); err != nil {
+		return fmt.Errorf("set grants: %w", err)
+	}
+	for _, g := range grants {
+		if err := w.exec(`assertz(grant(?, ?, ?)).`, g.Right, g.User, res); err != nil {
+			return fmt.Errorf("set grants: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetGroups replaces the groups of user.
+func (w *WhoProlog) SetGroups(user string, groups ...string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.setGroups(user, groups...)
+}
+
+// setGroups is SetGroups for callers holding the lock.
+func (w *WhoProlog) setGroups(user string, groups ...string) error {
+	if err := w.exec(`retractall(in_group(?, _)).`, user); err != nil {
+		return fmt.Errorf("set groups: %w", err)
+	}
+	for _, group := range groups {
+		if err := w.exec(`assertz(in_group(?, ?)).`, user, group); err != nil {
+			return fmt.Errorf("set groups: %w", err)
+		}
+	}
+	return nil
+}
+
+// exec runs a goal for its side effects. The caller holds the lock.
+func (w *WhoProlog) exec(goal string, args ...any) error {
+	sols, err := w.p.Query(goal, args...)
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	defer sols.Close()
+	sols.Next()
+	return sols.Err()
+}
+
 func (w *WhoProlog) test(predicate string, args ...any) (bool, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.query(predicate, args...)
+}
+
+// query reports whether the goal has a solution. The caller holds the lock.
+func (w *WhoProlog) query(predicate string, args ...any) (bool, error) {
 	sols, err := w.p.Query(predicate, args...)
 	if err != nil {
 		return false, fmt.Errorf("query: %w", err)
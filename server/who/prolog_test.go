@@ -0,0 +1,129 @@
+package who
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"jst_dev/server/articles"
+	"jst_dev/server/jst_log"
+	"jst_dev/server/who/api"
+)
+
+func TestArticleAccess(t *testing.T) {
+	w, err := NewProlog(jst_log.NewLogger("test", jst_log.DefaultSubjects()))
+	if err != nil {
+		t.Fatalf("new prolog: %v", err)
+	}
+
+	art := articles.Article{
+		Id:     uuid.New(),
+		Owners: []string{"owner"},
+		Collaborators: []articles.Collaborator{
+			{UserID: "reader", Access: articles.AccessRead},
+			{UserID: "writer", Access: articles.AccessChange},
+		},
+	}
+
+	admin := api.User{ID: "admin", Permissions: []api.Permission{api.PermissionPostEditAny}}
+	users := map[string]api.User{
+		"owner":    {ID: "owner"},
+		"reader":   {ID: "reader"},
+		"writer":   {ID: "writer"},
+		"stranger": {ID: "stranger"},
+		"admin":    admin,
+	}
+	checks := map[string]Check{
+		"read":   CheckRead,
+		"change": CheckChange,
+		"delete": CheckDelete,
+		"share":  CheckShare,
+	}
+	expect := map[string]map[string]bool{
+		"owner":    {"read": true, "change": true, "delete": true, "share": true},
+		"reader":   {"read": true},
+		"writer":   {"read": true, "change": true},
+		"stranger": {},
+		"admin":    {"read": true, "change": true, "delete": true, "share": true},
+	}
+
+	for name, user := range users {
+		for check, c := range checks {
+			got, err := w.Allowed(c, user, art)
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, check, err)
+			}
+			if got != expect[name][check] {
+				t.Errorf("%s %s: got %v, want %v", name, check, got, expect[name][check])
+			}
+		}
+	}
+
+	// losing the permission drops the admin group
+	if ok, _ := w.Allowed(CheckChange, api.User{ID: "admin"}, art); ok {
+		t.Error("admin without post_edit_any may still change")
+	}
+
+	// decisions follow the article in hand
+	changed := art
+	changed.Owners = []string{"writer"}
+	changed.Collaborators = nil
+	if ok, _ := w.Allowed(CheckShare, users["writer"], changed); !ok {
+		t.Error("new owner may not share")
+	}
+	if ok, _ := w.Allowed(CheckRead, users["owner"], changed); ok {
+		t.Error("previous owner may still read")
+	}
+	if ok, _ := w.Allowed(CheckRead, users["reader"], changed); ok {
+		t.Error("removed collaborator may still read")
+	}
+	if ok, _ := w.Allowed(CheckRead, users["reader"], art); !ok {
+		t.Error("facts of a previous check leaked into the next one")
+	}
+
+	// no facts outlive a check
+	if ok, _ := w.Read("owner", ArticleResource(art.Id)); ok {
+		t.Error("owner facts left behind")
+	}
+	if ok, _ := w.Read("admin", ArticleResource(art.Id)); ok {
+		t.Error("group facts left behind")
+	}
+}
+
+func TestArticleAccessConcurrent(t *testing.T) {
+	w, err := NewProlog(jst_log.NewLogger("test", jst_log.DefaultSubjects()))
+	if err != nil {
+		t.Fatalf("new prolog: %v", err)
+	}
+
+	art := articles.Article{Id: uuid.New(), Owners: []string{"owner"}}
+	admin := api.User{ID: "user", Permissions: []api.Permission{api.PermissionPostEditAny}}
+	plain := api.User{ID: "user"}
+
+	// the same user id with and without post_edit_any, one must never get
+	// the groups of the other
+	var wg sync.WaitGroup
+	errs := make(chan string, 200)
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if ok, err := w.Allowed(CheckChange, admin, art); err != nil || !ok {
+				errs <- fmt.Sprintf("admin denied (%v)", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if ok, err := w.Allowed(CheckChange, plain, art); err != nil || ok {
+				errs <- fmt.Sprintf("plain user allowed (%v)", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+}
@@ -27,6 +27,7 @@ var UserKey = userKeyType{}
 const jwtExpiresAfterTime = time.Hour * 12
 
 var PermissionsAll = []api.Permission{
+	api.PermissionPostCreate,
 	api.PermissionPostEditAny,
 }
 
@@ -349,8 +350,8 @@ func (w *Who) handleUserGet() micro.HandlerFunc {
 		if reqData.ID != "" {
 			user = w.userGet(reqData.ID)
 			if user == nil {
-				l.Warn(fmt.Sprintf("error getting user: %s", err.Error()))
-				if err := req.Error("SERVER_ERROR", "server error while getting user", []byte(err.Error())); err != nil {
+				l.Warn(fmt.Sprintf("user not found: %s", reqData.ID))
+				if err := req.Error("USER_NOT_FOUND", "user not found", []byte(reqData.ID)); err != nil {
 					l.Error("failed to respond to user get request: %v", err)
 				}
 				return